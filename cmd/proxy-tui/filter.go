@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/daronenko/https-proxy/internal/model"
+)
+
+// filter is a list of terms that all must match. Terms look like
+// host:mail.ru, method:POST, status:5xx, status:>=400, path:/api or
+// user:alice, bare
+// words match host or path and a leading "-" negates a term.
+type filter []term
+
+type term struct {
+	negate bool
+	match  func(model.Summary) bool
+}
+
+func parseFilter(expr string) (filter, error) {
+	var f filter
+	for _, word := range strings.Fields(expr) {
+		t := term{}
+		if rest, found := strings.CutPrefix(word, "-"); found && rest != "" {
+			t.negate = true
+			word = rest
+		}
+
+		key, value, found := strings.Cut(word, ":")
+		if !found {
+			needle := strings.ToLower(word)
+			t.match = func(s model.Summary) bool {
+				return strings.Contains(strings.ToLower(s.Host), needle) || strings.Contains(strings.ToLower(s.Path), needle)
+			}
+			f = append(f, t)
+			continue
+		}
+
+		switch key {
+		case "host":
+			host := strings.ToLower(value)
+			t.match = func(s model.Summary) bool {
+				h := strings.ToLower(s.Host)
+				return h == host || strings.HasSuffix(h, "."+host) || strings.HasPrefix(h, host+":")
+			}
+		case "method":
+			method := strings.ToUpper(value)
+			t.match = func(s model.Summary) bool { return s.Method == method }
+		case "path":
+			t.match = func(s model.Summary) bool { return strings.Contains(s.Path, value) }
+		case "user":
+			t.match = func(s model.Summary) bool { return s.User == value }
+		case "status":
+			match, err := parseStatus(value)
+			if err != nil {
+				return nil, err
+			}
+			t.match = match
+		default:
+			return nil, fmt.Errorf("unknown filter key %q", key)
+		}
+		f = append(f, t)
+	}
+
+	return f, nil
+}
+
+func parseStatus(value string) (func(model.Summary) bool, error) {
+	if class, found := strings.CutSuffix(strings.ToLower(value), "xx"); found {
+		n, err := strconv.Atoi(class)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status class %q", value)
+		}
+		return func(s model.Summary) bool { return s.Status/100 == n }, nil
+	}
+
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		if rest, found := strings.CutPrefix(value, op); found {
+			n, err := strconv.Atoi(rest)
+			if err != nil {
+				return nil, fmt.Errorf("invalid status %q", value)
+			}
+			return func(s model.Summary) bool {
+				switch op {
+				case ">=":
+					return s.Status >= n
+				case "<=":
+					return s.Status <= n
+				case ">":
+					return s.Status > n
+				default:
+					return s.Status < n
+				}
+			}, nil
+		}
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid status %q", value)
+	}
+	return func(s model.Summary) bool { return s.Status == n }, nil
+}
+
+func (f filter) Match(s model.Summary) bool {
+	for _, t := range f {
+		if t.match(s) == t.negate {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+// Command proxy-tui is a terminal client for the api server that follows
+// proxied traffic live and inspects, repeats, scans and exports requests.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/daronenko/https-proxy/pkg/apiclient"
+)
+
+func main() {
+	address := flag.String("api", envOr("PROXY_API", "localhost:8000"), "api server address, also read from PROXY_API")
+	token := flag.String("token", os.Getenv("PROXY_TOKEN"), "api token, also read from PROXY_TOKEN")
+	caCert := flag.String("cacert", os.Getenv("PROXY_CACERT"), "ca certificate of the api server, enables https, also read from PROXY_CACERT")
+	cert := flag.String("cert", os.Getenv("PROXY_CERT"), "client certificate for mtls, also read from PROXY_CERT")
+	key := flag.String("key", os.Getenv("PROXY_KEY"), "client certificate key for mtls, also read from PROXY_KEY")
+	flag.Parse()
+
+	client := apiclient.New(*address)
+	client.Token = *token
+	if *caCert != "" || *cert != "" || *key != "" {
+		if err := client.SetTLS(*caCert, *cert, *key); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+	}
+
+	program := tea.NewProgram(newUI(client), tea.WithAltScreen())
+	if _, err := program.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
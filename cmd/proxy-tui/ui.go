@@ -0,0 +1,522 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/pkg/apiclient"
+)
+
+const (
+	maxRows         = 5000
+	maxBodyLines    = 2000
+	requestTimeout  = 2 * time.Minute
+	reconnectPeriod = 2 * time.Second
+)
+
+var exportFormats = []string{"curl", "raw", "go", "python", "js"}
+
+type screen int
+
+const (
+	listScreen screen = iota
+	detailScreen
+)
+
+type (
+	listMsg    []model.Summary
+	summaryMsg model.Summary
+	detailMsg  struct {
+		title string
+		lines []string
+	}
+	statusMsg string
+)
+
+type ui struct {
+	client  *apiclient.Client
+	summary chan model.Summary
+
+	all     []model.Summary
+	visible []model.Summary
+	cursor  int
+	offset  int
+
+	filter      filter
+	filterText  string
+	editing     bool
+	input       string
+	exportIndex int
+
+	screen       screen
+	detailTitle  string
+	detail       []string
+	detailOffset int
+
+	width  int
+	height int
+	status string
+}
+
+func newUI(client *apiclient.Client) *ui {
+	return &ui{
+		client:  client,
+		summary: make(chan model.Summary, 256),
+		status:  "loading...",
+	}
+}
+
+func (u *ui) Init() tea.Cmd {
+	go u.follow()
+	return tea.Batch(u.load, u.waitSummary)
+}
+
+// follow keeps the live feed connected and forwards summaries to the ui.
+func (u *ui) follow() {
+	for {
+		u.client.Stream(context.Background(), url.Values{}, func(s model.Summary) {
+			u.summary <- s
+		})
+		time.Sleep(reconnectPeriod)
+	}
+}
+
+func (u *ui) waitSummary() tea.Msg {
+	return summaryMsg(<-u.summary)
+}
+
+func (u *ui) load() tea.Msg {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	transactions, err := u.client.Requests(ctx)
+	if err != nil {
+		return statusMsg("failed to load requests: " + err.Error())
+	}
+
+	summaries := make([]model.Summary, 0, len(transactions))
+	for _, t := range transactions {
+		summaries = append(summaries, model.NewSummary(t))
+	}
+	return listMsg(summaries)
+}
+
+func (u *ui) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		u.width, u.height = msg.Width, msg.Height
+	case listMsg:
+		u.all = msg
+		u.status = fmt.Sprintf("%d requests", len(u.all))
+		u.refilter()
+	case summaryMsg:
+		u.all = slices.Insert(u.all, 0, model.Summary(msg))
+		if len(u.all) > maxRows {
+			u.all = u.all[:maxRows]
+		}
+		if u.filter.Match(model.Summary(msg)) && u.cursor > 0 {
+			// keep the selected row in place while new rows arrive on top
+			u.cursor++
+			u.offset++
+		}
+		u.refilter()
+		return u, u.waitSummary
+	case detailMsg:
+		u.screen = detailScreen
+		u.detailTitle = msg.title
+		u.detail = msg.lines
+		u.detailOffset = 0
+	case statusMsg:
+		u.status = string(msg)
+	case tea.KeyMsg:
+		if u.editing {
+			return u, u.editFilter(msg)
+		}
+		return u, u.handleKey(msg)
+	}
+
+	return u, nil
+}
+
+func (u *ui) editFilter(msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyEnter:
+		f, err := parseFilter(u.input)
+		if err != nil {
+			u.status = err.Error()
+			return nil
+		}
+		u.editing = false
+		u.filter, u.filterText = f, u.input
+		u.cursor, u.offset = 0, 0
+		u.refilter()
+	case tea.KeyEsc:
+		u.editing = false
+	case tea.KeyBackspace:
+		if len(u.input) > 0 {
+			_, size := utf8.DecodeLastRuneInString(u.input)
+			u.input = u.input[:len(u.input)-size]
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		u.input += string(msg.Runes)
+	}
+	return nil
+}
+
+func (u *ui) handleKey(msg tea.KeyMsg) tea.Cmd {
+	page := max(u.bodyHeight()-1, 1)
+
+	switch msg.String() {
+	case "ctrl+c":
+		return tea.Quit
+	case "q":
+		if u.screen == detailScreen {
+			u.screen = listScreen
+			return nil
+		}
+		return tea.Quit
+	case "esc":
+		u.screen = listScreen
+	case "up", "k":
+		u.move(-1)
+	case "down", "j":
+		u.move(1)
+	case "pgup":
+		u.move(-page)
+	case "pgdown", " ":
+		u.move(page)
+	case "home", "g":
+		u.move(-len(u.all) - len(u.detail))
+	case "end", "G":
+		u.move(len(u.all) + len(u.detail))
+	case "/":
+		u.editing = true
+		u.input = u.filterText
+	case "c":
+		u.filter, u.filterText = nil, ""
+		u.refilter()
+	case "enter":
+		if s, ok := u.selected(); ok {
+			return u.showDetail(s)
+		}
+	case "r":
+		if s, ok := u.selected(); ok {
+			u.status = "repeating..."
+			return u.repeat(s)
+		}
+	case "s":
+		if s, ok := u.selected(); ok {
+			u.status = "scanning, this may take a while..."
+			return u.scan(s)
+		}
+	case "e":
+		if s, ok := u.selected(); ok {
+			format := exportFormats[u.exportIndex%len(exportFormats)]
+			u.exportIndex++
+			return u.export(s, format)
+		}
+	}
+
+	return nil
+}
+
+func (u *ui) move(delta int) {
+	if u.screen == detailScreen {
+		u.detailOffset = clamp(u.detailOffset+delta, 0, max(len(u.detail)-u.bodyHeight(), 0))
+		return
+	}
+
+	u.cursor = clamp(u.cursor+delta, 0, max(len(u.visible)-1, 0))
+	u.scrollToCursor()
+}
+
+func (u *ui) scrollToCursor() {
+	height := u.bodyHeight() - 1 // table header
+	if u.cursor < u.offset {
+		u.offset = u.cursor
+	}
+	if u.cursor >= u.offset+height {
+		u.offset = u.cursor - height + 1
+	}
+	u.offset = clamp(u.offset, 0, max(len(u.visible)-height, 0))
+}
+
+func (u *ui) refilter() {
+	u.visible = u.visible[:0]
+	for _, s := range u.all {
+		if u.filter.Match(s) {
+			u.visible = append(u.visible, s)
+		}
+	}
+	u.cursor = clamp(u.cursor, 0, max(len(u.visible)-1, 0))
+	u.scrollToCursor()
+}
+
+func (u *ui) selected() (model.Summary, bool) {
+	if u.cursor >= len(u.visible) {
+		return model.Summary{}, false
+	}
+	return u.visible[u.cursor], true
+}
+
+func (u *ui) bodyHeight() int {
+	return max(u.height-2, 1) // status and help lines
+}
+
+// commands
+
+func summaryID(s model.Summary) string {
+	return fmt.Sprint(s.ID)
+}
+
+func (u *ui) showDetail(s model.Summary) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+
+		t, err := u.client.Request(ctx, summaryID(s))
+		if err != nil {
+			return statusMsg("failed to load request: " + err.Error())
+		}
+		responseBody, err := u.client.Body(ctx, summaryID(s), "response")
+		if err != nil {
+			return statusMsg("failed to load response body: " + err.Error())
+		}
+		if t.Request.BodyRef != "" {
+			if t.Request.Body, err = u.client.Body(ctx, summaryID(s), "request"); err != nil {
+				return statusMsg("failed to load request body: " + err.Error())
+			}
+		}
+
+		var lines []string
+		lines = append(lines, fmt.Sprintf("%s %s %s", t.Request.Method, model.BuildURL(t.Request), t.Request.Version))
+		if t.ParentID != nil {
+			lines = append(lines, fmt.Sprintf("repeat of %v", t.ParentID))
+		}
+		lines = append(lines, headerLines(t.Request.Headers)...)
+		lines = append(lines, "")
+		lines = append(lines, bodyLines(t.Request.Body, t.Request.Headers.Get("Content-Type"))...)
+		lines = append(lines, "", strings.Repeat("─", 40), "")
+		lines = append(lines, fmt.Sprintf("HTTP %d", t.Response.Status))
+		lines = append(lines, headerLines(t.Response.Headers)...)
+		lines = append(lines, "")
+		lines = append(lines, bodyLines(responseBody, t.Response.Headers.Get("Content-Type"))...)
+
+		return detailMsg{title: "request " + summaryID(s), lines: lines}
+	}
+}
+
+func (u *ui) repeat(s model.Summary) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+
+		result, err := u.client.Repeat(ctx, summaryID(s), nil)
+		if err != nil {
+			return statusMsg("repeat failed: " + err.Error())
+		}
+
+		lines := []string{fmt.Sprintf("HTTP %d", result.Status)}
+		if result.TransactionID != "" {
+			lines = append(lines, "stored as "+result.TransactionID)
+		}
+		lines = append(lines, headerLines(model.Values(result.Header))...)
+		lines = append(lines, "")
+		lines = append(lines, bodyLines(result.Body, result.Header.Get("Content-Type"))...)
+
+		return detailMsg{title: "repeat of " + summaryID(s), lines: lines}
+	}
+}
+
+func (u *ui) scan(s model.Summary) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+
+		report, err := u.client.Scan(ctx, summaryID(s))
+		if err != nil {
+			return statusMsg("scan failed: " + err.Error())
+		}
+
+		lines := []string{report.Result, ""}
+		names := make([]string, 0, len(report.Vulnerabilities))
+		for name := range report.Vulnerabilities {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			lines = append(lines, name)
+			for _, point := range report.Vulnerabilities[name] {
+				lines = append(lines, "  • "+point)
+			}
+		}
+
+		return detailMsg{title: "scan of " + summaryID(s), lines: lines}
+	}
+}
+
+func (u *ui) export(s model.Summary, format string) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+
+		rendered, err := u.client.Export(ctx, summaryID(s), format)
+		if err != nil {
+			return statusMsg("export failed: " + err.Error())
+		}
+
+		lines := strings.Split(strings.ReplaceAll(rendered, "\r\n", "\n"), "\n")
+		return detailMsg{title: format + " export of " + summaryID(s) + " (e for next format)", lines: lines}
+	}
+}
+
+// rendering
+
+func (u *ui) View() string {
+	if u.width == 0 {
+		return "loading..."
+	}
+
+	var b strings.Builder
+
+	if u.screen == detailScreen {
+		b.WriteString(reverse(pad(u.detailTitle, u.width)) + "\n")
+		end := min(u.detailOffset+u.bodyHeight()-1, len(u.detail))
+		for _, line := range u.detail[u.detailOffset:end] {
+			b.WriteString(truncate(line, u.width) + "\n")
+		}
+		for i := end - u.detailOffset; i < u.bodyHeight()-1; i++ {
+			b.WriteString("\n")
+		}
+	} else {
+		b.WriteString(reverse(pad(fmt.Sprintf("%-8s %-7s %-30s %-6s %s", "time", "method", "host", "status", "path"), u.width)) + "\n")
+		height := u.bodyHeight() - 1
+		for i := u.offset; i < u.offset+height; i++ {
+			if i >= len(u.visible) {
+				b.WriteString("\n")
+				continue
+			}
+			line := truncate(rowLine(u.visible[i]), u.width)
+			if i == u.cursor {
+				line = reverse(pad(line, u.width))
+			} else {
+				line = colorStatus(u.visible[i].Status, line)
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+
+	if u.editing {
+		b.WriteString(truncate("filter: "+u.input+"█", u.width) + "\n")
+	} else {
+		status := u.status
+		if u.filterText != "" {
+			status = fmt.Sprintf("%s | filter: %s (%d shown)", status, u.filterText, len(u.visible))
+		}
+		b.WriteString(truncate(status, u.width) + "\n")
+	}
+
+	help := "↑↓ move  enter details  / filter  c clear  r repeat  s scan  e export  q quit"
+	if u.screen == detailScreen {
+		help = "↑↓ scroll  esc back  r repeat  s scan  e export  q back"
+	}
+	b.WriteString(dim(truncate(help, u.width)))
+
+	return b.String()
+}
+
+func rowLine(s model.Summary) string {
+	return fmt.Sprintf("%-8s %-7s %-30s %-6d %s",
+		s.CreatedAt.Local().Format("15:04:05"),
+		s.Method,
+		truncate(s.Host, 30),
+		s.Status,
+		s.Path,
+	)
+}
+
+func headerLines(headers model.Values) []string {
+	var lines []string
+	for _, k := range headers.Keys() {
+		for _, v := range headers[k] {
+			lines = append(lines, k+": "+v)
+		}
+	}
+	return lines
+}
+
+func bodyLines(body []byte, contentType string) []string {
+	if len(body) == 0 {
+		return []string{"(empty body)"}
+	}
+
+	if !utf8.Valid(body) {
+		return []string{fmt.Sprintf("(binary body, %d bytes)", len(body))}
+	}
+
+	text := string(body)
+	if strings.Contains(contentType, "json") {
+		var v any
+		if err := json.Unmarshal(body, &v); err == nil {
+			if pretty, err := json.MarshalIndent(v, "", "  "); err == nil {
+				text = string(pretty)
+			}
+		}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if len(lines) > maxBodyLines {
+		lines = append(lines[:maxBodyLines], fmt.Sprintf("... %d more lines", len(lines)-maxBodyLines))
+	}
+	for i, line := range lines {
+		lines[i] = strings.ReplaceAll(line, "\t", "    ")
+	}
+	return lines
+}
+
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	if width <= 1 {
+		return string(runes[:width])
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+func pad(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return truncate(s, width)
+}
+
+func reverse(s string) string {
+	return "\x1b[7m" + s + "\x1b[0m"
+}
+
+func dim(s string) string {
+	return "\x1b[2m" + s + "\x1b[0m"
+}
+
+func colorStatus(status int, s string) string {
+	codes := map[int]string{2: "32", 3: "36", 4: "33", 5: "31"}
+	if code, ok := codes[status/100]; ok {
+		return "\x1b[" + code + "m" + s + "\x1b[0m"
+	}
+	return s
+}
+
+func clamp(v, lo, hi int) int {
+	return max(lo, min(v, hi))
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/daronenko/https-proxy/internal/app"
 	"github.com/daronenko/https-proxy/internal/app/config"
 	"github.com/daronenko/https-proxy/internal/httpserver"
+	"github.com/daronenko/https-proxy/pkg/oob"
 	"github.com/rs/zerolog/log"
 	"go.uber.org/fx"
 )
@@ -16,7 +17,7 @@ func main() {
 	app.New(fx.Invoke(run)).Run()
 }
 
-func run(proxyServer *httpserver.ProxyServer, apiServer *httpserver.ApiServer, conf *config.Config, lc fx.Lifecycle) {
+func run(proxyServer *httpserver.ProxyServer, apiServer *httpserver.ApiServer, oobServer *oob.Server, conf *config.Config, lc fx.Lifecycle) {
 	proxyListener, err := net.Listen("tcp", conf.App.ProxyServer.Address)
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to start proxy listener")
@@ -27,6 +28,22 @@ func run(proxyServer *httpserver.ProxyServer, apiServer *httpserver.ApiServer, c
 		log.Fatal().Err(err).Msg("failed to start api listener")
 	}
 
+	var (
+		oobHttpListener net.Listener
+		oobDnsConn      net.PacketConn
+	)
+	if oobServer != nil {
+		oobHttpListener, err = net.Listen("tcp", conf.App.Oob.HttpAddress)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to start oob http listener")
+		}
+
+		oobDnsConn, err = net.ListenPacket("udp", conf.App.Oob.DnsAddress)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to start oob dns listener")
+		}
+	}
+
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
 			go func() {
@@ -39,6 +56,13 @@ func run(proxyServer *httpserver.ProxyServer, apiServer *httpserver.ApiServer, c
 				apiServer.Serve(apiListener)
 			}()
 
+			if oobServer != nil {
+				go func() {
+					log.Info().Msg(fmt.Sprintf("starting oob server on %s (http) and %s (dns)...", conf.App.Oob.HttpAddress, conf.App.Oob.DnsAddress))
+					oobServer.Serve(oobHttpListener, oobDnsConn)
+				}()
+			}
+
 			return nil
 		},
 		OnStop: func(ctx context.Context) error {
@@ -52,6 +76,12 @@ func run(proxyServer *httpserver.ProxyServer, apiServer *httpserver.ApiServer, c
 				log.Error().Err(err).Msg("failed to shutdown api server")
 			}
 
+			if oobServer != nil {
+				if err := oobServer.Shutdown(ctx); err != nil {
+					log.Error().Err(err).Msg("failed to shutdown oob server")
+				}
+			}
+
 			return nil
 		},
 	})
@@ -0,0 +1,668 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/internal/services/api/feed"
+	"github.com/daronenko/https-proxy/pkg/apiauth"
+	"github.com/daronenko/https-proxy/pkg/apiclient"
+	"github.com/daronenko/https-proxy/pkg/certstore"
+	"github.com/daronenko/https-proxy/pkg/scope"
+)
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func runList(ctx context.Context, e *env, args []string) error {
+	host := e.flags.String("host", "", "only requests to this host or its subdomains")
+	method := e.flags.String("method", "", "only requests with this method")
+	status := e.flags.String("status", "", "only responses with this status or class like 5xx")
+	user := e.flags.String("user", "", "only requests sent by this proxy user")
+	limit := e.flags.Int("limit", 0, "show at most this many requests")
+	if _, err := e.parse(args, 0); err != nil {
+		return err
+	}
+
+	filter, err := feed.ParseFilter(url.Values{
+		"host":   {*host},
+		"method": {*method},
+		"status": {*status},
+		"user":   {*user},
+	})
+	if err != nil {
+		return &usageError{err.Error()}
+	}
+
+	transactions, err := e.client().Requests(ctx)
+	if err != nil {
+		return err
+	}
+
+	matched := make([]*model.Transaction, 0, len(transactions))
+	for _, t := range transactions {
+		if filter.Match(model.NewSummary(t)) {
+			matched = append(matched, t)
+		}
+		if *limit > 0 && len(matched) == *limit {
+			break
+		}
+	}
+
+	if e.output == "json" {
+		archived := make([]*model.ArchivedTransaction, 0, len(matched))
+		for _, t := range matched {
+			a, err := e.archive(ctx, t)
+			if err != nil {
+				return err
+			}
+			archived = append(archived, a)
+		}
+		return e.json(archived)
+	}
+
+	w := e.table()
+	fmt.Fprintln(w, "ID\tTIME\tUSER\tMETHOD\tHOST\tSTATUS\tPATH")
+	for _, t := range matched {
+		user := t.User
+		if user == "" {
+			user = "-"
+		}
+		fmt.Fprintf(w, "%v\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			t.ID, t.CreatedAt.Local().Format("2006-01-02 15:04:05"), user, t.Request.Method, t.Request.Host, t.Response.Status, t.Request.Path)
+	}
+	return w.Flush()
+}
+
+func runShow(ctx context.Context, e *env, args []string) error {
+	args, err := e.parse(args, 1)
+	if err != nil {
+		return err
+	}
+	id, err := e.requireID(args)
+	if err != nil {
+		return err
+	}
+
+	t, err := e.client().Request(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if e.output == "json" {
+		archived, err := e.archive(ctx, t)
+		if err != nil {
+			return err
+		}
+		return e.json(archived)
+	}
+
+	if t.Request.BodyRef != "" {
+		if t.Request.Body, err = e.client().Body(ctx, id, "request"); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(e.stdout, "%s %s %s\n", t.Request.Method, model.BuildURL(t.Request), t.Request.Version)
+	writeHeaders(e.stdout, t.Request.Headers)
+	if len(t.Request.Body) > 0 {
+		fmt.Fprintf(e.stdout, "\n%s\n", t.Request.Body)
+	}
+	fmt.Fprintf(e.stdout, "\nHTTP %d\n", t.Response.Status)
+	writeHeaders(e.stdout, t.Response.Headers)
+	if t.ParentID != nil {
+		fmt.Fprintf(e.stdout, "\nrepeat of %v\n", t.ParentID)
+	}
+	return nil
+}
+
+// archive loads the bodies left out of the transaction json, so that the
+// json output can be imported with them.
+func (e *env) archive(ctx context.Context, t *model.Transaction) (*model.ArchivedTransaction, error) {
+	id := fmt.Sprint(t.ID)
+
+	if t.Request.BodyRef != "" {
+		body, err := e.client().Body(ctx, id, "request")
+		if err != nil {
+			return nil, err
+		}
+		t.Request.Body, t.Request.BodyRef, t.Request.BodySize = body, "", 0
+	}
+
+	body, err := e.client().Body(ctx, id, "response")
+	if err != nil {
+		return nil, err
+	}
+	t.Response.BodyRef, t.Response.BodySize = "", 0
+
+	return &model.ArchivedTransaction{Transaction: t, ResponseBody: body}, nil
+}
+
+func runRepeat(ctx context.Context, e *env, args []string) error {
+	var headers, cookies stringList
+	method := e.flags.String("method", "", "replace the method")
+	rawURL := e.flags.String("url", "", "replace the url")
+	data := e.flags.String("d", "", "replace the body, @file reads it from a file")
+	e.flags.Var(&headers, "H", "set a header as 'Name: value', an empty value removes it, repeatable")
+	e.flags.Var(&cookies, "b", "set a cookie as 'name=value', an empty value removes it, repeatable")
+
+	args, err := e.parse(args, 1)
+	if err != nil {
+		return err
+	}
+	id, err := e.requireID(args)
+	if err != nil {
+		return err
+	}
+
+	patch := &apiclient.RepeatPatch{}
+	if *method != "" {
+		patch.Method = method
+	}
+	if *rawURL != "" {
+		patch.URL = rawURL
+	}
+	if *data != "" {
+		body := *data
+		if path, found := strings.CutPrefix(body, "@"); found {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			body = string(content)
+		}
+		patch.Body = &body
+	}
+	if patch.Headers, err = parsePairs(headers, ":"); err != nil {
+		return err
+	}
+	if patch.Cookies, err = parsePairs(cookies, "="); err != nil {
+		return err
+	}
+
+	result, err := e.client().Repeat(ctx, id, patch)
+	if err != nil {
+		return err
+	}
+
+	if e.output == "json" {
+		return e.json(map[string]any{
+			"transaction_id": result.TransactionID,
+			"status":         result.Status,
+			"headers":        result.Header,
+			"body":           string(result.Body),
+		})
+	}
+
+	fmt.Fprintf(e.stdout, "HTTP %d\n", result.Status)
+	if result.TransactionID != "" {
+		fmt.Fprintf(e.stdout, "stored as %s\n", result.TransactionID)
+	}
+	return nil
+}
+
+func runScan(ctx context.Context, e *env, args []string) error {
+	args, err := e.parse(args, 1)
+	if err != nil {
+		return err
+	}
+	id, err := e.requireID(args)
+	if err != nil {
+		return err
+	}
+
+	report, err := e.client().Scan(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if e.output == "json" {
+		if err := e.json(report); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintln(e.stdout, report.Result)
+		names := make([]string, 0, len(report.Vulnerabilities))
+		for name := range report.Vulnerabilities {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(e.stdout, "\n%s\n", name)
+			for _, point := range report.Vulnerabilities[name] {
+				fmt.Fprintf(e.stdout, "  %s\n", point)
+			}
+		}
+	}
+
+	if len(report.Vulnerabilities) > 0 {
+		return errVulnerable
+	}
+	return nil
+}
+
+func runExport(ctx context.Context, e *env, args []string) error {
+	format := e.flags.String("format", "curl", "curl, raw, go, python or js")
+	args, err := e.parse(args, 1)
+	if err != nil {
+		return err
+	}
+	id, err := e.requireID(args)
+	if err != nil {
+		return err
+	}
+
+	rendered, err := e.client().Export(ctx, id, *format)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(e.stdout, rendered)
+	return err
+}
+
+func runImport(ctx context.Context, e *env, args []string) error {
+	args, err := e.parse(args, 1)
+	if err != nil {
+		return err
+	}
+
+	input := io.Reader(os.Stdin)
+	if len(args) == 1 && args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	content, err := io.ReadAll(input)
+	if err != nil {
+		return err
+	}
+
+	// accept both a list and a single transaction
+	var transactions []*model.ArchivedTransaction
+	if err := json.Unmarshal(content, &transactions); err != nil {
+		var single model.ArchivedTransaction
+		if err := json.Unmarshal(content, &single); err != nil {
+			return fmt.Errorf("decode transactions: %w", err)
+		}
+		transactions = []*model.ArchivedTransaction{&single}
+	}
+
+	result, err := e.client().Import(ctx, transactions)
+	if err != nil {
+		return err
+	}
+
+	if e.output == "json" {
+		return e.json(result)
+	}
+
+	fmt.Fprintf(e.stdout, "imported %d requests\n", result.Imported)
+	return nil
+}
+
+func runCA(ctx context.Context, e *env, args []string) error {
+	format := e.flags.String("format", "pem", "pem or der")
+	out := e.flags.String("out", "", "write to this file instead of stdout")
+	if _, err := e.parse(args, 0); err != nil {
+		return err
+	}
+
+	cert, err := e.client().CA(ctx, *format)
+	if err != nil {
+		return err
+	}
+
+	if *out != "" {
+		return os.WriteFile(*out, cert, 0644)
+	}
+
+	_, err = e.stdout.Write(cert)
+	return err
+}
+
+func runCerts(ctx context.Context, e *env, args []string) error {
+	args, err := e.parse(args, 2)
+	if err != nil {
+		return err
+	}
+
+	var info *certstore.Info
+	switch {
+	case len(args) == 0:
+		certs, err := e.client().Certs(ctx)
+		if err != nil {
+			return err
+		}
+		if e.output == "json" {
+			return e.json(certs)
+		}
+
+		w := e.table()
+		fmt.Fprintln(w, "HOST\tSERIAL\tNOT AFTER\tSHA256")
+		for _, cert := range certs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", cert.Host, cert.Serial, cert.NotAfter.Local().Format("2006-01-02 15:04"), cert.SHA256[:16])
+		}
+		return w.Flush()
+
+	case args[0] == "show" && len(args) == 2:
+		info, err = e.client().Cert(ctx, args[1])
+	case args[0] == "renew" && len(args) == 2:
+		info, err = e.client().RenewCert(ctx, args[1])
+	case args[0] == "revoke" && len(args) == 2:
+		if err := e.client().RevokeCert(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "revoked %s\n", args[1])
+		return nil
+	case args[0] == "ca" && len(args) == 1:
+		info, err = e.client().CAInfo(ctx)
+	case args[0] == "regenerate-ca" && len(args) == 1:
+		info, err = e.client().RegenerateCA(ctx)
+	default:
+		return &usageError{"expected show, renew or revoke <host>, ca or regenerate-ca"}
+	}
+	if err != nil {
+		return err
+	}
+
+	if e.output == "json" {
+		return e.json(info)
+	}
+
+	w := e.table()
+	if info.Host != "" {
+		fmt.Fprintf(w, "host:\t%s\n", info.Host)
+	}
+	fmt.Fprintf(w, "subject:\t%s\n", info.Subject)
+	fmt.Fprintf(w, "issuer:\t%s\n", info.Issuer)
+	fmt.Fprintf(w, "serial:\t%s\n", info.Serial)
+	if names := slices.Concat(info.DNSNames, info.IPAddresses); len(names) > 0 {
+		fmt.Fprintf(w, "names:\t%s\n", strings.Join(names, ", "))
+	}
+	fmt.Fprintf(w, "valid:\t%s - %s\n", info.NotBefore.Local().Format("2006-01-02 15:04"), info.NotAfter.Local().Format("2006-01-02 15:04"))
+	fmt.Fprintf(w, "sha256:\t%s\n", info.SHA256)
+	return w.Flush()
+}
+
+func runScope(ctx context.Context, e *env, args []string) error {
+	args, err := e.parse(args, 2)
+	if err != nil {
+		return err
+	}
+
+	var conf *scope.Config
+	switch {
+	case len(args) == 0:
+		if conf, err = e.client().Scope(ctx); err != nil {
+			return err
+		}
+
+	case args[0] == "set":
+		input := io.Reader(os.Stdin)
+		if len(args) == 2 && args[1] != "-" {
+			file, err := os.Open(args[1])
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			input = file
+		}
+
+		var replacement scope.Config
+		if err := json.NewDecoder(input).Decode(&replacement); err != nil {
+			return fmt.Errorf("decode scope: %w", err)
+		}
+		if conf, err = e.client().SetScope(ctx, &replacement); err != nil {
+			return err
+		}
+
+	case args[0] == "check" && len(args) == 2:
+		check, err := e.client().CheckScope(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		if e.output == "json" {
+			return e.json(check)
+		}
+		fmt.Fprintf(e.stdout, "in scope: %t\naction: %s\n", check.InScope, check.Action)
+		return nil
+
+	default:
+		return &usageError{"expected set [file] or check <url>"}
+	}
+
+	return e.writeScope(conf)
+}
+
+func runRules(ctx context.Context, e *env, args []string) error {
+	var rule scope.Rule
+	e.flags.StringVar(&rule.Scheme, "scheme", "", "scheme the rule matches, any when empty")
+	e.flags.StringVar(&rule.Host, "host", "", "exact host, *.domain for subdomains, ip or cidr, any when empty")
+	e.flags.IntVar(&rule.Port, "port", 0, "port the rule matches, any when 0")
+	e.flags.StringVar(&rule.Path, "path", "", "regular expression for the path, any when empty")
+	args, err := e.parse(args, 3)
+	if err != nil {
+		return err
+	}
+
+	conf, err := e.client().Scope(ctx)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return e.writeScope(conf)
+	}
+
+	if len(args) < 2 {
+		return &usageError{"expected add include|exclude or remove include|exclude <n>"}
+	}
+	var rules *[]scope.Rule
+	switch args[1] {
+	case "include":
+		rules = &conf.Include
+	case "exclude":
+		rules = &conf.Exclude
+	default:
+		return &usageError{fmt.Sprintf("unknown rule kind %q, expected include or exclude", args[1])}
+	}
+
+	switch {
+	case args[0] == "add" && len(args) == 2:
+		if rule == (scope.Rule{}) {
+			return &usageError{"a rule needs at least one of -scheme, -host, -port or -path"}
+		}
+		*rules = append(*rules, rule)
+
+	case args[0] == "remove" && len(args) == 3:
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 1 || n > len(*rules) {
+			return &usageError{fmt.Sprintf("no %s rule %s, rules are numbered from 1", args[1], args[2])}
+		}
+		*rules = slices.Delete(*rules, n-1, n)
+
+	default:
+		return &usageError{"expected add include|exclude or remove include|exclude <n>"}
+	}
+
+	if conf, err = e.client().SetScope(ctx, conf); err != nil {
+		return err
+	}
+	return e.writeScope(conf)
+}
+
+// writeScope prints the rules numbered within their kind, the numbers are
+// the ones rules remove takes.
+func (e *env) writeScope(conf *scope.Config) error {
+	if e.output == "json" {
+		return e.json(conf)
+	}
+
+	fmt.Fprintf(e.stdout, "out of scope: %s\n\n", conf.OutOfScope)
+	w := e.table()
+	fmt.Fprintln(w, "KIND\tN\tSCHEME\tHOST\tPORT\tPATH")
+	for _, list := range []struct {
+		kind  string
+		rules []scope.Rule
+	}{{"include", conf.Include}, {"exclude", conf.Exclude}} {
+		for i, rule := range list.rules {
+			port := "*"
+			if rule.Port != 0 {
+				port = fmt.Sprint(rule.Port)
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", list.kind, i+1, orAny(rule.Scheme), orAny(rule.Host), port, orAny(rule.Path))
+		}
+	}
+	return w.Flush()
+}
+
+func runAudit(ctx context.Context, e *env, args []string) error {
+	actor := e.flags.String("actor", "", "only calls made with this token")
+	limit := e.flags.Int("limit", 100, "show at most this many entries")
+	if _, err := e.parse(args, 0); err != nil {
+		return err
+	}
+	if *limit < 1 {
+		return &usageError{"limit must be positive"}
+	}
+
+	entries, err := e.client().Audit(ctx, *actor, *limit)
+	if err != nil {
+		return err
+	}
+
+	if e.output == "json" {
+		return e.json(entries)
+	}
+
+	w := e.table()
+	fmt.Fprintln(w, "TIME\tACTOR\tROLE\tMETHOD\tPATH\tSTATUS")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n",
+			entry.CreatedAt.Local().Format("2006-01-02 15:04:05"), entry.Actor, entry.Role, entry.Method, entry.Path, entry.Status)
+	}
+	return w.Flush()
+}
+
+func runReload(ctx context.Context, e *env, args []string) error {
+	if _, err := e.parse(args, 0); err != nil {
+		return err
+	}
+
+	// a partly applied reload comes with its result, it is shown before
+	// the error
+	result, err := e.client().Reload(ctx)
+	if result == nil {
+		return err
+	}
+
+	if e.output == "json" {
+		if jsonErr := e.json(result); jsonErr != nil {
+			return jsonErr
+		}
+		return err
+	}
+
+	w := e.table()
+	fmt.Fprintf(w, "applied:\t%s\n", strings.Join(result.Applied, ", "))
+	if len(result.RestartRequired) > 0 {
+		fmt.Fprintf(w, "restart required:\t%s\n", strings.Join(result.RestartRequired, ", "))
+	}
+	for _, failure := range result.Errors {
+		fmt.Fprintf(w, "failed:\t%s\n", failure)
+	}
+	if flushErr := w.Flush(); flushErr != nil {
+		return flushErr
+	}
+	return err
+}
+
+// runToken works offline, the token is shown once and only its hash goes
+// into the config.
+func runToken(ctx context.Context, e *env, args []string) error {
+	name := e.flags.String("name", "cli", "token name shown in the audit log")
+	role := e.flags.String("role", "viewer", "viewer, operator or admin")
+	if _, err := e.parse(args, 0); err != nil {
+		return err
+	}
+	if _, err := apiauth.ParseRole(*role); err != nil {
+		return &usageError{err.Error()}
+	}
+
+	token, err := apiauth.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	if e.output == "json" {
+		return e.json(map[string]string{
+			"name":  *name,
+			"role":  *role,
+			"token": token,
+			"hash":  apiauth.HashToken(token),
+		})
+	}
+
+	fmt.Fprintf(e.stdout, "token: %s\n\nadd to apiAuth.tokens:\n  - name: %s\n    hash: %s\n    role: %s\n",
+		token, *name, apiauth.HashToken(token), *role)
+	return nil
+}
+
+func orAny(value string) string {
+	if value == "" {
+		return "*"
+	}
+	return value
+}
+
+func parsePairs(pairs []string, separator string) (map[string]*string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	result := make(map[string]*string, len(pairs))
+	for _, pair := range pairs {
+		name, value, found := strings.Cut(pair, separator)
+		if !found {
+			return nil, &usageError{fmt.Sprintf("expected name%svalue, got %q", separator, pair)}
+		}
+
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if value == "" {
+			result[name] = nil
+		} else {
+			result[name] = &value
+		}
+	}
+
+	return result, nil
+}
+
+func writeHeaders(w io.Writer, headers model.Values) {
+	for _, k := range headers.Keys() {
+		for _, v := range headers[k] {
+			fmt.Fprintf(w, "%s: %s\n", k, v)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/daronenko/https-proxy/pkg/apiclient"
+)
+
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+// env holds the flags shared by all commands.
+type env struct {
+	flags   *flag.FlagSet
+	address string
+	token   string
+	caCert  string
+	cert    string
+	key     string
+	output  string
+	stdout  io.Writer
+	api     *apiclient.Client
+}
+
+func newEnv(name, usage string) *env {
+	e := &env{
+		flags:  flag.NewFlagSet(name, flag.ContinueOnError),
+		stdout: os.Stdout,
+	}
+
+	e.flags.StringVar(&e.address, "api", envOr("PROXY_API", "localhost:8000"), "api server address, also read from PROXY_API")
+	e.flags.StringVar(&e.token, "token", os.Getenv("PROXY_TOKEN"), "api token, also read from PROXY_TOKEN")
+	e.flags.StringVar(&e.caCert, "cacert", os.Getenv("PROXY_CACERT"), "ca certificate of the api server, enables https, also read from PROXY_CACERT")
+	e.flags.StringVar(&e.cert, "cert", os.Getenv("PROXY_CERT"), "client certificate for mtls, also read from PROXY_CERT")
+	e.flags.StringVar(&e.key, "key", os.Getenv("PROXY_KEY"), "client certificate key for mtls, also read from PROXY_KEY")
+	e.flags.StringVar(&e.output, "o", "table", "output format, table or json")
+	e.flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: proxyctl %s\n\n", usage)
+		e.flags.PrintDefaults()
+	}
+
+	return e
+}
+
+// parse parses flags placed before or after positional arguments and checks
+// their count.
+func (e *env) parse(args []string, positional int) ([]string, error) {
+	var rest []string
+	for {
+		if err := e.flags.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, &usageError{err.Error()}
+		}
+		if e.flags.NArg() == 0 {
+			break
+		}
+		rest = append(rest, e.flags.Arg(0))
+		args = e.flags.Args()[1:]
+	}
+
+	if len(rest) > positional {
+		return nil, &usageError{fmt.Sprintf("unexpected arguments %v", rest[positional:])}
+	}
+	if e.output != "table" && e.output != "json" {
+		return nil, &usageError{fmt.Sprintf("unknown output format %q", e.output)}
+	}
+
+	e.api = apiclient.New(e.address)
+	e.api.Token = e.token
+	if e.caCert != "" || e.cert != "" || e.key != "" {
+		if err := e.api.SetTLS(e.caCert, e.cert, e.key); err != nil {
+			return nil, err
+		}
+	}
+
+	return rest, nil
+}
+
+func (e *env) requireID(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", &usageError{"request id is required"}
+	}
+	return args[0], nil
+}
+
+func (e *env) client() *apiclient.Client {
+	return e.api
+}
+
+func (e *env) json(v any) error {
+	encoder := json.NewEncoder(e.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (e *env) table() *tabwriter.Writer {
+	return tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
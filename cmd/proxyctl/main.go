@@ -0,0 +1,100 @@
+// Command proxyctl is a command line client for the api server.
+//
+// Exit codes: 0 on success, 1 on api or network errors, 2 on usage errors
+// and 3 when a scan finds vulnerabilities.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+)
+
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+	exitVulnerable
+)
+
+var errVulnerable = errors.New("vulnerabilities found")
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, env *env, args []string) error
+}
+
+var commands = map[string]command{
+	"list":   {"list [-host h] [-method m] [-status s] [-user u] [-limit n]", runList},
+	"show":   {"show <id>", runShow},
+	"repeat": {"repeat <id> [-method m] [-url u] [-H 'K: V'] [-b 'k=v'] [-d body]", runRepeat},
+	"scan":   {"scan <id>", runScan},
+	"export": {"export <id> [-format curl|raw|go|python|js]", runExport},
+	"import": {"import [file], reads json written by list -o json or show -o json, bodies included", runImport},
+	"ca":     {"ca [-format pem|der] [-out file]", runCA},
+	"certs":  {"certs [show|renew|revoke <host> | ca | regenerate-ca], lists host certificates without arguments", runCerts},
+	"scope":  {"scope [set [file] | check <url>], prints the scope without arguments", runScope},
+	"rules":  {"rules [add include|exclude [-scheme s] [-host h] [-port n] [-path re] | remove include|exclude <n>], lists the scope rules without arguments", runRules},
+	"audit":  {"audit [-actor name] [-limit n]", runAudit},
+	"reload": {"reload, applies config.yaml of the server without a restart", runReload},
+	"token":  {"token [-name n] [-role viewer|operator|admin], prints a new api token and its config entry", runToken},
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	name := flag.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err := cmd.run(ctx, newEnv(name, cmd.usage), flag.Args()[1:])
+	switch {
+	case err == nil:
+		os.Exit(exitOK)
+	case errors.Is(err, errVulnerable):
+		os.Exit(exitVulnerable)
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(exitOK)
+	case errors.As(err, new(*usageError)):
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(exitUsage)
+	default:
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(exitError)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: proxyctl <command> [flags]\n\ncommands:\n")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+
+	fmt.Fprintf(os.Stderr, "\ncommon flags:\n")
+	fmt.Fprintf(os.Stderr, "  -api string    api server address (env PROXY_API, default localhost:8000)\n")
+	fmt.Fprintf(os.Stderr, "  -token string  api token (env PROXY_TOKEN)\n")
+	fmt.Fprintf(os.Stderr, "  -o string      output format, table or json (default table)\n")
+}
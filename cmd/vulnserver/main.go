@@ -0,0 +1,23 @@
+// Command vulnserver runs a deliberately vulnerable http server used to check
+// the scanners by hand. Never expose it outside of a local machine.
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+
+	"github.com/daronenko/https-proxy/internal/vulnserver"
+	"github.com/rs/zerolog/log"
+)
+
+func main() {
+	address := flag.String("address", "127.0.0.1:9000", "address to listen on")
+	root := flag.String("root", os.TempDir(), "directory the file handlers pretend to serve from")
+	flag.Parse()
+
+	log.Info().Msgf("starting vulnerable server on %s...", *address)
+	if err := http.ListenAndServe(*address, vulnserver.Handler(*root)); err != nil {
+		log.Fatal().Err(err).Msg("failed to start vulnerable server")
+	}
+}
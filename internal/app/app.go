@@ -8,8 +8,10 @@ import (
 	"github.com/daronenko/https-proxy/internal/app/config"
 	"github.com/daronenko/https-proxy/internal/httpserver"
 	"github.com/daronenko/https-proxy/internal/infra"
+	"github.com/daronenko/https-proxy/internal/metrics"
 	"github.com/daronenko/https-proxy/internal/services/api"
 	"github.com/daronenko/https-proxy/internal/services/proxy"
+	"github.com/daronenko/https-proxy/internal/tracing"
 	"github.com/daronenko/https-proxy/pkg/logger"
 	"go.uber.org/fx"
 )
@@ -29,6 +31,8 @@ func Options(extraOpts ...fx.Option) []fx.Option {
 		fx.WithLogger(logger.Fx),
 
 		infra.Module(),
+		metrics.Module(),
+		tracing.Module(),
 		httpserver.Module(),
 
 		proxy.Module(),
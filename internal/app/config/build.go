@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/daronenko/https-proxy/pkg/apiauth"
+	"github.com/daronenko/https-proxy/pkg/proxyauth"
+	"github.com/daronenko/https-proxy/pkg/scope"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Build converts the spec into the scope rules.
+func (s ScopeSpec) Build() scope.Config {
+	rules := func(specs []ScopeRuleSpec) []scope.Rule {
+		rules := make([]scope.Rule, 0, len(specs))
+		for _, spec := range specs {
+			rules = append(rules, scope.Rule(spec))
+		}
+		return rules
+	}
+
+	return scope.Config{
+		Include:    rules(s.Include),
+		Exclude:    rules(s.Exclude),
+		OutOfScope: scope.Action(s.OutOfScope),
+	}
+}
+
+// Build reads the htpasswd file and merges it with the configured users
+// and their projects.
+func (s ProxyAuthSpec) Build() (proxyauth.Config, error) {
+	users := map[string]string{}
+	if s.Htpasswd != "" {
+		var err error
+		if users, err = proxyauth.LoadHtpasswd(s.Htpasswd); err != nil {
+			return proxyauth.Config{}, fmt.Errorf("failed to load htpasswd: %w", err)
+		}
+	}
+
+	projects := map[string]string{}
+	for _, user := range s.Users {
+		if user.Project != "" {
+			if _, err := bson.ObjectIDFromHex(user.Project); err != nil {
+				return proxyauth.Config{}, fmt.Errorf("proxy user %q: invalid project id %q", user.Name, user.Project)
+			}
+			projects[user.Name] = user.Project
+		}
+		if user.PasswordHash != "" {
+			users[user.Name] = user.PasswordHash
+		} else if _, ok := users[user.Name]; !ok {
+			return proxyauth.Config{}, fmt.Errorf("proxy user %q has no password hash", user.Name)
+		}
+	}
+
+	allow := make([]*net.IPNet, 0, len(s.Allow))
+	for _, cidr := range s.Allow {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return proxyauth.Config{}, fmt.Errorf("invalid proxy allow entry %q", cidr)
+		}
+		allow = append(allow, network)
+	}
+
+	return proxyauth.Config{
+		Realm:    s.Realm,
+		Users:    users,
+		Projects: projects,
+		Allow:    allow,
+	}, nil
+}
+
+// Build parses the roles of the configured tokens.
+func (s ApiAuthSpec) Build() ([]apiauth.Token, error) {
+	tokens := make([]apiauth.Token, 0, len(s.Tokens))
+	for _, spec := range s.Tokens {
+		role, err := apiauth.ParseRole(spec.Role)
+		if err != nil {
+			return nil, fmt.Errorf("api token %q: %w", spec.Name, err)
+		}
+		tokens = append(tokens, apiauth.Token{Name: spec.Name, Hash: spec.Hash, Role: role})
+	}
+	return tokens, nil
+}
@@ -21,22 +21,34 @@ type Config struct {
 func New() (*Config, error) {
 	flag.Parse()
 
-	viper.SetConfigFile(configPath)
-	if err := viper.ReadInConfig(); err != nil {
+	return load(configPath)
+}
+
+// load reads and validates the file, environment variables win over it. A
+// fresh viper is used every time, overrides set by an earlier load would
+// hide changes to the file.
+func load(path string) (*Config, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
 		return nil, fmt.Errorf("failed to read config: %w", err)
 	}
 
-	viper.AutomaticEnv()
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	for _, key := range viper.AllKeys() {
-		value := viper.Get(key)
-		viper.Set(key, value)
+	v.AutomaticEnv()
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	for _, key := range v.AllKeys() {
+		value := v.Get(key)
+		v.Set(key, value)
 	}
 
 	conf := &Config{}
-	if err := viper.Unmarshal(conf); err != nil {
+	if err := v.Unmarshal(conf); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config:\n%w", err)
+	}
+
 	return conf, nil
 }
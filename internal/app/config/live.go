@@ -0,0 +1,31 @@
+package config
+
+import "sync/atomic"
+
+// Live holds a section that is applied on reload, readers always get the
+// last one that passed validation.
+type Live[T any] struct {
+	value atomic.Pointer[T]
+}
+
+// NewLive starts with the section of conf and follows its reloads, get
+// picks the section out of a config.
+func NewLive[T any](reloader *Reloader, section string, conf *Config, get func(*Config) T) *Live[T] {
+	l := &Live[T]{}
+	l.Set(get(conf))
+
+	reloader.OnChange(section, func(conf *Config) error {
+		l.Set(get(conf))
+		return nil
+	})
+
+	return l
+}
+
+func (l *Live[T]) Get() T {
+	return *l.value.Load()
+}
+
+func (l *Live[T]) Set(value T) {
+	l.value.Store(&value)
+}
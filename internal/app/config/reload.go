@@ -0,0 +1,244 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/daronenko/https-proxy/pkg/logger"
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// watchDelay lets editors finish writing, a save often fires several events
+const watchDelay = 500 * time.Millisecond
+
+// Reloader reads the config file again and hands the sections that changed
+// to their handlers. A file that fails validation is rejected as a whole.
+// Sections without a handler are only read on start, their changes are
+// reported as needing a restart and are not applied. The htpasswd file of
+// proxyAuth is watched too, a change to it applies proxyAuth again.
+type Reloader struct {
+	path string
+
+	mu       sync.Mutex
+	current  Config
+	handlers map[string][]func(*Config) error
+	timer    *time.Timer
+
+	files     *fsnotify.Watcher
+	htpasswd  string
+	fileTimer *time.Timer
+}
+
+// Result tells what a reload changed, sections are named as in the file.
+// Errors lists the sections that changed but failed to apply.
+type Result struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+	Errors          []string `json:"errors,omitempty"`
+}
+
+func NewReloader(conf *Config) *Reloader {
+	return &Reloader{
+		path:     configPath,
+		current:  *conf,
+		handlers: make(map[string][]func(*Config) error),
+	}
+}
+
+// OnChange registers apply for a section, it gets the whole new config once
+// the section changed. apply must not fail for a config that passed
+// validation but for reasons outside of it, like an unreadable file.
+func (r *Reloader) OnChange(section string, apply func(*Config) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[section] = append(r.handlers[section], apply)
+}
+
+// Reload reads the file and applies it. The sections that could not be
+// applied are returned with the error.
+func (r *Reloader) Reload() (*Result, error) {
+	conf, err := load(r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := &Result{Applied: []string{}, RestartRequired: []string{}}
+	var errs []error
+
+	current := reflect.ValueOf(&r.current.App).Elem()
+	next := reflect.ValueOf(&conf.App).Elem()
+	for i := range current.NumField() {
+		name := current.Type().Field(i).Tag.Get("mapstructure")
+		was, now := current.Field(i).Interface(), next.Field(i).Interface()
+		if reflect.DeepEqual(was, now) {
+			continue
+		}
+
+		handlers := r.handlers[name]
+		if len(handlers) == 0 || !reflect.DeepEqual(restartPart(was), restartPart(now)) {
+			result.RestartRequired = append(result.RestartRequired, name)
+			continue
+		}
+
+		var failed bool
+		for _, apply := range handlers {
+			if err := apply(conf); err != nil {
+				err = fmt.Errorf("%s: %w", name, err)
+				errs = append(errs, err)
+				result.Errors = append(result.Errors, err.Error())
+				failed = true
+			}
+		}
+		if failed {
+			continue
+		}
+
+		// only what is applied is remembered, a section waiting for a
+		// restart is reported again on the next reload
+		current.Field(i).Set(next.Field(i))
+		result.Applied = append(result.Applied, name)
+	}
+	r.followHtpasswd()
+
+	return result, errors.Join(errs...)
+}
+
+// Reapply runs the handlers of a section with the running config, for
+// sections that read files of their own.
+func (r *Reloader) Reapply(section string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conf := r.current
+	var errs []error
+	for _, apply := range r.handlers[section] {
+		if err := apply(&conf); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", section, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Watch reloads the config whenever the file changes, for as long as the
+// process runs.
+func (r *Reloader) Watch() {
+	v := viper.New()
+	v.SetConfigFile(r.path)
+	v.OnConfigChange(func(fsnotify.Event) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if r.timer != nil {
+			r.timer.Stop()
+		}
+		r.timer = time.AfterFunc(watchDelay, r.reloadChanged)
+	})
+	v.WatchConfig()
+
+	files, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Err(err).Msg("failed to watch the htpasswd file, it is read on reload only")
+		return
+	}
+
+	r.mu.Lock()
+	r.files = files
+	r.followHtpasswd()
+	r.mu.Unlock()
+
+	go r.watchFiles(files)
+}
+
+// followHtpasswd moves the watch to the htpasswd file of the running config.
+// The directory is watched, editors replace the file rather than write it.
+func (r *Reloader) followHtpasswd() {
+	path := r.current.App.ProxyAuth.Htpasswd
+	if r.files == nil || path == r.htpasswd {
+		return
+	}
+
+	if r.htpasswd != "" {
+		_ = r.files.Remove(filepath.Dir(r.htpasswd))
+	}
+	r.htpasswd = path
+	if path == "" {
+		return
+	}
+	if err := r.files.Add(filepath.Dir(path)); err != nil {
+		log.Err(err).Str("path", path).Msg("failed to watch the htpasswd file, it is read on reload only")
+	}
+}
+
+func (r *Reloader) watchFiles(files *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-files.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Chmod) {
+				continue
+			}
+
+			r.mu.Lock()
+			if r.htpasswd != "" && filepath.Clean(event.Name) == filepath.Clean(r.htpasswd) {
+				if r.fileTimer != nil {
+					r.fileTimer.Stop()
+				}
+				r.fileTimer = time.AfterFunc(watchDelay, r.reloadHtpasswd)
+			}
+			r.mu.Unlock()
+		case err, ok := <-files.Errors:
+			if !ok {
+				return
+			}
+			log.Err(err).Msg("htpasswd watch failed")
+		}
+	}
+}
+
+func (r *Reloader) reloadHtpasswd() {
+	if err := r.Reapply("proxyAuth"); err != nil {
+		log.Err(err).Msg("htpasswd changed but was rejected, keeping the running users")
+		return
+	}
+	log.Info().Msg("htpasswd reloaded")
+}
+
+func (r *Reloader) reloadChanged() {
+	result, err := r.Reload()
+	if result == nil {
+		log.Err(err).Str("path", r.path).Msg("config changed but was rejected, keeping the running one")
+		return
+	}
+	if err != nil {
+		log.Err(err).Msg("failed to apply part of the config")
+	}
+	if len(result.Applied) > 0 {
+		log.Info().Strs("sections", result.Applied).Msg("config reloaded")
+	}
+	if len(result.RestartRequired) > 0 {
+		log.Warn().Strs("sections", result.RestartRequired).Msg("config changes need a restart")
+	}
+}
+
+// restartPart drops the fields of a section that are applied live, what is
+// left needs a restart to change. Only the logger is applied in part.
+func restartPart(section any) any {
+	switch section := section.(type) {
+	case logger.Config:
+		section.Level, section.TraceLevel = "", false
+		return section
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// copyConfig puts the example config into a temp dir with the first match
+// of every old, new pair replaced, the paths it refers to are moved into the
+// dir.
+func copyConfig(t *testing.T, replace ...string) string {
+	t.Helper()
+
+	data, err := os.ReadFile("../../../config/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(replace); i += 2 {
+		data = bytes.Replace(data, []byte(replace[i]), []byte(replace[i+1]), 1)
+	}
+
+	dir := t.TempDir()
+	for _, sub := range []string{"certs", "spill", "blobs"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		data = bytes.ReplaceAll(data, []byte(": /"+sub), []byte(": "+filepath.Join(dir, sub)))
+	}
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReloadReportsFailures(t *testing.T) {
+	conf, err := load(copyConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReloader(conf)
+	r.OnChange("logger", func(*Config) error { return errors.New("level is stuck") })
+
+	r.path = copyConfig(t, "level: trace", "level: info")
+	result, err := r.Reload()
+	if err == nil {
+		t.Fatal("Reload() hid the failed section")
+	}
+	if result == nil {
+		t.Fatal("Reload() dropped the result of a partly applied config")
+	}
+	if slices.Contains(result.Applied, "logger") {
+		t.Errorf("applied = %v, logger failed", result.Applied)
+	}
+	if len(result.Errors) != 1 || result.Errors[0] != "logger: level is stuck" {
+		t.Errorf("errors = %q", result.Errors)
+	}
+}
+
+func TestReloadLiveSections(t *testing.T) {
+	conf, err := load(copyConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReloader(conf)
+	scanner := NewLive(r, "scanner", conf, func(conf *Config) ScannerSpec { return conf.App.Scanner })
+
+	r.path = copyConfig(t,
+		`";cat /etc/passwd;"`, `";id;"`,
+		"maxConnections: 0", "maxConnections: 100",
+	)
+	result, err := r.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(result.Applied, []string{"scanner"}) {
+		t.Errorf("applied = %v, want [scanner]", result.Applied)
+	}
+	// the copy lives in another dir, so the paths of queue and blobs
+	// changed too
+	if !slices.Contains(result.RestartRequired, "proxyServer") {
+		t.Errorf("restart required = %v, the proxy limits are missing", result.RestartRequired)
+	}
+	if payloads := scanner.Get().CmdInjection.Payloads; payloads[0] != ";id;" {
+		t.Errorf("scanner payloads = %q, the reload was not applied", payloads)
+	}
+}
+
+func TestWatchHtpasswd(t *testing.T) {
+	dir := t.TempDir()
+	htpasswd := filepath.Join(dir, "htpasswd")
+	if err := os.WriteFile(htpasswd, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &Config{}
+	conf.App.ProxyAuth.Htpasswd = htpasswd
+	r := NewReloader(conf)
+	r.path = copyConfig(t)
+
+	applied := make(chan string, 10)
+	r.OnChange("proxyAuth", func(conf *Config) error {
+		applied <- conf.App.ProxyAuth.Htpasswd
+		return nil
+	})
+	r.Watch()
+
+	if err := os.WriteFile(htpasswd, []byte("user:$2y$05$hash\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case path := <-applied:
+		if path != htpasswd {
+			t.Errorf("proxyAuth applied with htpasswd %q, want %q", path, htpasswd)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxyAuth was not applied after the htpasswd file changed")
+	}
+}
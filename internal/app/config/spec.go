@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/daronenko/https-proxy/pkg/logger"
 )
 
@@ -9,16 +11,86 @@ type Spec struct {
 	ApiServer   HttpServerSpec `mapstructure:"apiServer"`
 	Logger      logger.Config  `mapstructure:"logger"`
 	Mongo       MongoSpec      `mapstructure:"mongo"`
+	Scanner     ScannerSpec    `mapstructure:"scanner"`
+	Oob         OobSpec        `mapstructure:"oob"`
+	Fuzzer      FuzzerSpec     `mapstructure:"fuzzer"`
+	Tracing     TracingSpec    `mapstructure:"tracing"`
+	Queue       QueueSpec      `mapstructure:"queue"`
+	Retention   RetentionSpec  `mapstructure:"retention"`
+	Blobs       BlobSpec       `mapstructure:"blobs"`
+	Scope       ScopeSpec      `mapstructure:"scope"`
+	ProxyAuth   ProxyAuthSpec  `mapstructure:"proxyAuth"`
+	ApiAuth     ApiAuthSpec    `mapstructure:"apiAuth"`
+	Onboarding  OnboardingSpec `mapstructure:"onboarding"`
+	Certs       CertsSpec      `mapstructure:"certs"`
 }
 
 type HttpServerSpec struct {
-	Address string  `mapstructure:"address"`
-	TLS     TLSSpec `mapstructure:"tls"`
+	Address string     `mapstructure:"address"`
+	TLS     TLSSpec    `mapstructure:"tls"`
+	Limits  LimitsSpec `mapstructure:"limits"`
+}
+
+const (
+	defaultQueueTimeout   = 10 * time.Second
+	defaultHeaderTimeout  = 30 * time.Second
+	defaultIdleTimeout    = 90 * time.Second
+	defaultRequestTimeout = 5 * time.Minute
+)
+
+type LimitsSpec struct {
+	// MaxConnections caps the client connections served at once, zero is
+	// unlimited. Up to MaxQueued more wait QueueTimeout for a free slot,
+	// the rest are answered with 503
+	MaxConnections int           `mapstructure:"maxConnections"`
+	MaxQueued      int           `mapstructure:"maxQueued"`
+	QueueTimeout   time.Duration `mapstructure:"queueTimeout"`
+
+	// HeaderTimeout bounds reading the headers of a request from the client
+	// and of the response from the upstream
+	HeaderTimeout time.Duration `mapstructure:"headerTimeout"`
+	// IdleTimeout closes kept alive connections and tunnels without traffic
+	IdleTimeout time.Duration `mapstructure:"idleTimeout"`
+	// RequestTimeout bounds a request from its headers to the end of the
+	// response, tunnels are only bounded by IdleTimeout
+	RequestTimeout time.Duration `mapstructure:"requestTimeout"`
+}
+
+// OrDefaults fills in the timeouts left at zero.
+func (s LimitsSpec) OrDefaults() LimitsSpec {
+	if s.QueueTimeout <= 0 {
+		s.QueueTimeout = defaultQueueTimeout
+	}
+	if s.HeaderTimeout <= 0 {
+		s.HeaderTimeout = defaultHeaderTimeout
+	}
+	if s.IdleTimeout <= 0 {
+		s.IdleTimeout = defaultIdleTimeout
+	}
+	if s.RequestTimeout <= 0 {
+		s.RequestTimeout = defaultRequestTimeout
+	}
+	return s
 }
 
 type TLSSpec struct {
-	CertPath string `mapstructure:"certPath"`
-	KeyPath  string `mapstructure:"keyPath"`
+	CertPath   string `mapstructure:"certPath"`
+	KeyPath    string `mapstructure:"keyPath"`
+	CACertPath string `mapstructure:"caCertPath"`
+	CAKeyPath  string `mapstructure:"caKeyPath"`
+
+	// Auto mints the server certificate for Hosts with the proxy CA instead
+	// of reading CertPath and KeyPath
+	Auto  bool     `mapstructure:"auto"`
+	Hosts []string `mapstructure:"hosts"`
+
+	// ClientCAPath makes client certificates signed by this CA required
+	ClientCAPath string `mapstructure:"clientCaPath"`
+}
+
+// Enabled reports whether the server speaks tls.
+func (s TLSSpec) Enabled() bool {
+	return s.Auto || s.CertPath != ""
 }
 
 type MongoSpec struct {
@@ -31,4 +103,135 @@ type MongoSpec struct {
 
 type MongoCollectionsSpec struct {
 	Transactions string `mapstructure:"transactions"`
+	Blobs        string `mapstructure:"blobs"`
+	Projects     string `mapstructure:"projects"`
+	Audit        string `mapstructure:"audit"`
+}
+
+type ScannerSpec struct {
+	CmdInjection  CmdInjectionSpec  `mapstructure:"cmdInjection"`
+	PathTraversal PathTraversalSpec `mapstructure:"pathTraversal"`
+	Blind         BlindSpec         `mapstructure:"blind"`
+}
+
+type CmdInjectionSpec struct {
+	Payloads []string `mapstructure:"payloads"`
+}
+
+type PathTraversalSpec struct {
+	Payloads   []string `mapstructure:"payloads"`
+	Signatures []string `mapstructure:"signatures"`
+}
+
+type BlindSpec struct {
+	Wait         time.Duration `mapstructure:"wait"`
+	SSRF         []string      `mapstructure:"ssrf"`
+	CmdInjection []string      `mapstructure:"cmdInjection"`
+	XXE          []string      `mapstructure:"xxe"`
+}
+
+type OobSpec struct {
+	Enabled       bool   `mapstructure:"enabled"`
+	HttpAddress   string `mapstructure:"httpAddress"`
+	DnsAddress    string `mapstructure:"dnsAddress"`
+	Domain        string `mapstructure:"domain"`
+	PublicAddress string `mapstructure:"publicAddress"`
+	PublicIP      string `mapstructure:"publicIP"`
+}
+
+type FuzzerSpec struct {
+	MaxRequests    int           `mapstructure:"maxRequests"`
+	MaxConcurrency int           `mapstructure:"maxConcurrency"`
+	Timeout        time.Duration `mapstructure:"timeout"`
+	PayloadDir     string        `mapstructure:"payloadDir"`
+}
+
+type TracingSpec struct {
+	Enabled     bool    `mapstructure:"enabled"`
+	Endpoint    string  `mapstructure:"endpoint"`
+	Insecure    bool    `mapstructure:"insecure"`
+	ServiceName string  `mapstructure:"serviceName"`
+	SampleRatio float64 `mapstructure:"sampleRatio"`
+}
+
+type QueueSpec struct {
+	Capacity        int           `mapstructure:"capacity"`
+	BatchSize       int           `mapstructure:"batchSize"`
+	FlushInterval   time.Duration `mapstructure:"flushInterval"`
+	MaxRetries      int           `mapstructure:"maxRetries"`
+	RetryBackoff    time.Duration `mapstructure:"retryBackoff"`
+	MaxRetryBackoff time.Duration `mapstructure:"maxRetryBackoff"`
+	SpillPath       string        `mapstructure:"spillPath"`
+	SpillMaxBytes   int64         `mapstructure:"spillMaxBytes"`
+}
+
+type RetentionSpec struct {
+	MaxAge        time.Duration       `mapstructure:"maxAge"`
+	MaxCount      int64               `mapstructure:"maxCount"`
+	MaxBytes      int64               `mapstructure:"maxBytes"`
+	PruneInterval time.Duration       `mapstructure:"pruneInterval"`
+	Hosts         []HostRetentionSpec `mapstructure:"hosts"`
+}
+
+type HostRetentionSpec struct {
+	Host     string        `mapstructure:"host"`
+	MaxAge   time.Duration `mapstructure:"maxAge"`
+	MaxCount int64         `mapstructure:"maxCount"`
+}
+
+type BlobSpec struct {
+	Path       string        `mapstructure:"path"`
+	Threshold  int           `mapstructure:"threshold"`
+	GcInterval time.Duration `mapstructure:"gcInterval"`
+	GcGrace    time.Duration `mapstructure:"gcGrace"`
+}
+
+type ScopeSpec struct {
+	Include    []ScopeRuleSpec `mapstructure:"include"`
+	Exclude    []ScopeRuleSpec `mapstructure:"exclude"`
+	OutOfScope string          `mapstructure:"outOfScope"`
+}
+
+type ScopeRuleSpec struct {
+	Scheme string `mapstructure:"scheme"`
+	Host   string `mapstructure:"host"`
+	Port   int    `mapstructure:"port"`
+	Path   string `mapstructure:"path"`
+}
+
+type ProxyAuthSpec struct {
+	Realm    string          `mapstructure:"realm"`
+	Htpasswd string          `mapstructure:"htpasswd"`
+	Users    []ProxyUserSpec `mapstructure:"users"`
+	Allow    []string        `mapstructure:"allow"`
+}
+
+type ProxyUserSpec struct {
+	Name         string `mapstructure:"name"`
+	PasswordHash string `mapstructure:"passwordHash"`
+	Project      string `mapstructure:"project"`
+}
+
+type ApiAuthSpec struct {
+	Tokens []ApiTokenSpec `mapstructure:"tokens"`
+}
+
+type ApiTokenSpec struct {
+	Name string `mapstructure:"name"`
+	Hash string `mapstructure:"hash"`
+	Role string `mapstructure:"role"`
+}
+
+type OnboardingSpec struct {
+	Host         string `mapstructure:"host"`
+	ProxyAddress string `mapstructure:"proxyAddress"`
+}
+
+type CertsSpec struct {
+	Validity    time.Duration `mapstructure:"validity"`
+	RenewBefore time.Duration `mapstructure:"renewBefore"`
+	CAValidity  time.Duration `mapstructure:"caValidity"`
+	Wildcard    bool          `mapstructure:"wildcard"`
+	Mimic       bool          `mapstructure:"mimic"`
+	MaxMimics   int           `mapstructure:"maxMimics"`
 }
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/daronenko/https-proxy/pkg/apiauth"
+	"github.com/daronenko/https-proxy/pkg/logger"
+	"github.com/daronenko/https-proxy/pkg/proxyauth"
+	"github.com/daronenko/https-proxy/pkg/scope"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Validate checks the whole config and reports every problem at once, each
+// prefixed with the key it is about.
+func (c *Config) Validate() error {
+	v := &validator{}
+	spec := c.App
+
+	v.address("proxyServer.address", spec.ProxyServer.Address, true)
+	v.proxyTLS("proxyServer.tls", spec.ProxyServer.TLS)
+	v.limits("proxyServer.limits", spec.ProxyServer.Limits)
+	v.address("apiServer.address", spec.ApiServer.Address, true)
+	v.apiTLS("apiServer.tls", spec.ApiServer.TLS)
+	v.limits("apiServer.limits", spec.ApiServer.Limits)
+
+	if _, err := logger.ParseLevel(&spec.Logger); err != nil {
+		v.fail("logger.level", err)
+	}
+
+	v.required("mongo.uri", spec.Mongo.URI)
+	if spec.Mongo.URI != "" && !strings.HasPrefix(spec.Mongo.URI, "mongodb://") && !strings.HasPrefix(spec.Mongo.URI, "mongodb+srv://") {
+		v.fail("mongo.uri", errors.New("must start with mongodb:// or mongodb+srv://"))
+	}
+	v.required("mongo.database", spec.Mongo.Database)
+	v.required("mongo.collections.transactions", spec.Mongo.Collections.Transactions)
+	v.required("mongo.collections.blobs", spec.Mongo.Collections.Blobs)
+	v.required("mongo.collections.projects", spec.Mongo.Collections.Projects)
+	v.required("mongo.collections.audit", spec.Mongo.Collections.Audit)
+
+	v.duration("scanner.blind.wait", spec.Scanner.Blind.Wait)
+
+	if spec.Oob.Enabled {
+		v.address("oob.httpAddress", spec.Oob.HttpAddress, true)
+		v.address("oob.dnsAddress", spec.Oob.DnsAddress, true)
+		v.required("oob.domain", spec.Oob.Domain)
+		v.address("oob.publicAddress", spec.Oob.PublicAddress, false)
+		if spec.Oob.PublicIP != "" && net.ParseIP(spec.Oob.PublicIP) == nil {
+			v.fail("oob.publicIP", fmt.Errorf("%q is not an ip address", spec.Oob.PublicIP))
+		}
+	}
+
+	v.count("fuzzer.maxRequests", int64(spec.Fuzzer.MaxRequests))
+	v.count("fuzzer.maxConcurrency", int64(spec.Fuzzer.MaxConcurrency))
+	v.duration("fuzzer.timeout", spec.Fuzzer.Timeout)
+	if spec.Fuzzer.PayloadDir != "" {
+		v.dir("fuzzer.payloadDir", spec.Fuzzer.PayloadDir)
+	}
+
+	if spec.Tracing.Enabled {
+		v.required("tracing.endpoint", spec.Tracing.Endpoint)
+	}
+	if spec.Tracing.SampleRatio < 0 || spec.Tracing.SampleRatio > 1 {
+		v.fail("tracing.sampleRatio", errors.New("must be between 0 and 1"))
+	}
+
+	v.count("queue.capacity", int64(spec.Queue.Capacity))
+	v.count("queue.batchSize", int64(spec.Queue.BatchSize))
+	v.duration("queue.flushInterval", spec.Queue.FlushInterval)
+	v.count("queue.maxRetries", int64(spec.Queue.MaxRetries))
+	v.duration("queue.retryBackoff", spec.Queue.RetryBackoff)
+	v.duration("queue.maxRetryBackoff", spec.Queue.MaxRetryBackoff)
+	v.count("queue.spillMaxBytes", spec.Queue.SpillMaxBytes)
+
+	v.duration("retention.maxAge", spec.Retention.MaxAge)
+	v.count("retention.maxCount", spec.Retention.MaxCount)
+	v.count("retention.maxBytes", spec.Retention.MaxBytes)
+	v.duration("retention.pruneInterval", spec.Retention.PruneInterval)
+	for i, host := range spec.Retention.Hosts {
+		key := fmt.Sprintf("retention.hosts[%d]", i)
+		v.required(key+".host", host.Host)
+		v.duration(key+".maxAge", host.MaxAge)
+		v.count(key+".maxCount", host.MaxCount)
+	}
+
+	v.count("blobs.threshold", int64(spec.Blobs.Threshold))
+	v.duration("blobs.gcInterval", spec.Blobs.GcInterval)
+	v.duration("blobs.gcGrace", spec.Blobs.GcGrace)
+
+	if _, err := scope.New(spec.Scope.Build()); err != nil {
+		v.fail("scope", err)
+	}
+
+	if auth, err := spec.ProxyAuth.Build(); err != nil {
+		v.fail("proxyAuth", err)
+	} else if _, err := proxyauth.New(auth); err != nil {
+		v.fail("proxyAuth", err)
+	}
+	for i, user := range spec.ProxyAuth.Users {
+		key := fmt.Sprintf("proxyAuth.users[%d]", i)
+		v.required(key+".name", user.Name)
+		if _, err := bson.ObjectIDFromHex(user.Project); user.Project != "" && err != nil {
+			v.fail(key+".project", fmt.Errorf("invalid project id %q", user.Project))
+		}
+	}
+
+	if tokens, err := spec.ApiAuth.Build(); err != nil {
+		v.fail("apiAuth", err)
+	} else if _, err := apiauth.New(tokens); err != nil {
+		v.fail("apiAuth", err)
+	}
+
+	v.address("onboarding.proxyAddress", spec.Onboarding.ProxyAddress, false)
+
+	v.duration("certs.validity", spec.Certs.Validity)
+	v.duration("certs.renewBefore", spec.Certs.RenewBefore)
+	v.duration("certs.caValidity", spec.Certs.CAValidity)
+	v.count("certs.maxMimics", int64(spec.Certs.MaxMimics))
+	if spec.Certs.Validity > 0 && spec.Certs.RenewBefore >= spec.Certs.Validity {
+		v.fail("certs.renewBefore", errors.New("must be shorter than certs.validity"))
+	}
+
+	return errors.Join(v.errs...)
+}
+
+type validator struct {
+	errs []error
+}
+
+func (v *validator) fail(key string, err error) {
+	v.errs = append(v.errs, fmt.Errorf("app.%s: %w", key, err))
+}
+
+func (v *validator) required(key, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.fail(key, errors.New("is required"))
+	}
+}
+
+func (v *validator) duration(key string, d time.Duration) {
+	if d < 0 {
+		v.fail(key, errors.New("must not be negative"))
+	}
+}
+
+func (v *validator) count(key string, n int64) {
+	if n < 0 {
+		v.fail(key, errors.New("must not be negative"))
+	}
+}
+
+// address checks a host:port pair, the host may be empty to listen on every
+// interface.
+func (v *validator) address(key, address string, required bool) {
+	if address == "" {
+		if required {
+			v.fail(key, errors.New("is required"))
+		}
+		return
+	}
+
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		v.fail(key, fmt.Errorf("%q is not host:port", address))
+		return
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		v.fail(key, fmt.Errorf("invalid port %q", port))
+	}
+}
+
+// proxyTLS checks the paths of the certificate store, missing files are
+// created on start but their directories have to exist.
+func (v *validator) proxyTLS(key string, spec TLSSpec) {
+	v.required(key+".certPath", spec.CertPath)
+	files := []struct{ name, path string }{
+		{"keyPath", spec.KeyPath},
+		{"caCertPath", spec.CACertPath},
+		{"caKeyPath", spec.CAKeyPath},
+	}
+	for _, file := range files {
+		if file.path == "" {
+			v.fail(key+"."+file.name, errors.New("is required"))
+			continue
+		}
+		if _, err := os.Stat(filepath.Dir(file.path)); err != nil {
+			v.fail(key+"."+file.name, fmt.Errorf("directory of %s does not exist", file.path))
+		}
+	}
+
+	// a CA without its key, or the other way round, is not recreated
+	if spec.CACertPath != "" && spec.CAKeyPath != "" && exists(spec.CACertPath) != exists(spec.CAKeyPath) {
+		v.fail(key, errors.New("caCertPath and caKeyPath must both exist or both be missing"))
+	}
+}
+
+func (v *validator) apiTLS(key string, spec TLSSpec) {
+	if !spec.Enabled() {
+		if spec.KeyPath != "" || spec.ClientCAPath != "" {
+			v.fail(key, errors.New("certPath or auto is required to serve tls"))
+		}
+		return
+	}
+
+	if spec.Auto {
+		if len(spec.Hosts) == 0 {
+			v.fail(key+".hosts", errors.New("is required to mint a certificate"))
+		}
+	} else {
+		v.file(key+".certPath", spec.CertPath)
+		v.required(key+".keyPath", spec.KeyPath)
+		if spec.KeyPath != "" {
+			v.file(key+".keyPath", spec.KeyPath)
+		}
+	}
+	if spec.ClientCAPath != "" {
+		v.file(key+".clientCaPath", spec.ClientCAPath)
+	}
+}
+
+func (v *validator) limits(key string, spec LimitsSpec) {
+	v.count(key+".maxConnections", int64(spec.MaxConnections))
+	v.count(key+".maxQueued", int64(spec.MaxQueued))
+	v.duration(key+".queueTimeout", spec.QueueTimeout)
+	v.duration(key+".headerTimeout", spec.HeaderTimeout)
+	v.duration(key+".idleTimeout", spec.IdleTimeout)
+	v.duration(key+".requestTimeout", spec.RequestTimeout)
+	if spec.MaxQueued > 0 && spec.MaxConnections == 0 {
+		v.fail(key+".maxQueued", errors.New("needs maxConnections"))
+	}
+}
+
+func (v *validator) file(key, path string) {
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		v.fail(key, fmt.Errorf("%s does not exist", path))
+	case info.IsDir():
+		v.fail(key, fmt.Errorf("%s is a directory", path))
+	}
+}
+
+func (v *validator) dir(key, path string) {
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		v.fail(key, fmt.Errorf("%s does not exist", path))
+	case !info.IsDir():
+		v.fail(key, fmt.Errorf("%s is not a directory", path))
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
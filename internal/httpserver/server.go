@@ -4,59 +4,153 @@ import (
 	"bufio"
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/internal/metrics"
 	httpdelivery "github.com/daronenko/https-proxy/internal/services/proxy/delivery"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/netutil"
 )
 
+const (
+	// accept errors like running out of file descriptors are retried with
+	// a growing delay instead of spinning
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+	// rejectTimeout bounds writing the 503 to a client that is turned away
+	rejectTimeout = time.Second
+)
+
+// proxyHandler serves a connection once its first request is read.
+type proxyHandler interface {
+	Proxy(ctx context.Context, conn net.Conn, req *http.Request)
+	Drain()
+}
+
 type ProxyServer struct {
-	proxy    *httpdelivery.Proxy
+	proxy   proxyHandler
+	metrics *metrics.Metrics
+	tracer  trace.Tracer
+	limits  config.LimitsSpec
+
+	// slots holds a token per served connection, nil when unlimited
+	slots  chan struct{}
+	queued atomic.Int64
+
+	mu       sync.Mutex
 	listener net.Listener
+	// conns maps the open connections to whether they are still waiting
+	// for their first request
+	conns map[net.Conn]bool
+
 	wg       sync.WaitGroup
 	shutdown chan struct{}
+	stopOnce sync.Once
 }
 
-func NewProxyServer(proxy *httpdelivery.Proxy, config *config.Config) *ProxyServer {
-	return &ProxyServer{
+func NewProxyServer(proxy *httpdelivery.Proxy, metrics *metrics.Metrics, tracerProvider trace.TracerProvider, config *config.Config) *ProxyServer {
+	return newProxyServer(proxy, metrics, tracerProvider, config.App.ProxyServer.Limits)
+}
+
+func newProxyServer(proxy proxyHandler, metrics *metrics.Metrics, tracerProvider trace.TracerProvider, limits config.LimitsSpec) *ProxyServer {
+	s := &ProxyServer{
 		proxy:    proxy,
+		metrics:  metrics,
+		tracer:   tracerProvider.Tracer("github.com/daronenko/https-proxy/internal/httpserver"),
+		limits:   limits.OrDefaults(),
+		conns:    make(map[net.Conn]bool),
 		shutdown: make(chan struct{}),
 	}
+	if s.limits.MaxConnections > 0 {
+		s.slots = make(chan struct{}, s.limits.MaxConnections)
+	}
+	return s
 }
 
+// Serve accepts connections until Shutdown, which makes it return nil.
 func (s *ProxyServer) Serve(listener net.Listener) error {
+	s.mu.Lock()
 	s.listener = listener
+	s.mu.Unlock()
 
+	select {
+	case <-s.shutdown:
+		listener.Close()
+		return nil
+	default:
+	}
+
+	var backoff time.Duration
 	for {
-		select {
-		case <-s.shutdown:
-			log.Info().Msg("gracefully shutting down proxy server...")
-			return nil
-		default:
-			conn, err := listener.Accept()
-			if err != nil {
-				log.Err(err).Msg("failed to accept connection")
-				continue
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.shutdown:
+				log.Info().Msg("proxy server stopped accepting connections")
+				return nil
+			default:
 			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+
+			backoff = min(max(2*backoff, minAcceptBackoff), maxAcceptBackoff)
+			s.metrics.ProxyErrors.WithLabelValues("accept").Inc()
+			log.Err(err).Dur("retry_in", backoff).Msg("failed to accept connection")
 
-			s.wg.Add(1)
-			go func() {
-				defer s.wg.Done()
-				s.handleConnection(conn)
-			}()
+			select {
+			case <-time.After(backoff):
+			case <-s.shutdown:
+			}
+			continue
 		}
+		backoff = 0
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+
+			if !s.acquire(conn) {
+				return
+			}
+			defer s.release()
+
+			s.handleConnection(conn)
+		}()
 	}
 }
 
+// Shutdown stops accepting, closes connections waiting for a request and
+// waits for the ones in flight. Those left when ctx ends are closed.
 func (s *ProxyServer) Shutdown(ctx context.Context) error {
-	close(s.shutdown)
+	s.stopOnce.Do(func() {
+		close(s.shutdown)
+	})
+	s.metrics.Draining.Set(1)
+	defer s.metrics.Draining.Set(0)
 
+	s.mu.Lock()
 	if s.listener != nil {
 		s.listener.Close()
 	}
+	for conn, waiting := range s.conns {
+		if waiting {
+			conn.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	s.proxy.Drain()
+	log.Info().Msg("gracefully shutting down proxy server...")
 
 	done := make(chan struct{})
 	go func() {
@@ -69,31 +163,175 @@ func (s *ProxyServer) Shutdown(ctx context.Context) error {
 		log.Info().Msg("proxy server gracefully stopped")
 		return nil
 	case <-ctx.Done():
-		log.Warn().Msg("proxy server shutdown timed out, forcing exit")
+		s.mu.Lock()
+		forced := len(s.conns)
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+
+		s.metrics.ForcedCloses.Add(float64(forced))
+		log.Warn().Int("connections", forced).Msg("proxy server shutdown timed out, closing the remaining connections")
 		return errors.New("proxy server shutdown timed out")
 	}
 }
 
+// acquire takes a connection slot, waiting in the queue when all are taken.
+// Connections that get none are answered with 503 and closed.
+func (s *ProxyServer) acquire(conn net.Conn) bool {
+	if s.slots == nil {
+		return true
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if s.queued.Add(1) > int64(s.limits.MaxQueued) {
+		s.queued.Add(-1)
+		s.reject(conn, "limit")
+		return false
+	}
+	s.metrics.QueuedConnections.Inc()
+	defer func() {
+		s.queued.Add(-1)
+		s.metrics.QueuedConnections.Dec()
+	}()
+
+	timer := time.NewTimer(s.limits.QueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		s.reject(conn, "queue_timeout")
+	case <-s.shutdown:
+		s.reject(conn, "shutdown")
+	}
+	return false
+}
+
+func (s *ProxyServer) release() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+func (s *ProxyServer) reject(conn net.Conn, reason string) {
+	defer conn.Close()
+
+	s.metrics.RejectedConnections.WithLabelValues(reason).Inc()
+	log.Warn().Str("client", conn.RemoteAddr().String()).Str("reason", reason).Msg("rejected proxy connection")
+
+	conn.SetWriteDeadline(time.Now().Add(rejectTimeout))
+	conn.Write([]byte("HTTP/1.1 503 Service Unavailable\r\nRetry-After: 1\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+}
+
+func (s *ProxyServer) track(conn net.Conn, waiting bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn] = waiting
+}
+
+func (s *ProxyServer) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
 func (s *ProxyServer) handleConnection(conn net.Conn) {
 	defer conn.Close()
 
+	s.track(conn, true)
+	defer s.untrack(conn)
+
+	select {
+	case <-s.shutdown:
+		return
+	default:
+	}
+
+	s.metrics.ActiveConnections.Inc()
+	defer s.metrics.ActiveConnections.Dec()
+
+	ctx, span := s.tracer.Start(context.Background(), "proxy.connection", trace.WithAttributes(
+		attribute.String("client.address", conn.RemoteAddr().String()),
+	))
+	defer span.End()
+
+	conn.SetReadDeadline(time.Now().Add(s.limits.HeaderTimeout))
 	request, err := http.ReadRequest(bufio.NewReader(conn))
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if isTimeout(err) {
+			s.metrics.Timeouts.WithLabelValues("client_header").Inc()
+			return
+		}
+		select {
+		case <-s.shutdown:
+			// closed while waiting for the request
+			return
+		default:
+		}
+		s.metrics.ProxyErrors.WithLabelValues("read").Inc()
 		log.Err(err).Msg("failed to read http request")
 		return
 	}
+	s.track(conn, false)
+	span.SetAttributes(
+		attribute.String("http.request.method", request.Method),
+		attribute.String("server.address", request.Host),
+	)
 
-	s.proxy.Proxy(conn, request)
+	s.proxy.Proxy(ctx, conn, request)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
 
 type ApiServer struct {
 	http.Server
+	maxConnections int
 }
 
-func NewApiServer(api *ApiRouter, config *config.Config) *ApiServer {
-	return &ApiServer{
-		http.Server{
-			Handler: api,
+// NewApiServer applies the header and idle timeouts and the connection
+// limit, connections over it wait in the listen backlog. Requests have no
+// total timeout, the live feed streams for as long as the client listens.
+func NewApiServer(api *ApiRouter, config *config.Config) (*ApiServer, error) {
+	limits := config.App.ApiServer.Limits.OrDefaults()
+	server := &ApiServer{
+		Server: http.Server{
+			Handler:           api,
+			ReadHeaderTimeout: limits.HeaderTimeout,
+			IdleTimeout:       limits.IdleTimeout,
 		},
+		maxConnections: limits.MaxConnections,
+	}
+
+	if spec := config.App.ApiServer.TLS; spec.Enabled() {
+		source, err := newTLSSource(spec, config.App.ProxyServer.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("api server tls: %w", err)
+		}
+		server.TLSConfig = source.Config()
+	}
+
+	return server, nil
+}
+
+// Serve speaks tls when it is configured, certificates come from TLSConfig.
+func (s *ApiServer) Serve(listener net.Listener) error {
+	if s.maxConnections > 0 {
+		listener = netutil.LimitListener(listener, s.maxConnections)
+	}
+	if s.TLSConfig != nil {
+		return s.ServeTLS(listener, "", "")
 	}
+	return s.Server.Serve(listener)
 }
@@ -0,0 +1,281 @@
+package httpserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// blockingProxy holds every connection until released.
+type blockingProxy struct {
+	served  chan string
+	release chan struct{}
+	drained chan struct{}
+	once    sync.Once
+}
+
+func newBlockingProxy() *blockingProxy {
+	return &blockingProxy{
+		served:  make(chan string, 10),
+		release: make(chan struct{}),
+		drained: make(chan struct{}),
+	}
+}
+
+func (p *blockingProxy) Proxy(ctx context.Context, conn net.Conn, req *http.Request) {
+	p.served <- req.URL.Path
+	<-p.release
+	conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+}
+
+func (p *blockingProxy) Drain() {
+	p.once.Do(func() { close(p.drained) })
+}
+
+func startProxyServer(t *testing.T, proxy proxyHandler, limits config.LimitsSpec) (*ProxyServer, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newProxyServer(proxy, metrics.New(), noop.NewTracerProvider(), limits)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(listener) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+		<-served
+	})
+
+	return s, listener.Addr().String()
+}
+
+func dial(t *testing.T, addr string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func send(t *testing.T, conn net.Conn, path string) {
+	t.Helper()
+
+	if _, err := io.WriteString(conn, "GET http://example.com"+path+" HTTP/1.1\r\nHost: example.com\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func status(t *testing.T, conn net.Conn) int {
+	t.Helper()
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestProxyServerQueue(t *testing.T) {
+	proxy := newBlockingProxy()
+	s, addr := startProxyServer(t, proxy, config.LimitsSpec{
+		MaxConnections: 1,
+		MaxQueued:      1,
+		QueueTimeout:   5 * time.Second,
+	})
+
+	first := dial(t, addr)
+	send(t, first, "/first")
+	if path := <-proxy.served; path != "/first" {
+		t.Fatalf("served %s, want /first", path)
+	}
+
+	queued := dial(t, addr)
+	send(t, queued, "/queued")
+	waitFor(t, "the queued connection", func() bool {
+		return testutil.ToFloat64(s.metrics.QueuedConnections) == 1
+	})
+
+	// the slot and the queue are full
+	over := dial(t, addr)
+	if code := status(t, over); code != http.StatusServiceUnavailable {
+		t.Errorf("connection over the queue got %d, want 503", code)
+	}
+	if rejected := testutil.ToFloat64(s.metrics.RejectedConnections.WithLabelValues("limit")); rejected != 1 {
+		t.Errorf("rejected %v connections for the limit, want 1", rejected)
+	}
+
+	// the queued connection takes the slot once it is free
+	proxy.release <- struct{}{}
+	if code := status(t, first); code != http.StatusOK {
+		t.Errorf("first connection got %d, want 200", code)
+	}
+	select {
+	case path := <-proxy.served:
+		if path != "/queued" {
+			t.Errorf("served %s, want /queued", path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued connection did not get the free slot")
+	}
+	close(proxy.release)
+	if code := status(t, queued); code != http.StatusOK {
+		t.Errorf("queued connection got %d, want 200", code)
+	}
+}
+
+func TestProxyServerQueueTimeout(t *testing.T) {
+	proxy := newBlockingProxy()
+	defer close(proxy.release)
+	s, addr := startProxyServer(t, proxy, config.LimitsSpec{
+		MaxConnections: 1,
+		MaxQueued:      1,
+		QueueTimeout:   50 * time.Millisecond,
+	})
+
+	first := dial(t, addr)
+	send(t, first, "/first")
+	<-proxy.served
+
+	queued := dial(t, addr)
+	if code := status(t, queued); code != http.StatusServiceUnavailable {
+		t.Errorf("connection waiting past the queue timeout got %d, want 503", code)
+	}
+	if rejected := testutil.ToFloat64(s.metrics.RejectedConnections.WithLabelValues("queue_timeout")); rejected != 1 {
+		t.Errorf("rejected %v connections for the queue timeout, want 1", rejected)
+	}
+}
+
+func TestProxyServerShutdown(t *testing.T) {
+	proxy := newBlockingProxy()
+	s, addr := startProxyServer(t, proxy, config.LimitsSpec{})
+
+	// one connection has not sent its request yet, the other is in flight
+	waiting := dial(t, addr)
+	busy := dial(t, addr)
+	send(t, busy, "/busy")
+	<-proxy.served
+	waitFor(t, "both connections", func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.conns) == 2
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err == nil {
+		t.Fatal("Shutdown() waited out a connection that never finished")
+	}
+
+	select {
+	case <-proxy.drained:
+	default:
+		t.Error("Shutdown() did not drain kept alive connections")
+	}
+	if _, err := waiting.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("connection waiting for its request read %v, want EOF", err)
+	}
+	if forced := testutil.ToFloat64(s.metrics.ForcedCloses); forced != 1 {
+		t.Errorf("forced %v closes, want 1", forced)
+	}
+	if draining := testutil.ToFloat64(s.metrics.Draining); draining != 0 {
+		t.Errorf("draining = %v after shutdown", draining)
+	}
+
+	close(proxy.release)
+	if _, err := busy.Read(make([]byte, 1)); err == nil {
+		t.Error("connection in flight was left open after the timeout")
+	}
+}
+
+// flakyListener fails Accept a few times before serving a real listener.
+type flakyListener struct {
+	net.Listener
+
+	mu       sync.Mutex
+	failures int
+	calls    []time.Time
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	l.calls = append(l.calls, time.Now())
+	fail := len(l.calls) <= l.failures
+	l.mu.Unlock()
+
+	if fail {
+		return nil, errors.New("too many open files")
+	}
+	return l.Listener.Accept()
+}
+
+func TestProxyServerAcceptBackoff(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := &flakyListener{Listener: inner, failures: 4}
+
+	proxy := newBlockingProxy()
+	close(proxy.release)
+	s := newProxyServer(proxy, metrics.New(), noop.NewTracerProvider(), config.LimitsSpec{})
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(listener) }()
+
+	conn := dial(t, inner.Addr().String())
+	send(t, conn, "/after")
+	if code := status(t, conn); code != http.StatusOK {
+		t.Errorf("connection after the failures got %d, want 200", code)
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("Serve() = %v after shutdown", err)
+	}
+
+	listener.mu.Lock()
+	defer listener.mu.Unlock()
+	want := minAcceptBackoff
+	for i := 1; i <= listener.failures; i++ {
+		if gap := listener.calls[i].Sub(listener.calls[i-1]); gap < want {
+			t.Errorf("accept %d retried after %s, want at least %s", i, gap, want)
+		}
+		want *= 2
+	}
+	if failed := testutil.ToFloat64(s.metrics.ProxyErrors.WithLabelValues("accept")); failed != float64(listener.failures) {
+		t.Errorf("counted %v accept errors, want %d", failed, listener.failures)
+	}
+}
@@ -0,0 +1,188 @@
+package httpserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/pkg/ca"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// reloadInterval bounds how often the files are checked for changes
+	reloadInterval = time.Second
+	// autoValidity is the lifetime of minted certificates, they are renewed
+	// once a third of it is left
+	autoValidity = 30 * 24 * time.Hour
+)
+
+// tlsSource serves the certificate and the client CA from disk and picks up
+// rotated files on the next handshake, so no restart is needed.
+type tlsSource struct {
+	spec   config.TLSSpec
+	caCert string
+	caKey  string
+
+	mu       sync.Mutex
+	checked  time.Time
+	modTimes map[string]time.Time
+	config   *tls.Config
+}
+
+func newTLSSource(spec config.TLSSpec, proxyTLS config.TLSSpec) (*tlsSource, error) {
+	if spec.Auto && len(spec.Hosts) == 0 {
+		return nil, errors.New("tls.hosts is required to mint a certificate")
+	}
+	if !spec.Auto && spec.KeyPath == "" {
+		return nil, errors.New("tls.keyPath is required with tls.certPath")
+	}
+
+	s := &tlsSource{
+		spec:   spec,
+		caCert: proxyTLS.CACertPath,
+		caKey:  proxyTLS.CAKeyPath,
+	}
+	if _, err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *tlsSource) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.current()
+		},
+	}
+}
+
+// current returns the cached config, reloading it when a file changed or a
+// minted certificate is about to expire. A broken rotation keeps the old
+// config serving.
+func (s *tlsSource) current() (*tls.Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.checked) < reloadInterval {
+		return s.config, nil
+	}
+	s.checked = time.Now()
+
+	if !s.stale() {
+		return s.config, nil
+	}
+
+	conf, err := s.loadLocked()
+	if err != nil {
+		log.Err(err).Msg("failed to reload api server certificate, keeping the old one")
+		return s.config, nil
+	}
+	log.Info().Msg("reloaded api server certificate")
+	return conf, nil
+}
+
+func (s *tlsSource) load() (*tls.Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checked = time.Now()
+	return s.loadLocked()
+}
+
+func (s *tlsSource) loadLocked() (*tls.Config, error) {
+	modTimes := make(map[string]time.Time)
+	for _, path := range s.paths() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[path] = info.ModTime()
+	}
+
+	var (
+		cert *tls.Certificate
+		err  error
+	)
+	if s.spec.Auto {
+		var authority *ca.Authority
+		if authority, err = ca.Load(s.caCert, s.caKey); err != nil {
+			return nil, err
+		}
+		cert, err = authority.Issue(s.spec.Hosts, autoValidity)
+	} else {
+		var pair tls.Certificate
+		pair, err = tls.LoadX509KeyPair(s.spec.CertPath, s.spec.KeyPath)
+		cert = &pair
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load api server certificate: %w", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	conf := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+		Certificates: []tls.Certificate{*cert},
+	}
+
+	if s.spec.ClientCAPath != "" {
+		pem, err := os.ReadFile(s.spec.ClientCAPath)
+		if err != nil {
+			return nil, fmt.Errorf("read client ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("client ca has no certificates")
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	s.modTimes, s.config = modTimes, conf
+	return conf, nil
+}
+
+func (s *tlsSource) stale() bool {
+	if s.spec.Auto {
+		leaf := s.config.Certificates[0].Leaf
+		if time.Until(leaf.NotAfter) < autoValidity/3 {
+			return true
+		}
+	}
+
+	for _, path := range s.paths() {
+		info, err := os.Stat(path)
+		if err != nil {
+			// mid rotation, try again on a later handshake
+			continue
+		}
+		if !info.ModTime().Equal(s.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *tlsSource) paths() []string {
+	var paths []string
+	if s.spec.Auto {
+		paths = append(paths, s.caCert, s.caKey)
+	} else {
+		paths = append(paths, s.spec.CertPath, s.spec.KeyPath)
+	}
+	if s.spec.ClientCAPath != "" {
+		paths = append(paths, s.spec.ClientCAPath)
+	}
+	return paths
+}
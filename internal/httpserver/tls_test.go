@@ -0,0 +1,236 @@
+package httpserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/pkg/ca"
+)
+
+func newAuthority(t *testing.T, name string) *ca.Authority {
+	t.Helper()
+
+	authority, err := ca.Generate(name, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authority
+}
+
+// writePair saves cert as pem files modified at modTime.
+func writePair(t *testing.T, cert *tls.Certificate, certPath, keyPath string, modTime time.Time) {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{certPath, keyPath} {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// serveTLS runs an https server with the certificates of source, refused
+// handshakes are not logged.
+func serveTLS(t *testing.T, source *tlsSource) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.TLS = source.Config()
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// get makes a new handshake with srv and returns the certificate it served.
+func get(srv *httptest.Server, roots *x509.CertPool, certs ...tls.Certificate) (*x509.Certificate, error) {
+	client := &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certs},
+	}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp.TLS.PeerCertificates[0], nil
+}
+
+func pool(authorities ...*ca.Authority) *x509.CertPool {
+	roots := x509.NewCertPool()
+	for _, authority := range authorities {
+		roots.AddCert(authority.Cert)
+	}
+	return roots
+}
+
+func TestTLSReloadsRotatedFiles(t *testing.T) {
+	authority := newAuthority(t, "test ca")
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "api.crt"), filepath.Join(dir, "api.key")
+
+	first, err := authority.Issue([]string{"127.0.0.1"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePair(t, first, certPath, keyPath, time.Now().Add(-time.Hour))
+
+	source, err := newTLSSource(config.TLSSpec{CertPath: certPath, KeyPath: keyPath}, config.TLSSpec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := serveTLS(t, source)
+
+	leaf, err := get(srv, pool(authority))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.SerialNumber.Cmp(first.Leaf.SerialNumber) != 0 {
+		t.Fatal("handshake did not serve the configured certificate")
+	}
+
+	second, err := authority.Issue([]string{"127.0.0.1"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePair(t, second, certPath, keyPath, time.Now())
+	expireCheck(source)
+
+	leaf, err = get(srv, pool(authority))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.SerialNumber.Cmp(second.Leaf.SerialNumber) != 0 {
+		t.Fatal("handshake after the rotation served the old certificate")
+	}
+
+	// a broken rotation keeps the last good certificate
+	if err := os.WriteFile(certPath, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expireCheck(source)
+	leaf, err = get(srv, pool(authority))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.SerialNumber.Cmp(second.Leaf.SerialNumber) != 0 {
+		t.Fatal("broken rotation replaced the certificate")
+	}
+}
+
+// expireCheck lets the next handshake look at the files at once instead of
+// after reloadInterval.
+func expireCheck(source *tlsSource) {
+	source.mu.Lock()
+	source.checked = time.Time{}
+	source.mu.Unlock()
+}
+
+func TestTLSAutoMint(t *testing.T) {
+	dir := t.TempDir()
+	proxyTLS := config.TLSSpec{
+		CACertPath: filepath.Join(dir, "ca.crt"),
+		CAKeyPath:  filepath.Join(dir, "ca.key"),
+	}
+	authority := newAuthority(t, "proxy ca")
+	if err := authority.Save(proxyTLS.CACertPath, proxyTLS.CAKeyPath); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(proxyTLS.CACertPath, past, past)
+	os.Chtimes(proxyTLS.CAKeyPath, past, past)
+
+	source, err := newTLSSource(config.TLSSpec{Auto: true, Hosts: []string{"127.0.0.1"}}, proxyTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := serveTLS(t, source)
+
+	if _, err := get(srv, pool(authority)); err != nil {
+		t.Fatalf("minted certificate is not trusted through the proxy CA: %v", err)
+	}
+
+	// a regenerated proxy CA mints a new certificate
+	regenerated := newAuthority(t, "proxy ca")
+	if err := regenerated.Save(proxyTLS.CACertPath, proxyTLS.CAKeyPath); err != nil {
+		t.Fatal(err)
+	}
+	expireCheck(source)
+	if _, err := get(srv, pool(regenerated)); err != nil {
+		t.Fatalf("certificate was not minted again with the new CA: %v", err)
+	}
+}
+
+func TestTLSClientCertificates(t *testing.T) {
+	authority := newAuthority(t, "server ca")
+	clients := newAuthority(t, "client ca")
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "api.crt"), filepath.Join(dir, "api.key")
+	clientCAPath := filepath.Join(dir, "clients.crt")
+
+	server, err := authority.Issue([]string{"127.0.0.1"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePair(t, server, certPath, keyPath, time.Now())
+	if err := os.WriteFile(clientCAPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clients.Cert.Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := newTLSSource(config.TLSSpec{CertPath: certPath, KeyPath: keyPath, ClientCAPath: clientCAPath}, config.TLSSpec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := serveTLS(t, source)
+
+	clientCert := func(signer *ca.Authority) tls.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := signer.Sign(&x509.Certificate{
+			Subject:     pkix.Name{CommonName: "ci"},
+			NotBefore:   time.Now().Add(-time.Minute),
+			NotAfter:    time.Now().Add(time.Hour),
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *cert
+	}
+
+	if _, err := get(srv, pool(authority)); err == nil {
+		t.Error("client without a certificate was served")
+	}
+	if _, err := get(srv, pool(authority), clientCert(authority)); err == nil {
+		t.Error("client with a certificate of another CA was served")
+	}
+	if _, err := get(srv, pool(authority), clientCert(clients)); err != nil {
+		t.Errorf("client with a certificate of the client CA was refused: %v", err)
+	}
+}
@@ -4,6 +4,15 @@ import "go.uber.org/fx"
 
 func Module() fx.Option {
 	return fx.Module("infra", fx.Provide(
+		NewReloader,
 		NewMongo,
+		NewOob,
+		NewScope,
+		NewProxyAuth,
+		NewApiAuth,
+		NewOnboarding,
+		NewCertStore,
+		NewScannerSpec,
+		NewFuzzerSpec,
 	))
 }
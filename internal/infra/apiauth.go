@@ -0,0 +1,32 @@
+package infra
+
+import (
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/pkg/apiauth"
+	"github.com/rs/zerolog/log"
+)
+
+func NewApiAuth(conf *config.Config, reloader *config.Reloader) (*apiauth.Authenticator, error) {
+	tokens, err := conf.App.ApiAuth.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := apiauth.New(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if !auth.Enabled() {
+		log.Warn().Msg("no api tokens configured, the api server is open to everyone")
+	}
+
+	reloader.OnChange("apiAuth", func(conf *config.Config) error {
+		tokens, err := conf.App.ApiAuth.Build()
+		if err != nil {
+			return err
+		}
+		return auth.Replace(tokens)
+	})
+
+	return auth, nil
+}
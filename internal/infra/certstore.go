@@ -0,0 +1,45 @@
+package infra
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/pkg/certstore"
+)
+
+const (
+	defaultCertValidity = 365 * 24 * time.Hour
+	defaultCertRenewal  = 30 * 24 * time.Hour
+	defaultCAValidity   = 10 * 365 * 24 * time.Hour
+)
+
+func NewCertStore(conf *config.Config) (*certstore.Store, error) {
+	tls, spec := conf.App.ProxyServer.TLS, conf.App.Certs
+
+	if spec.Validity <= 0 {
+		spec.Validity = defaultCertValidity
+	}
+	if spec.RenewBefore <= 0 {
+		spec.RenewBefore = defaultCertRenewal
+	}
+	if spec.CAValidity <= 0 {
+		spec.CAValidity = defaultCAValidity
+	}
+
+	store, err := certstore.New(certstore.Config{
+		Dir:         tls.CertPath,
+		KeyPath:     tls.KeyPath,
+		CACertPath:  tls.CACertPath,
+		CAKeyPath:   tls.CAKeyPath,
+		Validity:    spec.Validity,
+		RenewBefore: spec.RenewBefore,
+		CAValidity:  spec.CAValidity,
+		Wildcard:    spec.Wildcard,
+		MaxMimics:   spec.MaxMimics,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open certificate store: %w", err)
+	}
+	return store, nil
+}
@@ -0,0 +1,14 @@
+package infra
+
+import (
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/pkg/onboarding"
+)
+
+func NewOnboarding(conf *config.Config) *onboarding.Portal {
+	return onboarding.New(
+		conf.App.ProxyServer.TLS.CACertPath,
+		conf.App.Onboarding.Host,
+		conf.App.Onboarding.ProxyAddress,
+	)
+}
@@ -0,0 +1,22 @@
+package infra
+
+import (
+	"net"
+
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/pkg/oob"
+)
+
+// NewOob returns nil when the interaction server is disabled.
+func NewOob(conf *config.Config) *oob.Server {
+	spec := conf.App.Oob
+	if !spec.Enabled {
+		return nil
+	}
+
+	return oob.New(oob.Config{
+		Domain:        spec.Domain,
+		PublicAddress: spec.PublicAddress,
+		PublicIP:      net.ParseIP(spec.PublicIP),
+	})
+}
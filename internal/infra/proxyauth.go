@@ -0,0 +1,30 @@
+package infra
+
+import (
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/pkg/proxyauth"
+)
+
+func NewProxyAuth(conf *config.Config, reloader *config.Reloader) (*proxyauth.Authenticator, error) {
+	spec, err := conf.App.ProxyAuth.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := proxyauth.New(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	// the only handler of the section, users, projects and the realm are
+	// built first and swapped together
+	reloader.OnChange("proxyAuth", func(conf *config.Config) error {
+		spec, err := conf.App.ProxyAuth.Build()
+		if err != nil {
+			return err
+		}
+		return auth.Replace(spec)
+	})
+
+	return auth, nil
+}
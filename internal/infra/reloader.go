@@ -0,0 +1,26 @@
+package infra
+
+import (
+	"context"
+
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/pkg/logger"
+	"go.uber.org/fx"
+)
+
+func NewReloader(lc fx.Lifecycle, conf *config.Config) *config.Reloader {
+	reloader := config.NewReloader(conf)
+
+	reloader.OnChange("logger", func(conf *config.Config) error {
+		return logger.SetLevel(&conf.App.Logger)
+	})
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			reloader.Watch()
+			return nil
+		},
+	})
+
+	return reloader
+}
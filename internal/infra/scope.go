@@ -0,0 +1,23 @@
+package infra
+
+import (
+	"fmt"
+
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/pkg/scope"
+)
+
+func NewScope(conf *config.Config, reloader *config.Reloader) (*scope.Scope, error) {
+	s, err := scope.New(conf.App.Scope.Build())
+	if err != nil {
+		return nil, fmt.Errorf("invalid scope: %w", err)
+	}
+
+	// replaces rules set through the api as well, but only when the file
+	// changed them
+	reloader.OnChange("scope", func(conf *config.Config) error {
+		return s.Replace(conf.App.Scope.Build())
+	})
+
+	return s, nil
+}
@@ -0,0 +1,19 @@
+package infra
+
+import "github.com/daronenko/https-proxy/internal/app/config"
+
+// NewScannerSpec hands out the scanner payloads, a scan started after a
+// reload uses the new ones.
+func NewScannerSpec(conf *config.Config, reloader *config.Reloader) *config.Live[config.ScannerSpec] {
+	return config.NewLive(reloader, "scanner", conf, func(conf *config.Config) config.ScannerSpec {
+		return conf.App.Scanner
+	})
+}
+
+// NewFuzzerSpec hands out the fuzzer limits, a run started after a reload
+// uses the new ones.
+func NewFuzzerSpec(conf *config.Config, reloader *config.Reloader) *config.Live[config.FuzzerSpec] {
+	return config.NewLive(reloader, "fuzzer", conf, func(conf *config.Config) config.FuzzerSpec {
+		return conf.App.Fuzzer
+	})
+}
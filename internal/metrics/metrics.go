@@ -0,0 +1,262 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/daronenko/https-proxy/pkg/httpctl"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "mitm"
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	ProxyRequests     *prometheus.CounterVec
+	ProxyErrors       *prometheus.CounterVec
+	UpstreamLatency   *prometheus.HistogramVec
+	ActiveConnections prometheus.Gauge
+	ScopeDecisions    *prometheus.CounterVec
+	AuthFailures      *prometheus.CounterVec
+
+	QueuedConnections   prometheus.Gauge
+	RejectedConnections *prometheus.CounterVec
+	Timeouts            *prometheus.CounterVec
+	Draining            prometheus.Gauge
+	ForcedCloses        prometheus.Counter
+
+	CertCache       *prometheus.CounterVec
+	CertGenerations prometheus.Counter
+	CertFailures    prometheus.Counter
+
+	StorageWriteLatency  prometheus.Histogram
+	StorageWriteFailures prometheus.Counter
+
+	QueueDepth   prometheus.Gauge
+	QueueRetries prometheus.Counter
+	QueueSpilled prometheus.Counter
+	QueueDropped *prometheus.CounterVec
+
+	RetentionPruned *prometheus.CounterVec
+
+	ScanJobs *prometheus.CounterVec
+
+	ApiRequests  *prometheus.HistogramVec
+	AuditDropped prometheus.Counter
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		ProxyRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "requests_total",
+			Help:      "Proxied requests by method, response status and target host.",
+		}, []string{"method", "status", "host"}),
+		ProxyErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "errors_total",
+			Help:      "Proxy failures by stage: accept, read, dial, tls or forward.",
+		}, []string{"stage"}),
+		UpstreamLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "upstream_duration_seconds",
+			Help:      "Time from sending a request upstream to reading the whole response.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"scheme"}),
+		ActiveConnections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "active_connections",
+			Help:      "Client connections currently handled by the proxy.",
+		}),
+		ScopeDecisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "scope_decisions_total",
+			Help:      "Scope decisions by action: record, mitm or tunnel.",
+		}, []string{"action"}),
+		AuthFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "auth_failures_total",
+			Help:      "Rejected proxy clients by reason: forbidden or unauthorized.",
+		}, []string{"reason"}),
+
+		QueuedConnections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "queued_connections",
+			Help:      "Client connections waiting for a free slot.",
+		}),
+		RejectedConnections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "rejected_connections_total",
+			Help:      "Client connections answered with 503 by reason: limit, queue_timeout or shutdown.",
+		}, []string{"reason"}),
+		Timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "timeouts_total",
+			Help:      "Connections closed by timeout by stage: client_header, client_idle, upstream_header, request or tunnel_idle.",
+		}, []string{"stage"}),
+		Draining: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "draining",
+			Help:      "1 while the proxy shuts down and waits for in-flight requests.",
+		}),
+		ForcedCloses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "forced_closes_total",
+			Help:      "Connections still busy when the shutdown timed out and were closed.",
+		}),
+
+		CertCache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "certs",
+			Name:      "cache_lookups_total",
+			Help:      "Certificate cache lookups by result: hit or miss.",
+		}, []string{"result"}),
+		CertGenerations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "certs",
+			Name:      "generations_total",
+			Help:      "Host certificates generated.",
+		}),
+		CertFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "certs",
+			Name:      "failures_total",
+			Help:      "Failures to load, generate or parse host certificates.",
+		}),
+
+		StorageWriteLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "write_duration_seconds",
+			Help:      "Time spent writing transactions to the database.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		StorageWriteFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "write_failures_total",
+			Help:      "Failed transaction writes.",
+		}),
+
+		QueueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "queue",
+			Name:      "depth",
+			Help:      "Transactions waiting to be written to the database.",
+		}),
+		QueueRetries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "queue",
+			Name:      "retries_total",
+			Help:      "Retried batch writes.",
+		}),
+		QueueSpilled: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "queue",
+			Name:      "spilled_total",
+			Help:      "Transactions written to the spill file.",
+		}),
+		QueueDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "queue",
+			Name:      "dropped_total",
+			Help:      "Transactions lost by reason: full, storage, closed or spill_full.",
+		}, []string{"reason"}),
+
+		RetentionPruned: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "retention",
+			Name:      "deleted_total",
+			Help:      "Transactions deleted by reason: age, count, size, host or purge.",
+		}, []string{"reason"}),
+
+		ScanJobs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "scanner",
+			Name:      "jobs_total",
+			Help:      "Scan jobs by result: clean or vulnerable.",
+		}, []string{"result"}),
+
+		ApiRequests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "api",
+			Name:      "request_duration_seconds",
+			Help:      "Api requests by route, method and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		AuditDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "audit",
+			Name:      "dropped_total",
+			Help:      "Audit entries that were logged but not stored.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+
+		m.ProxyRequests,
+		m.ProxyErrors,
+		m.UpstreamLatency,
+		m.ActiveConnections,
+		m.ScopeDecisions,
+		m.AuthFailures,
+		m.QueuedConnections,
+		m.RejectedConnections,
+		m.Timeouts,
+		m.Draining,
+		m.ForcedCloses,
+		m.CertCache,
+		m.CertGenerations,
+		m.CertFailures,
+		m.StorageWriteLatency,
+		m.StorageWriteFailures,
+		m.QueueDepth,
+		m.QueueRetries,
+		m.QueueSpilled,
+		m.QueueDropped,
+		m.RetentionPruned,
+		m.ScanJobs,
+		m.ApiRequests,
+		m.AuditDropped,
+	)
+
+	return m
+}
+
+// Middleware records the duration of every api request under its route
+// template.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := httpctl.Route(r)
+
+		rec := httpctl.NewStatusRecorder(w)
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+
+		m.ApiRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.Status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Handler serves the registry in the prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
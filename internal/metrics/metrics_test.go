@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	m := New()
+
+	router := mux.NewRouter()
+	router.Use(m.Middleware)
+	router.HandleFunc("/request/{request_id}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}).Methods("GET")
+	router.Handle("/metrics", m.Handler()).Methods("GET")
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	for _, id := range []string{"a", "b"} {
+		resp, err := http.Get(srv.URL + "/request/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	// the proxy counts every forwarded request this way
+	m.ProxyRequests.WithLabelValues("GET", "200", "example.com").Inc()
+	m.UpstreamLatency.WithLabelValues("https").Observe(0.25)
+	m.ProxyErrors.WithLabelValues("dial").Inc()
+	m.ActiveConnections.Inc()
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		value  float64
+	}{
+		{
+			name:   "mitm_api_request_duration_seconds",
+			labels: map[string]string{"route": "/request/{request_id}", "method": "GET", "status": "404"},
+			value:  2,
+		},
+		{
+			name:   "mitm_proxy_requests_total",
+			labels: map[string]string{"method": "GET", "status": "200", "host": "example.com"},
+			value:  1,
+		},
+		{
+			name:   "mitm_proxy_upstream_duration_seconds",
+			labels: map[string]string{"scheme": "https"},
+			value:  1,
+		},
+		{
+			name:   "mitm_proxy_errors_total",
+			labels: map[string]string{"stage": "dial"},
+			value:  1,
+		},
+		{
+			name:  "mitm_proxy_active_connections",
+			value: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			family, ok := families[tt.name]
+			if !ok {
+				t.Fatalf("series %s is not exported", tt.name)
+			}
+
+			metric := find(family, tt.labels)
+			if metric == nil {
+				t.Fatalf("no %s series with labels %v", tt.name, tt.labels)
+			}
+			if got := value(metric); got != tt.value {
+				t.Errorf("%s = %v, want %v", tt.name, got, tt.value)
+			}
+		})
+	}
+}
+
+func find(family *dto.MetricFamily, labels map[string]string) *dto.Metric {
+	for _, metric := range family.GetMetric() {
+		matched := 0
+		for _, pair := range metric.GetLabel() {
+			if want, ok := labels[pair.GetName()]; ok && want == pair.GetValue() {
+				matched++
+			}
+		}
+		if matched == len(labels) {
+			return metric
+		}
+	}
+	return nil
+}
+
+// value returns the count of histograms and the value of everything else.
+func value(metric *dto.Metric) float64 {
+	switch {
+	case metric.GetHistogram() != nil:
+		return float64(metric.GetHistogram().GetSampleCount())
+	case metric.GetCounter() != nil:
+		return metric.GetCounter().GetValue()
+	default:
+		return metric.GetGauge().GetValue()
+	}
+}
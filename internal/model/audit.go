@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// AuditEntry records one api call that changed something or was denied.
+type AuditEntry struct {
+	ID         interface{} `bson:"_id,omitempty" json:"id,omitempty"`
+	Actor      string      `bson:"actor" json:"actor"`
+	Role       string      `bson:"role" json:"role"`
+	Method     string      `bson:"method" json:"method"`
+	Route      string      `bson:"route" json:"route"`
+	Path       string      `bson:"path" json:"path"`
+	Status     int         `bson:"status" json:"status"`
+	RemoteAddr string      `bson:"remote_addr" json:"remote_addr"`
+	CreatedAt  time.Time   `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// Project groups transactions captured during one engagement or test run.
+type Project struct {
+	ID          interface{} `bson:"_id,omitempty" json:"id,omitempty"`
+	Name        string      `bson:"name" json:"name"`
+	Description string      `bson:"description" json:"description"`
+	Active      bool        `bson:"active" json:"active"`
+	CreatedAt   time.Time   `bson:"created_at" json:"created_at"`
+}
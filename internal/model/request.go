@@ -7,6 +7,7 @@ import (
 	"io"
 	"maps"
 	"net/http"
+	"net/url"
 	"slices"
 	"strings"
 	"time"
@@ -14,9 +15,49 @@ import (
 
 type Transaction struct {
 	ID        interface{} `bson:"_id,omitempty" json:"id,omitempty"`
+	ParentID  interface{} `bson:"parent_id,omitempty" json:"parent_id,omitempty"`
+	ProjectID interface{} `bson:"project_id,omitempty" json:"project_id,omitempty"`
+	User      string      `bson:"user,omitempty" json:"user,omitempty"`
 	Request   Request     `bson:"request" json:"request"`
 	Response  Response    `bson:"response" json:"response"`
 	CreatedAt time.Time   `bson:"created_at" json:"created_at"`
+	Pinned    bool        `bson:"pinned" json:"pinned"`
+}
+
+// ArchivedTransaction carries the response body that transaction json leaves
+// out, exports use it so that an import gets the body back.
+type ArchivedTransaction struct {
+	*Transaction
+	ResponseBody []byte `json:"response_body,omitempty"`
+}
+
+// Summary is a compact view of a transaction pushed to live feed consumers.
+type Summary struct {
+	ID        interface{} `json:"id,omitempty"`
+	ProjectID interface{} `json:"project_id,omitempty"`
+	User      string      `json:"user,omitempty"`
+	Method    string      `json:"method"`
+	Protocol  string      `json:"protocol"`
+	Host      string      `json:"host"`
+	Path      string      `json:"path"`
+	Status    int         `json:"status"`
+	Length    int         `json:"length"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+func NewSummary(t *Transaction) Summary {
+	return Summary{
+		ID:        t.ID,
+		ProjectID: t.ProjectID,
+		User:      t.User,
+		Method:    t.Request.Method,
+		Protocol:  t.Request.Protocol,
+		Host:      t.Request.Host,
+		Path:      t.Request.Path,
+		Status:    t.Response.Status,
+		Length:    t.Response.Length(),
+		CreatedAt: t.CreatedAt,
+	}
 }
 
 type Request struct {
@@ -25,47 +66,52 @@ type Request struct {
 	Host        string            `bson:"host" json:"host"`
 	Path        string            `bson:"path" json:"path"`
 	Protocol    string            `bson:"protocol" json:"protocol"`
-	Headers     map[string]string `bson:"headers" json:"headers"`
-	Cookies     map[string]string `bson:"cookies" json:"cookies"`
-	QueryParams map[string]string `bson:"query_params" json:"query_params"`
+	Headers     Values            `bson:"headers" json:"headers"`
+	Cookies     Values            `bson:"cookies" json:"cookies"`
+	QueryParams Values            `bson:"query_params" json:"query_params"`
 	FormParams  map[string]string `bson:"form_params" json:"form_params"`
 	Body        []byte            `bson:"body" json:"body"`
+	BodyRef     string            `bson:"body_ref,omitempty" json:"body_ref,omitempty"`
+	BodySize    int64             `bson:"body_size,omitempty" json:"body_size,omitempty"`
 }
 
 type Response struct {
-	Status  int               `bson:"status" json:"status"`
-	Headers map[string]string `bson:"headers" json:"headers"`
-	Body    []byte            `bson:"body" json:"-"`
+	Status   int    `bson:"status" json:"status"`
+	Headers  Values `bson:"headers" json:"headers"`
+	Body     []byte `bson:"body" json:"-"`
+	BodyRef  string `bson:"body_ref,omitempty" json:"body_ref,omitempty"`
+	BodySize int64  `bson:"body_size,omitempty" json:"body_size,omitempty"`
+}
+
+// Length is the body size, also for bodies kept in the blob store.
+func (r Response) Length() int {
+	if r.BodyRef != "" {
+		return int(r.BodySize)
+	}
+	return len(r.Body)
 }
 
 func NewRequest(req *http.Request) Request {
 	var bodyBytes []byte
 	if req.Body != nil {
-		encoding := req.Header.Get("Content-Encoding")
-		switch encoding {
-		case "gzip":
-			gzReader, _ := gzip.NewReader(req.Body)
-			defer gzReader.Close()
-			bodyBytes, _ = io.ReadAll(gzReader)
-		default:
-			bodyBytes, _ = io.ReadAll(req.Body)
+		bodyBytes, _ = io.ReadAll(req.Body)
+		if req.Header.Get("Content-Encoding") == "gzip" {
+			// a body that claims gzip but is not is kept as sent
+			if decoded, err := gunzip(bodyBytes); err == nil {
+				bodyBytes = decoded
+			}
 		}
 		req.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 	}
 
-	headers := make(map[string]string)
+	headers := make(Values, len(req.Header))
 	for key, values := range req.Header {
-		headers[key] = strings.Join(values, ", ")
+		headers[key] = slices.Clone(values)
 	}
 
-	cookies := make(map[string]string)
+	cookies := make(Values)
 	for _, c := range req.Cookies() {
-		cookies[c.Name] = c.Value
-	}
-
-	queryParams := make(map[string]string)
-	for key, values := range req.URL.Query() {
-		queryParams[key] = strings.Join(values, ", ")
+		cookies.Add(c.Name, c.Value)
 	}
 
 	req.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
@@ -90,12 +136,22 @@ func NewRequest(req *http.Request) Request {
 		Protocol:    scheme,
 		Headers:     headers,
 		Cookies:     cookies,
-		QueryParams: queryParams,
+		QueryParams: Values(req.URL.Query()),
 		FormParams:  formParams,
 		Body:        bodyBytes,
 	}
 }
 
+func gunzip(body []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
 func (r Request) Clone() Request {
 	cloneMap := func(src map[string]string) map[string]string {
 		dst := make(map[string]string, len(src))
@@ -109,9 +165,9 @@ func (r Request) Clone() Request {
 		Host:        r.Host,
 		Path:        r.Path,
 		Protocol:    r.Protocol,
-		Headers:     cloneMap(r.Headers),
-		Cookies:     cloneMap(r.Cookies),
-		QueryParams: cloneMap(r.QueryParams),
+		Headers:     r.Headers.Clone(),
+		Cookies:     r.Cookies.Clone(),
+		QueryParams: r.QueryParams.Clone(),
 		FormParams:  cloneMap(r.FormParams),
 		Body:        slices.Clone(r.Body),
 	}
@@ -125,9 +181,9 @@ func NewResponse(resp *http.Response) Response {
 		resp.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 	}
 
-	headers := make(map[string]string)
+	headers := make(Values, len(resp.Header))
 	for key, values := range resp.Header {
-		headers[key] = strings.Join(values, ", ")
+		headers[key] = slices.Clone(values)
 	}
 
 	return Response{
@@ -148,24 +204,87 @@ func BuildURL(req Request) string {
 	builder.WriteString("://")
 
 	builder.WriteString(req.Host)
-	builder.WriteString(req.Path)
+	builder.WriteString(BuildRequestURI(req))
 
-	if len(req.QueryParams) > 0 {
-		builder.WriteString("?")
-		queries := make([]string, 0, len(req.QueryParams))
-		for k, v := range req.QueryParams {
-			queries = append(queries, fmt.Sprintf("%s=%s", k, v))
+	return builder.String()
+}
+
+// BuildRequestURI returns the path with the query, params are stored decoded
+// and escaped here.
+func BuildRequestURI(req Request) string {
+	if len(req.QueryParams) == 0 {
+		return req.Path
+	}
+	return req.Path + "?" + url.Values(req.QueryParams).Encode()
+}
+
+// BuildRaw renders the request in http/1.1 wire format. The body is stored
+// decoded, so content encoding and length are dropped from the headers and
+// the length is recomputed.
+func BuildRaw(req Request) string {
+	var builder strings.Builder
+
+	path := BuildRequestURI(req)
+	if path == "" {
+		path = "/"
+	}
+	fmt.Fprintf(&builder, "%s %s HTTP/1.1\r\n", req.Method, path)
+	fmt.Fprintf(&builder, "Host: %s\r\n", req.Host)
+
+	for _, k := range req.Headers.Keys() {
+		switch http.CanonicalHeaderKey(k) {
+		case "Host", "Cookie", "Content-Length", "Content-Encoding":
+			continue
 		}
-		builder.WriteString(strings.Join(queries, "&"))
+		for _, v := range req.Headers[k] {
+			fmt.Fprintf(&builder, "%s: %s\r\n", k, v)
+		}
+	}
+
+	if len(req.Cookies) > 0 {
+		fmt.Fprintf(&builder, "Cookie: %s\r\n", BuildCookieHeader(req.Cookies))
+	}
+
+	body := BuildBody(req)
+	if len(body) > 0 {
+		fmt.Fprintf(&builder, "Content-Length: %d\r\n", len(body))
 	}
 
+	builder.WriteString("\r\n")
+	builder.Write(body)
+
 	return builder.String()
 }
 
+// BuildBody returns the form params encoded for form requests and the stored
+// body otherwise.
+func BuildBody(req Request) []byte {
+	if len(req.FormParams) > 0 {
+		return []byte(BuildFormBody(req.FormParams))
+	}
+	return req.Body
+}
+
 func BuildFormBody(form map[string]string) string {
-	parts := make([]string, 0, len(form))
-	for k, v := range form {
-		parts = append(parts, fmt.Sprintf("%s=%s", k, v))
+	return encodeParams(form)
+}
+
+// BuildCookieHeader joins the cookies into one header, repeated names keep
+// every value in the order they were sent.
+func BuildCookieHeader(cookies Values) string {
+	pairs := make([]string, 0, len(cookies))
+	for _, k := range cookies.Keys() {
+		for _, v := range cookies[k] {
+			pairs = append(pairs, k+"="+v)
+		}
+	}
+	return strings.Join(pairs, "; ")
+}
+
+func encodeParams(params map[string]string) string {
+	values := make(url.Values, len(params))
+	for k, v := range params {
+		values.Set(k, v)
 	}
-	return strings.Join(parts, "&")
+	return values.Encode()
 }
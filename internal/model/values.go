@@ -0,0 +1,120 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Values keeps every value of headers, cookies and query params that were
+// sent more than once, in the order they were sent. Transactions stored before values were
+// kept have a single string per key, both forms are read.
+type Values map[string][]string
+
+// Get returns the first value of key.
+func (v Values) Get(key string) string {
+	if values := v[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Set replaces every value of key.
+func (v Values) Set(key, value string) {
+	v[key] = []string{value}
+}
+
+func (v Values) Add(key, value string) {
+	v[key] = append(v[key], value)
+}
+
+// Keys returns the keys in a stable order.
+func (v Values) Keys() []string {
+	return slices.Sorted(maps.Keys(v))
+}
+
+// Flat joins repeated values with a comma, for views that show one value
+// per key.
+func (v Values) Flat() map[string]string {
+	flat := make(map[string]string, len(v))
+	for k, values := range v {
+		flat[k] = strings.Join(values, ", ")
+	}
+	return flat
+}
+
+func (v Values) Clone() Values {
+	clone := make(Values, len(v))
+	for k, values := range v {
+		clone[k] = slices.Clone(values)
+	}
+	return clone
+}
+
+func (v *Values) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		*v = nil
+		return nil
+	}
+
+	values := make(Values, len(raw))
+	for k, value := range raw {
+		var single string
+		if err := json.Unmarshal(value, &single); err == nil {
+			values[k] = []string{single}
+			continue
+		}
+
+		var list []string
+		if err := json.Unmarshal(value, &list); err != nil {
+			return fmt.Errorf("value of %q: %w", k, err)
+		}
+		values[k] = list
+	}
+
+	*v = values
+	return nil
+}
+
+func (v *Values) UnmarshalBSONValue(typ byte, data []byte) error {
+	raw := bson.RawValue{Type: bson.Type(typ), Value: data}
+	if raw.Type == bson.TypeNull {
+		*v = nil
+		return nil
+	}
+
+	doc, ok := raw.DocumentOK()
+	if !ok {
+		return fmt.Errorf("cannot decode %s into values", raw.Type)
+	}
+	elements, err := doc.Elements()
+	if err != nil {
+		return err
+	}
+
+	values := make(Values, len(elements))
+	for _, element := range elements {
+		k, value := element.Key(), element.Value()
+		if single, ok := value.StringValueOK(); ok {
+			values[k] = []string{single}
+			continue
+		}
+
+		var list []string
+		if err := value.Unmarshal(&list); err != nil {
+			return fmt.Errorf("value of %q: %w", k, err)
+		}
+		values[k] = list
+	}
+
+	*v = values
+	return nil
+}
@@ -0,0 +1,95 @@
+package model
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// transactions stored before repeated values were kept have plain strings
+func TestValuesDecodeLegacy(t *testing.T) {
+	want := Values{"Accept": {"text/html"}, "X-Forwarded-For": {"10.0.0.1", "10.0.0.2"}}
+
+	t.Run("json", func(t *testing.T) {
+		var req Request
+		data := `{"headers": {"Accept": "text/html", "X-Forwarded-For": ["10.0.0.1", "10.0.0.2"]}}`
+		if err := json.Unmarshal([]byte(data), &req); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(req.Headers, want) {
+			t.Errorf("Headers = %v, want %v", req.Headers, want)
+		}
+	})
+
+	t.Run("bson", func(t *testing.T) {
+		data, err := bson.Marshal(bson.M{"headers": bson.M{
+			"Accept":          "text/html",
+			"X-Forwarded-For": bson.A{"10.0.0.1", "10.0.0.2"},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var req Request
+		if err := bson.Unmarshal(data, &req); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(req.Headers, want) {
+			t.Errorf("Headers = %v, want %v", req.Headers, want)
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		data, err := bson.Marshal(Request{Headers: want})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var req Request
+		if err := bson.Unmarshal(data, &req); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(req.Headers, want) {
+			t.Errorf("Headers = %v, want %v", req.Headers, want)
+		}
+	})
+}
+
+// repeated query params come back as sent, also through a stored transaction
+func TestQueryParamsRoundTrip(t *testing.T) {
+	const target = "http://example.com/search?a=1&a=2&q=x+y"
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	captured := NewRequest(req)
+	want := Values{"a": {"1", "2"}, "q": {"x y"}}
+	if !reflect.DeepEqual(captured.QueryParams, want) {
+		t.Fatalf("QueryParams = %v, want %v", captured.QueryParams, want)
+	}
+
+	data, err := bson.Marshal(captured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored Request
+	if err := bson.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if got := BuildURL(stored); got != target {
+		t.Errorf("BuildURL() = %s, want %s", got, target)
+	}
+
+	// query params stored before repeats were kept have plain strings
+	var legacy Request
+	if err := json.Unmarshal([]byte(`{"query_params": {"a": "1, 2"}}`), &legacy); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(legacy.QueryParams, Values{"a": {"1, 2"}}) {
+		t.Errorf("legacy QueryParams = %v", legacy.QueryParams)
+	}
+}
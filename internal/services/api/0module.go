@@ -1,8 +1,15 @@
 package api
 
 import (
+	"github.com/daronenko/https-proxy/internal/services/api/audit"
+	"github.com/daronenko/https-proxy/internal/services/api/blob"
 	httpdelivery "github.com/daronenko/https-proxy/internal/services/api/delivery"
+	"github.com/daronenko/https-proxy/internal/services/api/feed"
+	"github.com/daronenko/https-proxy/internal/services/api/project"
+	"github.com/daronenko/https-proxy/internal/services/api/queue"
 	"github.com/daronenko/https-proxy/internal/services/api/repo"
+	"github.com/daronenko/https-proxy/internal/services/api/retention"
+	"github.com/daronenko/https-proxy/internal/services/api/ui"
 	"go.uber.org/fx"
 )
 
@@ -10,6 +17,13 @@ func Module() fx.Option {
 	return fx.Module(
 		"api",
 		repo.Module(),
+		audit.Module(),
+		feed.Module(),
+		blob.Module(),
+		project.Module(),
+		queue.Module(),
+		retention.Module(),
 		httpdelivery.Module(),
+		ui.Module(),
 	)
 }
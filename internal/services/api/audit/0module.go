@@ -0,0 +1,12 @@
+package audit
+
+import (
+	"go.uber.org/fx"
+)
+
+func Module() fx.Option {
+	return fx.Module(
+		"api.audit",
+		fx.Provide(New),
+	)
+}
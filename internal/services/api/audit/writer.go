@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/metrics"
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/internal/services/api/repo"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/fx"
+)
+
+const (
+	capacity     = 1000
+	writeTimeout = 5 * time.Second
+)
+
+// Writer stores audit entries in the background from a single goroutine.
+// Entries wait in a bounded buffer, once the database falls that far behind
+// new ones are dropped and counted. Every entry is logged before it is
+// queued, so a dropped one is still in the log.
+type Writer struct {
+	store   func(context.Context, *model.AuditEntry) error
+	metrics *metrics.Metrics
+
+	entries chan *model.AuditEntry
+	done    chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func New(repo *repo.Request, metrics *metrics.Metrics, lc fx.Lifecycle) *Writer {
+	w := newWriter(repo.CreateAuditEntry, metrics)
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go w.run()
+			return nil
+		},
+		OnStop: w.stop,
+	})
+
+	return w
+}
+
+func newWriter(store func(context.Context, *model.AuditEntry) error, metrics *metrics.Metrics) *Writer {
+	return &Writer{
+		store:   store,
+		metrics: metrics,
+		entries: make(chan *model.AuditEntry, capacity),
+		done:    make(chan struct{}),
+	}
+}
+
+// Write queues entry without waiting for the database.
+func (w *Writer) Write(entry *model.AuditEntry) {
+	log.Info().
+		Str("actor", entry.Actor).
+		Str("role", entry.Role).
+		Str("method", entry.Method).
+		Str("path", entry.Path).
+		Int("status", entry.Status).
+		Msg("audit")
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		w.metrics.AuditDropped.Inc()
+		return
+	}
+
+	select {
+	case w.entries <- entry:
+	default:
+		w.metrics.AuditDropped.Inc()
+	}
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+
+	for entry := range w.entries {
+		ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+		if err := w.store(ctx, entry); err != nil {
+			w.metrics.AuditDropped.Inc()
+			log.Err(err).Msg("failed to store audit entry")
+		}
+		cancel()
+	}
+}
+
+// stop writes what is buffered until ctx expires.
+func (w *Writer) stop(ctx context.Context) error {
+	w.mu.Lock()
+	w.closed = true
+	close(w.entries)
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		log.Warn().Int("entries", len(w.entries)).Msg("audit log drain timed out")
+	}
+	return nil
+}
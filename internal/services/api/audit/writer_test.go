@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/metrics"
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWriterDropsWhenFull(t *testing.T) {
+	m := metrics.New()
+
+	release := make(chan struct{})
+	var stored atomic.Int64
+	w := newWriter(func(context.Context, *model.AuditEntry) error {
+		<-release
+		stored.Add(1)
+		return nil
+	}, m)
+	go w.run()
+
+	// one entry is being stored, the buffer holds capacity more
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range capacity + 10 {
+			w.Write(&model.AuditEntry{Actor: "viewer"})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Write blocked on a slow database")
+	}
+
+	if dropped := testutil.ToFloat64(m.AuditDropped); dropped < 9 || dropped > 10 {
+		t.Errorf("dropped %v entries, want 9 or 10", dropped)
+	}
+
+	close(release)
+	if err := w.stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stored.Load()+int64(testutil.ToFloat64(m.AuditDropped)), int64(capacity+10); got != want {
+		t.Errorf("stored and dropped %d entries, want %d", got, want)
+	}
+
+	w.Write(&model.AuditEntry{Actor: "late"})
+	if got, want := stored.Load()+int64(testutil.ToFloat64(m.AuditDropped)), int64(capacity+11); got != want {
+		t.Errorf("entry written after stop was not counted as dropped")
+	}
+}
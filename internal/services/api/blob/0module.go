@@ -0,0 +1,12 @@
+package blob
+
+import (
+	"go.uber.org/fx"
+)
+
+func Module() fx.Option {
+	return fx.Module(
+		"api.blob",
+		fx.Provide(New),
+	)
+}
@@ -0,0 +1,380 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/internal/services/api/repo"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/fx"
+)
+
+const (
+	defaultGcInterval = time.Hour
+	defaultGcGrace    = time.Hour
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// refCounter is the bookkeeping the store needs from the request repo.
+type refCounter interface {
+	AddBlobRef(ctx context.Context, hash string, size int64) error
+	CountBlobRefs(ctx context.Context) (map[string]int64, error)
+	ListBlobs(ctx context.Context, before time.Time) ([]repo.Blob, error)
+	SetBlobRefs(ctx context.Context, hash string, refs int64, before time.Time) error
+	DeleteBlob(ctx context.Context, hash string, before time.Time) (bool, error)
+	HasBlob(ctx context.Context, hash string) (bool, error)
+}
+
+// Store keeps large bodies on disk under their sha-256, so the same content
+// is stored once however many transactions carry it. Reference counts live
+// in mongo and are reconciled by a periodic collector, which also removes
+// blobs no transaction points to anymore.
+type Store struct {
+	repo      refCounter
+	root      string
+	threshold int
+	gcGrace   time.Duration
+
+	// mu keeps the collector from removing a file between Put finding it
+	// and counting the new reference
+	mu sync.RWMutex
+}
+
+func New(repo *repo.Request, conf *config.Config, lc fx.Lifecycle) (*Store, error) {
+	spec := conf.App.Blobs
+
+	s := &Store{
+		repo:      repo,
+		root:      spec.Path,
+		threshold: spec.Threshold,
+		gcGrace:   spec.GcGrace,
+	}
+	if s.gcGrace <= 0 {
+		s.gcGrace = defaultGcGrace
+	}
+
+	if !s.Enabled() {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(s.root, 0o755); err != nil {
+		return nil, fmt.Errorf("create blob directory: %w", err)
+	}
+
+	interval := spec.GcInterval
+	if interval <= 0 {
+		interval = defaultGcInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go s.collectEvery(ctx, interval, done)
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+			case <-stopCtx.Done():
+			}
+			return nil
+		},
+	})
+
+	return s, nil
+}
+
+func (s *Store) Enabled() bool {
+	return s.threshold > 0 && s.root != ""
+}
+
+// Offload moves bodies above the threshold into the store and replaces
+// them with references. The returned function puts the bodies back, for
+// transactions that end up spilled instead of stored. A body that can not
+// be written stays inline.
+func (s *Store) Offload(ctx context.Context, transactions []*model.Transaction) (restore func()) {
+	type inline struct {
+		body *[]byte
+		ref  *string
+		size *int64
+		data []byte
+	}
+	var moved []inline
+
+	for _, transaction := range transactions {
+		for _, part := range []inline{
+			{body: &transaction.Request.Body, ref: &transaction.Request.BodyRef, size: &transaction.Request.BodySize},
+			{body: &transaction.Response.Body, ref: &transaction.Response.BodyRef, size: &transaction.Response.BodySize},
+		} {
+			if !s.Enabled() || len(*part.body) <= s.threshold {
+				continue
+			}
+
+			hash, err := s.Put(ctx, *part.body)
+			if err != nil {
+				log.Err(err).Msg("failed to offload body, keeping it inline")
+				continue
+			}
+
+			part.data = *part.body
+			moved = append(moved, part)
+			*part.ref, *part.size, *part.body = hash, int64(len(part.data)), nil
+		}
+	}
+
+	return func() {
+		for _, part := range moved {
+			*part.body, *part.ref, *part.size = part.data, "", 0
+		}
+	}
+}
+
+// Put stores data unless it is already there and counts one more
+// reference to it.
+func (s *Store) Put(ctx context.Context, data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path := s.path(hash)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		if err := writeFile(path, data); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", fmt.Errorf("stat blob: %w", err)
+	}
+
+	if err := s.repo.AddBlobRef(ctx, hash, int64(len(data))); err != nil {
+		return "", err
+	}
+
+	return hash, nil
+}
+
+// writeFile writes through a temporary file, so a blob under its final
+// name is always complete.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create blob directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close blob: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename blob: %w", err)
+	}
+
+	return nil
+}
+
+// Open returns the blob for streaming, the caller closes it.
+func (s *Store) Open(hash string) (*os.File, error) {
+	if !validHash(hash) {
+		return nil, ErrBlobNotFound
+	}
+
+	f, err := os.Open(s.path(hash))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("open blob: %w", err)
+	}
+
+	return f, nil
+}
+
+// Inline loads referenced bodies back into the transaction, for handlers
+// that work on whole requests such as repeat, scan and export.
+func (s *Store) Inline(transaction *model.Transaction) error {
+	for _, part := range []struct {
+		body *[]byte
+		ref  string
+	}{
+		{&transaction.Request.Body, transaction.Request.BodyRef},
+		{&transaction.Response.Body, transaction.Response.BodyRef},
+	} {
+		if part.ref == "" {
+			continue
+		}
+
+		f, err := s.Open(part.ref)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("read blob: %w", err)
+		}
+		*part.body = data
+	}
+
+	return nil
+}
+
+func (s *Store) path(hash string) string {
+	return filepath.Join(s.root, hash[:2], hash[2:4], hash)
+}
+
+func validHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil && strings.ToLower(hash) == hash
+}
+
+func (s *Store) collectEvery(ctx context.Context, interval time.Duration, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		removed, err := s.Collect(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Err(err).Msg("failed to collect blobs")
+		}
+		if removed > 0 {
+			log.Info().Int("blobs", removed).Msg("removed unreferenced blobs")
+		}
+	}
+}
+
+// Collect recounts references from the transactions collection, which also
+// catches transactions removed by the ttl index, and deletes blobs left
+// without any. Blobs touched within the grace period are skipped, their
+// transactions may still be on the way to the database.
+func (s *Store) Collect(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-s.gcGrace)
+
+	blobs, err := s.repo.ListBlobs(ctx, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	refs, err := s.repo.CountBlobRefs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, blob := range blobs {
+		actual := refs[blob.Hash]
+		if actual > 0 {
+			if actual != blob.Refs {
+				if err := s.repo.SetBlobRefs(ctx, blob.Hash, actual, cutoff); err != nil {
+					return removed, err
+				}
+			}
+			continue
+		}
+
+		deleted, err := s.remove(ctx, blob.Hash, cutoff)
+		if err != nil {
+			return removed, err
+		}
+		if deleted {
+			removed++
+		}
+	}
+
+	orphans, err := s.removeOrphans(ctx, cutoff)
+	return removed + orphans, err
+}
+
+func (s *Store) remove(ctx context.Context, hash string, cutoff time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.repo.SetBlobRefs(ctx, hash, 0, cutoff); err != nil {
+		return false, err
+	}
+	deleted, err := s.repo.DeleteBlob(ctx, hash, cutoff)
+	if err != nil || !deleted {
+		return false, err
+	}
+
+	if err := os.Remove(s.path(hash)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("remove blob: %w", err)
+	}
+
+	return true, nil
+}
+
+// removeOrphans deletes files without a record, left behind when the
+// process died between writing a blob and counting its reference.
+func (s *Store) removeOrphans(ctx context.Context, cutoff time.Time) (int, error) {
+	removed := 0
+
+	err := filepath.WalkDir(s.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return err
+		}
+
+		name := entry.Name()
+		if strings.HasPrefix(name, ".tmp-") {
+			removed++
+			return os.Remove(path)
+		}
+		if !validHash(name) {
+			return nil
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		exists, err := s.repo.HasBlob(ctx, name)
+		if err != nil || exists {
+			return err
+		}
+		removed++
+		return os.Remove(path)
+	})
+	if err != nil {
+		return removed, fmt.Errorf("walk blobs: %w", err)
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,280 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/services/api/repo"
+)
+
+// memoryRefs keeps blob records the way the mongo repo does, refs are the
+// body references of stored transactions.
+type memoryRefs struct {
+	mu    sync.Mutex
+	blobs map[string]repo.Blob
+	refs  map[string]int64
+}
+
+func newMemoryRefs() *memoryRefs {
+	return &memoryRefs{
+		blobs: make(map[string]repo.Blob),
+		refs:  make(map[string]int64),
+	}
+}
+
+func (m *memoryRefs) AddBlobRef(ctx context.Context, hash string, size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	blob, ok := m.blobs[hash]
+	if !ok {
+		blob = repo.Blob{Hash: hash, Size: size}
+	}
+	blob.Refs++
+	blob.TouchedAt = time.Now()
+	m.blobs[hash] = blob
+	return nil
+}
+
+func (m *memoryRefs) CountBlobRefs(ctx context.Context) (map[string]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	refs := make(map[string]int64, len(m.refs))
+	for hash, count := range m.refs {
+		refs[hash] = count
+	}
+	return refs, nil
+}
+
+func (m *memoryRefs) ListBlobs(ctx context.Context, before time.Time) ([]repo.Blob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var blobs []repo.Blob
+	for _, blob := range m.blobs {
+		if blob.TouchedAt.Before(before) {
+			blobs = append(blobs, blob)
+		}
+	}
+	return blobs, nil
+}
+
+func (m *memoryRefs) SetBlobRefs(ctx context.Context, hash string, refs int64, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if blob, ok := m.blobs[hash]; ok && blob.TouchedAt.Before(before) {
+		blob.Refs = refs
+		m.blobs[hash] = blob
+	}
+	return nil
+}
+
+func (m *memoryRefs) DeleteBlob(ctx context.Context, hash string, before time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	blob, ok := m.blobs[hash]
+	if !ok || blob.Refs > 0 || !blob.TouchedAt.Before(before) {
+		return false, nil
+	}
+	delete(m.blobs, hash)
+	return true, nil
+}
+
+func (m *memoryRefs) HasBlob(ctx context.Context, hash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.blobs[hash]
+	return ok, nil
+}
+
+// age moves the touch time of a blob and the mtime of its file back.
+func (m *memoryRefs) age(t *testing.T, s *Store, hash string, by time.Duration) {
+	t.Helper()
+
+	m.mu.Lock()
+	blob := m.blobs[hash]
+	blob.TouchedAt = blob.TouchedAt.Add(-by)
+	m.blobs[hash] = blob
+	m.mu.Unlock()
+
+	ageFile(t, s.path(hash), by)
+}
+
+func ageFile(t *testing.T, path string, by time.Duration) {
+	t.Helper()
+
+	past := time.Now().Add(-by)
+	if err := os.Chtimes(path, past, past); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestStore(t *testing.T) (*Store, *memoryRefs) {
+	t.Helper()
+
+	refs := newMemoryRefs()
+	return &Store{
+		repo:      refs,
+		root:      t.TempDir(),
+		threshold: 16,
+		gcGrace:   time.Hour,
+	}, refs
+}
+
+// files lists the names of everything stored under the root.
+func files(t *testing.T, root string) []string {
+	t.Helper()
+
+	var names []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestPutDeduplicates(t *testing.T) {
+	s, refs := newTestStore(t)
+	ctx := context.Background()
+
+	body := bytes.Repeat([]byte("large body "), 100)
+	first, err := s.Put(ctx, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.Put(ctx, bytes.Clone(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(body)
+	if first != hex.EncodeToString(sum[:]) || second != first {
+		t.Fatalf("Put() = %s and %s, want the sha-256 of the body", first, second)
+	}
+	if stored := files(t, s.root); len(stored) != 1 || stored[0] != first {
+		t.Fatalf("store holds %v, want a single file named after the hash", stored)
+	}
+	if got, err := os.ReadFile(s.path(first)); err != nil || !bytes.Equal(got, body) {
+		t.Fatalf("stored file differs from the body: %v", err)
+	}
+	if blob := refs.blobs[first]; blob.Refs != 2 || blob.Size != int64(len(body)) {
+		t.Errorf("blob record = %+v, want 2 references of %d bytes", blob, len(body))
+	}
+
+	other, err := s.Put(ctx, []byte("another large body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == first || len(files(t, s.root)) != 2 {
+		t.Error("different content was not stored separately")
+	}
+	for _, name := range files(t, s.root) {
+		if strings.HasPrefix(name, ".tmp-") {
+			t.Errorf("temporary file %s was left behind", name)
+		}
+	}
+}
+
+func TestCollectKeepsBlobsWithinGrace(t *testing.T) {
+	s, refs := newTestStore(t)
+	ctx := context.Background()
+
+	put := func(data string) string {
+		hash, err := s.Put(ctx, []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	// unreferenced long ago, unreferenced but just written, still referenced
+	stale := put("body of a pruned transaction")
+	fresh := put("body of a transaction still on its way")
+	kept := put("body of a stored transaction")
+	refs.age(t, s, stale, 2*s.gcGrace)
+	refs.age(t, s, kept, 2*s.gcGrace)
+	refs.refs[kept] = 3
+
+	// files without a record, from a crash between writing and counting
+	orphan := sha256.Sum256([]byte("orphan"))
+	oldOrphan := filepath.Join(s.root, hex.EncodeToString(orphan[:]))
+	youngOrphan := filepath.Join(s.root, strings.Repeat("a", sha256.Size*2))
+	oldTemp := filepath.Join(s.root, ".tmp-1234")
+	for _, path := range []string{oldOrphan, youngOrphan, oldTemp} {
+		if err := os.WriteFile(path, []byte("partial"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ageFile(t, oldOrphan, 2*s.gcGrace)
+	ageFile(t, oldTemp, 2*s.gcGrace)
+
+	removed, err := s.Collect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 3 {
+		t.Errorf("Collect() removed %d, want 3", removed)
+	}
+
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	tests := []struct {
+		name string
+		path string
+		want bool
+	}{
+		{"unreferenced blob past the grace", s.path(stale), false},
+		{"unreferenced blob within the grace", s.path(fresh), true},
+		{"referenced blob", s.path(kept), true},
+		{"orphan past the grace", oldOrphan, false},
+		{"orphan within the grace", youngOrphan, true},
+		{"temporary file past the grace", oldTemp, false},
+	}
+	for _, tt := range tests {
+		if got := exists(tt.path); got != tt.want {
+			t.Errorf("%s: exists = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, ok := refs.blobs[stale]; ok {
+		t.Error("record of the removed blob was kept")
+	}
+	if blob := refs.blobs[fresh]; blob.Refs != 1 {
+		t.Errorf("blob within the grace has %d references, want it untouched", blob.Refs)
+	}
+	if blob := refs.blobs[kept]; blob.Refs != 3 {
+		t.Errorf("referenced blob has %d references, want the recount of 3", blob.Refs)
+	}
+
+	// a blob picked up again before the pass is not removed
+	refs.age(t, s, fresh, 2*s.gcGrace)
+	if _, err := s.Put(ctx, []byte("body of a transaction still on its way")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Collect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(s.path(fresh)) {
+		t.Error("blob referenced again was removed")
+	}
+}
@@ -3,18 +3,37 @@ package httpdelivery
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/daronenko/https-proxy/internal/app/config"
 	"github.com/daronenko/https-proxy/internal/httpserver"
+	"github.com/daronenko/https-proxy/internal/metrics"
 	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/internal/services/api/audit"
+	"github.com/daronenko/https-proxy/internal/services/api/blob"
+	"github.com/daronenko/https-proxy/internal/services/api/feed"
+	"github.com/daronenko/https-proxy/internal/services/api/project"
 	"github.com/daronenko/https-proxy/internal/services/api/repo"
+	"github.com/daronenko/https-proxy/internal/tracing"
+	"github.com/daronenko/https-proxy/pkg/apiauth"
+	"github.com/daronenko/https-proxy/pkg/certstore"
 	"github.com/daronenko/https-proxy/pkg/httpctl"
+	"github.com/daronenko/https-proxy/pkg/onboarding"
+	"github.com/daronenko/https-proxy/pkg/oob"
 	"github.com/daronenko/https-proxy/pkg/scanner"
+	"github.com/daronenko/https-proxy/pkg/scope"
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/fx"
 )
 
@@ -22,17 +41,73 @@ const RequestID = "request_id"
 
 type Api struct {
 	fx.In
-	Conf *config.Config
-	Repo *repo.Request
+	Conf     *config.Config
+	Repo     *repo.Request
+	Audit    *audit.Writer
+	Blobs    *blob.Store
+	Feed     *feed.Hub
+	Oob      *oob.Server
+	Metrics  *metrics.Metrics
+	Tracing  trace.TracerProvider
+	Projects *project.Active
+	Scope    *scope.Scope
+	Auth     *apiauth.Authenticator
+	Portal   *onboarding.Portal
+	Certs    *certstore.Store
+	Reloader *config.Reloader
+	Scanner  *config.Live[config.ScannerSpec]
+	Fuzzer   *config.Live[config.FuzzerSpec]
 }
 
 func Init(d Api, api *httpserver.ApiRouter) {
+	api.Use(d.Metrics.Middleware)
+	api.Use(tracing.Middleware(d.Tracing))
+	api.Use(d.Authorize)
+
 	api.HandleFunc("/ping", d.Ping).Methods("GET")
+	api.Handle("/metrics", d.Metrics.Handler()).Methods("GET")
 
 	api.HandleFunc("/requests", d.RequestsList).Methods("GET")
+	api.HandleFunc("/requests/import", d.ImportRequests).Methods("POST")
+	api.HandleFunc("/stream", d.StreamSSE).Methods("GET")
+	api.HandleFunc("/stream/ws", d.StreamWebSocket).Methods("GET")
 	api.HandleFunc("/request/{request_id}", d.GetRequestByID).Methods("GET")
+	api.HandleFunc("/request/{request_id}/export", d.ExportRequestByID).Methods("GET")
+	api.HandleFunc("/request/{request_id}/body", d.GetBodyByID).Methods("GET")
 	api.HandleFunc("/repeat/{request_id}", d.RepeatRequestByID).Methods("POST")
 	api.HandleFunc("/scan/{request_id}", d.ScanRequestByID).Methods("POST")
+	api.HandleFunc("/fuzz/{request_id}", d.FuzzRequestByID).Methods("POST")
+	api.HandleFunc("/diff/{a}/{b}", d.DiffTransactions).Methods("GET")
+	api.HandleFunc("/request/{request_id}/pin", d.PinRequest).Methods("POST", "DELETE")
+
+	api.HandleFunc("/projects", d.ProjectsList).Methods("GET")
+	api.HandleFunc("/projects", d.CreateProject).Methods("POST")
+	api.HandleFunc("/projects/import", d.ImportProject).Methods("POST")
+	api.HandleFunc("/projects/active", d.GetActiveProject).Methods("GET")
+	api.HandleFunc("/projects/active", d.SetActiveProject).Methods("PUT")
+	api.HandleFunc("/projects/{project_id}", d.GetProjectByID).Methods("GET")
+	api.HandleFunc("/projects/{project_id}", d.UpdateProject).Methods("PATCH")
+	api.HandleFunc("/projects/{project_id}", d.DeleteProject).Methods("DELETE")
+	api.HandleFunc("/projects/{project_id}/export", d.ExportProject).Methods("GET")
+
+	api.HandleFunc("/scope", d.GetScope).Methods("GET")
+	api.HandleFunc("/scope", d.ReplaceScope).Methods("PUT")
+	api.HandleFunc("/scope/check", d.CheckScope).Methods("GET")
+
+	api.HandleFunc("/admin/requests", d.PurgeRequests).Methods("DELETE")
+	api.HandleFunc("/admin/audit", d.AuditLog).Methods("GET")
+	api.HandleFunc("/admin/certs", d.CertsList).Methods("GET")
+	api.HandleFunc("/admin/certs/{host}", d.GetCert).Methods("GET")
+	api.HandleFunc("/admin/certs/{host}", d.RenewCert).Methods("POST")
+	api.HandleFunc("/admin/certs/{host}", d.RevokeCert).Methods("DELETE")
+	api.HandleFunc("/admin/ca", d.GetCAInfo).Methods("GET")
+	api.HandleFunc("/admin/ca", d.RegenerateCA).Methods("POST")
+	api.HandleFunc("/admin/reload", d.ReloadConfig).Methods("POST")
+
+	api.HandleFunc("/ca", d.GetCA).Methods("GET")
+	api.Handle("/onboarding", http.RedirectHandler("/onboarding/", http.StatusFound)).Methods("GET")
+	api.HandleFunc("/onboarding/", d.Onboarding).Methods("GET")
+	api.HandleFunc("/onboarding/{file}", d.Onboarding).Methods("GET")
 }
 
 func (d *Api) Ping(w http.ResponseWriter, r *http.Request) {
@@ -41,7 +116,17 @@ func (d *Api) Ping(w http.ResponseWriter, r *http.Request) {
 }
 
 func (d *Api) RequestsList(w http.ResponseWriter, r *http.Request) {
-	requests, err := d.Repo.GetTransactionsList(context.Background())
+	projectID, ok := d.projectScope(w, r)
+	if !ok {
+		return
+	}
+
+	filter := repo.ProjectScope(projectID)
+	if user := r.URL.Query().Get("user"); user != "" {
+		filter = append(filter, bson.E{Key: "user", Value: user})
+	}
+
+	requests, err := d.Repo.GetTransactionsList(r.Context(), filter)
 	if err != nil {
 		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to get requests")
 		return
@@ -68,7 +153,12 @@ func (d *Api) GetRequestByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	request, err := d.Repo.GetTransactionByID(context.Background(), requestID)
+	projectID, ok := d.projectScope(w, r)
+	if !ok {
+		return
+	}
+
+	request, err := d.Repo.GetTransactionByID(r.Context(), requestID, repo.ProjectScope(projectID))
 	if err != nil {
 		httpctl.ErrorResponse(w, http.StatusNotFound, "request not found")
 		return
@@ -77,6 +167,89 @@ func (d *Api) GetRequestByID(w http.ResponseWriter, r *http.Request) {
 	httpctl.JsonResponse(w, http.StatusOK, request)
 }
 
+// repeatPatch describes changes applied to the stored request before it is
+// repeated, null header and cookie values remove them.
+type repeatPatch struct {
+	Method  *string            `json:"method"`
+	URL     *string            `json:"url"`
+	Headers map[string]*string `json:"headers"`
+	Cookies map[string]*string `json:"cookies"`
+	Body    *string            `json:"body"`
+}
+
+func (p repeatPatch) apply(req *model.Request) error {
+	if p.Method != nil {
+		req.Method = strings.ToUpper(*p.Method)
+	}
+
+	if p.URL != nil {
+		u, err := url.Parse(*p.URL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid url %q", *p.URL)
+		}
+		req.Protocol = u.Scheme
+		req.Host = u.Host
+		req.Path = u.Path
+		req.QueryParams = model.Values(u.Query())
+	}
+
+	if req.Headers == nil {
+		req.Headers = make(model.Values)
+	}
+	if req.Cookies == nil {
+		req.Cookies = make(model.Values)
+	}
+
+	for k, v := range p.Headers {
+		k = http.CanonicalHeaderKey(k)
+		if v == nil {
+			delete(req.Headers, k)
+		} else {
+			req.Headers.Set(k, *v)
+		}
+	}
+
+	for k, v := range p.Cookies {
+		if v == nil {
+			delete(req.Cookies, k)
+		} else {
+			req.Cookies.Set(k, *v)
+		}
+	}
+
+	if p.Body != nil {
+		req.Body = []byte(*p.Body)
+	}
+
+	return nil
+}
+
+func newHTTPRequest(req model.Request) (*http.Request, error) {
+	httpReq, err := http.NewRequest(req.Method, model.BuildURL(req), bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	// the stored body is decoded, like in model.BuildRaw
+	for k, values := range req.Headers {
+		switch http.CanonicalHeaderKey(k) {
+		case "Host", "Cookie", "Content-Length", "Content-Encoding":
+			continue
+		}
+		for _, v := range values {
+			httpReq.Header.Add(k, v)
+		}
+	}
+
+	for name, values := range req.Cookies {
+		for _, val := range values {
+			httpReq.AddCookie(&http.Cookie{Name: name, Value: val})
+		}
+	}
+
+	return httpReq, nil
+}
+
 func (d *Api) RepeatRequestByID(w http.ResponseWriter, r *http.Request) {
 	requestIDStr, present := mux.Vars(r)["request_id"]
 	if !present {
@@ -90,29 +263,45 @@ func (d *Api) RepeatRequestByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	transaction, err := d.Repo.GetTransactionByID(context.Background(), requestID)
-	if err != nil {
-		httpctl.ErrorResponse(w, http.StatusNotFound, "original request not found")
+	var patch repeatPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil && err != io.EOF {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid repeat patch")
 		return
 	}
 
-	originalReq := transaction.Request
-	url := model.BuildURL(originalReq)
+	projectID, ok := d.projectScope(w, r)
+	if !ok {
+		return
+	}
 
-	req, err := http.NewRequest(originalReq.Method, url, bytes.NewReader(originalReq.Body))
+	transaction, err := d.Repo.GetTransactionByID(r.Context(), requestID, repo.ProjectScope(projectID))
 	if err != nil {
-		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to construct repeated request")
+		httpctl.ErrorResponse(w, http.StatusNotFound, "original request not found")
+		return
+	}
+	if err := d.Blobs.Inline(transaction); err != nil {
+		log.Err(err).Msg("failed to load transaction bodies")
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to load request body")
 		return
 	}
 
-	for k, v := range originalReq.Headers {
-		req.Header.Set(k, v)
+	modifiedReq := transaction.Request.Clone()
+	if err := patch.apply(&modifiedReq); err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	for name, val := range originalReq.Cookies {
-		req.AddCookie(&http.Cookie{Name: name, Value: val})
+	req, err := newHTTPRequest(modifiedReq)
+	if err != nil {
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to construct repeated request")
+		return
 	}
 
+	// parsing drains the body, so it is restored before sending
+	storedReq := model.NewRequest(req)
+	storedReq.Protocol = req.URL.Scheme
+	req.Body = io.NopCloser(bytes.NewReader(storedReq.Body))
+
 	client := &http.Client{
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
@@ -125,7 +314,24 @@ func (d *Api) RepeatRequestByID(w http.ResponseWriter, r *http.Request) {
 	}
 	defer resp.Body.Close()
 
-	w.WriteHeader(resp.StatusCode)
+	repeated := &model.Transaction{
+		ParentID:  transaction.ID,
+		ProjectID: transaction.ProjectID,
+		Request:   storedReq,
+		Response:  model.NewResponse(resp),
+		CreatedAt: time.Now(),
+	}
+	d.Blobs.Offload(r.Context(), []*model.Transaction{repeated})
+
+	repeated, err = d.Repo.CreateTransaction(r.Context(), repeated)
+	if err != nil {
+		log.Err(err).Msg("failed to store repeated transaction")
+	} else {
+		d.Feed.Publish(repeated)
+		if id, ok := repeated.ID.(bson.ObjectID); ok {
+			w.Header().Set("X-Transaction-Id", id.Hex())
+		}
+	}
 
 	for k, v := range resp.Header {
 		for _, hv := range v {
@@ -133,9 +339,11 @@ func (d *Api) RepeatRequestByID(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	w.WriteHeader(resp.StatusCode)
+
 	_, err = io.Copy(w, resp.Body)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to write response body")
+		log.Err(err).Msg("failed to write response body")
 	}
 }
 
@@ -144,6 +352,58 @@ type VulnerabilityScanner interface {
 	Scan(original model.Request, try func(*http.Request) bool) []string
 }
 
+// ResponseMatcher is implemented by scanners that confirm findings with their
+// own response signatures instead of the default one.
+type ResponseMatcher interface {
+	Match(body []byte) bool
+}
+
+func (d *Api) scanners() []VulnerabilityScanner {
+	spec := d.Scanner.Get()
+
+	cmdInjection := scanner.CmdInjection{
+		Payloads: spec.CmdInjection.Payloads,
+	}
+	if len(cmdInjection.Payloads) == 0 {
+		cmdInjection.Payloads = scanner.DefaultCmdInjectionPayloads
+	}
+
+	pathTraversal := scanner.PathTraversal{
+		Payloads:   spec.PathTraversal.Payloads,
+		Signatures: spec.PathTraversal.Signatures,
+	}
+	if len(pathTraversal.Payloads) == 0 {
+		pathTraversal.Payloads = scanner.DefaultPathTraversalPayloads()
+	}
+	if len(pathTraversal.Signatures) == 0 {
+		pathTraversal.Signatures = scanner.DefaultPathTraversalSignatures
+	}
+
+	scanners := []VulnerabilityScanner{cmdInjection, pathTraversal}
+
+	if d.Oob != nil {
+		blind := scanner.Blind{
+			Interactor:   d.Oob,
+			SSRF:         spec.Blind.SSRF,
+			CmdInjection: spec.Blind.CmdInjection,
+			XXE:          spec.Blind.XXE,
+			Wait:         spec.Blind.Wait,
+		}
+		if len(blind.SSRF) == 0 {
+			blind.SSRF = scanner.DefaultBlindSSRFPayloads
+		}
+		if len(blind.CmdInjection) == 0 {
+			blind.CmdInjection = scanner.DefaultBlindCmdInjectionPayloads
+		}
+		if len(blind.XXE) == 0 {
+			blind.XXE = scanner.DefaultBlindXXEPayloads
+		}
+		scanners = append(scanners, blind)
+	}
+
+	return scanners
+}
+
 func (d *Api) ScanRequestByID(w http.ResponseWriter, r *http.Request) {
 	requestIDStr, present := mux.Vars(r)["request_id"]
 	if !present {
@@ -157,45 +417,94 @@ func (d *Api) ScanRequestByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	transaction, err := d.Repo.GetTransactionByID(context.Background(), requestID)
-	if err != nil {
-		httpctl.ErrorResponse(w, http.StatusNotFound, "original request not found")
+	projectID, ok := d.projectScope(w, r)
+	if !ok {
 		return
 	}
-	originalReq := transaction.Request
 
-	try := func(modifiedReq *http.Request) bool {
-		resp, err := http.DefaultClient.Do(modifiedReq)
-		if err != nil {
-			return false
-		}
-		defer resp.Body.Close()
-		body, _ := io.ReadAll(resp.Body)
-		return bytes.Contains(body, []byte("root:"))
+	transaction, err := d.Repo.GetTransactionByID(r.Context(), requestID, repo.ProjectScope(projectID))
+	if err != nil {
+		httpctl.ErrorResponse(w, http.StatusNotFound, "original request not found")
+		return
 	}
-
-	scanners := []VulnerabilityScanner{
-		scanner.CmdInjection{
-			Payloads: []string{";cat /etc/passwd;", "|cat /etc/passwd|", "`cat /etc/passwd`"},
-		},
+	if err := d.Blobs.Inline(transaction); err != nil {
+		log.Err(err).Msg("failed to load transaction bodies")
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to load request body")
+		return
 	}
-
-	found := map[string][]string{}
-	for _, scanner := range scanners {
-		vuln := scanner.Scan(originalReq, try)
-		if len(vuln) > 0 {
-			found[scanner.Name()] = vuln
-		}
+	originalReq := transaction.Request
+	if !d.inScope(w, originalReq) {
+		return
 	}
 
+	found := d.scan(r.Context(), requestIDStr, originalReq)
 	if len(found) == 0 {
+		d.Metrics.ScanJobs.WithLabelValues("clean").Inc()
 		httpctl.JsonResponse(w, http.StatusOK, map[string]any{
 			"result": "no vulnerabilities found",
 		})
 	} else {
+		d.Metrics.ScanJobs.WithLabelValues("vulnerable").Inc()
 		httpctl.JsonResponse(w, http.StatusOK, map[string]any{
 			"result":          "vulnerabilities found",
 			"vulnerabilities": found,
 		})
 	}
 }
+
+// scan runs every scanner against req, each in a span with a child span per
+// probe. The findings are keyed by scanner name.
+func (d *Api) scan(ctx context.Context, transactionID string, req model.Request) map[string][]string {
+	// payloads may rewrite the target, every probe is checked again
+	client := &http.Client{Transport: &scope.Transport{Scope: d.Scope}}
+
+	tracer := d.Tracing.Tracer("github.com/daronenko/https-proxy/internal/services/api")
+
+	found := map[string][]string{}
+	for _, scanner := range d.scanners() {
+		scanCtx, scanSpan := tracer.Start(ctx, "scanner.scan", trace.WithAttributes(
+			attribute.String("scanner.name", scanner.Name()),
+			tracing.TransactionID.String(transactionID),
+		))
+
+		match := func(body []byte) bool {
+			return bytes.Contains(body, []byte("root:"))
+		}
+		if m, ok := scanner.(ResponseMatcher); ok {
+			match = m.Match
+		}
+
+		try := func(modifiedReq *http.Request) bool {
+			_, span := tracer.Start(scanCtx, "scanner.probe", trace.WithAttributes(
+				attribute.String("http.request.method", modifiedReq.Method),
+				attribute.String("url.full", modifiedReq.URL.String()),
+			))
+			defer span.End()
+
+			resp, err := client.Do(modifiedReq)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				return false
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			matched := match(body)
+			span.SetAttributes(
+				attribute.Int("http.response.status_code", resp.StatusCode),
+				attribute.Bool("scanner.matched", matched),
+			)
+			return matched
+		}
+
+		vuln := scanner.Scan(req, try)
+		if len(vuln) > 0 {
+			found[scanner.Name()] = vuln
+		}
+		scanSpan.SetAttributes(attribute.Int("scanner.findings", len(vuln)))
+		scanSpan.End()
+	}
+
+	return found
+}
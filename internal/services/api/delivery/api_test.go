@@ -0,0 +1,260 @@
+package httpdelivery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/internal/metrics"
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/internal/services/api/repo"
+	"github.com/daronenko/https-proxy/internal/tracing"
+	"github.com/daronenko/https-proxy/internal/vulnserver"
+	"github.com/daronenko/https-proxy/pkg/scope"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTracedApi(t *testing.T) (*Api, *tracetest.InMemoryExporter) {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewProvider(config.TracingSpec{SampleRatio: 1}, sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	conf := &config.Config{}
+	conf.App.Mongo.Database = "proxy"
+	conf.App.Mongo.Collections.Transactions = "transactions"
+	conf.App.Scanner.CmdInjection.Payloads = []string{";cat /etc/passwd"}
+	conf.App.Scanner.PathTraversal.Payloads = []string{"../etc/passwd"}
+
+	// nothing listens there, every query fails once server selection gives up
+	client, err := mongo.Connect(options.Client().ApplyURI("mongodb://127.0.0.1:1/?serverSelectionTimeoutMS=50"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	s, err := scope.New(scope.Config{Include: []scope.Rule{{Host: "127.0.0.1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scanner := &config.Live[config.ScannerSpec]{}
+	scanner.Set(conf.App.Scanner)
+
+	m := metrics.New()
+	return &Api{
+		Conf:    conf,
+		Repo:    repo.New(client, conf, m, provider),
+		Metrics: m,
+		Tracing: provider,
+		Scope:   s,
+		Scanner: scanner,
+	}, exporter
+}
+
+func TestScanRequestSpans(t *testing.T) {
+	d, exporter := newTracedApi(t)
+
+	router := mux.NewRouter()
+	router.Use(tracing.Middleware(d.Tracing))
+	router.HandleFunc("/scan/{request_id}", d.ScanRequestByID).Methods("POST")
+
+	id := bson.NewObjectID().Hex()
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	req := httptest.NewRequest(http.MethodPost, "/scan/"+id+"?project=all", nil)
+	req.Header.Set("traceparent", parent)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	spans := exporter.GetSpans()
+	server := findSpan(t, spans, "POST /scan/{request_id}")
+	query := findSpan(t, spans, "mongo.findOne")
+
+	if server.SpanKind != trace.SpanKindServer {
+		t.Errorf("server span kind = %v", server.SpanKind)
+	}
+	if got := server.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("server span trace = %s, traceparent not continued", got)
+	}
+	if got := server.Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("server span parent = %s, want the traceparent span", got)
+	}
+	wantAttributes(t, server,
+		attribute.String("http.request.method", "POST"),
+		attribute.String("http.route", "/scan/{request_id}"),
+		tracing.TransactionID.String(id),
+		attribute.Int("http.response.status_code", http.StatusNotFound),
+	)
+
+	if query.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Error("mongo span is not a child of the server span")
+	}
+	if query.SpanKind != trace.SpanKindClient {
+		t.Errorf("mongo span kind = %v", query.SpanKind)
+	}
+	if query.Status.Code != codes.Error {
+		t.Errorf("mongo span status = %v, want error", query.Status.Code)
+	}
+	wantAttributes(t, query,
+		attribute.String("db.system", "mongodb"),
+		attribute.String("db.operation.name", "findOne"),
+		attribute.String("db.collection.name", "transactions"),
+		tracing.TransactionID.String(id),
+	)
+}
+
+func TestScanSpans(t *testing.T) {
+	d, exporter := newTracedApi(t)
+
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"www/report.txt": "quarterly report",
+		"etc/passwd":     "root:x:0:0:root:/root:/bin/sh\n",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := httptest.NewServer(vulnserver.Handler(filepath.Join(root, "www")))
+	defer srv.Close()
+	target, _ := url.Parse(srv.URL)
+
+	id := bson.NewObjectID().Hex()
+	ctx, parent := d.Tracing.Tracer("test").Start(context.Background(), "request")
+	found := d.scan(ctx, id, model.Request{
+		Method:      http.MethodGet,
+		Protocol:    target.Scheme,
+		Host:        target.Host,
+		Path:        "/download",
+		QueryParams: model.Values{"file": {"report.txt"}},
+	})
+	parent.End()
+
+	if len(found["Path Traversal"]) == 0 {
+		t.Fatalf("found = %v, want a path traversal", found)
+	}
+
+	scans := map[trace.SpanID]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		if span.Name != "scanner.scan" {
+			continue
+		}
+		scans[span.SpanContext.SpanID()] = span
+		if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("scan span %s is not a child of the request span", attr(span, "scanner.name").AsString())
+		}
+		wantAttributes(t, span, tracing.TransactionID.String(id))
+	}
+	if len(scans) != 2 {
+		t.Fatalf("got %d scan spans, want one per scanner", len(scans))
+	}
+
+	var probes, matched int
+	for _, span := range exporter.GetSpans() {
+		if span.Name != "scanner.probe" {
+			continue
+		}
+		probes++
+		scan, ok := scans[span.Parent.SpanID()]
+		if !ok {
+			t.Error("probe span is not a child of a scan span")
+			continue
+		}
+		if attr(span, "http.request.method").AsString() != http.MethodGet {
+			t.Errorf("probe method = %s", attr(span, "http.request.method").Emit())
+		}
+		if attr(span, "http.response.status_code").Type() != attribute.INT64 {
+			t.Error("probe span has no status code")
+		}
+		if attr(span, "scanner.matched").AsBool() {
+			matched++
+			if name := attr(scan, "scanner.name").AsString(); name != "Path Traversal" {
+				t.Errorf("probe of %s matched", name)
+			}
+		}
+	}
+	if probes == 0 || matched == 0 {
+		t.Errorf("got %d probes with %d matches", probes, matched)
+	}
+
+	for _, scan := range scans {
+		want := int64(len(found[attr(scan, "scanner.name").AsString()]))
+		if got := attr(scan, "scanner.findings").AsInt64(); got != want {
+			t.Errorf("%s findings = %d, want %d", attr(scan, "scanner.name").AsString(), got, want)
+		}
+	}
+}
+
+func findSpan(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	t.Fatalf("no %q span", name)
+	return tracetest.SpanStub{}
+}
+
+func attr(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func wantAttributes(t *testing.T, span tracetest.SpanStub, want ...attribute.KeyValue) {
+	t.Helper()
+	for _, kv := range want {
+		if got := attr(span, kv.Key); got != kv.Value {
+			t.Errorf("%s: %s = %s, want %s", span.Name, kv.Key, got.Emit(), kv.Value.Emit())
+		}
+	}
+}
+
+func TestInScopeNeedsInclude(t *testing.T) {
+	d, _ := newTracedApi(t)
+	req := model.Request{Method: http.MethodGet, Protocol: "http", Host: "127.0.0.1", Path: "/"}
+
+	rec := httptest.NewRecorder()
+	if !d.inScope(rec, req) {
+		t.Fatalf("request to an included host refused: %s", rec.Body)
+	}
+
+	if err := d.Scope.Replace(scope.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	if d.inScope(rec, req) {
+		t.Fatal("request allowed without include rules")
+	}
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), scope.ErrNoInclude.Error()) {
+		t.Errorf("got %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,127 @@
+package httpdelivery
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/internal/services/api/blob"
+)
+
+// archiveWriter writes a projectArchive one transaction at a time. Bodies
+// kept in the blob store are copied into the json as they are read, so an
+// export never holds more than one transaction in memory.
+type archiveWriter struct {
+	w     io.Writer
+	blobs *blob.Store
+
+	// placeholders stand in for offloaded bodies while a transaction is
+	// encoded, random so no captured content can look like them
+	requestMark  []byte
+	responseMark []byte
+
+	started bool
+	count   int
+}
+
+func newArchiveWriter(w io.Writer, blobs *blob.Store) *archiveWriter {
+	return &archiveWriter{
+		w:            w,
+		blobs:        blobs,
+		requestMark:  []byte(rand.Text()),
+		responseMark: []byte(rand.Text()),
+	}
+}
+
+// Start writes the project, nothing is written before it so a failure up to
+// here can still be answered with an error status.
+func (a *archiveWriter) Start(project *model.Project) error {
+	data, err := json.Marshal(project)
+	if err != nil {
+		return err
+	}
+
+	a.started = true
+	_, err = fmt.Fprintf(a.w, `{"project":%s,"transactions":[`, data)
+	return err
+}
+
+func (a *archiveWriter) Write(transaction *model.Transaction) error {
+	type offloaded struct {
+		mark []byte
+		ref  string
+	}
+	var parts []offloaded
+
+	archived := model.ArchivedTransaction{Transaction: transaction, ResponseBody: transaction.Response.Body}
+	if ref := transaction.Request.BodyRef; ref != "" {
+		transaction.Request.Body = a.requestMark
+		parts = append(parts, offloaded{a.requestMark, ref})
+	}
+	if ref := transaction.Response.BodyRef; ref != "" {
+		archived.ResponseBody = a.responseMark
+		parts = append(parts, offloaded{a.responseMark, ref})
+	}
+
+	data, err := json.Marshal(archived)
+	if err != nil {
+		return err
+	}
+
+	if a.count > 0 {
+		if _, err := io.WriteString(a.w, ","); err != nil {
+			return err
+		}
+	}
+	a.count++
+
+	// the request comes before response_body in the json
+	for _, part := range parts {
+		before, after, found := bytes.Cut(data, []byte(`"`+base64.StdEncoding.EncodeToString(part.mark)+`"`))
+		if !found {
+			continue
+		}
+		if _, err := a.w.Write(before); err != nil {
+			return err
+		}
+		if err := a.copyBlob(part.ref); err != nil {
+			return err
+		}
+		data = after
+	}
+
+	_, err = a.w.Write(data)
+	return err
+}
+
+// copyBlob writes a blob as a base64 json string, the way json encodes
+// []byte.
+func (a *archiveWriter) copyBlob(hash string) error {
+	f, err := a.blobs.Open(hash)
+	if err != nil {
+		return fmt.Errorf("open body %s: %w", hash, err)
+	}
+	defer f.Close()
+
+	if _, err := io.WriteString(a.w, `"`); err != nil {
+		return err
+	}
+	enc := base64.NewEncoder(base64.StdEncoding, a.w)
+	if _, err := io.Copy(enc, f); err != nil {
+		return fmt.Errorf("copy body %s: %w", hash, err)
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(a.w, `"`)
+	return err
+}
+
+func (a *archiveWriter) Close() error {
+	_, err := io.WriteString(a.w, "]}\n")
+	return err
+}
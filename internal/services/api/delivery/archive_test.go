@@ -0,0 +1,118 @@
+package httpdelivery
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/internal/services/api/blob"
+	"go.uber.org/fx/fxtest"
+)
+
+func TestArchiveWriter(t *testing.T) {
+	conf := &config.Config{}
+	conf.App.Blobs.Path = t.TempDir()
+	conf.App.Blobs.Threshold = 1
+	store, err := blob.New(nil, conf, fxtest.NewLifecycle(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// blobs are laid out as the store writes them
+	put := func(data string) string {
+		sum := sha256.Sum256([]byte(data))
+		hash := hex.EncodeToString(sum[:])
+		path := filepath.Join(conf.App.Blobs.Path, hash[:2], hash[2:4], hash)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	requestBlob := "request body \x00 kept in the store"
+	responseBlob := "<html>response body kept in the store</html>"
+
+	transactions := []*model.Transaction{
+		{
+			ID:       "a",
+			Request:  model.Request{Method: "POST", Body: []byte("inline request")},
+			Response: model.Response{Status: 200, Body: []byte("inline response")},
+		},
+		{
+			ID:       "b",
+			Request:  model.Request{Method: "POST", BodyRef: put(requestBlob), BodySize: int64(len(requestBlob))},
+			Response: model.Response{Status: 200, BodyRef: put(responseBlob), BodySize: int64(len(responseBlob))},
+		},
+		{
+			ID:       "c",
+			Request:  model.Request{Method: "GET"},
+			Response: model.Response{Status: 200, BodyRef: put(responseBlob), BodySize: int64(len(responseBlob))},
+		},
+	}
+
+	var buf bytes.Buffer
+	archive := newArchiveWriter(&buf, store)
+	if err := archive.Start(&model.Project{Name: "export"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, transaction := range transactions {
+		if err := archive.Write(transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got projectArchive
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("archive is not valid json: %v\n%s", err, buf.Bytes())
+	}
+	if got.Project == nil || got.Project.Name != "export" {
+		t.Fatalf("project = %+v", got.Project)
+	}
+
+	want := []struct{ request, response string }{
+		{"inline request", "inline response"},
+		{requestBlob, responseBlob},
+		{"", responseBlob},
+	}
+	if len(got.Transactions) != len(want) {
+		t.Fatalf("got %d transactions, want %d", len(got.Transactions), len(want))
+	}
+	for i, w := range want {
+		archived := got.Transactions[i]
+		if string(archived.Request.Body) != w.request {
+			t.Errorf("transaction %d request body = %q, want %q", i, archived.Request.Body, w.request)
+		}
+		if string(archived.ResponseBody) != w.response {
+			t.Errorf("transaction %d response body = %q, want %q", i, archived.ResponseBody, w.response)
+		}
+	}
+}
+
+func TestArchiveWriterMissingBlob(t *testing.T) {
+	conf := &config.Config{}
+	conf.App.Blobs.Path = t.TempDir()
+	conf.App.Blobs.Threshold = 1
+	store, err := blob.New(nil, conf, fxtest.NewLifecycle(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := newArchiveWriter(&bytes.Buffer{}, store)
+	err = archive.Write(&model.Transaction{
+		Response: model.Response{BodyRef: hex.EncodeToString(make([]byte, sha256.Size)), BodySize: 1},
+	})
+	if err == nil {
+		t.Fatal("Write() succeeded without the blob")
+	}
+}
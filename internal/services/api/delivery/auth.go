@@ -0,0 +1,142 @@
+package httpdelivery
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/pkg/apiauth"
+	"github.com/daronenko/https-proxy/pkg/httpctl"
+	"github.com/rs/zerolog/log"
+)
+
+// publicRoutes are served without a token, the ui asks for one itself and
+// new devices fetch the CA from the onboarding page.
+var publicRoutes = map[string]bool{
+	"/":                  true,
+	"/ui/":               true,
+	"/ping":              true,
+	"/onboarding":        true,
+	"/onboarding/":       true,
+	"/onboarding/{file}": true,
+}
+
+// auditedReads are the reads that hand out captured traffic, they are
+// audited like changes. Streams are audited once they close.
+var auditedReads = map[string]bool{
+	"/requests":                     true,
+	"/stream":                       true,
+	"/stream/ws":                    true,
+	"/diff/{a}/{b}":                 true,
+	"/request/{request_id}":         true,
+	"/request/{request_id}/body":    true,
+	"/request/{request_id}/export":  true,
+	"/projects/{project_id}/export": true,
+}
+
+// requiredRole maps a route to the lowest role allowed to call it. Reads
+// need viewer, changes need operator, and admin routes, scope changes and
+// deleting whole projects need admin.
+func requiredRole(method, route string) apiauth.Role {
+	switch {
+	case strings.HasPrefix(route, "/admin/"):
+		return apiauth.Admin
+	case method == http.MethodGet || method == http.MethodHead:
+		return apiauth.Viewer
+	case route == "/scope", route == "/projects/{project_id}" && method == http.MethodDelete:
+		return apiauth.Admin
+	default:
+		return apiauth.Operator
+	}
+}
+
+// bearerToken reads the Authorization header. Browsers can't set headers on
+// EventSource and WebSocket, so the access_token param is accepted too.
+func bearerToken(r *http.Request) string {
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		return strings.TrimSpace(token)
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// Authorize checks the api token against the role the route needs and
+// audits every call that changes something, reads captured traffic or is
+// denied.
+func (d *Api) Authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := httpctl.Route(r)
+		if publicRoutes[route] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity := apiauth.Identity{Name: "anonymous", Role: apiauth.Admin}
+		if d.Auth.Enabled() {
+			var err error
+			if identity, err = d.Auth.Authenticate(bearerToken(r)); err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="mitm-proxy"`)
+				httpctl.ErrorResponse(w, http.StatusUnauthorized, err.Error())
+				d.audit(r, route, apiauth.Identity{Name: "unknown"}, http.StatusUnauthorized)
+				return
+			}
+		}
+
+		if required := requiredRole(r.Method, route); identity.Role < required {
+			httpctl.ErrorResponse(w, http.StatusForbidden, "requires the "+required.String()+" role")
+			d.audit(r, route, identity, http.StatusForbidden)
+			return
+		}
+
+		rec := httpctl.NewStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || auditedReads[route] {
+			d.audit(r, route, identity, rec.Status)
+		}
+	})
+}
+
+func (d *Api) audit(r *http.Request, route string, identity apiauth.Identity, status int) {
+	query := r.URL.Query()
+	query.Del("access_token")
+	path := r.URL.Path
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	entry := &model.AuditEntry{
+		Actor:      identity.Name,
+		Role:       identity.Role.String(),
+		Method:     r.Method,
+		Route:      route,
+		Path:       path,
+		Status:     status,
+		RemoteAddr: r.RemoteAddr,
+		CreatedAt:  time.Now(),
+	}
+
+	d.Audit.Write(entry)
+}
+
+// AuditLog lists the latest audit entries, actor and limit are optional.
+func (d *Api) AuditLog(w http.ResponseWriter, r *http.Request) {
+	limit := int64(100)
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.ParseInt(raw, 10, 64); err != nil || limit < 1 {
+			httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	entries, err := d.Repo.GetAuditEntries(r.Context(), r.URL.Query().Get("actor"), limit)
+	if err != nil {
+		log.Err(err).Msg("failed to list audit entries")
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to get audit log")
+		return
+	}
+
+	httpctl.JsonResponse(w, http.StatusOK, entries)
+}
@@ -0,0 +1,85 @@
+package httpdelivery
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/internal/metrics"
+	"github.com/daronenko/https-proxy/internal/services/api/audit"
+	"github.com/daronenko/https-proxy/internal/services/api/repo"
+	"github.com/daronenko/https-proxy/pkg/apiauth"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/fx/fxtest"
+)
+
+func TestAuditedReads(t *testing.T) {
+	// every entry is logged before it is queued, the log is what is checked
+	var logged bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&logged)
+	t.Cleanup(func() { log.Logger = logger })
+
+	conf := &config.Config{}
+	client, err := mongo.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := metrics.New()
+	auth, err := apiauth.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Api{
+		Auth:  auth,
+		Audit: audit.New(repo.New(client, conf, m, noop.NewTracerProvider()), m, fxtest.NewLifecycle(t)),
+	}
+
+	router := mux.NewRouter()
+	router.Use(d.Authorize)
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	for _, route := range []string{"/requests", "/stream", "/stream/ws", "/diff/{a}/{b}", "/request/{request_id}", "/ping"} {
+		router.HandleFunc(route, ok).Methods("GET")
+	}
+
+	tests := []struct {
+		path    string
+		audited bool
+	}{
+		{"/requests?host=example.com", true},
+		{"/stream", true},
+		{"/stream/ws?access_token=secret", true},
+		{"/diff/a/b", true},
+		{"/request/a", true},
+		{"/ping", false},
+	}
+	for _, tt := range tests {
+		logged.Reset()
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		var entry struct {
+			Message string `json:"message"`
+			Path    string `json:"path"`
+		}
+		if logged.Len() > 0 {
+			if err := json.Unmarshal(logged.Bytes(), &entry); err != nil {
+				t.Fatalf("%s: %v\n%s", tt.path, err, logged.Bytes())
+			}
+		}
+
+		if audited := entry.Message == "audit"; audited != tt.audited {
+			t.Errorf("GET %s audited = %v, want %v", tt.path, audited, tt.audited)
+			continue
+		}
+		if tt.audited && bytes.Contains(logged.Bytes(), []byte("secret")) {
+			t.Errorf("GET %s audited the access token: %s", tt.path, entry.Path)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package httpdelivery
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/internal/services/api/repo"
+	"github.com/daronenko/https-proxy/pkg/httpctl"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// GetBodyByID returns the raw request or response body, response bodies are
+// not included in the transaction json.
+func (d *Api) GetBodyByID(w http.ResponseWriter, r *http.Request) {
+	requestIDStr, present := mux.Vars(r)["request_id"]
+	if !present {
+		httpctl.ErrorResponse(w, http.StatusNotFound, "request id not found")
+		return
+	}
+
+	requestID, err := bson.ObjectIDFromHex(requestIDStr)
+	if err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid request id format")
+		return
+	}
+
+	projectID, ok := d.projectScope(w, r)
+	if !ok {
+		return
+	}
+
+	transaction, err := d.Repo.GetTransactionByID(r.Context(), requestID, repo.ProjectScope(projectID))
+	if err != nil {
+		httpctl.ErrorResponse(w, http.StatusNotFound, "request not found")
+		return
+	}
+
+	var (
+		body    io.Reader
+		size    int64
+		ref     string
+		headers model.Values
+	)
+	switch part := r.URL.Query().Get("part"); part {
+	case "request":
+		body, size, ref = bytes.NewReader(transaction.Request.Body), int64(len(transaction.Request.Body)), transaction.Request.BodyRef
+		// request bodies are stored decoded, so the encoding is not passed on
+		headers = model.Values{"Content-Type": {transaction.Request.Headers.Get("Content-Type")}}
+	case "response", "":
+		body, size, ref = bytes.NewReader(transaction.Response.Body), int64(len(transaction.Response.Body)), transaction.Response.BodyRef
+		headers = transaction.Response.Headers
+	default:
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "part must be request or response")
+		return
+	}
+
+	// offloaded bodies are streamed from the blob store
+	if ref != "" {
+		f, err := d.Blobs.Open(ref)
+		if err != nil {
+			log.Err(err).Str("blob", ref).Msg("failed to open body blob")
+			httpctl.ErrorResponse(w, http.StatusNotFound, "body not found")
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to read body")
+			return
+		}
+		body, size = f, info.Size()
+	}
+
+	contentType := headers.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if encoding := headers.Get("Content-Encoding"); encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, body); err != nil {
+		log.Err(err).Msg("failed to write body")
+	}
+}
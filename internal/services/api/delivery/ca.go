@@ -0,0 +1,69 @@
+package httpdelivery
+
+import (
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/daronenko/https-proxy/pkg/httpctl"
+	"github.com/daronenko/https-proxy/pkg/onboarding"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// GetCA returns the certificate clients have to trust, pem by default or
+// der with format=der.
+func (d *Api) GetCA(w http.ResponseWriter, r *http.Request) {
+	certPEM, err := os.ReadFile(d.Conf.App.ProxyServer.TLS.CACertPath)
+	if err != nil {
+		log.Err(err).Msg("failed to read ca certificate")
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to read ca certificate")
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "pem", "":
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Header().Set("Content-Disposition", `attachment; filename="ca.crt"`)
+		w.WriteHeader(http.StatusOK)
+		w.Write(certPEM)
+	case "der":
+		block, _ := pem.Decode(certPEM)
+		if block == nil {
+			httpctl.ErrorResponse(w, http.StatusInternalServerError, "invalid ca certificate")
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+		w.Header().Set("Content-Disposition", `attachment; filename="ca.der"`)
+		w.WriteHeader(http.StatusOK)
+		w.Write(block.Bytes)
+	default:
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "format must be pem or der")
+	}
+}
+
+// Onboarding serves the same page and files as the proxy portal. The pac
+// file points at the proxy port on the host the api was reached on.
+func (d *Api) Onboarding(w http.ResponseWriter, r *http.Request) {
+	proxyAddress := d.Conf.App.ProxyServer.Address
+	if _, port, err := net.SplitHostPort(proxyAddress); err == nil {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		proxyAddress = net.JoinHostPort(host, port)
+	}
+
+	err := d.Portal.Serve(w, mux.Vars(r)["file"], proxyAddress)
+	switch {
+	case errors.Is(err, onboarding.ErrNotFound):
+		httpctl.ErrorResponse(w, http.StatusNotFound, "file not found")
+	case errors.Is(err, onboarding.ErrInvalidProxyAddress):
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid host")
+	case err != nil:
+		log.Err(err).Msg("failed to serve onboarding file")
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to read ca certificate")
+	}
+}
@@ -0,0 +1,83 @@
+package httpdelivery
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/daronenko/https-proxy/pkg/certstore"
+	"github.com/daronenko/https-proxy/pkg/httpctl"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+func (d *Api) CertsList(w http.ResponseWriter, r *http.Request) {
+	certs, err := d.Certs.List()
+	if err != nil {
+		log.Err(err).Msg("failed to list certificates")
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to list certificates")
+		return
+	}
+
+	httpctl.JsonResponse(w, http.StatusOK, certs)
+}
+
+func (d *Api) GetCert(w http.ResponseWriter, r *http.Request) {
+	info, err := d.Certs.Get(mux.Vars(r)["host"])
+	if err != nil {
+		d.certError(w, err)
+		return
+	}
+
+	httpctl.JsonResponse(w, http.StatusOK, info)
+}
+
+// RenewCert issues a new certificate for the host, whether it had one or not.
+func (d *Api) RenewCert(w http.ResponseWriter, r *http.Request) {
+	info, err := d.Certs.Renew(mux.Vars(r)["host"])
+	if err != nil {
+		d.certError(w, err)
+		return
+	}
+
+	httpctl.JsonResponse(w, http.StatusOK, info)
+}
+
+// RevokeCert drops the certificate of the host, the next connection to it
+// gets a new one.
+func (d *Api) RevokeCert(w http.ResponseWriter, r *http.Request) {
+	if err := d.Certs.Revoke(mux.Vars(r)["host"]); err != nil {
+		d.certError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *Api) GetCAInfo(w http.ResponseWriter, r *http.Request) {
+	httpctl.JsonResponse(w, http.StatusOK, d.Certs.CA())
+}
+
+// RegenerateCA replaces the CA, every client has to trust the new one.
+func (d *Api) RegenerateCA(w http.ResponseWriter, r *http.Request) {
+	info, err := d.Certs.RegenerateCA()
+	if err != nil {
+		log.Err(err).Msg("failed to regenerate ca")
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to regenerate ca")
+		return
+	}
+
+	log.Warn().Str("sha256", info.SHA256).Msg("ca regenerated, clients have to trust the new certificate")
+	httpctl.JsonResponse(w, http.StatusOK, info)
+}
+
+func (d *Api) certError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, certstore.ErrNotFound):
+		httpctl.ErrorResponse(w, http.StatusNotFound, "certificate not found")
+	case errors.Is(err, certstore.ErrInvalidHost):
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid host")
+	default:
+		log.Err(err).Msg("certificate store failed")
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "certificate store failed")
+	}
+}
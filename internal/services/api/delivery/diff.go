@@ -0,0 +1,153 @@
+package httpdelivery
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/internal/services/api/repo"
+	"github.com/daronenko/https-proxy/pkg/diff"
+	"github.com/daronenko/https-proxy/pkg/httpctl"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// line diffs take linear memory but quadratic time in the worst case,
+// larger bodies are only compared for equality
+const maxDiffLines = 5000
+
+const diffContext = 3
+
+type bodyDiff struct {
+	Type    string        `json:"type"`
+	Equal   bool          `json:"equal"`
+	SizeA   int           `json:"size_a"`
+	SizeB   int           `json:"size_b"`
+	Changes []diff.Change `json:"changes,omitempty"`
+	Unified string        `json:"unified,omitempty"`
+}
+
+type requestDiff struct {
+	Line    []diff.Change `json:"line"`
+	Headers []diff.Change `json:"headers"`
+	Cookies []diff.Change `json:"cookies"`
+	Body    bodyDiff      `json:"body"`
+}
+
+type responseDiff struct {
+	Status  []diff.Change `json:"status"`
+	Headers []diff.Change `json:"headers"`
+	Body    bodyDiff      `json:"body"`
+}
+
+type transactionDiff struct {
+	A        string       `json:"a"`
+	B        string       `json:"b"`
+	Request  requestDiff  `json:"request"`
+	Response responseDiff `json:"response"`
+}
+
+func (d *Api) DiffTransactions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	projectID, ok := d.projectScope(w, r)
+	if !ok {
+		return
+	}
+
+	transactions := make([]*model.Transaction, 0, 2)
+	for _, name := range []string{"a", "b"} {
+		transactionID, err := bson.ObjectIDFromHex(vars[name])
+		if err != nil {
+			httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid request id format")
+			return
+		}
+
+		transaction, err := d.Repo.GetTransactionByID(r.Context(), transactionID, repo.ProjectScope(projectID))
+		if err != nil {
+			httpctl.ErrorResponse(w, http.StatusNotFound, "request not found")
+			return
+		}
+		if err := d.Blobs.Inline(transaction); err != nil {
+			log.Err(err).Msg("failed to load transaction bodies")
+			httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to load request body")
+			return
+		}
+		transactions = append(transactions, transaction)
+	}
+	a, b := transactions[0], transactions[1]
+
+	httpctl.JsonResponse(w, http.StatusOK, transactionDiff{
+		A: vars["a"],
+		B: vars["b"],
+		Request: requestDiff{
+			Line: diff.Map(
+				map[string]string{"method": a.Request.Method, "url": model.BuildURL(a.Request)},
+				map[string]string{"method": b.Request.Method, "url": model.BuildURL(b.Request)},
+			),
+			Headers: diff.Map(a.Request.Headers.Flat(), b.Request.Headers.Flat()),
+			Cookies: diff.Map(a.Request.Cookies.Flat(), b.Request.Cookies.Flat()),
+			Body:    diffBodies(a.Request.Body, b.Request.Body),
+		},
+		Response: responseDiff{
+			Status: diff.Map(
+				map[string]string{"status": strconv.Itoa(a.Response.Status)},
+				map[string]string{"status": strconv.Itoa(b.Response.Status)},
+			),
+			Headers: diff.Map(a.Response.Headers.Flat(), b.Response.Headers.Flat()),
+			Body: diffBodies(
+				decodeBody(a.Response.Headers, a.Response.Body),
+				decodeBody(b.Response.Headers, b.Response.Body),
+			),
+		},
+	})
+}
+
+func diffBodies(a, b []byte) bodyDiff {
+	result := bodyDiff{
+		Equal: bytes.Equal(a, b),
+		SizeA: len(a),
+		SizeB: len(b),
+	}
+
+	var aJSON, bJSON any
+	switch {
+	case len(a) > 0 && len(b) > 0 && json.Unmarshal(a, &aJSON) == nil && json.Unmarshal(b, &bJSON) == nil:
+		result.Type = "json"
+		result.Changes = diff.JSON(aJSON, bJSON)
+	case utf8.Valid(a) && utf8.Valid(b):
+		result.Type = "text"
+		if !result.Equal && bytes.Count(a, []byte("\n"))+bytes.Count(b, []byte("\n")) <= maxDiffLines {
+			result.Unified = diff.Unified(diff.Lines(string(a), string(b)), diffContext)
+		}
+	default:
+		result.Type = "binary"
+	}
+
+	return result
+}
+
+func decodeBody(headers model.Values, body []byte) []byte {
+	if headers.Get("Content-Encoding") != "gzip" {
+		return body
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return body
+	}
+	defer reader.Close()
+
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return body
+	}
+
+	return decoded
+}
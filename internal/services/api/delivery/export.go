@@ -0,0 +1,59 @@
+package httpdelivery
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/daronenko/https-proxy/internal/services/api/repo"
+	"github.com/daronenko/https-proxy/pkg/export"
+	"github.com/daronenko/https-proxy/pkg/httpctl"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func (d *Api) ExportRequestByID(w http.ResponseWriter, r *http.Request) {
+	requestIDStr, present := mux.Vars(r)["request_id"]
+	if !present {
+		httpctl.ErrorResponse(w, http.StatusNotFound, "request id not found")
+		return
+	}
+
+	requestID, err := bson.ObjectIDFromHex(requestIDStr)
+	if err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid request id format")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "curl"
+	}
+
+	projectID, ok := d.projectScope(w, r)
+	if !ok {
+		return
+	}
+
+	transaction, err := d.Repo.GetTransactionByID(r.Context(), requestID, repo.ProjectScope(projectID))
+	if err != nil {
+		httpctl.ErrorResponse(w, http.StatusNotFound, "request not found")
+		return
+	}
+	if err := d.Blobs.Inline(transaction); err != nil {
+		log.Err(err).Msg("failed to load transaction bodies")
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to load request body")
+		return
+	}
+
+	rendered, err := export.Render(format, transaction.Request)
+	if errors.Is(err, export.ErrUnknownFormat) {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "unknown format, expected one of: "+strings.Join(export.Formats(), ", "))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(rendered))
+}
@@ -0,0 +1,152 @@
+package httpdelivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/services/api/repo"
+	"github.com/daronenko/https-proxy/pkg/fuzzer"
+	"github.com/daronenko/https-proxy/pkg/httpctl"
+	"github.com/daronenko/https-proxy/pkg/scope"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type fuzzRequest struct {
+	// Template is a raw http request with payload positions wrapped in
+	// markers, when empty every parameter of the stored request is marked.
+	Template    string              `json:"template"`
+	Mode        fuzzer.Mode         `json:"mode"`
+	Payloads    []fuzzer.PayloadSet `json:"payloads"`
+	Grep        []string            `json:"grep"`
+	Concurrency int                 `json:"concurrency"`
+	Delay       string              `json:"delay"`
+}
+
+func (d *Api) FuzzRequestByID(w http.ResponseWriter, r *http.Request) {
+	requestIDStr, present := mux.Vars(r)["request_id"]
+	if !present {
+		httpctl.ErrorResponse(w, http.StatusNotFound, "request id not found")
+		return
+	}
+
+	requestID, err := bson.ObjectIDFromHex(requestIDStr)
+	if err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid request id format")
+		return
+	}
+
+	var body fuzzRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid fuzz request body")
+		return
+	}
+
+	projectID, ok := d.projectScope(w, r)
+	if !ok {
+		return
+	}
+
+	transaction, err := d.Repo.GetTransactionByID(r.Context(), requestID, repo.ProjectScope(projectID))
+	if err != nil {
+		httpctl.ErrorResponse(w, http.StatusNotFound, "original request not found")
+		return
+	}
+	if err := d.Blobs.Inline(transaction); err != nil {
+		log.Err(err).Msg("failed to load transaction bodies")
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to load request body")
+		return
+	}
+
+	if !d.inScope(w, transaction.Request) {
+		return
+	}
+
+	spec := d.Fuzzer.Get()
+
+	if body.Template == "" {
+		body.Template = fuzzer.AutoTemplate(transaction.Request)
+	}
+	template, err := fuzzer.ParseTemplate(body.Template)
+	if err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if body.Mode == "" {
+		body.Mode = fuzzer.Sniper
+	}
+
+	var delay time.Duration
+	if body.Delay != "" {
+		if delay, err = time.ParseDuration(body.Delay); err != nil {
+			httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid delay")
+			return
+		}
+	}
+
+	sets := make([][]string, 0, len(body.Payloads))
+	for _, set := range body.Payloads {
+		payloads, err := set.Generate(spec.PayloadDir, spec.MaxRequests)
+		if err != nil {
+			httpctl.ErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		sets = append(sets, payloads)
+	}
+
+	attack := &fuzzer.Attack{
+		Template:    template,
+		Mode:        body.Mode,
+		Sets:        sets,
+		Grep:        body.Grep,
+		Concurrency: min(max(body.Concurrency, 1), spec.MaxConcurrency),
+		Delay:       delay,
+		Scheme:      transaction.Request.Protocol,
+		Host:        transaction.Request.Host,
+		Client: &http.Client{
+			Timeout:   spec.Timeout,
+			Transport: &scope.Transport{Scope: d.Scope},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+
+	total, err := attack.Total(spec.MaxRequests)
+	if err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if total > spec.MaxRequests {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "attack exceeds the maximum number of requests")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Fuzz-Total", fmt.Sprint(total))
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	attack.Run(r.Context(), func(result fuzzer.Result) {
+		if err := encoder.Encode(result); err != nil {
+			if !errors.Is(r.Context().Err(), context.Canceled) {
+				log.Err(err).Msg("failed to write fuzz result")
+			}
+			return
+		}
+		flusher.Flush()
+	})
+}
@@ -0,0 +1,94 @@
+package httpdelivery
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/pkg/httpctl"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// ImportRequests stores transactions exported from another instance, either
+// a list or a single one, with the response bodies in response_body. Ids are
+// assigned anew, so links to parent transactions are dropped. They land in
+// the project given by the project param or the active one.
+func (d *Api) ImportRequests(w http.ResponseWriter, r *http.Request) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid transactions list")
+		return
+	}
+
+	// a single transaction is accepted as well, as printed by proxyctl show
+	var archived []*model.ArchivedTransaction
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '{' {
+		var transaction model.ArchivedTransaction
+		if err := json.Unmarshal(raw, &transaction); err != nil {
+			httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid transaction")
+			return
+		}
+		archived = append(archived, &transaction)
+	} else if err := json.Unmarshal(raw, &archived); err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid transactions list")
+		return
+	}
+
+	transactions := make([]*model.Transaction, 0, len(archived))
+	for _, a := range archived {
+		if a == nil || a.Transaction == nil {
+			continue
+		}
+		// body references point into the blob store of the exporting
+		// instance, the bodies are offloaded here again
+		a.Request.BodyRef, a.Request.BodySize = "", 0
+		if len(a.ResponseBody) > 0 {
+			a.Response.Body = a.ResponseBody
+		}
+		a.Response.BodyRef, a.Response.BodySize = "", 0
+		transactions = append(transactions, a.Transaction)
+	}
+
+	if len(transactions) == 0 {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "transactions list is empty")
+		return
+	}
+
+	projectID, ok := d.projectScope(w, r)
+	if !ok {
+		return
+	}
+
+	for _, transaction := range transactions {
+		transaction.ID = nil
+		transaction.ParentID = nil
+		transaction.ProjectID = projectTag(projectID)
+		if transaction.CreatedAt.IsZero() {
+			transaction.CreatedAt = time.Now()
+		}
+	}
+
+	d.Blobs.Offload(r.Context(), transactions)
+
+	ids, err := d.Repo.CreateTransactions(r.Context(), transactions)
+	if err != nil {
+		log.Err(err).Msg("failed to import transactions")
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to import transactions")
+		return
+	}
+
+	hexIDs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if oid, ok := id.(bson.ObjectID); ok {
+			hexIDs = append(hexIDs, oid.Hex())
+		}
+	}
+
+	httpctl.JsonResponse(w, http.StatusCreated, map[string]any{
+		"imported": len(hexIDs),
+		"ids":      hexIDs,
+	})
+}
@@ -0,0 +1,350 @@
+package httpdelivery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/internal/services/api/repo"
+	"github.com/daronenko/https-proxy/pkg/httpctl"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const ProjectID = "project_id"
+
+// projectID reads the project query param: a project id, "all" for every
+// transaction, or nothing for the active project. A zero id means unscoped.
+func (d *Api) projectID(r *http.Request) (bson.ObjectID, error) {
+	switch project := r.URL.Query().Get("project"); project {
+	case "all":
+		return bson.ObjectID{}, nil
+	case "":
+		return d.Projects.ID(), nil
+	default:
+		projectID, err := bson.ObjectIDFromHex(project)
+		if err != nil {
+			return bson.ObjectID{}, errors.New("invalid project id format")
+		}
+		return projectID, nil
+	}
+}
+
+// projectScope is projectID that writes the error response itself.
+func (d *Api) projectScope(w http.ResponseWriter, r *http.Request) (bson.ObjectID, bool) {
+	projectID, err := d.projectID(r)
+	if err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return bson.ObjectID{}, false
+	}
+
+	return projectID, true
+}
+
+// projectTag is the value stored in project_id of new transactions.
+func projectTag(projectID bson.ObjectID) interface{} {
+	if projectID.IsZero() {
+		return nil
+	}
+	return projectID
+}
+
+type projectPatch struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+func (d *Api) ProjectsList(w http.ResponseWriter, r *http.Request) {
+	projects, err := d.Repo.GetProjectsList(r.Context())
+	if err != nil {
+		log.Err(err).Msg("failed to list projects")
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to get projects")
+		return
+	}
+
+	httpctl.JsonResponse(w, http.StatusOK, projects)
+}
+
+func (d *Api) CreateProject(w http.ResponseWriter, r *http.Request) {
+	var patch projectPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid project")
+		return
+	}
+
+	if patch.Name == nil || strings.TrimSpace(*patch.Name) == "" {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "project name is required")
+		return
+	}
+
+	project := &model.Project{
+		Name:      strings.TrimSpace(*patch.Name),
+		CreatedAt: time.Now(),
+	}
+	if patch.Description != nil {
+		project.Description = *patch.Description
+	}
+
+	project, err := d.Repo.CreateProject(r.Context(), project)
+	if err != nil {
+		log.Err(err).Msg("failed to create project")
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to create project")
+		return
+	}
+
+	httpctl.JsonResponse(w, http.StatusCreated, project)
+}
+
+func (d *Api) GetProjectByID(w http.ResponseWriter, r *http.Request) {
+	projectID, ok := projectIDVar(w, r)
+	if !ok {
+		return
+	}
+
+	project, err := d.Repo.GetProjectByID(r.Context(), projectID)
+	if err != nil {
+		projectError(w, err)
+		return
+	}
+
+	httpctl.JsonResponse(w, http.StatusOK, project)
+}
+
+func (d *Api) UpdateProject(w http.ResponseWriter, r *http.Request) {
+	projectID, ok := projectIDVar(w, r)
+	if !ok {
+		return
+	}
+
+	var patch projectPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid project patch")
+		return
+	}
+	if patch.Name != nil && strings.TrimSpace(*patch.Name) == "" {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "project name must not be empty")
+		return
+	}
+
+	project, err := d.Repo.UpdateProject(r.Context(), projectID, patch.Name, patch.Description)
+	if err != nil {
+		projectError(w, err)
+		return
+	}
+
+	httpctl.JsonResponse(w, http.StatusOK, project)
+}
+
+// DeleteProject deletes the project and every transaction captured in it.
+func (d *Api) DeleteProject(w http.ResponseWriter, r *http.Request) {
+	projectID, ok := projectIDVar(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := d.Repo.DeleteProject(r.Context(), projectID)
+	if err != nil {
+		projectError(w, err)
+		return
+	}
+	d.Projects.Forget(projectID)
+
+	httpctl.JsonResponse(w, http.StatusOK, map[string]any{
+		"deleted": deleted,
+	})
+}
+
+func (d *Api) GetActiveProject(w http.ResponseWriter, r *http.Request) {
+	projectID := d.Projects.ID()
+	if projectID.IsZero() {
+		httpctl.ErrorResponse(w, http.StatusNotFound, "no active project")
+		return
+	}
+
+	project, err := d.Repo.GetProjectByID(r.Context(), projectID)
+	if err != nil {
+		projectError(w, err)
+		return
+	}
+
+	httpctl.JsonResponse(w, http.StatusOK, project)
+}
+
+// SetActiveProject switches the project new transactions are tagged with,
+// a null id stops tagging.
+func (d *Api) SetActiveProject(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ID *string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid active project")
+		return
+	}
+
+	var projectID bson.ObjectID
+	if body.ID != nil {
+		var err error
+		if projectID, err = bson.ObjectIDFromHex(*body.ID); err != nil {
+			httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid project id format")
+			return
+		}
+	}
+
+	if err := d.Projects.Set(r.Context(), projectID); err != nil {
+		projectError(w, err)
+		return
+	}
+
+	httpctl.JsonResponse(w, http.StatusOK, map[string]any{
+		"id": projectTag(projectID),
+	})
+}
+
+// projectArchive is a whole project with its transactions.
+type projectArchive struct {
+	Project      *model.Project              `json:"project"`
+	Transactions []model.ArchivedTransaction `json:"transactions"`
+}
+
+// ExportProject streams the project with its transactions as they are read
+// from the database.
+func (d *Api) ExportProject(w http.ResponseWriter, r *http.Request) {
+	projectID, ok := projectIDVar(w, r)
+	if !ok {
+		return
+	}
+
+	project, err := d.Repo.GetProjectByID(r.Context(), projectID)
+	if err != nil {
+		projectError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "project-"+projectID.Hex()+".json"))
+
+	archive := newArchiveWriter(w, d.Blobs)
+	if err = archive.Start(project); err == nil {
+		err = d.Repo.EachTransaction(r.Context(), repo.ProjectScope(projectID), archive.Write)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err == nil {
+		return
+	}
+
+	log.Err(err).Msg("failed to export project")
+	if !archive.started {
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to export project")
+		return
+	}
+	// the status went out with the start of the archive, the connection is
+	// cut so a partial archive does not pass for a whole one
+	panic(http.ErrAbortHandler)
+}
+
+// ImportProject restores an exported project as a new one, parent links
+// between its transactions are kept.
+func (d *Api) ImportProject(w http.ResponseWriter, r *http.Request) {
+	var archive projectArchive
+	if err := json.NewDecoder(r.Body).Decode(&archive); err != nil || archive.Project == nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid project archive")
+		return
+	}
+
+	project := &model.Project{
+		Name:        archive.Project.Name,
+		Description: archive.Project.Description,
+		CreatedAt:   time.Now(),
+	}
+	project, err := d.Repo.CreateProject(r.Context(), project)
+	if err != nil {
+		log.Err(err).Msg("failed to create project")
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to import project")
+		return
+	}
+	projectID := project.ID.(bson.ObjectID)
+
+	// ids are assigned anew, parents are remapped to the new ids
+	ids := make(map[string]bson.ObjectID, len(archive.Transactions))
+	for _, archived := range archive.Transactions {
+		if archived.Transaction == nil {
+			continue
+		}
+		if old, ok := archived.ID.(string); ok {
+			ids[old] = bson.NewObjectID()
+		}
+	}
+
+	transactions := make([]*model.Transaction, 0, len(archive.Transactions))
+	for _, archived := range archive.Transactions {
+		transaction := archived.Transaction
+		if transaction == nil {
+			continue
+		}
+
+		id, ok := ids[fmt.Sprint(transaction.ID)]
+		if !ok {
+			id = bson.NewObjectID()
+		}
+		transaction.ID = id
+		transaction.ProjectID = projectID
+		transaction.ParentID = nil
+		if parent, ok := archived.ParentID.(string); ok {
+			if parentID, ok := ids[parent]; ok {
+				transaction.ParentID = parentID
+			}
+		}
+		transaction.Request.BodyRef, transaction.Request.BodySize = "", 0
+		transaction.Response.Body = archived.ResponseBody
+		transaction.Response.BodyRef, transaction.Response.BodySize = "", 0
+
+		transactions = append(transactions, transaction)
+	}
+
+	if len(transactions) > 0 {
+		d.Blobs.Offload(r.Context(), transactions)
+		if _, err := d.Repo.CreateTransactions(r.Context(), transactions); err != nil {
+			log.Err(err).Msg("failed to import project transactions")
+			httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to import project transactions")
+			return
+		}
+	}
+
+	httpctl.JsonResponse(w, http.StatusCreated, map[string]any{
+		"project":  project,
+		"imported": len(transactions),
+	})
+}
+
+func projectIDVar(w http.ResponseWriter, r *http.Request) (bson.ObjectID, bool) {
+	projectIDStr, present := mux.Vars(r)[ProjectID]
+	if !present {
+		httpctl.ErrorResponse(w, http.StatusNotFound, "project id not found")
+		return bson.ObjectID{}, false
+	}
+
+	projectID, err := bson.ObjectIDFromHex(projectIDStr)
+	if err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid project id format")
+		return bson.ObjectID{}, false
+	}
+
+	return projectID, true
+}
+
+func projectError(w http.ResponseWriter, err error) {
+	if errors.Is(err, repo.ErrProjectNotFound) {
+		httpctl.ErrorResponse(w, http.StatusNotFound, "project not found")
+		return
+	}
+
+	log.Err(err).Msg("project request failed")
+	httpctl.ErrorResponse(w, http.StatusInternalServerError, "project request failed")
+}
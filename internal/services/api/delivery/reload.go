@@ -0,0 +1,31 @@
+package httpdelivery
+
+import (
+	"net/http"
+
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/pkg/httpctl"
+	"github.com/rs/zerolog/log"
+)
+
+// ReloadConfig reads config.yaml again, the same happens on its own when
+// the file changes. An invalid file is rejected with every problem listed.
+// When some sections fail to apply the answer is 500, it still tells what
+// was applied and lists the failures in errors.
+func (d *Api) ReloadConfig(w http.ResponseWriter, r *http.Request) {
+	result, err := d.Reloader.Reload()
+	if result == nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Err(err).Msg("failed to apply part of the config")
+		httpctl.JsonResponse(w, http.StatusInternalServerError, struct {
+			*config.Result
+			Error string `json:"error"`
+		}{result, err.Error()})
+		return
+	}
+
+	httpctl.JsonResponse(w, http.StatusOK, result)
+}
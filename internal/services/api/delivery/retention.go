@@ -0,0 +1,98 @@
+package httpdelivery
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/services/api/feed"
+	"github.com/daronenko/https-proxy/internal/services/api/repo"
+	"github.com/daronenko/https-proxy/pkg/httpctl"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// PurgeRequests deletes transactions matching host, method, status, user
+// and before, which takes a timestamp or an age like 24h. Pinned transactions
+// are kept unless pinned=true, and an empty filter needs all=true.
+func (d *Api) PurgeRequests(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	match, err := feed.ParseFilter(query)
+	if err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	projectID, ok := d.projectScope(w, r)
+	if !ok {
+		return
+	}
+
+	filter := repo.PurgeFilter{
+		ProjectID:     projectID,
+		Host:          match.Host,
+		Method:        match.Method,
+		Status:        match.Status,
+		User:          match.User,
+		IncludePinned: query.Get("pinned") == "true",
+	}
+
+	if before := query.Get("before"); before != "" {
+		if age, err := time.ParseDuration(before); err == nil {
+			filter.Before = time.Now().Add(-age)
+		} else if filter.Before, err = time.Parse(time.RFC3339, before); err != nil {
+			httpctl.ErrorResponse(w, http.StatusBadRequest, "before must be an rfc 3339 time or a duration")
+			return
+		}
+	}
+
+	empty := filter.Host == "" && filter.Method == "" && filter.Status == 0 && filter.Before.IsZero() && filter.User == ""
+	if empty && query.Get("all") != "true" {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "empty filter, pass all=true to purge everything")
+		return
+	}
+
+	deleted, err := d.Repo.PurgeTransactions(r.Context(), filter)
+	if err != nil {
+		log.Err(err).Msg("failed to purge transactions")
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to purge requests")
+		return
+	}
+	d.Metrics.RetentionPruned.WithLabelValues("purge").Add(float64(deleted))
+
+	httpctl.JsonResponse(w, http.StatusOK, map[string]any{
+		"deleted": deleted,
+	})
+}
+
+// PinRequest marks a transaction to survive retention, DELETE unpins it.
+func (d *Api) PinRequest(w http.ResponseWriter, r *http.Request) {
+	requestIDStr, present := mux.Vars(r)["request_id"]
+	if !present {
+		httpctl.ErrorResponse(w, http.StatusNotFound, "request id not found")
+		return
+	}
+
+	requestID, err := bson.ObjectIDFromHex(requestIDStr)
+	if err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid request id format")
+		return
+	}
+
+	pinned := r.Method != http.MethodDelete
+	if err := d.Repo.SetPinned(r.Context(), requestID, pinned); err != nil {
+		if errors.Is(err, repo.ErrTransactionsNotFound) {
+			httpctl.ErrorResponse(w, http.StatusNotFound, "request not found")
+			return
+		}
+		log.Err(err).Msg("failed to pin transaction")
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "failed to update request")
+		return
+	}
+
+	httpctl.JsonResponse(w, http.StatusOK, map[string]any{
+		"pinned": pinned,
+	})
+}
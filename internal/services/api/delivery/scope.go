@@ -0,0 +1,64 @@
+package httpdelivery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/pkg/httpctl"
+	"github.com/daronenko/https-proxy/pkg/scope"
+)
+
+func (d *Api) GetScope(w http.ResponseWriter, r *http.Request) {
+	httpctl.JsonResponse(w, http.StatusOK, d.Scope.Config())
+}
+
+// ReplaceScope swaps the scope rules until the next restart, config.yaml
+// is not rewritten.
+func (d *Api) ReplaceScope(w http.ResponseWriter, r *http.Request) {
+	var conf scope.Config
+	if err := json.NewDecoder(r.Body).Decode(&conf); err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "invalid scope")
+		return
+	}
+
+	if err := d.Scope.Replace(conf); err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	httpctl.JsonResponse(w, http.StatusOK, d.Scope.Config())
+}
+
+// CheckScope tells what the proxy does with a request to the url param.
+func (d *Api) CheckScope(w http.ResponseWriter, r *http.Request) {
+	u, err := url.Parse(r.URL.Query().Get("url"))
+	if err != nil || u.Host == "" {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, "url must be absolute")
+		return
+	}
+
+	target := scope.TargetFromURL(u)
+	httpctl.JsonResponse(w, http.StatusOK, map[string]any{
+		"in_scope": d.Scope.Contains(target),
+		"action":   d.Scope.Decide(target),
+	})
+}
+
+// inScope writes 403 when the stored request targets a host outside of the
+// scope, so it is never scanned or fuzzed. Without include rules nothing is.
+func (d *Api) inScope(w http.ResponseWriter, req model.Request) bool {
+	if !d.Scope.Defined() {
+		httpctl.ErrorResponse(w, http.StatusForbidden, scope.ErrNoInclude.Error()+", add the targets to scan to scope.include")
+		return false
+	}
+
+	u, err := url.Parse(model.BuildURL(req))
+	if err != nil || !d.Scope.Contains(scope.TargetFromURL(u)) {
+		httpctl.ErrorResponse(w, http.StatusForbidden, scope.ErrOutOfScope.Error())
+		return false
+	}
+
+	return true
+}
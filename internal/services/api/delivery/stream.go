@@ -0,0 +1,159 @@
+package httpdelivery
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/services/api/feed"
+	"github.com/daronenko/https-proxy/pkg/httpctl"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	streamKeepAlive    = 15 * time.Second
+	streamWriteTimeout = 10 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+type streamEvent struct {
+	Event string `json:"event"`
+	Data  any    `json:"data"`
+}
+
+type droppedEvent struct {
+	Count int64 `json:"count"`
+}
+
+// StreamSSE pushes summaries of stored transactions as server-sent events.
+func (d *Api) StreamSSE(w http.ResponseWriter, r *http.Request) {
+	filter, err := feed.ParseFilter(r.URL.Query())
+	if err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if filter.Project, err = d.projectID(r); err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpctl.ErrorResponse(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	sub := d.Feed.Subscribe(filter)
+	defer d.Feed.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	write := func(event string, data any) error {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+		return err
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case summary := <-sub.C:
+			if dropped := sub.Dropped(); dropped > 0 {
+				if err := write("dropped", droppedEvent{dropped}); err != nil {
+					return
+				}
+			}
+			if err := write("transaction", summary); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// StreamWebSocket pushes the same events as StreamSSE over a websocket.
+func (d *Api) StreamWebSocket(w http.ResponseWriter, r *http.Request) {
+	filter, err := feed.ParseFilter(r.URL.Query())
+	if err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if filter.Project, err = d.projectID(r); err != nil {
+		httpctl.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Err(err).Msg("failed to upgrade stream connection")
+		return
+	}
+	defer conn.Close()
+
+	sub := d.Feed.Subscribe(filter)
+	defer d.Feed.Unsubscribe(sub)
+
+	// the client never sends anything meaningful, reading only detects close
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(event streamEvent) error {
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return conn.WriteJSON(event)
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-keepAlive.C:
+			deadline := time.Now().Add(streamWriteTimeout)
+			if err := conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				return
+			}
+		case summary := <-sub.C:
+			if dropped := sub.Dropped(); dropped > 0 {
+				if err := write(streamEvent{"dropped", droppedEvent{dropped}}); err != nil {
+					return
+				}
+			}
+			if err := write(streamEvent{"transaction", summary}); err != nil {
+				return
+			}
+		}
+	}
+}
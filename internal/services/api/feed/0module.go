@@ -0,0 +1,12 @@
+package feed
+
+import (
+	"go.uber.org/fx"
+)
+
+func Module() fx.Option {
+	return fx.Module(
+		"api.feed",
+		fx.Provide(New),
+	)
+}
@@ -0,0 +1,148 @@
+package feed
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/daronenko/https-proxy/internal/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const subscriptionBuffer = 256
+
+type Filter struct {
+	Host   string
+	Method string
+	// Status is either an exact code or a class such as 5 for 5xx.
+	Status int
+	User   string
+	// Project limits the stream to one project when set.
+	Project bson.ObjectID
+}
+
+// ParseFilter reads host, method, status and user query params. Host
+// matches subdomains too and status accepts classes like "4xx".
+func ParseFilter(query url.Values) (Filter, error) {
+	filter := Filter{
+		Host:   strings.ToLower(query.Get("host")),
+		Method: strings.ToUpper(query.Get("method")),
+		User:   query.Get("user"),
+	}
+
+	if status := strings.ToLower(query.Get("status")); status != "" {
+		var err error
+		if class, found := strings.CutSuffix(status, "xx"); found {
+			filter.Status, err = strconv.Atoi(class)
+		} else {
+			filter.Status, err = strconv.Atoi(status)
+		}
+		if err != nil || filter.Status < 1 || filter.Status > 599 || (filter.Status > 5 && filter.Status < 100) {
+			return Filter{}, fmt.Errorf("invalid status filter %q", status)
+		}
+	}
+
+	return filter, nil
+}
+
+func (f Filter) Match(s model.Summary) bool {
+	if !f.Project.IsZero() {
+		if id, ok := s.ProjectID.(bson.ObjectID); !ok || id != f.Project {
+			return false
+		}
+	}
+
+	if f.Method != "" && s.Method != f.Method {
+		return false
+	}
+
+	if f.User != "" && s.User != f.User {
+		return false
+	}
+
+	if f.Host != "" {
+		host := strings.ToLower(s.Host)
+		if host != f.Host && !strings.HasSuffix(host, "."+f.Host) {
+			return false
+		}
+	}
+
+	switch {
+	case f.Status == 0:
+	case f.Status < 10:
+		if s.Status/100 != f.Status {
+			return false
+		}
+	default:
+		if s.Status != f.Status {
+			return false
+		}
+	}
+
+	return true
+}
+
+type Subscription struct {
+	C       <-chan model.Summary
+	c       chan model.Summary
+	filter  Filter
+	dropped atomic.Int64
+}
+
+// Dropped returns how many summaries were skipped because the consumer fell
+// behind since the previous call.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Swap(0)
+}
+
+// Hub fans stored transactions out to live feed consumers. Publishing never
+// blocks, summaries for slow consumers are dropped and counted instead.
+type Hub struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+func New() *Hub {
+	return &Hub{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+func (h *Hub) Subscribe(filter Filter) *Subscription {
+	c := make(chan model.Summary, subscriptionBuffer)
+	sub := &Subscription{C: c, c: c, filter: filter}
+
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+
+	return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.mu.Unlock()
+}
+
+func (h *Hub) Publish(transaction *model.Transaction) {
+	summary := model.NewSummary(transaction)
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for sub := range h.subs {
+		if !sub.filter.Match(summary) {
+			continue
+		}
+
+		select {
+		case sub.c <- summary:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
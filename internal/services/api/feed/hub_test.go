@@ -0,0 +1,149 @@
+package feed_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/internal/services/api/feed"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    feed.Filter
+		wantErr bool
+	}{
+		{"", feed.Filter{}, false},
+		{"host=Example.COM&method=post&user=alice", feed.Filter{Host: "example.com", Method: "POST", User: "alice"}, false},
+		{"status=404", feed.Filter{Status: 404}, false},
+		{"status=5xx", feed.Filter{Status: 5}, false},
+		{"status=4XX", feed.Filter{Status: 4}, false},
+		{"status=1xx", feed.Filter{Status: 1}, false},
+		{"status=6xx", feed.Filter{}, true},
+		{"status=0xx", feed.Filter{}, true},
+		{"status=42", feed.Filter{}, true},
+		{"status=600", feed.Filter{}, true},
+		{"status=0", feed.Filter{}, true},
+		{"status=ok", feed.Filter{}, true},
+	}
+	for _, tt := range tests {
+		query, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := feed.ParseFilter(query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseFilter(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseFilter(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	project, other := bson.NewObjectID(), bson.NewObjectID()
+	summary := model.Summary{
+		ProjectID: project,
+		User:      "alice",
+		Method:    "GET",
+		Host:      "API.Example.com",
+		Status:    503,
+	}
+
+	tests := []struct {
+		name    string
+		filter  feed.Filter
+		summary model.Summary
+		want    bool
+	}{
+		{"empty filter", feed.Filter{}, summary, true},
+		{"exact host", feed.Filter{Host: "api.example.com"}, summary, true},
+		{"parent domain", feed.Filter{Host: "example.com"}, summary, true},
+		{"suffix that is not a subdomain", feed.Filter{Host: "ample.com"}, summary, false},
+		{"subdomain of the host", feed.Filter{Host: "v2.api.example.com"}, summary, false},
+		{"other host", feed.Filter{Host: "example.org"}, summary, false},
+		{"method", feed.Filter{Method: "GET"}, summary, true},
+		{"other method", feed.Filter{Method: "POST"}, summary, false},
+		{"user", feed.Filter{User: "alice"}, summary, true},
+		{"other user", feed.Filter{User: "bob"}, summary, false},
+		{"exact status", feed.Filter{Status: 503}, summary, true},
+		{"other status", feed.Filter{Status: 500}, summary, false},
+		{"status class", feed.Filter{Status: 5}, summary, true},
+		{"other status class", feed.Filter{Status: 4}, summary, false},
+		{"project", feed.Filter{Project: project}, summary, true},
+		{"other project", feed.Filter{Project: other}, summary, false},
+		{"project filter without a project", feed.Filter{Project: project}, model.Summary{Host: "api.example.com"}, false},
+		{"all fields", feed.Filter{Host: "example.com", Method: "GET", User: "alice", Status: 5, Project: project}, summary, true},
+		{"all fields but one", feed.Filter{Host: "example.com", Method: "GET", User: "alice", Status: 2, Project: project}, summary, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Match(tt.summary); got != tt.want {
+			t.Errorf("%s: Match() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func transaction(host string, status int) *model.Transaction {
+	return &model.Transaction{
+		Request:  model.Request{Method: "GET", Host: host, Path: "/"},
+		Response: model.Response{Status: status},
+	}
+}
+
+func TestPublishDropsForSlowConsumers(t *testing.T) {
+	hub := feed.New()
+	slow := hub.Subscribe(feed.Filter{})
+	filtered := hub.Subscribe(feed.Filter{Host: "example.org"})
+	defer hub.Unsubscribe(slow)
+	defer hub.Unsubscribe(filtered)
+
+	// nobody reads, publishing must still return
+	const published = 1000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range published {
+			hub.Publish(transaction("example.com", 200))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish() blocked on a full subscriber")
+	}
+
+	buffered := len(slow.C)
+	if buffered == 0 {
+		t.Fatal("nothing was delivered")
+	}
+	if dropped := slow.Dropped(); dropped != int64(published-buffered) {
+		t.Errorf("Dropped() = %d, want %d", dropped, published-buffered)
+	}
+	if dropped := slow.Dropped(); dropped != 0 {
+		t.Errorf("Dropped() = %d on the second call, want it reset", dropped)
+	}
+	if dropped := filtered.Dropped(); dropped != 0 || len(filtered.C) != 0 {
+		t.Errorf("subscriber filtered out got %d summaries and %d drops", len(filtered.C), dropped)
+	}
+
+	// a drained subscriber gets summaries again
+	for range buffered {
+		<-slow.C
+	}
+	hub.Publish(transaction("example.com", 201))
+	if summary := <-slow.C; summary.Status != 201 {
+		t.Errorf("received status %d, want 201", summary.Status)
+	}
+
+	hub.Unsubscribe(slow)
+	hub.Publish(transaction("example.com", 202))
+	if len(slow.C) != 0 {
+		t.Error("unsubscribed consumer got a summary")
+	}
+}
@@ -0,0 +1,12 @@
+package project
+
+import (
+	"go.uber.org/fx"
+)
+
+func Module() fx.Option {
+	return fx.Module(
+		"api.project",
+		fx.Provide(New),
+	)
+}
@@ -0,0 +1,77 @@
+package project
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/services/api/repo"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.uber.org/fx"
+)
+
+const loadTimeout = 10 * time.Second
+
+// Active keeps the id of the active project in memory, the proxy reads it
+// for every captured transaction.
+type Active struct {
+	repo *repo.Request
+	id   atomic.Pointer[bson.ObjectID]
+}
+
+func New(repo *repo.Request, lc fx.Lifecycle) *Active {
+	a := &Active{repo: repo}
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go a.load()
+			return nil
+		},
+	})
+
+	return a
+}
+
+func (a *Active) load() {
+	ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+	defer cancel()
+
+	project, err := a.repo.GetActiveProject(ctx)
+	if err != nil {
+		log.Err(err).Msg("failed to load active project")
+		return
+	}
+
+	if project != nil {
+		if id, ok := project.ID.(bson.ObjectID); ok {
+			a.id.CompareAndSwap(nil, &id)
+		}
+	}
+}
+
+// ID returns the active project, zero when there is none.
+func (a *Active) ID() bson.ObjectID {
+	if id := a.id.Load(); id != nil {
+		return *id
+	}
+	return bson.ObjectID{}
+}
+
+// Set switches the active project, a zero id clears it.
+func (a *Active) Set(ctx context.Context, id bson.ObjectID) error {
+	if err := a.repo.SetActiveProject(ctx, id); err != nil {
+		return err
+	}
+
+	a.id.Store(&id)
+	return nil
+}
+
+// Forget clears the active project if it is id, after it was deleted.
+func (a *Active) Forget(id bson.ObjectID) {
+	current := a.id.Load()
+	if current != nil && *current == id {
+		a.id.CompareAndSwap(current, nil)
+	}
+}
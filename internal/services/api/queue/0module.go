@@ -0,0 +1,12 @@
+package queue
+
+import (
+	"go.uber.org/fx"
+)
+
+func Module() fx.Option {
+	return fx.Module(
+		"api.queue",
+		fx.Provide(New),
+	)
+}
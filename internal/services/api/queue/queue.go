@@ -0,0 +1,330 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/internal/metrics"
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/internal/services/api/blob"
+	"github.com/daronenko/https-proxy/internal/services/api/feed"
+	"github.com/daronenko/https-proxy/internal/services/api/repo"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+)
+
+const (
+	defaultCapacity        = 10000
+	defaultBatchSize       = 100
+	defaultFlushInterval   = 200 * time.Millisecond
+	defaultMaxRetries      = 5
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultMaxRetryBackoff = 5 * time.Second
+
+	// drainTimeout bounds every batch replayed from the spill file, a hung
+	// database fails the drain instead of stalling the queue
+	drainTimeout = 30 * time.Second
+)
+
+type item struct {
+	transaction *model.Transaction
+	span        trace.SpanContext
+}
+
+// Queue persists captured transactions in the background. Writes are
+// batched and retried, and when the queue overflows or the database stays
+// unreachable transactions go to a spill file that is replayed once writes
+// succeed again. Without a spill file they are dropped and counted.
+type Queue struct {
+	repo    *repo.Request
+	blobs   *blob.Store
+	feed    *feed.Hub
+	metrics *metrics.Metrics
+	tracer  trace.Tracer
+	spec    config.QueueSpec
+	spill   *spill
+
+	items chan item
+	done  chan struct{}
+
+	// ctx is cancelled when shutdown runs out of time, so pending retries
+	// give up and spill instead
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func New(repo *repo.Request, blobs *blob.Store, feed *feed.Hub, metrics *metrics.Metrics, tracerProvider trace.TracerProvider, conf *config.Config, lc fx.Lifecycle) (*Queue, error) {
+	spec := withDefaults(conf.App.Queue)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		repo:    repo,
+		blobs:   blobs,
+		feed:    feed,
+		metrics: metrics,
+		tracer:  tracerProvider.Tracer("github.com/daronenko/https-proxy/internal/services/api/queue"),
+		spec:    spec,
+		items:   make(chan item, spec.Capacity),
+		done:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	if spec.SpillPath != "" {
+		spill, err := newSpill(spec.SpillPath, spec.SpillMaxBytes)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		q.spill = spill
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go q.run()
+			return nil
+		},
+		OnStop: q.stop,
+	})
+
+	return q, nil
+}
+
+func withDefaults(spec config.QueueSpec) config.QueueSpec {
+	if spec.Capacity <= 0 {
+		spec.Capacity = defaultCapacity
+	}
+	if spec.BatchSize <= 0 {
+		spec.BatchSize = defaultBatchSize
+	}
+	if spec.FlushInterval <= 0 {
+		spec.FlushInterval = defaultFlushInterval
+	}
+	if spec.MaxRetries <= 0 {
+		spec.MaxRetries = defaultMaxRetries
+	}
+	if spec.RetryBackoff <= 0 {
+		spec.RetryBackoff = defaultRetryBackoff
+	}
+	if spec.MaxRetryBackoff <= 0 {
+		spec.MaxRetryBackoff = defaultMaxRetryBackoff
+	}
+	return spec
+}
+
+// Enqueue never blocks on the database. The transaction gets its id here so
+// that a retried batch which was partly written does not store duplicates.
+func (q *Queue) Enqueue(ctx context.Context, transaction *model.Transaction) {
+	if transaction.ID == nil {
+		transaction.ID = bson.NewObjectID()
+	}
+	it := item{transaction: transaction, span: trace.SpanContextFromContext(ctx)}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		q.overflow([]*model.Transaction{transaction}, "closed")
+		return
+	}
+
+	select {
+	case q.items <- it:
+		q.metrics.QueueDepth.Set(float64(len(q.items)))
+	default:
+		q.overflow([]*model.Transaction{transaction}, "full")
+	}
+}
+
+func (q *Queue) run() {
+	defer close(q.done)
+
+	q.drainSpill()
+
+	ticker := time.NewTicker(q.spec.FlushInterval)
+	defer ticker.Stop()
+
+	var (
+		batch     = make([]item, 0, q.spec.BatchSize)
+		lastDrain = time.Now()
+	)
+	for {
+		select {
+		case it, ok := <-q.items:
+			if !ok {
+				q.flush(batch)
+				return
+			}
+
+			batch = append(batch, it)
+			if len(batch) < q.spec.BatchSize {
+				continue
+			}
+
+		case <-ticker.C:
+			if len(batch) == 0 {
+				// retry the spill file now and then while idle, not on
+				// every tick, the database may still be down
+				if q.spill != nil && q.spill.Len() > 0 && time.Since(lastDrain) > q.spec.MaxRetryBackoff {
+					q.drainSpill()
+					lastDrain = time.Now()
+				}
+				continue
+			}
+		}
+
+		if q.flush(batch) && q.spill != nil && q.spill.Len() > 0 {
+			q.drainSpill()
+			lastDrain = time.Now()
+		}
+		batch = batch[:0]
+		q.metrics.QueueDepth.Set(float64(len(q.items)))
+	}
+}
+
+func (q *Queue) flush(batch []item) bool {
+	if len(batch) == 0 {
+		return true
+	}
+
+	transactions := make([]*model.Transaction, 0, len(batch))
+	links := make([]trace.Link, 0, len(batch))
+	for _, it := range batch {
+		transactions = append(transactions, it.transaction)
+		if it.span.IsValid() {
+			links = append(links, trace.Link{SpanContext: it.span})
+		}
+	}
+
+	ctx, span := q.tracer.Start(q.ctx, "queue.flush",
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("queue.batch.size", len(batch))),
+	)
+	defer span.End()
+
+	// spilled transactions keep their bodies, references to blobs could be
+	// collected before the spill file is replayed
+	restore := q.blobs.Offload(ctx, transactions)
+
+	if err := q.store(ctx, transactions); err != nil {
+		restore()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		log.Err(err).Int("transactions", len(transactions)).Msg("failed to store transactions")
+		q.overflow(transactions, "storage")
+		return false
+	}
+
+	return true
+}
+
+// store writes transactions with exponential backoff between attempts and
+// publishes them to the live feed once they are stored.
+func (q *Queue) store(ctx context.Context, transactions []*model.Transaction) error {
+	backoff := q.spec.RetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := q.repo.SaveTransactions(ctx, transactions)
+		if err == nil {
+			for _, transaction := range transactions {
+				q.feed.Publish(transaction)
+			}
+			return nil
+		}
+
+		if attempt >= q.spec.MaxRetries {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt+1, err)
+		}
+
+		q.metrics.QueueRetries.Inc()
+		log.Warn().Err(err).Dur("backoff", backoff).Msg("failed to store transactions, retrying")
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("retry cancelled: %w", err)
+		}
+		backoff = min(backoff*2, q.spec.MaxRetryBackoff)
+	}
+}
+
+func (q *Queue) drainSpill() {
+	if q.spill == nil {
+		return
+	}
+
+	stored, err := q.spill.Drain(q.spec.BatchSize, func(transactions []*model.Transaction) error {
+		ctx, cancel := context.WithTimeout(q.ctx, drainTimeout)
+		defer cancel()
+
+		restore := q.blobs.Offload(ctx, transactions)
+		if err := q.repo.SaveTransactions(ctx, transactions); err != nil {
+			restore()
+			return err
+		}
+		for _, transaction := range transactions {
+			q.feed.Publish(transaction)
+		}
+		return nil
+	})
+	if stored > 0 {
+		log.Info().Int("transactions", stored).Msg("replayed spilled transactions")
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to replay spilled transactions")
+	}
+}
+
+// overflow spills transactions that can not be queued or stored, or drops
+// them when there is no room left on disk either.
+func (q *Queue) overflow(transactions []*model.Transaction, reason string) {
+	if q.spill != nil {
+		err := q.spill.Write(transactions)
+		if err == nil {
+			q.metrics.QueueSpilled.Add(float64(len(transactions)))
+			return
+		}
+		if err == errSpillFull {
+			reason = "spill_full"
+		} else {
+			log.Err(err).Msg("failed to spill transactions")
+		}
+	}
+
+	q.metrics.QueueDropped.WithLabelValues(reason).Add(float64(len(transactions)))
+	log.Warn().Int("transactions", len(transactions)).Str("reason", reason).Msg("dropped transactions")
+}
+
+// stop closes the queue and waits until everything queued is stored. When
+// ctx expires first, pending writes are cancelled and the remaining
+// transactions are spilled.
+func (q *Queue) stop(ctx context.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	close(q.items)
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		q.cancel()
+		log.Info().Msg("transaction queue drained")
+		return nil
+	case <-ctx.Done():
+	}
+
+	log.Warn().Msg("transaction queue drain timed out, spilling the rest")
+	q.cancel()
+	<-q.done
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var errSpillFull = errors.New("spill file is full")
+
+// spill is an append-only file of bson documents holding transactions that
+// could not reach the database. Bson documents carry their own length, so
+// the file is read back without any extra framing.
+type spill struct {
+	path     string
+	maxBytes int64
+
+	// drainMu lets one drain run at a time, mu guards the file being
+	// appended to and size, which counts the file being drained too
+	drainMu sync.Mutex
+	mu      sync.Mutex
+	size    int64
+}
+
+func newSpill(path string, maxBytes int64) (*spill, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create spill directory: %w", err)
+	}
+
+	s := &spill{path: path, maxBytes: maxBytes}
+	for _, file := range []string{path, path + ".draining"} {
+		if info, err := os.Stat(file); err == nil {
+			s.size += info.Size()
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("stat spill file: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *spill) Len() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Write appends transactions, refusing the whole batch if it would grow
+// the file past its limit.
+func (s *spill) Write(transactions []*model.Transaction) error {
+	var buf []byte
+	for _, transaction := range transactions {
+		doc, err := bson.Marshal(transaction)
+		if err != nil {
+			return fmt.Errorf("marshal transaction: %w", err)
+		}
+		buf = append(buf, doc...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxBytes > 0 && s.size+int64(len(buf)) > s.maxBytes {
+		return errSpillFull
+	}
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open spill file: %w", err)
+	}
+	defer f.Close()
+
+	n, err := f.Write(buf)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write spill file: %w", err)
+	}
+
+	return f.Sync()
+}
+
+// Drain hands every spilled transaction to store in batches. The file is
+// moved aside first and store runs without the lock, so transactions keep
+// spilling while the database is slow. Transactions that were not stored
+// stay in the moved file and are tried first on the next drain.
+func (s *spill) Drain(batchSize int, store func([]*model.Transaction) error) (int, error) {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	draining := s.path + ".draining"
+
+	s.mu.Lock()
+	if s.size == 0 {
+		s.mu.Unlock()
+		return 0, nil
+	}
+	// a file left by an earlier drain is finished before a new one is taken
+	if _, err := os.Stat(draining); os.IsNotExist(err) {
+		if err := os.Rename(s.path, draining); err != nil && !os.IsNotExist(err) {
+			s.mu.Unlock()
+			return 0, fmt.Errorf("move spill file: %w", err)
+		}
+	}
+	s.mu.Unlock()
+
+	info, err := os.Stat(draining)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("stat spill file: %w", err)
+	}
+	taken := info.Size()
+
+	transactions, err := s.read(draining)
+	if err != nil {
+		return 0, err
+	}
+
+	stored := 0
+	for stored < len(transactions) {
+		end := min(stored+batchSize, len(transactions))
+		if err := store(transactions[stored:end]); err != nil {
+			left, rerr := rewrite(draining, transactions[stored:])
+			if rerr != nil {
+				return stored, errors.Join(err, rerr)
+			}
+			s.resize(left - taken)
+			return stored, err
+		}
+		stored = end
+	}
+
+	if err := os.Remove(draining); err != nil && !os.IsNotExist(err) {
+		return stored, fmt.Errorf("remove spill file: %w", err)
+	}
+	s.resize(-taken)
+
+	return stored, nil
+}
+
+func (s *spill) resize(delta int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size = max(s.size+delta, 0)
+}
+
+// read decodes the documents of path. Documents that do not decode, and the
+// rest of the file once a length runs past its end, are moved to a
+// quarantine file next to the spill file so they do not block every later
+// drain. A torn write at the tail left by a crash ends up there too.
+func (s *spill) read(path string) ([]*model.Transaction, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open spill file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat spill file: %w", err)
+	}
+
+	var (
+		r            = bufio.NewReader(f)
+		left         = info.Size()
+		transactions []*model.Transaction
+		corrupt      []byte
+		header       [4]byte
+	)
+	for left > 0 {
+		n, err := io.ReadFull(r, header[:])
+		size := int64(binary.LittleEndian.Uint32(header[:]))
+		if err != nil || size < int64(len(header)) || size > left {
+			rest, _ := io.ReadAll(r)
+			corrupt = append(append(corrupt, header[:n]...), rest...)
+			break
+		}
+		left -= size
+
+		doc := make([]byte, size)
+		copy(doc, header[:])
+		if _, err := io.ReadFull(r, doc[len(header):]); err != nil {
+			break
+		}
+
+		var transaction model.Transaction
+		if err := bson.Unmarshal(doc, &transaction); err != nil {
+			corrupt = append(corrupt, doc...)
+			continue
+		}
+		transactions = append(transactions, &transaction)
+	}
+
+	if len(corrupt) > 0 {
+		if err := s.quarantine(corrupt); err != nil {
+			return nil, err
+		}
+		log.Warn().Int("bytes", len(corrupt)).Str("path", s.path+".corrupt").Msg("moved undecodable spilled transactions aside")
+	}
+
+	return transactions, nil
+}
+
+func (s *spill) quarantine(data []byte) error {
+	f, err := os.OpenFile(s.path+".corrupt", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open quarantine file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("write quarantine file: %w", err)
+	}
+	return f.Sync()
+}
+
+// rewrite replaces path with transactions and returns the new size.
+func rewrite(path string, transactions []*model.Transaction) (int64, error) {
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, fmt.Errorf("create spill file: %w", err)
+	}
+
+	var size int64
+	for _, transaction := range transactions {
+		doc, err := bson.Marshal(transaction)
+		if err != nil {
+			f.Close()
+			return 0, fmt.Errorf("marshal transaction: %w", err)
+		}
+		n, err := f.Write(doc)
+		size += int64(n)
+		if err != nil {
+			f.Close()
+			return 0, fmt.Errorf("write spill file: %w", err)
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return 0, fmt.Errorf("sync spill file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return 0, fmt.Errorf("close spill file: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, fmt.Errorf("replace spill file: %w", err)
+	}
+
+	return size, nil
+}
@@ -0,0 +1,149 @@
+package queue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func newTransactions(n int) []*model.Transaction {
+	transactions := make([]*model.Transaction, n)
+	for i := range transactions {
+		transactions[i] = &model.Transaction{ID: bson.NewObjectID()}
+	}
+	return transactions
+}
+
+func TestSpillWritesWhileDraining(t *testing.T) {
+	s, err := newSpill(filepath.Join(t.TempDir(), "spill.bson"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(newTransactions(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	storing := make(chan struct{})
+	release := make(chan struct{})
+	drained := make(chan int)
+	go func() {
+		stored, _ := s.Drain(10, func([]*model.Transaction) error {
+			close(storing)
+			<-release
+			return nil
+		})
+		drained <- stored
+	}()
+
+	<-storing
+	written := make(chan error)
+	go func() { written <- s.Write(newTransactions(1)) }()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Write blocked while store was running")
+	}
+
+	close(release)
+	if stored := <-drained; stored != 2 {
+		t.Fatalf("drained %d, want 2", stored)
+	}
+
+	var got int
+	if _, err := s.Drain(10, func(transactions []*model.Transaction) error {
+		got += len(transactions)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got != 1 || s.Len() != 0 {
+		t.Fatalf("second drain stored %d with %d bytes left, want 1 and 0", got, s.Len())
+	}
+}
+
+func TestSpillKeepsUnstored(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spill.bson")
+	s, err := newSpill(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(newTransactions(5)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first batch is stored, then the database goes down
+	calls := 0
+	stored, err := s.Drain(2, func([]*model.Transaction) error {
+		calls++
+		if calls > 1 {
+			return errors.New("database down")
+		}
+		return nil
+	})
+	if stored != 2 || err == nil {
+		t.Fatalf("Drain() = %d, %v, want 2 and an error", stored, err)
+	}
+
+	// a restart picks up the file the failed drain left behind
+	s, err = newSpill(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() == 0 {
+		t.Fatal("restart lost the transactions left by the failed drain")
+	}
+
+	stored, err = s.Drain(10, func([]*model.Transaction) error { return nil })
+	if err != nil || stored != 3 {
+		t.Fatalf("Drain() = %d, %v, want 3", stored, err)
+	}
+}
+
+func TestSpillQuarantinesCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spill.bson")
+
+	good, err := bson.Marshal(&model.Transaction{ID: bson.NewObjectID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a document whose request is a string does not decode into a transaction
+	bad, err := bson.Marshal(bson.D{{Key: "request", Value: "garbage"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn := []byte{0xff, 0xff, 0, 0, 1}
+
+	data := append(append(append([]byte{}, good...), bad...), good...)
+	data = append(data, torn...)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newSpill(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := s.Drain(10, func([]*model.Transaction) error { return nil })
+	if err != nil || stored != 2 {
+		t.Fatalf("Drain() = %d, %v, want 2", stored, err)
+	}
+	if s.Len() != 0 {
+		t.Fatalf("%d bytes left after the drain", s.Len())
+	}
+
+	corrupt, err := os.ReadFile(path + ".corrupt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := len(bad) + len(torn); len(corrupt) != want {
+		t.Fatalf("quarantined %d bytes, want %d", len(corrupt), want)
+	}
+}
@@ -0,0 +1,49 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/daronenko/https-proxy/internal/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func (repo *Request) CreateAuditEntry(ctx context.Context, entry *model.AuditEntry) error {
+	if _, err := repo.getAuditCollection().InsertOne(ctx, entry); err != nil {
+		return fmt.Errorf("creating audit entry error: %w", err)
+	}
+
+	return nil
+}
+
+// GetAuditEntries returns the latest entries first, an empty actor matches
+// everyone.
+func (repo *Request) GetAuditEntries(ctx context.Context, actor string, limit int64) ([]*model.AuditEntry, error) {
+	filter := bson.D{}
+	if actor != "" {
+		filter = append(filter, bson.E{Key: "actor", Value: actor})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit)
+	cursor, err := repo.getAuditCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("listing audit entries error: %w", err)
+	}
+
+	entries := []*model.AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("decoding audit entries error: %w", err)
+	}
+
+	return entries, nil
+}
+
+func (repo *Request) getAuditCollection() *mongo.Collection {
+	return repo.db.Database(
+		repo.conf.App.Mongo.Database,
+	).Collection(
+		repo.conf.App.Mongo.Collections.Audit,
+	)
+}
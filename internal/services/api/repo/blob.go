@@ -0,0 +1,135 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Blob is the bookkeeping record of a body kept in the blob store.
+type Blob struct {
+	Hash      string    `bson:"_id"`
+	Size      int64     `bson:"size"`
+	Refs      int64     `bson:"refs"`
+	TouchedAt time.Time `bson:"touched_at"`
+}
+
+// AddBlobRef records one more transaction referencing the blob, creating
+// the record for a new blob. The touch time keeps the collector away from
+// blobs whose transactions may not be stored yet.
+func (repo *Request) AddBlobRef(ctx context.Context, hash string, size int64) error {
+	update := bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "refs", Value: 1}}},
+		{Key: "$set", Value: bson.D{{Key: "touched_at", Value: time.Now()}}},
+		{Key: "$setOnInsert", Value: bson.D{{Key: "size", Value: size}}},
+	}
+
+	_, err := repo.getBlobsCollection().UpdateByID(ctx, hash, update, options.UpdateOne().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("adding blob reference error: %w", err)
+	}
+
+	return nil
+}
+
+// CountBlobRefs counts the transactions referencing each blob by scanning
+// request and response body references.
+func (repo *Request) CountBlobRefs(ctx context.Context) (map[string]int64, error) {
+	refs := make(map[string]int64)
+
+	for _, field := range []string{"request.body_ref", "response.body_ref"} {
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: bson.D{{Key: field, Value: bson.M{"$exists": true}}}}},
+			{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: "$" + field},
+				{Key: "refs", Value: bson.D{{Key: "$sum", Value: 1}}},
+			}}},
+		}
+
+		cursor, err := repo.getTransactionsCollection().Aggregate(ctx, pipeline)
+		if err != nil {
+			return nil, fmt.Errorf("counting blob references error: %w", err)
+		}
+
+		var counts []struct {
+			Hash string `bson:"_id"`
+			Refs int64  `bson:"refs"`
+		}
+		if err := cursor.All(ctx, &counts); err != nil {
+			return nil, fmt.Errorf("decoding blob references error: %w", err)
+		}
+
+		for _, count := range counts {
+			refs[count.Hash] += count.Refs
+		}
+	}
+
+	return refs, nil
+}
+
+// ListBlobs returns blob records last referenced before the given time.
+func (repo *Request) ListBlobs(ctx context.Context, before time.Time) ([]Blob, error) {
+	cursor, err := repo.getBlobsCollection().Find(ctx, bson.D{{Key: "touched_at", Value: bson.M{"$lt": before}}})
+	if err != nil {
+		return nil, fmt.Errorf("listing blobs error: %w", err)
+	}
+
+	var blobs []Blob
+	if err := cursor.All(ctx, &blobs); err != nil {
+		return nil, fmt.Errorf("decoding blobs error: %w", err)
+	}
+
+	return blobs, nil
+}
+
+// SetBlobRefs corrects the reference count of a blob not touched since
+// before.
+func (repo *Request) SetBlobRefs(ctx context.Context, hash string, refs int64, before time.Time) error {
+	filter := bson.D{
+		{Key: "_id", Value: hash},
+		{Key: "touched_at", Value: bson.M{"$lt": before}},
+	}
+
+	_, err := repo.getBlobsCollection().UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "refs", Value: refs}}}})
+	if err != nil {
+		return fmt.Errorf("updating blob references error: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteBlob removes the record only while it is unreferenced and was not
+// touched since before, so a blob picked up again in the meantime survives.
+func (repo *Request) DeleteBlob(ctx context.Context, hash string, before time.Time) (bool, error) {
+	res, err := repo.getBlobsCollection().DeleteOne(ctx, bson.D{
+		{Key: "_id", Value: hash},
+		{Key: "refs", Value: bson.M{"$lte": 0}},
+		{Key: "touched_at", Value: bson.M{"$lt": before}},
+	})
+	if err != nil {
+		return false, fmt.Errorf("deleting blob error: %w", err)
+	}
+
+	return res.DeletedCount > 0, nil
+}
+
+func (repo *Request) HasBlob(ctx context.Context, hash string) (bool, error) {
+	count, err := repo.getBlobsCollection().CountDocuments(ctx, bson.D{{Key: "_id", Value: hash}})
+	if err != nil {
+		return false, fmt.Errorf("finding blob error: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+func (repo *Request) getBlobsCollection() *mongo.Collection {
+	return repo.db.Database(
+		repo.conf.App.Mongo.Database,
+	).Collection(
+		repo.conf.App.Mongo.Collections.Blobs,
+	)
+}
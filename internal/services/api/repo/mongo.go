@@ -4,12 +4,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/internal/metrics"
 	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/internal/tracing"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -17,61 +23,186 @@ var (
 )
 
 type Request struct {
-	db   *mongo.Client
-	conf *config.Config
+	db      *mongo.Client
+	conf    *config.Config
+	metrics *metrics.Metrics
+	tracer  trace.Tracer
 }
 
-func New(db *mongo.Client, conf *config.Config) *Request {
+func New(db *mongo.Client, conf *config.Config, metrics *metrics.Metrics, tracerProvider trace.TracerProvider) *Request {
 	return &Request{
-		db:   db,
-		conf: conf,
+		db:      db,
+		conf:    conf,
+		metrics: metrics,
+		tracer:  tracerProvider.Tracer("github.com/daronenko/https-proxy/internal/services/api/repo"),
 	}
 }
 
 func (repo *Request) CreateTransaction(ctx context.Context, transaction *model.Transaction) (*model.Transaction, error) {
-	_, err := repo.getTransactionsCollection().InsertOne(ctx, transaction)
+	ctx, span := repo.startSpan(ctx, "insert")
+	defer span.End()
+
+	start := time.Now()
+	res, err := repo.getTransactionsCollection().InsertOne(ctx, transaction)
+	repo.metrics.StorageWriteLatency.Observe(time.Since(start).Seconds())
 	if err != nil {
+		repo.metrics.StorageWriteFailures.Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return nil, fmt.Errorf("creating http transaction error: %w", err)
 	}
+	transaction.ID = res.InsertedID
+
+	if id, ok := res.InsertedID.(bson.ObjectID); ok {
+		span.SetAttributes(tracing.TransactionID.String(id.Hex()))
+	}
 
 	return transaction, nil
 }
 
-func (repo *Request) GetTransactionByID(ctx context.Context, transactionID bson.ObjectID) (*model.Transaction, error) {
-	filter := bson.M{"_id": transactionID}
+func (repo *Request) CreateTransactions(ctx context.Context, transactions []*model.Transaction) ([]interface{}, error) {
+	docs := make([]interface{}, 0, len(transactions))
+	for _, transaction := range transactions {
+		docs = append(docs, transaction)
+	}
+
+	ctx, span := repo.startSpan(ctx, "insertMany")
+	defer span.End()
+	span.SetAttributes(attribute.Int("db.operation.batch.size", len(docs)))
+
+	start := time.Now()
+	res, err := repo.getTransactionsCollection().InsertMany(ctx, docs)
+	repo.metrics.StorageWriteLatency.Observe(time.Since(start).Seconds())
+	if err != nil {
+		repo.metrics.StorageWriteFailures.Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, fmt.Errorf("creating http transactions error: %w", err)
+	}
+
+	for i, id := range res.InsertedIDs {
+		transactions[i].ID = id
+	}
+
+	return res.InsertedIDs, nil
+}
+
+// SaveTransactions inserts transactions that already carry ids. Documents
+// stored by an earlier attempt are skipped, so a failed batch can be retried
+// as a whole.
+func (repo *Request) SaveTransactions(ctx context.Context, transactions []*model.Transaction) error {
+	docs := make([]interface{}, 0, len(transactions))
+	for _, transaction := range transactions {
+		docs = append(docs, transaction)
+	}
+
+	ctx, span := repo.startSpan(ctx, "insertMany")
+	defer span.End()
+	span.SetAttributes(attribute.Int("db.operation.batch.size", len(docs)))
+
+	start := time.Now()
+	_, err := repo.getTransactionsCollection().InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	repo.metrics.StorageWriteLatency.Observe(time.Since(start).Seconds())
+	if err != nil && !onlyDuplicates(err) {
+		repo.metrics.StorageWriteFailures.Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("saving http transactions error: %w", err)
+	}
+
+	return nil
+}
+
+func onlyDuplicates(err error) bool {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) == 0 {
+		return false
+	}
+
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != 11000 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// GetTransactionByID finds a transaction within scope, see ProjectScope.
+func (repo *Request) GetTransactionByID(ctx context.Context, transactionID bson.ObjectID, scope bson.D) (*model.Transaction, error) {
+	ctx, span := repo.startSpan(ctx, "findOne")
+	defer span.End()
+	span.SetAttributes(tracing.TransactionID.String(transactionID.Hex()))
+
+	filter := append(bson.D{{Key: "_id", Value: transactionID}}, scope...)
 
 	var transaction model.Transaction
 	if err := repo.getTransactionsCollection().FindOne(ctx, filter).Decode(&transaction); err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, ErrTransactionsNotFound
 		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return nil, fmt.Errorf("getting transaction by id error: %w", err)
 	}
 
 	return &transaction, nil
 }
 
-func (repo *Request) GetTransactionsList(ctx context.Context) ([]*model.Transaction, error) {
-	cursor, err := repo.getTransactionsCollection().Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+func (repo *Request) GetTransactionsList(ctx context.Context, scope bson.D) ([]*model.Transaction, error) {
+	var results []*model.Transaction
+	err := repo.EachTransaction(ctx, scope, func(tx *model.Transaction) error {
+		results = append(results, tx)
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("listing transactions error: %w", err)
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// EachTransaction hands the transactions matching scope to fn one at a time
+// as the cursor reads them, newest first. An error from fn stops the walk
+// and is returned.
+func (repo *Request) EachTransaction(ctx context.Context, scope bson.D, fn func(*model.Transaction) error) error {
+	ctx, span := repo.startSpan(ctx, "find")
+	defer span.End()
+
+	cursor, err := repo.getTransactionsCollection().Find(ctx, scope, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("listing transactions error: %w", err)
 	}
 	defer cursor.Close(ctx)
 
-	var results []*model.Transaction
 	for cursor.Next(ctx) {
 		var tx model.Transaction
 		if err := cursor.Decode(&tx); err != nil {
-			return nil, fmt.Errorf("decoding transaction error: %w", err)
+			return fmt.Errorf("decoding transaction error: %w", err)
+		}
+		if err := fn(&tx); err != nil {
+			return err
 		}
-		results = append(results, &tx)
 	}
 
 	if err := cursor.Err(); err != nil {
-		return nil, fmt.Errorf("cursor error: %w", err)
+		return fmt.Errorf("cursor error: %w", err)
 	}
 
-	return results, nil
+	return nil
+}
+
+func (repo *Request) startSpan(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return repo.tracer.Start(ctx, "mongo."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "mongodb"),
+			attribute.String("db.operation.name", operation),
+			attribute.String("db.collection.name", repo.conf.App.Mongo.Collections.Transactions),
+		),
+	)
 }
 
 func (repo *Request) getTransactionsCollection() *mongo.Collection {
@@ -0,0 +1,150 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/daronenko/https-proxy/internal/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	ErrProjectNotFound = errors.New("project not found")
+)
+
+// ProjectScope matches transactions of a project, a zero id matches all.
+func ProjectScope(projectID bson.ObjectID) bson.D {
+	if projectID.IsZero() {
+		return bson.D{}
+	}
+	return bson.D{{Key: "project_id", Value: projectID}}
+}
+
+func (repo *Request) CreateProject(ctx context.Context, project *model.Project) (*model.Project, error) {
+	res, err := repo.getProjectsCollection().InsertOne(ctx, project)
+	if err != nil {
+		return nil, fmt.Errorf("creating project error: %w", err)
+	}
+	project.ID = res.InsertedID
+
+	return project, nil
+}
+
+func (repo *Request) GetProjectByID(ctx context.Context, projectID bson.ObjectID) (*model.Project, error) {
+	var project model.Project
+	if err := repo.getProjectsCollection().FindOne(ctx, bson.D{{Key: "_id", Value: projectID}}).Decode(&project); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrProjectNotFound
+		}
+		return nil, fmt.Errorf("getting project by id error: %w", err)
+	}
+
+	return &project, nil
+}
+
+func (repo *Request) GetProjectsList(ctx context.Context) ([]*model.Project, error) {
+	cursor, err := repo.getProjectsCollection().Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, fmt.Errorf("listing projects error: %w", err)
+	}
+
+	projects := []*model.Project{}
+	if err := cursor.All(ctx, &projects); err != nil {
+		return nil, fmt.Errorf("decoding projects error: %w", err)
+	}
+
+	return projects, nil
+}
+
+func (repo *Request) UpdateProject(ctx context.Context, projectID bson.ObjectID, name, description *string) (*model.Project, error) {
+	set := bson.D{}
+	if name != nil {
+		set = append(set, bson.E{Key: "name", Value: *name})
+	}
+	if description != nil {
+		set = append(set, bson.E{Key: "description", Value: *description})
+	}
+	if len(set) == 0 {
+		return repo.GetProjectByID(ctx, projectID)
+	}
+
+	var project model.Project
+	err := repo.getProjectsCollection().FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: projectID}},
+		bson.D{{Key: "$set", Value: set}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&project)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrProjectNotFound
+		}
+		return nil, fmt.Errorf("updating project error: %w", err)
+	}
+
+	return &project, nil
+}
+
+// DeleteProject removes the project together with its transactions and
+// returns how many transactions were deleted.
+func (repo *Request) DeleteProject(ctx context.Context, projectID bson.ObjectID) (int64, error) {
+	res, err := repo.getProjectsCollection().DeleteOne(ctx, bson.D{{Key: "_id", Value: projectID}})
+	if err != nil {
+		return 0, fmt.Errorf("deleting project error: %w", err)
+	}
+	if res.DeletedCount == 0 {
+		return 0, ErrProjectNotFound
+	}
+
+	return repo.DeleteTransactions(ctx, ProjectScope(projectID))
+}
+
+// GetActiveProject returns the project new transactions are tagged with,
+// or nil when there is none.
+func (repo *Request) GetActiveProject(ctx context.Context) (*model.Project, error) {
+	var project model.Project
+	if err := repo.getProjectsCollection().FindOne(ctx, bson.D{{Key: "active", Value: true}}).Decode(&project); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting active project error: %w", err)
+	}
+
+	return &project, nil
+}
+
+// SetActiveProject makes the project active and every other one inactive,
+// a zero id leaves no project active.
+func (repo *Request) SetActiveProject(ctx context.Context, projectID bson.ObjectID) error {
+	coll := repo.getProjectsCollection()
+
+	if !projectID.IsZero() {
+		res, err := coll.UpdateByID(ctx, projectID, bson.D{{Key: "$set", Value: bson.D{{Key: "active", Value: true}}}})
+		if err != nil {
+			return fmt.Errorf("activating project error: %w", err)
+		}
+		if res.MatchedCount == 0 {
+			return ErrProjectNotFound
+		}
+	}
+
+	_, err := coll.UpdateMany(ctx,
+		bson.D{{Key: "_id", Value: bson.M{"$ne": projectID}}, {Key: "active", Value: true}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "active", Value: false}}}},
+	)
+	if err != nil {
+		return fmt.Errorf("deactivating projects error: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *Request) getProjectsCollection() *mongo.Collection {
+	return repo.db.Database(
+		repo.conf.App.Mongo.Database,
+	).Collection(
+		repo.conf.App.Mongo.Collections.Projects,
+	)
+}
@@ -0,0 +1,252 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	ttlIndexName = "created_at_ttl"
+
+	// deleteChunk bounds the number of ids sent in a single delete
+	deleteChunk = 1000
+)
+
+// PurgeFilter selects transactions to delete. Zero fields match everything,
+// pinned transactions are kept unless IncludePinned is set.
+type PurgeFilter struct {
+	ProjectID bson.ObjectID
+	Host      string
+	Method    string
+	// Status is either an exact code or a class such as 5 for 5xx.
+	Status        int
+	Before        time.Time
+	User          string
+	IncludePinned bool
+}
+
+func (f PurgeFilter) bson() bson.D {
+	filter := ProjectScope(f.ProjectID)
+
+	if f.Host != "" {
+		filter = append(filter, HostFilter(f.Host)...)
+	}
+	if f.Method != "" {
+		filter = append(filter, bson.E{Key: "request.method", Value: f.Method})
+	}
+	if f.Status >= 100 {
+		filter = append(filter, bson.E{Key: "response.status", Value: f.Status})
+	} else if f.Status > 0 {
+		filter = append(filter, bson.E{Key: "response.status", Value: bson.M{"$gte": f.Status * 100, "$lt": (f.Status + 1) * 100}})
+	}
+	if !f.Before.IsZero() {
+		filter = append(filter, bson.E{Key: "created_at", Value: bson.M{"$lt": f.Before}})
+	}
+	if f.User != "" {
+		filter = append(filter, bson.E{Key: "user", Value: f.User})
+	}
+	if !f.IncludePinned {
+		filter = append(filter, NotPinned...)
+	}
+
+	return filter
+}
+
+// NotPinned matches transactions that may be pruned, including ones stored
+// before pinning existed.
+var NotPinned = bson.D{{Key: "pinned", Value: bson.M{"$ne": true}}}
+
+// HostFilter matches a host and its subdomains on any port.
+func HostFilter(host string) bson.D {
+	pattern := `^(.+\.)?` + regexp.QuoteMeta(host) + `(:\d+)?$`
+	return bson.D{{Key: "request.host", Value: bson.Regex{Pattern: pattern, Options: "i"}}}
+}
+
+func (repo *Request) PurgeTransactions(ctx context.Context, filter PurgeFilter) (int64, error) {
+	return repo.DeleteTransactions(ctx, filter.bson())
+}
+
+func (repo *Request) DeleteTransactions(ctx context.Context, filter bson.D) (int64, error) {
+	res, err := repo.getTransactionsCollection().DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("deleting transactions error: %w", err)
+	}
+
+	return res.DeletedCount, nil
+}
+
+// DeleteOldestTransactions deletes up to n of the oldest transactions
+// matching filter.
+func (repo *Request) DeleteOldestTransactions(ctx context.Context, filter bson.D, n int64) (int64, error) {
+	var deleted int64
+
+	for deleted < n {
+		limit := min(n-deleted, deleteChunk)
+		opts := options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: 1}}).
+			SetLimit(limit).
+			SetProjection(bson.D{{Key: "_id", Value: 1}})
+
+		cursor, err := repo.getTransactionsCollection().Find(ctx, filter, opts)
+		if err != nil {
+			return deleted, fmt.Errorf("finding oldest transactions error: %w", err)
+		}
+
+		var docs []struct {
+			ID bson.ObjectID `bson:"_id"`
+		}
+		if err := cursor.All(ctx, &docs); err != nil {
+			return deleted, fmt.Errorf("decoding oldest transactions error: %w", err)
+		}
+		if len(docs) == 0 {
+			break
+		}
+
+		ids := make(bson.A, 0, len(docs))
+		for _, doc := range docs {
+			ids = append(ids, doc.ID)
+		}
+
+		res, err := repo.getTransactionsCollection().DeleteMany(ctx, bson.D{{Key: "_id", Value: bson.M{"$in": ids}}})
+		if err != nil {
+			return deleted, fmt.Errorf("deleting oldest transactions error: %w", err)
+		}
+		deleted += res.DeletedCount
+	}
+
+	return deleted, nil
+}
+
+func (repo *Request) CountTransactions(ctx context.Context, filter bson.D) (int64, error) {
+	count, err := repo.getTransactionsCollection().CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("counting transactions error: %w", err)
+	}
+
+	return count, nil
+}
+
+// TransactionsSize returns the uncompressed size of the collection and the
+// number of documents in it, as reported by collection stats.
+func (repo *Request) TransactionsSize(ctx context.Context) (size, count int64, err error) {
+	pipeline := mongo.Pipeline{{{Key: "$collStats", Value: bson.D{{Key: "storageStats", Value: bson.D{}}}}}}
+
+	cursor, err := repo.getTransactionsCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, 0, fmt.Errorf("collection stats error: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var stats struct {
+		StorageStats struct {
+			Size  int64 `bson:"size"`
+			Count int64 `bson:"count"`
+		} `bson:"storageStats"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&stats); err != nil {
+			return 0, 0, fmt.Errorf("decoding collection stats error: %w", err)
+		}
+	}
+
+	return stats.StorageStats.Size, stats.StorageStats.Count, cursor.Err()
+}
+
+func (repo *Request) SetPinned(ctx context.Context, transactionID bson.ObjectID, pinned bool) error {
+	res, err := repo.getTransactionsCollection().UpdateByID(ctx, transactionID, bson.D{{Key: "$set", Value: bson.D{{Key: "pinned", Value: pinned}}}})
+	if err != nil {
+		return fmt.Errorf("updating transaction error: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrTransactionsNotFound
+	}
+
+	return nil
+}
+
+// EnsureTTLIndex lets mongo expire unpinned transactions older than maxAge
+// on its own. A zero maxAge removes the index.
+func (repo *Request) EnsureTTLIndex(ctx context.Context, maxAge time.Duration) error {
+	coll := repo.getTransactionsCollection()
+
+	if maxAge <= 0 {
+		err := coll.Indexes().DropOne(ctx, ttlIndexName)
+		var cmdErr mongo.CommandError
+		if err != nil && !(errors.As(err, &cmdErr) && cmdErr.Name == "IndexNotFound") {
+			return fmt.Errorf("dropping ttl index error: %w", err)
+		}
+		return nil
+	}
+
+	seconds := int32(maxAge / time.Second)
+	model := mongo.IndexModel{
+		Keys: bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().
+			SetName(ttlIndexName).
+			SetExpireAfterSeconds(seconds).
+			SetPartialFilterExpression(bson.D{{Key: "pinned", Value: false}}),
+	}
+
+	_, err := coll.Indexes().CreateOne(ctx, model)
+	if err == nil {
+		return nil
+	}
+
+	// the index exists with another expiry, which collMod changes in place
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Name != "IndexOptionsConflict" {
+		return fmt.Errorf("creating ttl index error: %w", err)
+	}
+
+	err = coll.Database().RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: coll.Name()},
+		{Key: "index", Value: bson.D{
+			{Key: "name", Value: ttlIndexName},
+			{Key: "expireAfterSeconds", Value: seconds},
+		}},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("updating ttl index error: %w", err)
+	}
+
+	return nil
+}
+
+// OffloadedSize sums the bodies of the transactions that are kept in the
+// blob store. A body shared by several transactions is counted for each of
+// them.
+func (repo *Request) OffloadedSize(ctx context.Context) (int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "size", Value: bson.D{{Key: "$sum", Value: bson.D{{Key: "$add", Value: bson.A{
+				bson.D{{Key: "$ifNull", Value: bson.A{"$request.body_size", 0}}},
+				bson.D{{Key: "$ifNull", Value: bson.A{"$response.body_size", 0}}},
+			}}}}}},
+		}}},
+	}
+
+	cursor, err := repo.getTransactionsCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, fmt.Errorf("offloaded size error: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var total struct {
+		Size int64 `bson:"size"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&total); err != nil {
+			return 0, fmt.Errorf("decoding offloaded size error: %w", err)
+		}
+	}
+
+	return total.Size, cursor.Err()
+}
@@ -0,0 +1,12 @@
+package retention
+
+import (
+	"go.uber.org/fx"
+)
+
+func Module() fx.Option {
+	return fx.Module(
+		"api.retention",
+		fx.Invoke(Init),
+	)
+}
@@ -0,0 +1,199 @@
+package retention
+
+import (
+	"context"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/internal/metrics"
+	"github.com/daronenko/https-proxy/internal/services/api/repo"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.uber.org/fx"
+)
+
+const (
+	defaultPruneInterval = time.Minute
+	indexTimeout         = 10 * time.Second
+)
+
+// transactionRepo is what the pruner needs from the request repo.
+type transactionRepo interface {
+	EnsureTTLIndex(ctx context.Context, maxAge time.Duration) error
+	DeleteTransactions(ctx context.Context, filter bson.D) (int64, error)
+	DeleteOldestTransactions(ctx context.Context, filter bson.D, n int64) (int64, error)
+	CountTransactions(ctx context.Context, filter bson.D) (int64, error)
+	TransactionsSize(ctx context.Context) (size, count int64, err error)
+	OffloadedSize(ctx context.Context) (int64, error)
+}
+
+// Pruner enforces the limits a ttl index can not express: total count and
+// size, per host rules and the age of transactions stored before pinning
+// existed. Pinned transactions are never deleted.
+type Pruner struct {
+	repo    transactionRepo
+	metrics *metrics.Metrics
+	spec    config.RetentionSpec
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func Init(repo *repo.Request, metrics *metrics.Metrics, conf *config.Config, lc fx.Lifecycle) {
+	spec := conf.App.Retention
+	if spec.PruneInterval <= 0 {
+		spec.PruneInterval = defaultPruneInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pruner{
+		repo:    repo,
+		metrics: metrics,
+		spec:    spec,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go p.run()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			p.cancel()
+			select {
+			case <-p.done:
+			case <-ctx.Done():
+			}
+			return nil
+		},
+	})
+}
+
+func (p *Pruner) run() {
+	defer close(p.done)
+
+	// a failed index is only logged, the pruner enforces the age limit too
+	ctx, cancel := context.WithTimeout(p.ctx, indexTimeout)
+	if err := p.repo.EnsureTTLIndex(ctx, p.spec.MaxAge); err != nil {
+		log.Err(err).Msg("failed to ensure retention ttl index")
+	}
+	cancel()
+
+	ticker := time.NewTicker(p.spec.PruneInterval)
+	defer ticker.Stop()
+
+	for {
+		p.Prune(p.ctx)
+
+		select {
+		case <-ticker.C:
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// Prune applies every configured limit once.
+func (p *Pruner) Prune(ctx context.Context) {
+	now := time.Now()
+
+	for _, rule := range p.spec.Hosts {
+		host := repo.HostFilter(rule.Host)
+		if rule.MaxAge > 0 {
+			p.deleteOlder(ctx, "host", host, now.Add(-rule.MaxAge))
+		}
+		if rule.MaxCount > 0 {
+			p.deleteOverCount(ctx, "host", host, rule.MaxCount)
+		}
+	}
+
+	if p.spec.MaxAge > 0 {
+		p.deleteOlder(ctx, "age", bson.D{}, now.Add(-p.spec.MaxAge))
+	}
+	if p.spec.MaxCount > 0 {
+		p.deleteOverCount(ctx, "count", bson.D{}, p.spec.MaxCount)
+	}
+	if p.spec.MaxBytes > 0 {
+		p.deleteOverSize(ctx)
+	}
+}
+
+func (p *Pruner) deleteOlder(ctx context.Context, reason string, filter bson.D, before time.Time) {
+	filter = append(append(bson.D{}, filter...), repo.NotPinned...)
+	filter = append(filter, bson.E{Key: "created_at", Value: bson.M{"$lt": before}})
+
+	deleted, err := p.repo.DeleteTransactions(ctx, filter)
+	p.record(reason, deleted, err)
+}
+
+func (p *Pruner) deleteOverCount(ctx context.Context, reason string, filter bson.D, limit int64) {
+	filter = append(append(bson.D{}, filter...), repo.NotPinned...)
+
+	count, err := p.repo.CountTransactions(ctx, filter)
+	excess := overCount(count, limit)
+	if err != nil || excess == 0 {
+		p.record(reason, 0, err)
+		return
+	}
+
+	deleted, err := p.repo.DeleteOldestTransactions(ctx, filter, excess)
+	p.record(reason, deleted, err)
+}
+
+// overCount returns how many transactions are past the limit.
+func overCount(count, limit int64) int64 {
+	return max(count-limit, 0)
+}
+
+// deleteOverSize estimates how many of the oldest transactions to delete
+// from the average transaction size, collection stats are not exact anyway.
+// Bodies in the blob store count towards the size of their transactions.
+func (p *Pruner) deleteOverSize(ctx context.Context) {
+	size, count, err := p.repo.TransactionsSize(ctx)
+	if err != nil || count == 0 {
+		p.record("size", 0, err)
+		return
+	}
+
+	offloaded, err := p.repo.OffloadedSize(ctx)
+	if err != nil {
+		p.record("size", 0, err)
+		return
+	}
+	excess := overSize(size+offloaded, count, p.spec.MaxBytes)
+	if excess == 0 {
+		p.record("size", 0, nil)
+		return
+	}
+
+	deleted, err := p.repo.DeleteOldestTransactions(ctx, repo.NotPinned, excess)
+	p.record("size", deleted, err)
+}
+
+// overSize returns how many transactions of average size have to go for
+// size to fit in limit, rounded up.
+func overSize(size, count, limit int64) int64 {
+	if count <= 0 || size <= limit {
+		return 0
+	}
+
+	avg := max(size/count, 1)
+	return min((size-limit+avg-1)/avg, count)
+}
+
+func (p *Pruner) record(reason string, deleted int64, err error) {
+	if err != nil {
+		if p.ctx.Err() != nil {
+			return
+		}
+		log.Err(err).Str("reason", reason).Msg("failed to prune transactions")
+		return
+	}
+	if deleted > 0 {
+		p.metrics.RetentionPruned.WithLabelValues(reason).Add(float64(deleted))
+		log.Info().Int64("transactions", deleted).Str("reason", reason).Msg("pruned transactions")
+	}
+}
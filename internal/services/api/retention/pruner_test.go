@@ -0,0 +1,199 @@
+package retention
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/internal/metrics"
+	"github.com/daronenko/https-proxy/internal/services/api/repo"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestOverCount(t *testing.T) {
+	tests := []struct {
+		count, limit, want int64
+	}{
+		{0, 10, 0},
+		{10, 10, 0},
+		{11, 10, 1},
+		{250, 100, 150},
+	}
+	for _, tt := range tests {
+		if got := overCount(tt.count, tt.limit); got != tt.want {
+			t.Errorf("overCount(%d, %d) = %d, want %d", tt.count, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestOverSize(t *testing.T) {
+	tests := []struct {
+		name               string
+		size, count, limit int64
+		want               int64
+	}{
+		{"empty collection", 0, 0, 100, 0},
+		{"stats without documents", 500, 0, 100, 0},
+		{"under the limit", 900, 9, 1000, 0},
+		{"at the limit", 1000, 10, 1000, 0},
+		{"one average transaction over", 1100, 11, 1000, 1},
+		{"part of a transaction over rounds up", 1050, 10, 1000, 1},
+		{"half over", 2000, 10, 1000, 5},
+		{"smaller than a byte on average", 5, 10, 2, 3},
+		{"everything over", 1000, 10, 0, 10},
+	}
+	for _, tt := range tests {
+		if got := overSize(tt.size, tt.count, tt.limit); got != tt.want {
+			t.Errorf("%s: overSize(%d, %d, %d) = %d, want %d", tt.name, tt.size, tt.count, tt.limit, got, tt.want)
+		}
+	}
+}
+
+type deletion struct {
+	filter bson.D
+	n      int64
+}
+
+// fakeRepo reports fixed sizes and counts and records every delete.
+type fakeRepo struct {
+	count     int64
+	size      int64
+	docs      int64
+	offloaded int64
+
+	counted []bson.D
+	older   []bson.D
+	oldest  []deletion
+}
+
+func (r *fakeRepo) EnsureTTLIndex(ctx context.Context, maxAge time.Duration) error {
+	return nil
+}
+
+func (r *fakeRepo) DeleteTransactions(ctx context.Context, filter bson.D) (int64, error) {
+	r.older = append(r.older, filter)
+	return 2, nil
+}
+
+func (r *fakeRepo) DeleteOldestTransactions(ctx context.Context, filter bson.D, n int64) (int64, error) {
+	r.oldest = append(r.oldest, deletion{filter, n})
+	return n, nil
+}
+
+func (r *fakeRepo) CountTransactions(ctx context.Context, filter bson.D) (int64, error) {
+	r.counted = append(r.counted, filter)
+	return r.count, nil
+}
+
+func (r *fakeRepo) TransactionsSize(ctx context.Context) (int64, int64, error) {
+	return r.size, r.docs, nil
+}
+
+func (r *fakeRepo) OffloadedSize(ctx context.Context) (int64, error) {
+	return r.offloaded, nil
+}
+
+func newTestPruner(r *fakeRepo, spec config.RetentionSpec) *Pruner {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Pruner{
+		repo:    r,
+		metrics: metrics.New(),
+		spec:    spec,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+}
+
+// has reports whether every element of part is in filter.
+func has(filter bson.D, part bson.D) bool {
+	for _, e := range part {
+		if !slices.ContainsFunc(filter, func(f bson.E) bool {
+			return bytes.Equal(mustMarshal(f), mustMarshal(e))
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustMarshal(e bson.E) []byte {
+	raw, err := bson.Marshal(bson.D{e})
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+func TestPrune(t *testing.T) {
+	host := repo.HostFilter("noisy.example.com")
+
+	r := &fakeRepo{count: 150, size: 1000, docs: 100, offloaded: 1000}
+	p := newTestPruner(r, config.RetentionSpec{
+		MaxAge:   24 * time.Hour,
+		MaxCount: 100,
+		MaxBytes: 1500,
+		Hosts: []config.HostRetentionSpec{
+			{Host: "noisy.example.com", MaxAge: time.Hour, MaxCount: 10},
+		},
+	})
+	p.Prune(context.Background())
+
+	// per host age, then the global age
+	if len(r.older) != 2 {
+		t.Fatalf("deleted by age %d times, want 2", len(r.older))
+	}
+	if !has(r.older[0], host) {
+		t.Error("host age rule is not limited to the host")
+	}
+	if has(r.older[1], host) {
+		t.Error("global age limit is limited to the host")
+	}
+
+	// per host count, global count, then size
+	if len(r.oldest) != 3 {
+		t.Fatalf("deleted the oldest %d times, want 3", len(r.oldest))
+	}
+	if d := r.oldest[0]; !has(d.filter, host) || d.n != 140 {
+		t.Errorf("host count rule deleted %d with %v, want 140 of the host", d.n, d.filter)
+	}
+	if d := r.oldest[1]; has(d.filter, host) || d.n != 50 {
+		t.Errorf("count limit deleted %d with %v, want 50 of all hosts", d.n, d.filter)
+	}
+	// 1000 bytes in the collection and 1000 in the blob store over 100
+	// transactions is 20 bytes each, 500 bytes over the limit is 25 of them
+	if d := r.oldest[2]; d.n != 25 {
+		t.Errorf("size limit deleted %d, want 25 counting the offloaded bodies", d.n)
+	}
+
+	// every delete and the counts behind them leave pinned transactions alone
+	filters := append(append([]bson.D{}, r.older...), r.counted...)
+	for _, d := range r.oldest {
+		filters = append(filters, d.filter)
+	}
+	for _, filter := range filters {
+		if !has(filter, repo.NotPinned) {
+			t.Errorf("filter %v does not exclude pinned transactions", filter)
+		}
+	}
+
+	for reason, want := range map[string]float64{"host": 2 + 140, "age": 2, "count": 50, "size": 25} {
+		if got := testutil.ToFloat64(p.metrics.RetentionPruned.WithLabelValues(reason)); got != want {
+			t.Errorf("pruned %v for %s, want %v", got, reason, want)
+		}
+	}
+}
+
+func TestPruneWithinLimits(t *testing.T) {
+	r := &fakeRepo{count: 100, size: 1000, docs: 100, offloaded: 500}
+	p := newTestPruner(r, config.RetentionSpec{MaxCount: 100, MaxBytes: 1500})
+	p.Prune(context.Background())
+
+	if len(r.oldest) != 0 || len(r.older) != 0 {
+		t.Errorf("pruned within the limits: %v, %v", r.oldest, r.older)
+	}
+}
@@ -0,0 +1,12 @@
+package ui
+
+import (
+	"go.uber.org/fx"
+)
+
+func Module() fx.Option {
+	return fx.Module(
+		"api.ui",
+		fx.Invoke(Init),
+	)
+}
@@ -0,0 +1,457 @@
+"use strict";
+
+const maxRows = 5000;
+const hexLimit = 64 * 1024;
+
+const state = {
+  rows: [],
+  selected: null,
+  transaction: null,
+};
+
+const $ = (selector) => document.querySelector(selector);
+
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  for (const [key, value] of Object.entries(attrs || {})) {
+    if (key === "class") {
+      node.className = value;
+    } else if (key.startsWith("on")) {
+      node.addEventListener(key.slice(2), value);
+    } else {
+      node.setAttribute(key, value);
+    }
+  }
+  for (const child of children) {
+    if (child !== null && child !== undefined) {
+      node.append(child instanceof Node ? child : String(child));
+    }
+  }
+  return node;
+}
+
+// the api token is kept in local storage, asked for on the first 401
+
+function token() {
+  return localStorage.getItem("token") || "";
+}
+
+async function request(path, options) {
+  options = options || {};
+  const headers = Object.assign({}, options.headers);
+  if (token()) {
+    headers["Authorization"] = "Bearer " + token();
+  }
+  const resp = await fetch(path, Object.assign({}, options, { headers }));
+  if (resp.status === 401) {
+    const entered = prompt("api token");
+    if (entered) {
+      localStorage.setItem("token", entered.trim());
+      return request(path, options);
+    }
+  }
+  return resp;
+}
+
+async function api(path, options) {
+  const resp = await request(path, options);
+  if (!resp.ok) {
+    let message = resp.statusText;
+    try {
+      message = (await resp.json()).error || message;
+    } catch (e) {}
+    throw new Error(message);
+  }
+  return resp;
+}
+
+function setStatus(text) {
+  $("#status").textContent = text;
+}
+
+// list
+
+function summaryOf(tx) {
+  const length = tx.response && tx.response.headers ? tx.response.headers["Content-Length"] : "";
+  return {
+    id: tx.id,
+    method: tx.request.method,
+    protocol: tx.request.protocol,
+    host: tx.request.host,
+    path: tx.request.path,
+    status: tx.response.status,
+    length: length || "",
+    created_at: tx.created_at,
+  };
+}
+
+function matches(summary) {
+  const query = $("#search").value.trim().toLowerCase();
+  if (!query) {
+    return true;
+  }
+  return query.split(/\s+/).every((term) =>
+    [summary.method, summary.host, summary.path, String(summary.status)]
+      .some((field) => field && field.toLowerCase().includes(term)));
+}
+
+function rowFor(summary) {
+  const time = new Date(summary.created_at).toLocaleTimeString();
+  const row = el("tr", { onclick: () => select(summary.id, row) },
+    el("td", { class: "muted" }, time),
+    el("td", {}, summary.method),
+    el("td", {}, summary.host),
+    el("td", { title: summary.path }, summary.path),
+    el("td", { class: "s" + String(summary.status)[0] }, summary.status),
+    el("td", { class: "muted" }, summary.length),
+  );
+  if (summary.id === state.selected) {
+    row.classList.add("selected");
+  }
+  return row;
+}
+
+function render() {
+  const rows = $("#rows");
+  rows.replaceChildren(...state.rows.filter(matches).map(rowFor));
+}
+
+function prepend(summary) {
+  state.rows.unshift(summary);
+  state.rows.length = Math.min(state.rows.length, maxRows);
+  if (matches(summary)) {
+    $("#rows").prepend(rowFor(summary));
+  }
+}
+
+async function loadList() {
+  try {
+    const resp = await request("/requests");
+    if (resp.status === 404) {
+      state.rows = [];
+    } else if (!resp.ok) {
+      throw new Error(resp.statusText);
+    } else {
+      state.rows = (await resp.json()).slice(0, maxRows).map(summaryOf);
+    }
+    render();
+  } catch (e) {
+    setStatus("failed to load requests: " + e.message);
+  }
+}
+
+let source = null;
+
+function follow(enabled) {
+  if (source) {
+    source.close();
+    source = null;
+  }
+  if (!enabled) {
+    setStatus("paused");
+    return;
+  }
+
+  // EventSource can't send headers, so the token goes into the query
+  source = new EventSource(token() ? "/stream?access_token=" + encodeURIComponent(token()) : "/stream");
+  source.addEventListener("open", () => setStatus("live"));
+  source.addEventListener("error", () => setStatus("reconnecting..."));
+  source.addEventListener("transaction", (event) => prepend(JSON.parse(event.data)));
+  source.addEventListener("dropped", (event) => {
+    setStatus("live, skipped " + JSON.parse(event.data).count + " while busy");
+  });
+}
+
+// detail
+
+function decodeBase64(data) {
+  if (!data) {
+    return new Uint8Array();
+  }
+  const binary = atob(data);
+  const bytes = new Uint8Array(binary.length);
+  for (let i = 0; i < binary.length; i++) {
+    bytes[i] = binary.charCodeAt(i);
+  }
+  return bytes;
+}
+
+function decodeText(bytes) {
+  try {
+    return new TextDecoder("utf-8", { fatal: true }).decode(bytes);
+  } catch (e) {
+    return null;
+  }
+}
+
+function hexdump(bytes) {
+  const lines = [];
+  const limit = Math.min(bytes.length, hexLimit);
+  for (let offset = 0; offset < limit; offset += 16) {
+    const chunk = bytes.slice(offset, Math.min(offset + 16, limit));
+    const hex = Array.from(chunk, (b) => b.toString(16).padStart(2, "0")).join(" ");
+    const ascii = Array.from(chunk, (b) => (b >= 0x20 && b < 0x7f ? String.fromCharCode(b) : ".")).join("");
+    lines.push(offset.toString(16).padStart(8, "0") + "  " + hex.padEnd(48) + "  " + ascii);
+  }
+  if (bytes.length > limit) {
+    lines.push("... " + (bytes.length - limit) + " more bytes");
+  }
+  return lines.join("\n");
+}
+
+function headerList(headers) {
+  const list = el("dl");
+  for (const key of Object.keys(headers || {}).sort()) {
+    list.append(el("dt", {}, key), el("dd", {}, headers[key]));
+  }
+  return list;
+}
+
+function bodyView(bytes, contentType) {
+  const container = el("div");
+  const views = {
+    body: () => {
+      const text = decodeText(bytes);
+      if (text === null) {
+        return el("pre", {}, "binary body, " + bytes.length + " bytes");
+      }
+      if ((contentType || "").includes("json")) {
+        try {
+          return el("pre", {}, JSON.stringify(JSON.parse(text), null, 2));
+        } catch (e) {}
+      }
+      return el("pre", {}, text);
+    },
+    hex: () => el("pre", {}, hexdump(bytes)),
+    rendered: () => {
+      const type = contentType || "";
+      if (type.startsWith("image/")) {
+        const url = URL.createObjectURL(new Blob([bytes], { type }));
+        return el("div", { class: "rendered" }, el("img", { src: url }));
+      }
+      // scripts never run inside the sandboxed frame
+      const frame = el("iframe", { sandbox: "" });
+      frame.srcdoc = decodeText(bytes) || "";
+      return frame;
+    },
+  };
+
+  const content = el("div");
+  const show = (name) => {
+    for (const button of container.querySelectorAll("button")) {
+      button.classList.toggle("active", button.dataset.view === name);
+    }
+    content.replaceChildren(views[name]());
+  };
+
+  const switcher = el("nav", { class: "tabs" });
+  for (const name of Object.keys(views)) {
+    const button = el("button", { onclick: () => show(name) }, name);
+    button.dataset.view = name;
+    switcher.append(button);
+  }
+
+  container.append(switcher, content);
+  show("body");
+  return container;
+}
+
+function buildURL(req) {
+  const query = Object.entries(req.query_params || {})
+    .sort(([a], [b]) => a.localeCompare(b))
+    .flatMap(([k, values]) => [].concat(values).map((v) => k + "=" + v))
+    .join("&");
+  return (req.protocol || "http") + "://" + req.host + req.path + (query ? "?" + query : "");
+}
+
+async function select(id, row) {
+  state.selected = id;
+  for (const selected of document.querySelectorAll("#rows tr.selected")) {
+    selected.classList.remove("selected");
+  }
+  if (row) {
+    row.classList.add("selected");
+  }
+
+  try {
+    const tx = await (await api("/request/" + id)).json();
+    const responseBody = new Uint8Array(await (await api("/request/" + id + "/body?part=response")).arrayBuffer());
+    // large request bodies live in the blob store and are fetched separately
+    const requestBody = tx.request.body_ref
+      ? new Uint8Array(await (await api("/request/" + id + "/body?part=request")).arrayBuffer())
+      : decodeBase64(tx.request.body);
+    state.transaction = tx;
+    showDetail(tx, requestBody, responseBody);
+  } catch (e) {
+    setStatus("failed to load request: " + e.message);
+  }
+}
+
+function showDetail(tx, requestBody, responseBody) {
+  const req = tx.request;
+  const resp = tx.response;
+
+  $("#detail").hidden = false;
+  $("#export-result").hidden = true;
+  $("#export").value = "";
+
+  $("#tab-request").replaceChildren(
+    el("pre", {}, req.method + " " + buildURL(req) + " " + (req.version || "")),
+    tx.parent_id ? el("p", { class: "muted" }, "repeat of " + tx.parent_id) : null,
+    headerList(req.headers),
+    bodyView(requestBody, (req.headers || {})["Content-Type"]),
+  );
+
+  $("#tab-response").replaceChildren(
+    el("pre", {}, "HTTP " + resp.status),
+    headerList(resp.headers),
+    bodyView(responseBody, (resp.headers || {})["Content-Type"]),
+  );
+
+  fillRepeater(req, requestBody);
+  $("#scan-result").replaceChildren();
+  $("#repeater-result").replaceChildren();
+}
+
+// repeater
+
+const skippedHeaders = ["host", "cookie", "content-length"];
+
+function fillRepeater(req, body) {
+  const form = $("#repeater");
+  form.method.value = req.method;
+  form.url.value = buildURL(req);
+  form.headers.value = Object.keys(req.headers || {})
+    .filter((k) => !skippedHeaders.includes(k.toLowerCase()))
+    .sort()
+    .map((k) => k + ": " + req.headers[k])
+    .join("\n");
+  form.cookies.value = Object.keys(req.cookies || {})
+    .sort()
+    .map((k) => k + "=" + req.cookies[k])
+    .join("\n");
+  form.body.value = decodeText(body) || "";
+}
+
+function parseLines(text, separator) {
+  const result = {};
+  for (const line of text.split("\n")) {
+    const index = line.indexOf(separator);
+    if (index > 0) {
+      result[line.slice(0, index).trim()] = line.slice(index + separator.length).trim();
+    }
+  }
+  return result;
+}
+
+// patch lists every edited value and nulls out removed ones
+function patchOf(original, edited) {
+  const patch = { ...edited };
+  for (const key of Object.keys(original || {})) {
+    if (!(key in edited) && !skippedHeaders.includes(key.toLowerCase())) {
+      patch[key] = null;
+    }
+  }
+  return patch;
+}
+
+async function repeat(event) {
+  event.preventDefault();
+
+  const req = state.transaction.request;
+  const form = $("#repeater");
+  const patch = {
+    method: form.method.value,
+    url: form.url.value,
+    headers: patchOf(req.headers, parseLines(form.headers.value, ":")),
+    cookies: patchOf(req.cookies, parseLines(form.cookies.value, "=")),
+    body: form.body.value,
+  };
+
+  const result = $("#repeater-result");
+  result.replaceChildren(el("p", { class: "muted" }, "sending..."));
+
+  try {
+    // upstream error statuses are results too, so the raw request is used
+    const resp = await request("/repeat/" + state.transaction.id, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify(patch),
+    });
+
+    const headers = {};
+    resp.headers.forEach((value, key) => (headers[key] = value));
+    const repeatID = resp.headers.get("X-Transaction-Id");
+
+    result.replaceChildren(
+      el("pre", {}, "HTTP " + resp.status),
+      repeatID ? el("p", {}, el("a", { href: "#", onclick: (e) => { e.preventDefault(); select(repeatID); } }, "open stored repeat")) : null,
+      headerList(headers),
+      bodyView(new Uint8Array(await resp.arrayBuffer()), resp.headers.get("Content-Type")),
+    );
+  } catch (e) {
+    result.replaceChildren(el("p", { class: "s5" }, "repeat failed: " + e.message));
+  }
+}
+
+// scan
+
+async function scan() {
+  const result = $("#scan-result");
+  result.replaceChildren(el("p", { class: "muted" }, "scanning, this may take a while..."));
+
+  try {
+    const report = await (await api("/scan/" + state.transaction.id, { method: "POST" })).json();
+    const findings = Object.entries(report.vulnerabilities || {});
+    if (findings.length === 0) {
+      result.replaceChildren(el("p", {}, report.result));
+      return;
+    }
+    result.replaceChildren(
+      el("p", {}, report.result),
+      ...findings.map(([name, points]) =>
+        el("div", { class: "finding" }, el("strong", {}, name), el("ul", {}, ...points.map((p) => el("li", {}, p))))),
+    );
+  } catch (e) {
+    result.replaceChildren(el("p", { class: "s5" }, "scan failed: " + e.message));
+  }
+}
+
+// export
+
+async function exportAs(format) {
+  const output = $("#export-result");
+  if (!format) {
+    output.hidden = true;
+    return;
+  }
+  try {
+    const resp = await api("/request/" + state.transaction.id + "/export?format=" + encodeURIComponent(format));
+    output.textContent = await resp.text();
+    output.hidden = false;
+  } catch (e) {
+    output.textContent = "export failed: " + e.message;
+    output.hidden = false;
+  }
+}
+
+// wiring
+
+for (const button of document.querySelectorAll("#tabs button")) {
+  button.addEventListener("click", () => {
+    for (const other of document.querySelectorAll("#tabs button")) {
+      other.classList.toggle("active", other === button);
+    }
+    for (const tab of document.querySelectorAll(".tab")) {
+      tab.hidden = tab.id !== "tab-" + button.dataset.tab;
+    }
+  });
+}
+
+$("#search").addEventListener("input", render);
+$("#follow").addEventListener("change", (event) => follow(event.target.checked));
+$("#repeater").addEventListener("submit", repeat);
+$("#scan").addEventListener("click", scan);
+$("#export").addEventListener("change", (event) => exportAs(event.target.value));
+
+loadList().then(() => follow(true));
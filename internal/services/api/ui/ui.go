@@ -0,0 +1,22 @@
+package ui
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/daronenko/https-proxy/internal/httpserver"
+)
+
+//go:embed static
+var static embed.FS
+
+func Init(api *httpserver.ApiRouter) {
+	assets, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+
+	api.Handle("/", http.RedirectHandler("/ui/", http.StatusFound)).Methods("GET")
+	api.PathPrefix("/ui/").Handler(http.StripPrefix("/ui/", http.FileServerFS(assets))).Methods("GET")
+}
@@ -0,0 +1,89 @@
+package httpdelivery
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// errDraining ends kept alive connections once the proxy shuts down.
+var errDraining = errors.New("proxy is shutting down")
+
+// Drain makes kept alive connections close instead of waiting for another
+// request, requests that have started are finished.
+func (d *Proxy) Drain() {
+	d.drain()
+}
+
+// nextRequest reads the next request of a kept alive connection. The client
+// has wait to start it and the header timeout to finish the headers.
+func (d *Proxy) nextRequest(conn net.Conn, reader *bufio.Reader, wait time.Duration) (*http.Request, error) {
+	conn.SetReadDeadline(time.Now().Add(wait))
+
+	// a shutdown ends the wait, not a request that has started
+	stop := context.AfterFunc(d.draining, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	_, err := reader.Peek(1)
+	if !stop() {
+		return nil, errDraining
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(d.limits.HeaderTimeout))
+	return http.ReadRequest(reader)
+}
+
+// closed reports whether err only means the client is gone or done, the
+// timeouts are counted by stage.
+func (d *Proxy) closed(err error, stage string) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, errDraining) || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	if isTimeout(err) {
+		d.metrics.Timeouts.WithLabelValues(stage).Inc()
+		return true
+	}
+	return false
+}
+
+// pipe copies src to dst until either side is closed. The tunnel is idle
+// only when neither direction moved anything for the idle timeout, a long
+// download with nothing to send back keeps it open.
+func (d *Proxy) pipe(dst, src net.Conn, active *atomic.Int64) {
+	buf := make([]byte, 32*1024)
+	for {
+		src.SetReadDeadline(time.Now().Add(d.limits.IdleTimeout))
+		n, err := src.Read(buf)
+		if n > 0 {
+			active.Store(time.Now().UnixNano())
+			dst.SetWriteDeadline(time.Now().Add(d.limits.IdleTimeout))
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err == nil {
+			continue
+		}
+
+		if isTimeout(err) {
+			if time.Since(time.Unix(0, active.Load())) < d.limits.IdleTimeout {
+				continue
+			}
+			d.metrics.Timeouts.WithLabelValues("tunnel_idle").Inc()
+		}
+		return
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,229 @@
+package httpdelivery
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func newTestProxy(limits config.LimitsSpec) *Proxy {
+	draining, drain := context.WithCancel(context.Background())
+	return &Proxy{
+		metrics:  metrics.New(),
+		tracer:   noop.NewTracerProvider().Tracer(""),
+		limits:   limits.OrDefaults(),
+		draining: draining,
+		drain:    drain,
+	}
+}
+
+// tcpPair connects two ends over a real listener.
+func tcpPair(t *testing.T) (client, server net.Conn) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+
+	client, err = net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server = <-accepted
+	if server == nil {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestNextRequestDrain(t *testing.T) {
+	d := newTestProxy(config.LimitsSpec{})
+	_, server := tcpPair(t)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := d.nextRequest(server, bufio.NewReader(server), time.Minute)
+		result <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	d.Drain()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, errDraining) {
+			t.Fatalf("nextRequest() = %v, want errDraining", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle connection kept waiting after the drain")
+	}
+	if !d.closed(errDraining, "client_idle") {
+		t.Error("a drained connection is reported as failed")
+	}
+}
+
+func TestNextRequestFinishesStartedRequest(t *testing.T) {
+	d := newTestProxy(config.LimitsSpec{HeaderTimeout: 5 * time.Second})
+	client, server := tcpPair(t)
+
+	// the request has started when the drain comes
+	if _, err := io.WriteString(client, "GET /kept HTTP/1.1\r\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(chan *http.Request, 1)
+	go func() {
+		req, err := d.nextRequest(server, bufio.NewReader(server), time.Minute)
+		if err != nil {
+			t.Error(err)
+		}
+		result <- req
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	d.Drain()
+	if _, err := io.WriteString(client, "Host: example.com\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case req := <-result:
+		if req == nil || req.URL.Path != "/kept" {
+			t.Fatalf("nextRequest() = %v, want the started request", req)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("started request was not read")
+	}
+}
+
+func TestNextRequestIdleTimeout(t *testing.T) {
+	d := newTestProxy(config.LimitsSpec{})
+	_, server := tcpPair(t)
+
+	_, err := d.nextRequest(server, bufio.NewReader(server), 50*time.Millisecond)
+	if !d.closed(err, "client_idle") {
+		t.Fatalf("nextRequest() = %v, want a timeout", err)
+	}
+	if timeouts := testutil.ToFloat64(d.metrics.Timeouts.WithLabelValues("client_idle")); timeouts != 1 {
+		t.Errorf("counted %v idle timeouts, want 1", timeouts)
+	}
+}
+
+// openTunnel connects a client through d.tunnel to target.
+func openTunnel(t *testing.T, d *Proxy, target string) (net.Conn, chan struct{}) {
+	t.Helper()
+
+	client, proxySide := tcpPair(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer proxySide.Close()
+		d.tunnel(context.Background(), proxySide, target)
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT answered %d", resp.StatusCode)
+	}
+	return client, done
+}
+
+func TestTunnelIdleTimeout(t *testing.T) {
+	d := newTestProxy(config.LimitsSpec{IdleTimeout: 100 * time.Millisecond})
+
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	client, done := openTunnel(t, d, target.Addr().String())
+	if _, err := io.WriteString(client, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	echo := make([]byte, 4)
+	if _, err := io.ReadFull(client, echo); err != nil || string(echo) != "ping" {
+		t.Fatalf("tunnel echoed %q, %v", echo, err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle tunnel was not closed")
+	}
+	if timeouts := testutil.ToFloat64(d.metrics.Timeouts.WithLabelValues("tunnel_idle")); timeouts < 1 {
+		t.Error("idle tunnel timeout was not counted")
+	}
+}
+
+func TestTunnelOneWayTraffic(t *testing.T) {
+	d := newTestProxy(config.LimitsSpec{IdleTimeout: 100 * time.Millisecond})
+
+	// a download: the target keeps sending, the client sends nothing
+	const chunks = 10
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for range chunks {
+			time.Sleep(40 * time.Millisecond)
+			if _, err := conn.Write([]byte("x")); err != nil {
+				return
+			}
+		}
+	}()
+
+	client, _ := openTunnel(t, d, target.Addr().String())
+	got, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != chunks {
+		t.Errorf("downloaded %d of %d chunks, the tunnel closed while one direction was busy", len(got), chunks)
+	}
+}
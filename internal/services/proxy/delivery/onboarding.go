@@ -0,0 +1,118 @@
+package httpdelivery
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/daronenko/https-proxy/pkg/onboarding"
+	"github.com/rs/zerolog/log"
+)
+
+// isPortal reports whether req is for the onboarding portal: it names the
+// portal host or was sent to the proxy directly instead of through it.
+func (d *Proxy) isPortal(req *http.Request) bool {
+	if req.Method == http.MethodConnect {
+		return d.portal.Matches(req.URL.Host)
+	}
+	return req.URL.Host == "" || d.portal.Matches(req.URL.Host)
+}
+
+// portalStrategy serves the portal over plain http or, for a CONNECT to the
+// portal host, over tls with a certificate of the proxy CA. Nothing is
+// forwarded, requests for other hosts on that connection get the portal too.
+func (d *Proxy) portalStrategy(clientConn net.Conn, req *http.Request) {
+	if req.Method != http.MethodConnect {
+		clientConn.SetDeadline(time.Now().Add(d.limits.RequestTimeout))
+		if err := d.servePortal(clientConn, req); err != nil {
+			log.Err(err).Msg("failed to write onboarding portal response")
+		}
+		return
+	}
+
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+
+	tlsClientConn := tls.Server(clientConn, d.getTLSConfig(d.portal.Host, "443"))
+	defer tlsClientConn.Close()
+
+	reader := bufio.NewReader(tlsClientConn)
+	for wait := d.limits.HeaderTimeout; ; wait = d.limits.IdleTimeout {
+		req, err := d.nextRequest(tlsClientConn, reader, wait)
+		if err != nil {
+			return
+		}
+		tlsClientConn.SetDeadline(time.Now().Add(d.limits.RequestTimeout))
+		if err := d.servePortal(tlsClientConn, req); err != nil {
+			log.Err(err).Msg("failed to write onboarding portal response")
+			return
+		}
+	}
+}
+
+// servePortal answers req itself. The pac file points at the address the
+// client reached the proxy on, unless one is configured.
+func (d *Proxy) servePortal(clientConn net.Conn, req *http.Request) error {
+	proxyAddress := clientConn.LocalAddr().String()
+	if req.URL.Host == "" && req.Host != "" && !d.portal.Matches(req.Host) {
+		proxyAddress = req.Host
+	}
+
+	rec := &portalResponse{header: http.Header{}, status: http.StatusOK}
+	name := strings.TrimPrefix(req.URL.Path, "/")
+
+	switch err := d.portal.Serve(rec, name, proxyAddress); {
+	case errors.Is(err, onboarding.ErrNotFound):
+		rec.status = http.StatusNotFound
+		rec.body.WriteString("not found\n")
+	case errors.Is(err, onboarding.ErrInvalidProxyAddress):
+		rec.status = http.StatusBadRequest
+		rec.body.WriteString("invalid host\n")
+	case err != nil:
+		log.Err(err).Msg("failed to serve onboarding portal")
+		rec.status = http.StatusInternalServerError
+		rec.body.WriteString("failed to read ca certificate\n")
+	}
+
+	// plain connections carry a single request, decrypted ones are kept
+	_, keepAlive := clientConn.(*tls.Conn)
+
+	resp := &http.Response{
+		StatusCode:    rec.status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.header,
+		ContentLength: int64(rec.body.Len()),
+		Body:          io.NopCloser(&rec.body),
+		Close:         !keepAlive,
+		Request:       req,
+	}
+	return resp.Write(clientConn)
+}
+
+// portalResponse buffers the portal answer so it can be written as a raw
+// http response to the client connection.
+type portalResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *portalResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *portalResponse) WriteHeader(status int) {
+	r.status = status
+}
+
+func (r *portalResponse) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
@@ -5,106 +5,252 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
-	"math/big"
 	"net"
 	"net/http"
-	"os"
-	"sync"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/daronenko/https-proxy/internal/app/config"
+	"github.com/daronenko/https-proxy/internal/metrics"
 	"github.com/daronenko/https-proxy/internal/model"
-	"github.com/daronenko/https-proxy/internal/services/api/repo"
+	"github.com/daronenko/https-proxy/internal/services/api/project"
+	"github.com/daronenko/https-proxy/internal/services/api/queue"
+	"github.com/daronenko/https-proxy/pkg/certstore"
+	"github.com/daronenko/https-proxy/pkg/onboarding"
+	"github.com/daronenko/https-proxy/pkg/proxyauth"
+	"github.com/daronenko/https-proxy/pkg/scope"
 	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Proxy struct {
-	repo      *repo.Request
-	conf      *config.Config
-	key       []byte
-	certCache sync.Map
+	queue    *queue.Queue
+	projects *project.Active
+	scope    *scope.Scope
+	auth     *proxyauth.Authenticator
+	portal   *onboarding.Portal
+	metrics  *metrics.Metrics
+	tracer   trace.Tracer
+	conf     *config.Config
+	certs    *certstore.Store
+	limits   config.LimitsSpec
+
+	// draining is cancelled on shutdown
+	draining context.Context
+	drain    context.CancelFunc
 }
 
-func New(repo *repo.Request, conf *config.Config) (*Proxy, error) {
-	key, err := os.ReadFile(conf.App.ProxyServer.TLS.KeyPath)
-	if err != nil {
-		log.Err(err).Msg("failed to read tls key")
-		return nil, err
+func New(queue *queue.Queue, projects *project.Active, scope *scope.Scope, auth *proxyauth.Authenticator, portal *onboarding.Portal, certs *certstore.Store, metrics *metrics.Metrics, tracerProvider trace.TracerProvider, conf *config.Config) (*Proxy, error) {
+	draining, drain := context.WithCancel(context.Background())
+
+	d := &Proxy{
+		queue:    queue,
+		projects: projects,
+		scope:    scope,
+		auth:     auth,
+		portal:   portal,
+		metrics:  metrics,
+		tracer:   tracerProvider.Tracer("github.com/daronenko/https-proxy/internal/services/proxy"),
+		conf:     conf,
+		certs:    certs,
+		limits:   conf.App.ProxyServer.Limits.OrDefaults(),
+		draining: draining,
+		drain:    drain,
 	}
 
-	return &Proxy{
-		repo: repo,
-		conf: conf,
-		key:  key,
-	}, nil
+	return d, nil
 }
 
-func (d *Proxy) Proxy(clientConn net.Conn, req *http.Request) {
+// client is who sent the traffic, project is zero unless the user is bound
+// to one.
+type client struct {
+	user    string
+	project bson.ObjectID
+}
+
+func (d *Proxy) Proxy(ctx context.Context, clientConn net.Conn, req *http.Request) {
+	// the portal is how new devices get set up, so it needs no credentials
+	if d.isPortal(req) {
+		if !d.auth.Allowed(clientConn.RemoteAddr()) {
+			d.reject(clientConn, proxyauth.ErrForbidden)
+			return
+		}
+		d.portalStrategy(clientConn, req)
+		return
+	}
+
+	user, err := d.auth.Authenticate(clientConn.RemoteAddr(), req.Header.Get("Proxy-Authorization"))
+	if err != nil {
+		d.reject(clientConn, err)
+		return
+	}
+	// the binding was checked when the config was built
+	c := client{user: user}
+	if project := d.auth.Project(user); project != "" {
+		c.project, _ = bson.ObjectIDFromHex(project)
+	}
+
 	if req.Method == http.MethodConnect {
-		d.httpsStrategy(clientConn, req)
+		d.httpsStrategy(ctx, c, clientConn, req)
 	} else {
-		d.httpStrategy(clientConn, req)
+		d.httpStrategy(ctx, c, clientConn, req)
 	}
 }
 
-func (d *Proxy) httpsStrategy(clientConn net.Conn, req *http.Request) {
-	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+func (d *Proxy) reject(clientConn net.Conn, err error) {
+	if errors.Is(err, proxyauth.ErrForbidden) {
+		d.metrics.AuthFailures.WithLabelValues("forbidden").Inc()
+		log.Warn().Str("client", clientConn.RemoteAddr().String()).Msg("proxy client address is not allowed")
+		clientConn.Write([]byte("HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
 		return
 	}
 
-	tlsConfig, err := d.getTLSConfig(req.URL.Hostname())
-	if err != nil {
-		log.Err(err).Msg("failed to get tls config")
+	d.metrics.AuthFailures.WithLabelValues("unauthorized").Inc()
+	fmt.Fprintf(clientConn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic realm=%q\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", d.auth.Realm())
+}
+
+func (d *Proxy) httpsStrategy(ctx context.Context, c client, clientConn net.Conn, req *http.Request) {
+	connectPort, _ := strconv.Atoi(getPort(req.URL))
+	if d.scope.DecideConnect(req.URL.Hostname(), connectPort) == scope.Tunnel {
+		d.metrics.ScopeDecisions.WithLabelValues(string(scope.Tunnel)).Inc()
+		d.tunnel(ctx, clientConn, net.JoinHostPort(req.URL.Hostname(), strconv.Itoa(connectPort)))
+		return
+	}
+
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
 		return
 	}
 
+	tlsConfig := d.getTLSConfig(req.URL.Hostname(), strconv.Itoa(connectPort))
 	tlsClientConn := tls.Server(clientConn, tlsConfig)
 	defer tlsClientConn.Close()
 
+	// the handshake and the first request are bounded by the header timeout,
+	// the client may wait for the idle timeout between the later ones
+	reader := bufio.NewReader(tlsClientConn)
+	wait, stage := d.limits.HeaderTimeout, "client_header"
 	for {
-		req, err := http.ReadRequest(bufio.NewReader(tlsClientConn))
-		if err == io.EOF {
-			break
+		req, err := d.nextRequest(tlsClientConn, reader, wait)
+		if d.closed(err, stage) {
+			return
 		} else if err != nil {
 			log.Err(err).Msg("failed to read request")
 			return
 		}
+		wait, stage = d.limits.IdleTimeout, "client_idle"
 
-		targetConn, err := d.secureConn(net.JoinHostPort(req.Host, "443"), tlsConfig)
+		targetConn, err := d.secureConn(ctx, net.JoinHostPort(req.Host, "443"), tlsConfig)
 		if err != nil {
 			log.Err(err).Msg("failed to establish tls connection")
 			return
 		}
 
-		if err := d.forwardRequest(tlsClientConn, targetConn, req); err != nil {
+		record := d.decide(scope.Target{
+			Scheme: "https",
+			Host:   req.Host,
+			Port:   connectPort,
+			Path:   req.URL.EscapedPath(),
+		})
+
+		err = d.forwardRequest(ctx, c, tlsClientConn, targetConn, req, record)
+		targetConn.Close()
+		if err != nil {
+			d.metrics.ProxyErrors.WithLabelValues("forward").Inc()
 			log.Err(err).Msg("failed to forward request from client to target connection over tls")
 			return
 		}
-
-		targetConn.Close()
 	}
 }
 
-func (d *Proxy) httpStrategy(clientConn net.Conn, req *http.Request) {
-	targetConn, err := d.tcpConn(net.JoinHostPort(req.Host, getPort(req.URL)))
+func (d *Proxy) httpStrategy(ctx context.Context, c client, clientConn net.Conn, req *http.Request) {
+	targetConn, err := d.tcpConn(ctx, net.JoinHostPort(req.Host, getPort(req.URL)))
 	if err != nil {
 		log.Err(err).Msg("failed to establish tcp connection")
 		return
 	}
 	defer targetConn.Close()
 
-	if err := d.forwardRequest(clientConn, targetConn, req); err != nil {
+	record := d.decide(scope.TargetFromURL(req.URL))
+
+	if err := d.forwardRequest(ctx, c, clientConn, targetConn, req, record); err != nil {
+		d.metrics.ProxyErrors.WithLabelValues("forward").Inc()
 		log.Err(err).Msg("failed to forward request from client to target connection")
 		return
 	}
 }
 
-func (d *Proxy) forwardRequest(clientConn, targetConn net.Conn, req *http.Request) error {
+// decide reports whether a request to target is stored. Requests that are
+// already decrypted can't be tunneled anymore, so they are only forwarded.
+func (d *Proxy) decide(target scope.Target) bool {
+	if host, _, err := net.SplitHostPort(target.Host); err == nil {
+		target.Host = host
+	}
+
+	action := d.scope.Decide(target)
+	d.metrics.ScopeDecisions.WithLabelValues(string(action)).Inc()
+
+	return action == scope.Record
+}
+
+// tunnel passes the connection through to address without decrypting it.
+func (d *Proxy) tunnel(ctx context.Context, clientConn net.Conn, address string) {
+	targetConn, err := d.tcpConn(ctx, address)
+	if err != nil {
+		clientConn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+		return
+	}
+	defer targetConn.Close()
+
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+
+	var active atomic.Int64
+	active.Store(time.Now().UnixNano())
+
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		d.pipe(dst, src, &active)
+		done <- struct{}{}
+	}
+	go pipe(targetConn, clientConn)
+	go pipe(clientConn, targetConn)
+
+	// the other direction is unblocked when both connections are closed
+	<-done
+}
+
+func (d *Proxy) forwardRequest(ctx context.Context, c client, clientConn, targetConn net.Conn, req *http.Request, record bool) (err error) {
+	ctx, span := d.tracer.Start(ctx, "proxy.forward", trace.WithAttributes(
+		attribute.String("http.request.method", req.Method),
+		attribute.String("server.address", req.Host),
+		attribute.String("url.path", req.URL.Path),
+		attribute.String("enduser.id", c.user),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	hideProxy(req)
 
-	resp, err := d.sendRequest(targetConn, req)
+	start := time.Now()
+	deadline := start.Add(d.limits.RequestTimeout)
+	clientConn.SetDeadline(deadline)
+	targetConn.SetDeadline(deadline)
+
+	resp, err := d.sendRequest(targetConn, req, deadline)
 	if err != nil {
 		return fmt.Errorf("send request: %w", err)
 	}
@@ -112,28 +258,39 @@ func (d *Proxy) forwardRequest(clientConn, targetConn net.Conn, req *http.Reques
 
 	bodyBytes, err := io.ReadAll(originalBody)
 	if err != nil {
+		d.closed(err, "request")
 		originalBody.Close()
 		return fmt.Errorf("read response body: %w", err)
 	}
 	originalBody.Close()
 
+	d.observeUpstream(clientConn, req, resp.StatusCode, time.Since(start))
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
 	resp.Body = io.NopCloser(bytes.NewReader(bodyBytes))
 
 	respCopy := *resp // shallow copy
 	respCopy.Body = io.NopCloser(bytes.NewReader(bodyBytes))
 
-	go func(respCopy *http.Response) {
+	if record {
 		transaction := &model.Transaction{
+			User:      c.user,
 			Request:   model.NewRequest(req),
-			Response:  model.NewResponse(respCopy),
+			Response:  model.NewResponse(&respCopy),
 			CreatedAt: time.Now(),
 		}
-		if _, err := d.repo.CreateTransaction(context.Background(), transaction); err != nil {
-			log.Err(err).Msg("failed to store transaction")
+		projectID := c.project
+		if projectID.IsZero() {
+			projectID = d.projects.ID()
 		}
-	}(&respCopy)
+		if !projectID.IsZero() {
+			transaction.ProjectID = projectID
+		}
+		d.queue.Enqueue(ctx, transaction)
+	}
 
 	if err := resp.Write(clientConn); err != nil {
+		d.closed(err, "request")
 		log.Err(err).Msg("failed to write response from target to client connection")
 		return fmt.Errorf("write response: %w", err)
 	}
@@ -141,24 +298,58 @@ func (d *Proxy) forwardRequest(clientConn, targetConn net.Conn, req *http.Reques
 	return nil
 }
 
-func (d *Proxy) sendRequest(targetConn net.Conn, req *http.Request) (*http.Response, error) {
+func (d *Proxy) observeUpstream(clientConn net.Conn, req *http.Request, status int, elapsed time.Duration) {
+	scheme := "http"
+	if _, ok := clientConn.(*tls.Conn); ok {
+		scheme = "https"
+	}
+
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	d.metrics.UpstreamLatency.WithLabelValues(scheme).Observe(elapsed.Seconds())
+	d.metrics.ProxyRequests.WithLabelValues(req.Method, strconv.Itoa(status), host).Inc()
+}
+
+// sendRequest gives the upstream the header timeout to answer, the body has
+// until deadline.
+func (d *Proxy) sendRequest(targetConn net.Conn, req *http.Request, deadline time.Time) (*http.Response, error) {
 	if err := req.Write(targetConn); err != nil {
+		d.closed(err, "request")
 		log.Err(err).Msg("failed to write request from client to target connection")
 		return nil, fmt.Errorf("write request: %w", err)
 	}
 
+	stage := "request"
+	if headerDeadline := time.Now().Add(d.limits.HeaderTimeout); headerDeadline.Before(deadline) {
+		targetConn.SetReadDeadline(headerDeadline)
+		stage = "upstream_header"
+	}
+
 	resp, err := http.ReadResponse(bufio.NewReader(targetConn), req)
 	if err != nil {
+		d.closed(err, stage)
 		log.Err(err).Msg("failed to read response from target connection")
 		return nil, fmt.Errorf("read response: %w", err)
 	}
+	targetConn.SetReadDeadline(deadline)
 
 	return resp, nil
 }
 
-func (d *Proxy) tcpConn(address string) (net.Conn, error) {
+func (d *Proxy) tcpConn(ctx context.Context, address string) (net.Conn, error) {
+	_, span := d.tracer.Start(ctx, "proxy.dial", trace.WithAttributes(
+		attribute.String("network.peer.address", address),
+	))
+	defer span.End()
+
 	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		d.metrics.ProxyErrors.WithLabelValues("dial").Inc()
 		log.Err(err).Msg("failed to dial tcp connection")
 		return nil, fmt.Errorf("tcp dial: %w", err)
 	}
@@ -166,10 +357,19 @@ func (d *Proxy) tcpConn(address string) (net.Conn, error) {
 	return conn, nil
 }
 
-func (d *Proxy) secureConn(address string, tlsConfig *tls.Config) (net.Conn, error) {
+func (d *Proxy) secureConn(ctx context.Context, address string, tlsConfig *tls.Config) (net.Conn, error) {
+	_, span := d.tracer.Start(ctx, "proxy.dial", trace.WithAttributes(
+		attribute.String("network.peer.address", address),
+		attribute.Bool("tls", true),
+	))
+	defer span.End()
+
 	dialer := &net.Dialer{Timeout: 5 * time.Second}
 	conn, err := tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		d.metrics.ProxyErrors.WithLabelValues("dial").Inc()
 		log.Err(err).Msg("failed to dial tls connection")
 		return nil, fmt.Errorf("tls dial: %w", err)
 	}
@@ -177,41 +377,74 @@ func (d *Proxy) secureConn(address string, tlsConfig *tls.Config) (net.Conn, err
 	return conn, nil
 }
 
-func (d *Proxy) getTLSConfig(host string) (*tls.Config, error) {
-	if certData, exists := d.certCache.Load(host); exists {
-		certBytes := certData.([]byte)
-		return d.createTLSConfig(certBytes)
-	}
-
-	certPath := fmt.Sprintf("%s/%s.crt", d.conf.App.ProxyServer.TLS.CertPath, host)
-	certBytes, err := os.ReadFile(certPath)
-	if err != nil {
-		log.Warn().Err(err).Msgf("certificate not found for host '%s', generating...", host)
+// getTLSConfig picks the certificate during the handshake. The SNI is
+// preferred over the CONNECT host, which may be an ip address or differ from
+// the name the client verifies. Clients send no SNI for ip addresses, those
+// get a certificate with the ip in its SANs. With certs.mimic the
+// certificate copies the one of the upstream at connectPort.
+func (d *Proxy) getTLSConfig(connectHost, connectPort string) *tls.Config {
+	mimic := d.conf.App.Certs.Mimic && !d.portal.Matches(connectHost)
 
-		certBytes, err = genCert(host, big.NewInt(time.Now().UnixNano()))
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate certificate: %w", err)
-		}
-
-		if err := os.WriteFile(certPath, certBytes, 0644); err != nil {
-			log.Err(err).Msg("failed to write generated certificate to file")
-			return nil, fmt.Errorf("failed to save certificate: %w", err)
-		}
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			host := hello.ServerName
+			if host == "" {
+				host = connectHost
+			}
+
+			var (
+				cert   *tls.Certificate
+				issued bool
+				err    error
+			)
+			if mimic {
+				cert, issued, err = d.certs.Mimic(host, func() (*x509.Certificate, error) {
+					return d.upstreamCert(net.JoinHostPort(connectHost, connectPort), hello.ServerName)
+				})
+				if errors.Is(err, certstore.ErrMimicBackoff) {
+					log.Debug().Err(err).Str("host", host).Msg("upstream certificate failed recently, issuing a plain one")
+				} else if err != nil {
+					log.Warn().Err(err).Str("host", host).Msg("failed to mimic upstream certificate, issuing a plain one")
+				}
+			}
+			if cert == nil {
+				cert, issued, err = d.certs.Certificate(host)
+			}
+			if err != nil {
+				d.metrics.CertFailures.Inc()
+				d.metrics.ProxyErrors.WithLabelValues("tls").Inc()
+				log.Err(err).Str("host", host).Msg("failed to get certificate")
+				return nil, err
+			}
+
+			if issued {
+				d.metrics.CertCache.WithLabelValues("miss").Inc()
+				d.metrics.CertGenerations.Inc()
+			} else {
+				d.metrics.CertCache.WithLabelValues("hit").Inc()
+			}
+			return cert, nil
+		},
 	}
-
-	d.certCache.Store(host, certBytes)
-
-	return d.createTLSConfig(certBytes)
 }
 
-func (d *Proxy) createTLSConfig(certBytes []byte) (*tls.Config, error) {
-	cert, err := tls.X509KeyPair(certBytes, d.key)
+// upstreamCert fetches the leaf certificate the upstream presents for
+// serverName, without verifying it: it is only copied.
+func (d *Proxy) upstreamCert(address, serverName string) (*x509.Certificate, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
 	if err != nil {
-		log.Err(err).Msg("failed to parse key pair from pem encoded data")
-		return nil, fmt.Errorf("tls x509 key pair: %w", err)
+		d.metrics.ProxyErrors.WithLabelValues("dial").Inc()
+		return nil, err
 	}
+	defer conn.Close()
 
-	return &tls.Config{
-		Certificates: []tls.Certificate{cert},
-	}, nil
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errors.New("upstream sent no certificate")
+	}
+	return certs[0], nil
 }
@@ -0,0 +1,12 @@
+package tracing
+
+import (
+	"go.uber.org/fx"
+)
+
+func Module() fx.Option {
+	return fx.Module(
+		"tracing",
+		fx.Provide(New),
+	)
+}
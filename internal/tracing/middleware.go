@@ -0,0 +1,47 @@
+package tracing
+
+import (
+	"net/http"
+
+	"github.com/daronenko/https-proxy/pkg/httpctl"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware starts a server span per api request named after its route
+// template and continues traces passed in the traceparent header.
+func Middleware(provider trace.TracerProvider) mux.MiddlewareFunc {
+	tracer := provider.Tracer("github.com/daronenko/https-proxy/internal/services/api")
+	propagator := propagation.TraceContext{}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := httpctl.Route(r)
+
+			ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracer.Start(ctx, r.Method+" "+route,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.request.method", r.Method),
+					attribute.String("http.route", route),
+				),
+			)
+			defer span.End()
+
+			if id, ok := mux.Vars(r)["request_id"]; ok {
+				span.SetAttributes(TransactionID.String(id))
+			}
+
+			rec := httpctl.NewStatusRecorder(w)
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			span.SetAttributes(attribute.Int("http.response.status_code", rec.Status))
+			if rec.Status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(rec.Status))
+			}
+		})
+	}
+}
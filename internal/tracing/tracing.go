@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/daronenko/https-proxy/internal/app/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/fx"
+)
+
+const (
+	defaultServiceName = "mitm-proxy"
+
+	// TransactionID is the span attribute holding the stored transaction id.
+	TransactionID = attribute.Key("transaction.id")
+)
+
+// New returns a provider exporting spans over otlp/http, or a no-op one when
+// tracing is disabled, so callers never check whether tracing is on.
+func New(conf *config.Config, lc fx.Lifecycle) (trace.TracerProvider, error) {
+	spec := conf.App.Tracing
+	if !spec.Enabled {
+		return noop.NewTracerProvider(), nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(spec.Endpoint)}
+	if spec.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create otlp exporter: %w", err)
+	}
+
+	provider := NewProvider(spec, sdktrace.WithBatcher(exporter))
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	lc.Append(fx.Hook{
+		OnStop: provider.Shutdown,
+	})
+
+	return provider, nil
+}
+
+// NewProvider builds an sdk provider with the configured sampling and service
+// name around any span processor, e.g. one feeding an in-memory exporter.
+func NewProvider(spec config.TracingSpec, opts ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	name := spec.ServiceName
+	if name == "" {
+		name = defaultServiceName
+	}
+
+	opts = append([]sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(spec.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", name))),
+	}, opts...)
+
+	return sdktrace.NewTracerProvider(opts...)
+}
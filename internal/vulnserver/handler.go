@@ -0,0 +1,64 @@
+// Package vulnserver is a deliberately vulnerable http handler used to check
+// the scanners. Never expose it outside of a local machine.
+package vulnserver
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Handler serves files from root the way a careless application would.
+func Handler(root string) http.Handler {
+	mux := http.NewServeMux()
+
+	// GET /download?file=report.txt
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		serveFile(w, root, r.URL.Query().Get("file"))
+	})
+
+	// POST /export with form param file=report.txt
+	mux.HandleFunc("/export", func(w http.ResponseWriter, r *http.Request) {
+		serveFile(w, root, r.PostFormValue("file"))
+	})
+
+	// GET /static/report.txt, decodes the escaped path once more like a
+	// misconfigured front server would
+	mux.HandleFunc("/static/", func(w http.ResponseWriter, r *http.Request) {
+		name, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/static/"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		serveFile(w, root, name)
+	})
+
+	// GET /fetch?url=http://example.com, blind ssrf
+	mux.HandleFunc("/fetch", func(w http.ResponseWriter, r *http.Request) {
+		if resp, err := http.Get(r.URL.Query().Get("url")); err == nil {
+			resp.Body.Close()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return mux
+}
+
+func serveFile(w http.ResponseWriter, root, name string) {
+	// emulate c-style string handling that stops at a null byte
+	if i := strings.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+
+	name = strings.ReplaceAll(name, "\\", "/")
+
+	content, err := os.ReadFile(filepath.Join(root, name))
+	if err != nil {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+
+	w.Write(content)
+}
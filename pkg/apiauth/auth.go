@@ -0,0 +1,134 @@
+package apiauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrUnauthorized = errors.New("invalid api token")
+
+// Role grants everything the lower roles can do.
+type Role int
+
+const (
+	// Viewer reads captured traffic.
+	Viewer Role = iota + 1
+	// Operator also sends traffic: repeat, scan, fuzz and imports.
+	Operator
+	// Admin also manages scope, certificates and retention.
+	Admin
+)
+
+func ParseRole(s string) (Role, error) {
+	switch s {
+	case "viewer":
+		return Viewer, nil
+	case "operator":
+		return Operator, nil
+	case "admin":
+		return Admin, nil
+	default:
+		return 0, fmt.Errorf("unknown role %q", s)
+	}
+}
+
+func (r Role) String() string {
+	switch r {
+	case Viewer:
+		return "viewer"
+	case Operator:
+		return "operator"
+	case Admin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// Token is a configured api token, only its sha-256 is kept.
+type Token struct {
+	Name string
+	Hash string
+	Role Role
+}
+
+type Identity struct {
+	Name string
+	Role Role
+}
+
+type Authenticator struct {
+	mu     sync.RWMutex
+	tokens map[[sha256.Size]byte]Identity
+}
+
+func New(tokens []Token) (*Authenticator, error) {
+	a := &Authenticator{}
+	if err := a.Replace(tokens); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Replace validates tokens and swaps them in, the old ones are kept on
+// error.
+func (a *Authenticator) Replace(tokens []Token) error {
+	identities := make(map[[sha256.Size]byte]Identity, len(tokens))
+	for _, token := range tokens {
+		hash, err := hex.DecodeString(token.Hash)
+		if err != nil || len(hash) != sha256.Size {
+			return fmt.Errorf("token %q: hash must be a hex encoded sha-256", token.Name)
+		}
+		if token.Role < Viewer || token.Role > Admin {
+			return fmt.Errorf("token %q: unknown role", token.Name)
+		}
+		identities[[sha256.Size]byte(hash)] = Identity{Name: token.Name, Role: token.Role}
+	}
+
+	a.mu.Lock()
+	a.tokens = identities
+	a.mu.Unlock()
+	return nil
+}
+
+// Enabled reports whether any token is configured, the api is open
+// otherwise.
+func (a *Authenticator) Enabled() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.tokens) > 0
+}
+
+// Authenticate looks the token up by its hash, so the lookup time tells
+// nothing about the configured tokens.
+func (a *Authenticator) Authenticate(token string) (Identity, error) {
+	if token == "" {
+		return Identity{}, ErrUnauthorized
+	}
+
+	a.mu.RLock()
+	identity, ok := a.tokens[sha256.Sum256([]byte(token))]
+	a.mu.RUnlock()
+	if !ok {
+		return Identity{}, ErrUnauthorized
+	}
+	return identity, nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateToken returns a random token suitable for the config.
+func GenerateToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,484 @@
+package apiclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/pkg/certstore"
+	"github.com/daronenko/https-proxy/pkg/scope"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Error is returned for non-2xx answers of the api server.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("api error %d: %s", e.Status, e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	return target == ErrNotFound && e.Status == http.StatusNotFound
+}
+
+type Client struct {
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+}
+
+func New(baseURL string) *Client {
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "http://" + baseURL
+	}
+
+	return &Client{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		HTTP:    &http.Client{},
+	}
+}
+
+// SetTLS talks https to the api server, verifying it against the CA in
+// caPath, or the system roots when empty, and presenting the client
+// certificate in certPath and keyPath when they are set.
+func (c *Client) SetTLS(caPath, certPath, keyPath string) error {
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caPath != "" {
+		pem, err := os.ReadFile(caPath)
+		if err != nil {
+			return fmt.Errorf("read ca certificate: %w", err)
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s has no certificates", caPath)
+		}
+	}
+
+	if certPath != "" || keyPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return fmt.Errorf("load client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = conf
+	c.HTTP.Transport = transport
+	c.BaseURL = strings.Replace(c.BaseURL, "http://", "https://", 1)
+
+	return nil
+}
+
+type ScanReport struct {
+	Result          string              `json:"result"`
+	Vulnerabilities map[string][]string `json:"vulnerabilities,omitempty"`
+}
+
+type RepeatResult struct {
+	TransactionID string
+	Status        int
+	Header        http.Header
+	Body          []byte
+}
+
+// RepeatPatch mirrors the body accepted by the repeat endpoint, nil header
+// and cookie values remove them.
+type RepeatPatch struct {
+	Method  *string            `json:"method,omitempty"`
+	URL     *string            `json:"url,omitempty"`
+	Headers map[string]*string `json:"headers,omitempty"`
+	Cookies map[string]*string `json:"cookies,omitempty"`
+	Body    *string            `json:"body,omitempty"`
+}
+
+func (c *Client) Requests(ctx context.Context) ([]*model.Transaction, error) {
+	var transactions []*model.Transaction
+	err := c.getJSON(ctx, "/requests", &transactions)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return transactions, err
+}
+
+func (c *Client) Request(ctx context.Context, id string) (*model.Transaction, error) {
+	var transaction model.Transaction
+	if err := c.getJSON(ctx, "/request/"+url.PathEscape(id), &transaction); err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// Body returns the decoded request or response body.
+func (c *Client) Body(ctx context.Context, id, part string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/request/"+url.PathEscape(id)+"/body?part="+url.QueryEscape(part), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+func (c *Client) Export(ctx context.Context, id, format string) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/request/"+url.PathEscape(id)+"/export?format="+url.QueryEscape(format), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func (c *Client) Scan(ctx context.Context, id string) (*ScanReport, error) {
+	resp, err := c.do(ctx, http.MethodPost, "/scan/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var report ScanReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("decode scan report: %w", err)
+	}
+	return &report, nil
+}
+
+// Repeat replays a stored request. Upstream error statuses are returned as
+// results, only failures of the api server itself are errors.
+func (c *Client) Repeat(ctx context.Context, id string, patch *RepeatPatch) (*RepeatResult, error) {
+	var body io.Reader
+	if patch != nil {
+		payload, err := json.Marshal(patch)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/repeat/"+url.PathEscape(id), body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	transactionID := resp.Header.Get("X-Transaction-Id")
+	if transactionID == "" && resp.StatusCode >= 400 {
+		return nil, readError(resp)
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RepeatResult{
+		TransactionID: transactionID,
+		Status:        resp.StatusCode,
+		Header:        resp.Header,
+		Body:          respBody,
+	}, nil
+}
+
+type ImportResult struct {
+	Imported int      `json:"imported"`
+	IDs      []string `json:"ids"`
+}
+
+func (c *Client) Import(ctx context.Context, transactions []*model.ArchivedTransaction) (*ImportResult, error) {
+	payload, err := json.Marshal(transactions)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, "/requests/import", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result ImportResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode import result: %w", err)
+	}
+	return &result, nil
+}
+
+// CA returns the proxy ca certificate in the requested format.
+func (c *Client) CA(ctx context.Context, format string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/ca?format="+url.QueryEscape(format), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+func (c *Client) Scope(ctx context.Context) (*scope.Config, error) {
+	var conf scope.Config
+	if err := c.getJSON(ctx, "/scope", &conf); err != nil {
+		return nil, err
+	}
+	return &conf, nil
+}
+
+// SetScope replaces the scope rules and returns them as applied.
+func (c *Client) SetScope(ctx context.Context, conf *scope.Config) (*scope.Config, error) {
+	payload, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(ctx, http.MethodPut, "/scope", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var applied scope.Config
+	if err := json.NewDecoder(resp.Body).Decode(&applied); err != nil {
+		return nil, fmt.Errorf("decode scope: %w", err)
+	}
+	return &applied, nil
+}
+
+type ScopeCheck struct {
+	InScope bool         `json:"in_scope"`
+	Action  scope.Action `json:"action"`
+}
+
+func (c *Client) CheckScope(ctx context.Context, rawURL string) (*ScopeCheck, error) {
+	var check ScopeCheck
+	if err := c.getJSON(ctx, "/scope/check?url="+url.QueryEscape(rawURL), &check); err != nil {
+		return nil, err
+	}
+	return &check, nil
+}
+
+// Audit returns the latest audit entries, an empty actor matches everyone.
+func (c *Client) Audit(ctx context.Context, actor string, limit int) ([]*model.AuditEntry, error) {
+	query := url.Values{"limit": {fmt.Sprint(limit)}}
+	if actor != "" {
+		query.Set("actor", actor)
+	}
+
+	var entries []*model.AuditEntry
+	if err := c.getJSON(ctx, "/admin/audit?"+query.Encode(), &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (c *Client) Certs(ctx context.Context) ([]certstore.Info, error) {
+	var certs []certstore.Info
+	if err := c.getJSON(ctx, "/admin/certs", &certs); err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+func (c *Client) Cert(ctx context.Context, host string) (*certstore.Info, error) {
+	var info certstore.Info
+	if err := c.getJSON(ctx, "/admin/certs/"+url.PathEscape(host), &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// RenewCert issues a new certificate for host.
+func (c *Client) RenewCert(ctx context.Context, host string) (*certstore.Info, error) {
+	return c.postCert(ctx, "/admin/certs/"+url.PathEscape(host))
+}
+
+func (c *Client) RevokeCert(ctx context.Context, host string) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/admin/certs/"+url.PathEscape(host), nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *Client) CAInfo(ctx context.Context) (*certstore.Info, error) {
+	var info certstore.Info
+	if err := c.getJSON(ctx, "/admin/ca", &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// RegenerateCA replaces the CA, clients have to trust the new one.
+func (c *Client) RegenerateCA(ctx context.Context) (*certstore.Info, error) {
+	return c.postCert(ctx, "/admin/ca")
+}
+
+func (c *Client) postCert(ctx context.Context, path string) (*certstore.Info, error) {
+	resp, err := c.do(ctx, http.MethodPost, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var info certstore.Info
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("decode certificate: %w", err)
+	}
+	return &info, nil
+}
+
+// ReloadResult lists the config sections a reload applied, the ones that
+// only change on restart and the ones that failed to apply.
+type ReloadResult struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+	Errors          []string `json:"errors,omitempty"`
+}
+
+// Reload makes the server read its config file again. When part of it
+// failed to apply the result is returned together with an *Error.
+func (c *Client) Reload(ctx context.Context) (*ReloadResult, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, "/admin/reload", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 && resp.StatusCode != http.StatusInternalServerError {
+		return nil, readError(resp)
+	}
+
+	var result struct {
+		ReloadResult
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		if resp.StatusCode >= 400 {
+			return nil, &Error{Status: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		}
+		return nil, fmt.Errorf("decode reload result: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		apiErr := &Error{Status: resp.StatusCode, Message: result.Error}
+		if result.Applied == nil {
+			return nil, apiErr
+		}
+		return &result.ReloadResult, apiErr
+	}
+	return &result.ReloadResult, nil
+}
+
+// Stream calls fn for every summary pushed by the server-sent events feed
+// until ctx is done or the connection breaks.
+func (c *Client) Stream(ctx context.Context, filter url.Values, fn func(model.Summary)) error {
+	resp, err := c.do(ctx, http.MethodGet, "/stream?"+filter.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var event string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && event == "transaction":
+			var summary model.Summary
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &summary); err == nil {
+				fn(summary)
+			}
+		case line == "":
+			event = ""
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, out any) error {
+	resp, err := c.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		return nil, readError(resp)
+	}
+
+	return resp, nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return req, nil
+}
+
+func readError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == "" {
+		body.Error = http.StatusText(resp.StatusCode)
+	}
+	return &Error{Status: resp.StatusCode, Message: body.Error}
+}
@@ -0,0 +1,225 @@
+package certstore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// mimicRetry is how long a failed fetch is remembered, handshakes in the
+// meantime fail at once instead of dialing an upstream that is down.
+const mimicRetry = 30 * time.Second
+
+// ErrMimicBackoff is returned while a failed fetch is remembered.
+var ErrMimicBackoff = errors.New("upstream certificate fetch failed recently")
+
+type mimicFailure struct {
+	err   error
+	until time.Time
+}
+
+// Mimic returns a certificate for host that copies the subject, names,
+// validity, key usages and key type of the real one, which fetch returns. It
+// is only called on a cache miss, concurrent misses for a host share one
+// fetch. Mimicked certificates are kept in memory, the upstream may have
+// rotated by the next start, and at most MaxMimics of them. An upstream
+// certificate that has expired is copied with a fresh validity instead, so
+// the connection still works and the cache entry lives. A failed fetch is
+// not tried again for mimicRetry.
+func (s *Store) Mimic(host string, fetch func() (*x509.Certificate, error)) (*tls.Certificate, bool, error) {
+	host, err := normalize(host)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if cert, err := s.cachedMimic(host); cert != nil || err != nil {
+		return cert, false, err
+	}
+
+	issued := false
+	v, err, _ := s.issuing.Do("mimic "+host, func() (any, error) {
+		// another handshake may have mimicked it meanwhile
+		if cert, err := s.cachedMimic(host); cert != nil || err != nil {
+			return cert, err
+		}
+
+		// fetched without the lock, a slow upstream must not stall
+		// handshakes with other hosts
+		upstream, err := fetch()
+		if err != nil {
+			err = fmt.Errorf("fetch upstream certificate of %s: %w", host, err)
+			s.rememberFailure(host, err)
+			return nil, err
+		}
+		key, err := s.keyLike(upstream.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		cert, err := s.ca.Sign(s.mimicTemplate(host, upstream), key)
+		if err != nil {
+			return nil, fmt.Errorf("issue certificate for %s: %w", host, err)
+		}
+
+		s.keepMimic(host, cert)
+		delete(s.mimicFailures, host)
+		issued = true
+		return cert, nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return v.(*tls.Certificate), issued, nil
+}
+
+// cachedMimic returns the mimicked certificate of host while it is valid,
+// or the remembered failure. Both are nil on a miss.
+func (s *Store) cachedMimic(host string) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	if cert, ok := s.mimics[host]; ok && now.Before(cert.Leaf.NotAfter) {
+		return cert, nil
+	}
+	if failure, ok := s.mimicFailures[host]; ok && now.Before(failure.until) {
+		return nil, fmt.Errorf("%w: %w", ErrMimicBackoff, failure.err)
+	}
+	return nil, nil
+}
+
+// keepMimic caches cert under s.mu. A full cache drops the expired
+// certificates first, then random ones, a dropped host is mimicked again on
+// its next connection.
+func (s *Store) keepMimic(host string, cert *tls.Certificate) {
+	if _, ok := s.mimics[host]; !ok && len(s.mimics) >= s.maxMimics() {
+		now := time.Now()
+		for cached, c := range s.mimics {
+			if !now.Before(c.Leaf.NotAfter) {
+				delete(s.mimics, cached)
+			}
+		}
+		for cached := range s.mimics {
+			if len(s.mimics) < s.maxMimics() {
+				break
+			}
+			delete(s.mimics, cached)
+		}
+	}
+	s.mimics[host] = cert
+}
+
+func (s *Store) maxMimics() int {
+	if s.conf.MaxMimics > 0 {
+		return s.conf.MaxMimics
+	}
+	return DefaultMaxMimics
+}
+
+// rememberFailure keeps err for mimicRetry and drops failures that are no
+// longer remembered, so hosts that were tried once do not pile up.
+func (s *Store) rememberFailure(host string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for failed, failure := range s.mimicFailures {
+		if now.After(failure.until) {
+			delete(s.mimicFailures, failed)
+		}
+	}
+	s.mimicFailures[host] = mimicFailure{err: err, until: now.Add(mimicRetry)}
+}
+
+func (s *Store) mimicTemplate(host string, upstream *x509.Certificate) *x509.Certificate {
+	template := &x509.Certificate{
+		RawSubject:            upstream.RawSubject,
+		DNSNames:              upstream.DNSNames,
+		IPAddresses:           upstream.IPAddresses,
+		EmailAddresses:        upstream.EmailAddresses,
+		URIs:                  upstream.URIs,
+		NotBefore:             upstream.NotBefore,
+		NotAfter:              upstream.NotAfter,
+		KeyUsage:              upstream.KeyUsage,
+		ExtKeyUsage:           upstream.ExtKeyUsage,
+		UnknownExtKeyUsage:    upstream.UnknownExtKeyUsage,
+		BasicConstraintsValid: upstream.BasicConstraintsValid,
+	}
+
+	now := time.Now()
+	if now.After(upstream.NotAfter) || now.Before(upstream.NotBefore) {
+		template.NotBefore, template.NotAfter = now.Add(-time.Hour), now.Add(s.conf.Validity)
+	}
+
+	// the client checks the name it asked for, whatever the upstream sent
+	if upstream.VerifyHostname(host) != nil {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	return template
+}
+
+// keyLike returns a key of the same type and size as pub. Keys are generated
+// once per type and shared, the configured host key is used when it fits.
+func (s *Store) keyLike(pub crypto.PublicKey) (crypto.Signer, error) {
+	kind := keyKind(pub)
+	if kind == "" || kind == keyKind(s.key.Public()) {
+		return s.key, nil
+	}
+
+	s.mu.RLock()
+	key, ok := s.keys[kind]
+	s.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	var err error
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		key, err = rsa.GenerateKey(rand.Reader, pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		key, err = ecdsa.GenerateKey(pub.Curve, rand.Reader)
+	case ed25519.PublicKey:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("generate %s key: %w", kind, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.keys[kind]; ok {
+		return existing, nil
+	}
+	s.keys[kind] = key
+	return key, nil
+}
+
+func keyKind(pub crypto.PublicKey) string {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("rsa-%d", pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ecdsa-" + pub.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "ed25519"
+	default:
+		return ""
+	}
+}
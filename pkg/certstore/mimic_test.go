@@ -0,0 +1,156 @@
+package certstore_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daronenko/https-proxy/pkg/certstore"
+)
+
+func TestMimicRemembersFailures(t *testing.T) {
+	s := newStore(t, 365*24*time.Hour)
+
+	fetches := 0
+	down := errors.New("connection refused")
+	fetch := func() (*x509.Certificate, error) {
+		fetches++
+		return nil, down
+	}
+
+	if _, _, err := s.Mimic("example.com", fetch); !errors.Is(err, down) {
+		t.Fatalf("first Mimic() error = %v, want the fetch error", err)
+	}
+	for range 3 {
+		_, _, err := s.Mimic("example.com", fetch)
+		if !errors.Is(err, certstore.ErrMimicBackoff) || !errors.Is(err, down) {
+			t.Fatalf("Mimic() error = %v, want the remembered failure", err)
+		}
+	}
+	if fetches != 1 {
+		t.Fatalf("upstream fetched %d times, want once", fetches)
+	}
+
+	// other hosts are not affected
+	if _, _, err := s.Mimic("other.com", fetch); errors.Is(err, certstore.ErrMimicBackoff) {
+		t.Fatal("failure of one host was remembered for another")
+	}
+
+	// revoking a host forgets its failure, the next handshake tries again
+	if err := s.Revoke("example.com"); err != nil && !errors.Is(err, certstore.ErrNotFound) {
+		t.Fatal(err)
+	}
+	s.Mimic("example.com", fetch)
+	if fetches != 3 {
+		t.Fatalf("upstream fetched %d times after revoke, want 3", fetches)
+	}
+}
+
+// upstreamCert is a self-signed stand-in for the certificate of a real host.
+func upstreamCert(t *testing.T, host string) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestMimicFetchesOnce(t *testing.T) {
+	s := newStore(t, 365*24*time.Hour)
+	upstream := upstreamCert(t, "example.com")
+
+	var fetches atomic.Int64
+	fetch := func() (*x509.Certificate, error) {
+		fetches.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		return upstream, nil
+	}
+
+	const handshakes = 20
+	var (
+		wg     sync.WaitGroup
+		issued atomic.Int64
+	)
+	for range handshakes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cert, fresh, err := s.Mimic("example.com", fetch)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if cert.Leaf.Subject.CommonName != "example.com" {
+				t.Errorf("mimicked subject %s", cert.Leaf.Subject)
+			}
+			if fresh {
+				issued.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if fetches.Load() != 1 || issued.Load() != 1 {
+		t.Fatalf("%d handshakes fetched %d times and issued %d certificates, want one", handshakes, fetches.Load(), issued.Load())
+	}
+}
+
+func TestMimicCacheBounded(t *testing.T) {
+	const limit = 3
+	s := newStore(t, 365*24*time.Hour, func(conf *certstore.Config) { conf.MaxMimics = limit })
+
+	hosts := []string{"a.example.com", "b.example.com", "c.example.com", "d.example.com", "e.example.com"}
+	for _, host := range hosts {
+		upstream := upstreamCert(t, host)
+		if _, _, err := s.Mimic(host, func() (*x509.Certificate, error) { return upstream, nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mimics := 0
+	for _, info := range list {
+		if info.Mimic {
+			mimics++
+		}
+	}
+	if mimics != limit {
+		t.Fatalf("cache holds %d mimicked certificates, want %d", mimics, limit)
+	}
+
+	// the last one is always kept
+	last := hosts[len(hosts)-1]
+	if _, fresh, err := s.Mimic(last, func() (*x509.Certificate, error) { return nil, errors.New("not cached") }); err != nil || fresh {
+		t.Fatalf("latest host was evicted: %v, %v", fresh, err)
+	}
+}
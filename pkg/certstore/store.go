@@ -0,0 +1,534 @@
+package certstore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/daronenko/https-proxy/pkg/ca"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/publicsuffix"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	ErrNotFound    = errors.New("certificate not found")
+	ErrInvalidHost = errors.New("invalid host name")
+)
+
+const caName = "MITM Proxy CA"
+
+type Config struct {
+	// Dir keeps the issued host certificates as <host>.crt.
+	Dir string
+	// KeyPath is the key shared by all host certificates, created when
+	// missing.
+	KeyPath string
+	// CACertPath and CAKeyPath are the CA, created when both are missing.
+	CACertPath string
+	CAKeyPath  string
+	// Validity is the lifetime of issued host certificates.
+	Validity time.Duration
+	// RenewBefore is how long before expiry a certificate is reissued.
+	RenewBefore time.Duration
+	// CAValidity is the lifetime of a generated CA.
+	CAValidity time.Duration
+	// Wildcard shares one *.domain certificate between the subdomains of a
+	// domain instead of issuing one per host.
+	Wildcard bool
+	// MaxMimics caps the mimicked certificates kept in memory,
+	// DefaultMaxMimics when zero.
+	MaxMimics int
+}
+
+// DefaultMaxMimics is the mimic cache size when none is configured.
+const DefaultMaxMimics = 10000
+
+// Info describes a certificate for listing and inspection.
+type Info struct {
+	Host        string    `json:"host,omitempty"`
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Serial      string    `json:"serial"`
+	DNSNames    []string  `json:"dns_names,omitempty"`
+	IPAddresses []string  `json:"ip_addresses,omitempty"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	SHA256      string    `json:"sha256"`
+	// Mimic is set for copies of upstream certificates.
+	Mimic bool `json:"mimic,omitempty"`
+}
+
+// Store issues host certificates with the CA, keeps them on disk and caches
+// them parsed, so a handshake costs a map lookup. Certificates close to
+// expiry, longer lived than Validity or signed by a previous CA are reissued
+// on the next lookup.
+type Store struct {
+	conf Config
+
+	mu    sync.RWMutex
+	ca    *ca.Authority
+	key   crypto.Signer
+	certs map[string]*tls.Certificate
+
+	// mimics and the keys of other types they need live in memory only,
+	// so do recent failures to fetch the upstream certificate
+	mimics        map[string]*tls.Certificate
+	mimicFailures map[string]mimicFailure
+	keys          map[string]crypto.Signer
+
+	issuing        singleflight.Group
+	caExpiryLogged atomic.Bool
+}
+
+func New(conf Config) (*Store, error) {
+	s := &Store{
+		conf:          conf,
+		certs:         make(map[string]*tls.Certificate),
+		mimics:        make(map[string]*tls.Certificate),
+		mimicFailures: make(map[string]mimicFailure),
+		keys:          make(map[string]crypto.Signer),
+	}
+
+	if err := os.MkdirAll(conf.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create certificate dir: %w", err)
+	}
+
+	authority, err := s.loadCA()
+	if err != nil {
+		return nil, err
+	}
+	key, err := loadKey(conf.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+	s.ca, s.key = authority, key
+
+	return s, nil
+}
+
+func (s *Store) loadCA() (*ca.Authority, error) {
+	_, certErr := os.Stat(s.conf.CACertPath)
+	_, keyErr := os.Stat(s.conf.CAKeyPath)
+	if errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist) {
+		authority, err := ca.Generate(caName, s.conf.CAValidity)
+		if err != nil {
+			return nil, err
+		}
+		if err := authority.Save(s.conf.CACertPath, s.conf.CAKeyPath); err != nil {
+			return nil, err
+		}
+		return authority, nil
+	}
+
+	return ca.Load(s.conf.CACertPath, s.conf.CAKeyPath)
+}
+
+func loadKey(path string) (crypto.Signer, error) {
+	keyPEM, err := os.ReadFile(path)
+	if err == nil {
+		key, err := ca.ParseKey(keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("parse host key: %w", err)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read host key: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate host key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return nil, fmt.Errorf("write host key: %w", err)
+	}
+	return key, nil
+}
+
+// Certificate returns a certificate valid for host and whether it had to be
+// issued for this call. Concurrent lookups of a host that is not cached
+// share one issue, and handshakes with other hosts are not held up by it.
+func (s *Store) Certificate(host string) (*tls.Certificate, bool, error) {
+	host, err := normalize(host)
+	if err != nil {
+		return nil, false, err
+	}
+	host = s.served(host)
+
+	if cert := s.cached(host); cert != nil {
+		return cert, false, nil
+	}
+
+	issued := false
+	v, err, _ := s.issuing.Do(host, func() (any, error) {
+		// another lookup may have issued it meanwhile
+		if cert := s.cached(host); cert != nil {
+			return cert, nil
+		}
+
+		s.mu.RLock()
+		cert, err := s.readLocked(host)
+		fresh := err == nil && s.fresh(cert.Leaf)
+		s.mu.RUnlock()
+		if fresh {
+			s.mu.Lock()
+			s.certs[host] = cert
+			s.mu.Unlock()
+			return cert, nil
+		}
+
+		issued = true
+		return s.issue(host)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return v.(*tls.Certificate), issued, nil
+}
+
+func (s *Store) cached(host string) *tls.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if cert, ok := s.certs[host]; ok && !s.expiring(cert.Leaf) {
+		return cert
+	}
+	return nil
+}
+
+// expiring is all that is checked for cached certificates, they are always
+// issued by the current CA for the current key. A certificate cut short by
+// the expiry of the CA is kept, another one would not live longer. The
+// caller holds mu.
+func (s *Store) expiring(leaf *x509.Certificate) bool {
+	if time.Until(leaf.NotAfter) >= s.conf.RenewBefore {
+		return false
+	}
+	if !leaf.NotAfter.Before(s.ca.Cert.NotAfter) {
+		if s.caExpiryLogged.CompareAndSwap(false, true) {
+			log.Warn().Time("not_after", s.ca.Cert.NotAfter).Msg("the CA expires before certificates can be renewed, regenerate it")
+		}
+		return false
+	}
+	return true
+}
+
+// fresh checks a certificate read from disk, it may be left over from a
+// previous CA, key or config. The caller holds mu.
+func (s *Store) fresh(leaf *x509.Certificate) bool {
+	if s.expiring(leaf) {
+		return false
+	}
+	if leaf.NotAfter.Sub(leaf.NotBefore) > s.conf.Validity+24*time.Hour {
+		return false
+	}
+	if key, ok := s.key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !key.Equal(leaf.PublicKey) {
+		return false
+	}
+	return leaf.CheckSignatureFrom(s.ca.Cert) == nil
+}
+
+func (s *Store) readLocked(host string) (*tls.Certificate, error) {
+	certPEM, err := os.ReadFile(s.path(host))
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: not a pem certificate", s.path(host))
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{leaf.Raw, s.ca.Cert.Raw},
+		PrivateKey:  s.key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// issue signs a certificate for host without holding mu and then saves
+// and caches it. One signed by a CA that was replaced meanwhile is
+// returned but not kept.
+func (s *Store) issue(host string) (*tls.Certificate, error) {
+	names := []string{host}
+	if domain, found := strings.CutPrefix(host, "*."); found {
+		names = []string{domain, host}
+	}
+
+	s.mu.RLock()
+	authority, key := s.ca, s.key
+	s.mu.RUnlock()
+
+	cert, err := authority.IssueWithKey(names, key, s.conf.Validity)
+	if err != nil {
+		return nil, fmt.Errorf("issue certificate for %s: %w", host, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ca != authority {
+		return cert, nil
+	}
+
+	leafPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Leaf.Raw})
+	if err := os.WriteFile(s.path(host), leafPEM, 0o644); err != nil {
+		return nil, fmt.Errorf("save certificate for %s: %w", host, err)
+	}
+
+	s.certs[host] = cert
+	return cert, nil
+}
+
+// List describes the host certificates on disk and the mimicked ones.
+func (s *Store) List() ([]Info, error) {
+	entries, err := os.ReadDir(s.conf.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Info, 0, len(entries))
+	for _, entry := range entries {
+		name, found := strings.CutSuffix(entry.Name(), ".crt")
+		if !found || entry.IsDir() {
+			continue
+		}
+		host := name
+		if domain, found := strings.CutPrefix(name, wildcardFile); found {
+			host = "*." + domain
+		}
+		cert, err := s.readLocked(host)
+		if err != nil {
+			continue
+		}
+		list = append(list, describe(host, cert.Leaf))
+	}
+	for host, cert := range s.mimics {
+		info := describe(host, cert.Leaf)
+		info.Mimic = true
+		list = append(list, info)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Host < list[j].Host
+	})
+	return list, nil
+}
+
+// Get describes the certificate of host, a mimicked one first.
+func (s *Store) Get(host string) (Info, error) {
+	host, err := normalize(host)
+	if err != nil {
+		return Info{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if cert, ok := s.mimics[host]; ok {
+		info := describe(host, cert.Leaf)
+		info.Mimic = true
+		return info, nil
+	}
+
+	host = s.served(host)
+	cert, err := s.readLocked(host)
+	if errors.Is(err, os.ErrNotExist) {
+		return Info{}, ErrNotFound
+	} else if err != nil {
+		return Info{}, err
+	}
+	return describe(host, cert.Leaf), nil
+}
+
+// Renew issues a new certificate for host right away. With wildcards it is
+// the wildcard covering host.
+func (s *Store) Renew(host string) (Info, error) {
+	host, err := normalize(host)
+	if err != nil {
+		return Info{}, err
+	}
+	host = s.served(host)
+
+	cert, err := s.issue(host)
+	if err != nil {
+		return Info{}, err
+	}
+	return describe(host, cert.Leaf), nil
+}
+
+// Revoke drops the certificate of host, the next connection gets a new one.
+// Nothing checks revocation of a mitm certificate, so this is how a leaked or
+// wrong certificate is taken out of use. With wildcards the wildcard
+// covering host is dropped, and with it every host it served.
+func (s *Store) Revoke(host string) error {
+	host, err := normalize(host)
+	if err != nil {
+		return err
+	}
+	served := s.served(host)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// mimicked certificates are kept per host
+	_, mimicked := s.mimics[host]
+	delete(s.mimics, host)
+	delete(s.mimicFailures, host)
+	delete(s.certs, served)
+
+	if err := os.Remove(s.path(served)); errors.Is(err, os.ErrNotExist) {
+		if mimicked {
+			return nil
+		}
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// CA describes the current CA.
+func (s *Store) CA() Info {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return describe("", s.ca.Cert)
+}
+
+// CAPool returns a pool with the current CA for verifying certificates.
+func (s *Store) CAPool() *x509.CertPool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(s.ca.Cert)
+	return pool
+}
+
+// RegenerateCA replaces the CA and drops every host certificate. Clients
+// have to trust the new CA before intercepted connections work again.
+func (s *Store) RegenerateCA() (Info, error) {
+	authority, err := ca.Generate(caName, s.conf.CAValidity)
+	if err != nil {
+		return Info{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := authority.Save(s.conf.CACertPath, s.conf.CAKeyPath); err != nil {
+		return Info{}, err
+	}
+	s.ca = authority
+	s.certs = make(map[string]*tls.Certificate)
+	s.mimics = make(map[string]*tls.Certificate)
+	s.caExpiryLogged.Store(false)
+
+	entries, err := os.ReadDir(s.conf.Dir)
+	if err != nil {
+		return Info{}, err
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".crt") {
+			os.Remove(filepath.Join(s.conf.Dir, entry.Name()))
+		}
+	}
+
+	return describe("", authority.Cert), nil
+}
+
+// wildcardFile replaces the asterisk in file names of wildcard certificates.
+const wildcardFile = "_wildcard."
+
+func (s *Store) path(host string) string {
+	if domain, found := strings.CutPrefix(host, "*."); found {
+		host = wildcardFile + domain
+	}
+	return filepath.Join(s.conf.Dir, host+".crt")
+}
+
+// served maps host to the name its certificate is issued and stored under.
+func (s *Store) served(host string) string {
+	if s.conf.Wildcard {
+		return wildcardName(host)
+	}
+	return host
+}
+
+// wildcardName returns the wildcard certificate covering host. A wildcard
+// matches a single label, so a.b.example.com gets *.b.example.com, and it
+// never goes above the registrable domain: no *.co.uk or *.github.io. Ip
+// addresses and hosts that are public suffixes keep their own certificate.
+func wildcardName(host string) string {
+	if net.ParseIP(host) != nil || strings.HasPrefix(host, "*.") {
+		return host
+	}
+
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+
+	if host != domain {
+		_, host, _ = strings.Cut(host, ".")
+	}
+	return "*." + host
+}
+
+// normalize lowercases host and refuses names that could escape Dir. An
+// asterisk is only allowed as the first label of a wildcard.
+func normalize(host string) (string, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	rest := strings.TrimPrefix(host, "*.")
+	if rest == "" || rest == ".." || strings.ContainsAny(rest, `/\*`) || strings.HasPrefix(rest, ".") {
+		return "", ErrInvalidHost
+	}
+	return host, nil
+}
+
+func describe(host string, cert *x509.Certificate) Info {
+	sum := sha256.Sum256(cert.Raw)
+	info := Info{
+		Host:      host,
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		Serial:    cert.SerialNumber.Text(16),
+		DNSNames:  cert.DNSNames,
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		SHA256:    hex.EncodeToString(sum[:]),
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	return info
+}
@@ -0,0 +1,170 @@
+package certstore_test
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daronenko/https-proxy/pkg/certstore"
+)
+
+func newStore(t *testing.T, caValidity time.Duration, options ...func(*certstore.Config)) *certstore.Store {
+	t.Helper()
+
+	dir := t.TempDir()
+	conf := certstore.Config{
+		Dir:         filepath.Join(dir, "hosts"),
+		KeyPath:     filepath.Join(dir, "cert.key"),
+		CACertPath:  filepath.Join(dir, "ca.crt"),
+		CAKeyPath:   filepath.Join(dir, "ca.key"),
+		Validity:    30 * 24 * time.Hour,
+		RenewBefore: 7 * 24 * time.Hour,
+		CAValidity:  caValidity,
+	}
+	for _, option := range options {
+		option(&conf)
+	}
+	s, err := certstore.New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestCertificateIssuesOnce(t *testing.T) {
+	s := newStore(t, 365*24*time.Hour)
+
+	const lookups = 50
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		issued int
+		serial = map[string]bool{}
+	)
+	for range lookups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cert, fresh, err := s.Certificate("www.example.com")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if fresh {
+				issued++
+			}
+			serial[cert.Leaf.SerialNumber.String()] = true
+		}()
+	}
+	wg.Wait()
+
+	if issued != 1 || len(serial) != 1 {
+		t.Fatalf("%d lookups issued %d certificates with %d serials, want one", lookups, issued, len(serial))
+	}
+
+	if _, fresh, err := s.Certificate("www.example.com"); err != nil || fresh {
+		t.Fatalf("cached lookup issued = %v, %v", fresh, err)
+	}
+}
+
+func TestCertificateCappedByCA(t *testing.T) {
+	// the CA expires within renewBefore, every certificate ends with it
+	s := newStore(t, 48*time.Hour)
+
+	first, fresh, err := s.Certificate("example.com")
+	if err != nil || !fresh {
+		t.Fatalf("first lookup issued = %v, %v", fresh, err)
+	}
+	if ca := s.CA(); !first.Leaf.NotAfter.Equal(ca.NotAfter) {
+		t.Fatalf("certificate expires %s, want the CA expiry %s", first.Leaf.NotAfter, ca.NotAfter)
+	}
+
+	for range 3 {
+		cert, fresh, err := s.Certificate("example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fresh || cert != first {
+			t.Fatal("certificate capped by the CA was issued again")
+		}
+	}
+}
+
+func TestRenew(t *testing.T) {
+	s := newStore(t, 365*24*time.Hour)
+
+	cert, _, err := s.Certificate("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.Renew("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Serial == cert.Leaf.SerialNumber.Text(16) {
+		t.Fatal("Renew kept the old certificate")
+	}
+
+	renewed, fresh, err := s.Certificate("example.com")
+	if err != nil || fresh {
+		t.Fatalf("lookup after renew issued = %v, %v", fresh, err)
+	}
+	if renewed.Leaf.SerialNumber.Text(16) != info.Serial {
+		t.Fatal("lookup after renew did not return the renewed certificate")
+	}
+}
+
+func TestWildcardRenewAndRevoke(t *testing.T) {
+	s := newStore(t, 365*24*time.Hour, func(conf *certstore.Config) { conf.Wildcard = true })
+
+	served, _, err := s.Certificate("www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := served.Leaf.DNSNames; !slices.Contains(names, "*.example.com") {
+		t.Fatalf("served names = %v, want *.example.com", names)
+	}
+
+	info, err := s.Renew("api.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Host != "*.example.com" {
+		t.Errorf("Renew() renewed %q, want the wildcard that is served", info.Host)
+	}
+	renewed, fresh, err := s.Certificate("www.example.com")
+	if err != nil || fresh {
+		t.Fatalf("lookup after renew issued = %v, %v", fresh, err)
+	}
+	if renewed.Leaf.SerialNumber.Text(16) != info.Serial {
+		t.Error("the served wildcard was not the renewed one")
+	}
+
+	list, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Errorf("store holds %d certificates after renew, want only the wildcard", len(list))
+	}
+
+	if err := s.Revoke("www.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("*.example.com"); !errors.Is(err, certstore.ErrNotFound) {
+		t.Errorf("wildcard still stored after revoke: %v", err)
+	}
+	again, fresh, err := s.Certificate("www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fresh || again.Leaf.SerialNumber.Text(16) == info.Serial {
+		t.Error("revoked wildcard was served again")
+	}
+}
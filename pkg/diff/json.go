@@ -0,0 +1,101 @@
+package diff
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+)
+
+type Change struct {
+	Path string `json:"path"`
+	Op   string `json:"op"`
+	A    any    `json:"a,omitempty"`
+	B    any    `json:"b,omitempty"`
+}
+
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// JSON compares two decoded json documents structurally and reports changes
+// by their json path.
+func JSON(a, b any) []Change {
+	return jsonChanges("$", a, b, nil)
+}
+
+// Map compares flat string maps such as headers.
+func Map(a, b map[string]string) []Change {
+	var changes []Change
+
+	keys := slices.Sorted(maps.Keys(a))
+	for _, k := range slices.Sorted(maps.Keys(b)) {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+
+	for _, k := range keys {
+		av, inA := a[k]
+		bv, inB := b[k]
+		switch {
+		case !inA:
+			changes = append(changes, Change{Path: k, Op: ChangeAdded, B: bv})
+		case !inB:
+			changes = append(changes, Change{Path: k, Op: ChangeRemoved, A: av})
+		case av != bv:
+			changes = append(changes, Change{Path: k, Op: ChangeChanged, A: av, B: bv})
+		}
+	}
+
+	return changes
+}
+
+func jsonChanges(path string, a, b any, changes []Change) []Change {
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		for _, k := range slices.Sorted(maps.Keys(av)) {
+			child := path + "." + k
+			if _, ok := bv[k]; !ok {
+				changes = append(changes, Change{Path: child, Op: ChangeRemoved, A: av[k]})
+				continue
+			}
+			changes = jsonChanges(child, av[k], bv[k], changes)
+		}
+		for _, k := range slices.Sorted(maps.Keys(bv)) {
+			if _, ok := av[k]; !ok {
+				changes = append(changes, Change{Path: path + "." + k, Op: ChangeAdded, B: bv[k]})
+			}
+		}
+		return changes
+	case []any:
+		bv, ok := b.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < max(len(av), len(bv)); i++ {
+			child := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(bv):
+				changes = append(changes, Change{Path: child, Op: ChangeRemoved, A: av[i]})
+			case i >= len(av):
+				changes = append(changes, Change{Path: child, Op: ChangeAdded, B: bv[i]})
+			default:
+				changes = jsonChanges(child, av[i], bv[i], changes)
+			}
+		}
+		return changes
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		changes = append(changes, Change{Path: path, Op: ChangeChanged, A: a, B: b})
+	}
+
+	return changes
+}
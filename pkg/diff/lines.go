@@ -0,0 +1,218 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Op string
+
+const (
+	OpEqual  Op = "="
+	OpInsert Op = "+"
+	OpDelete Op = "-"
+)
+
+type Edit struct {
+	Op   Op
+	Text string
+}
+
+// Lines computes the shortest edit script between the lines of a and b with
+// the linear space variant of the myers algorithm, which splits the inputs
+// at the middle snake instead of keeping every round of the search.
+func Lines(a, b string) []Edit {
+	x, y := splitLines(a), splitLines(b)
+	edits := make([]Edit, 0, len(x)+len(y))
+	return appendLines(edits, x, y)
+}
+
+func appendLines(edits []Edit, x, y []string) []Edit {
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	for _, line := range x[:prefix] {
+		edits = append(edits, Edit{OpEqual, line})
+	}
+	x, y = x[prefix:], y[prefix:]
+
+	suffix := 0
+	for suffix < len(x) && suffix < len(y) && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+	common := x[len(x)-suffix:]
+	x, y = x[:len(x)-suffix], y[:len(y)-suffix]
+
+	if i, j, ok := middleSnake(x, y); ok {
+		edits = appendLines(edits, x[:i], y[:j])
+		edits = appendLines(edits, x[i:], y[j:])
+	} else {
+		for _, line := range x {
+			edits = append(edits, Edit{OpDelete, line})
+		}
+		for _, line := range y {
+			edits = append(edits, Edit{OpInsert, line})
+		}
+	}
+
+	for _, line := range common {
+		edits = append(edits, Edit{OpEqual, line})
+	}
+	return edits
+}
+
+// middleSnake searches from both ends at once and returns where the paths
+// meet, a point some shortest edit script passes through. It fails when x
+// and y have nothing in common or either is empty.
+func middleSnake(x, y []string) (int, int, bool) {
+	n, m := len(x), len(y)
+	if n == 0 || m == 0 {
+		return 0, 0, false
+	}
+
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	forward := make([]int, 2*maxD+2)
+	backward := make([]int, 2*maxD+2)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	delta := n - m
+	// with an odd delta the paths meet on a forward step, else a backward one
+	odd := delta%2 != 0
+
+	// diagonals that ran off the edges are skipped in later rounds
+	var fStart, fEnd, bStart, bEnd int
+
+	for d := range maxD {
+		for k := -d + fStart; k <= d-fEnd; k += 2 {
+			var i int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				i = forward[offset+k+1]
+			} else {
+				i = forward[offset+k-1] + 1
+			}
+			j := i - k
+			for i < n && j < m && x[i] == y[j] {
+				i++
+				j++
+			}
+			forward[offset+k] = i
+
+			switch {
+			case i > n:
+				fEnd += 2
+			case j > m:
+				fStart += 2
+			case odd:
+				bk := offset + delta - k
+				if bk >= 0 && bk < len(backward) && backward[bk] != -1 && i >= n-backward[bk] {
+					return i, j, true
+				}
+			}
+		}
+
+		for k := -d + bStart; k <= d-bEnd; k += 2 {
+			var i int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				i = backward[offset+k+1]
+			} else {
+				i = backward[offset+k-1] + 1
+			}
+			j := i - k
+			for i < n && j < m && x[n-1-i] == y[m-1-j] {
+				i++
+				j++
+			}
+			backward[offset+k] = i
+
+			switch {
+			case i > n:
+				bEnd += 2
+			case j > m:
+				bStart += 2
+			case !odd:
+				fk := offset + delta - k
+				if fk >= 0 && fk < len(forward) && forward[fk] != -1 {
+					fi := forward[fk]
+					if fi >= n-i {
+						return fi, fi - (fk - offset), true
+					}
+				}
+			}
+		}
+	}
+
+	return 0, 0, false
+}
+
+// Unified renders edits as a unified diff with the given number of context
+// lines around every change, an empty string means the inputs are equal.
+func Unified(edits []Edit, context int) string {
+	var builder strings.Builder
+
+	for start := 0; start < len(edits); {
+		if edits[start].Op == OpEqual {
+			start++
+			continue
+		}
+
+		// grow the hunk while changes are closer than two contexts apart
+		first := max(start-context, 0)
+		end := start
+		for end < len(edits) {
+			if edits[end].Op != OpEqual {
+				end++
+				continue
+			}
+			next := end
+			for next < len(edits) && edits[next].Op == OpEqual {
+				next++
+			}
+			if next == len(edits) || next-end > 2*context {
+				break
+			}
+			end = next
+		}
+		last := min(end+context, len(edits))
+
+		aStart, bStart := lineNumbers(edits[:first])
+		aLen, bLen := lineNumbers(edits[first:last])
+		fmt.Fprintf(&builder, "@@ -%d,%d +%d,%d @@\n", aStart+1, aLen, bStart+1, bLen)
+
+		for _, edit := range edits[first:last] {
+			prefix := " "
+			if edit.Op != OpEqual {
+				prefix = string(edit.Op)
+			}
+			builder.WriteString(prefix + edit.Text + "\n")
+		}
+
+		start = last
+	}
+
+	return builder.String()
+}
+
+func lineNumbers(edits []Edit) (int, int) {
+	var a, b int
+	for _, edit := range edits {
+		if edit.Op != OpInsert {
+			a++
+		}
+		if edit.Op != OpDelete {
+			b++
+		}
+	}
+	return a, b
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,110 @@
+package diff
+
+import (
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+// apply rebuilds both inputs from an edit script.
+func apply(edits []Edit) (string, string) {
+	var a, b []string
+	for _, edit := range edits {
+		if edit.Op != OpInsert {
+			a = append(a, edit.Text)
+		}
+		if edit.Op != OpDelete {
+			b = append(b, edit.Text)
+		}
+	}
+	return strings.Join(a, "\n"), strings.Join(b, "\n")
+}
+
+func changes(edits []Edit) int {
+	n := 0
+	for _, edit := range edits {
+		if edit.Op != OpEqual {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		changes int
+	}{
+		{name: "equal", a: "a\nb\nc", b: "a\nb\nc", changes: 0},
+		{name: "empty a", a: "", b: "a\nb", changes: 2},
+		{name: "empty b", a: "a\nb", b: "", changes: 2},
+		{name: "nothing in common", a: "a\nb", b: "c\nd\ne", changes: 5},
+		{name: "insert in the middle", a: "a\nc", b: "a\nb\nc", changes: 1},
+		{name: "replace", a: "a\nb\nc", b: "a\nx\nc", changes: 2},
+		// the classic example of the myers paper, d = 5
+		{name: "myers", a: "a\nb\nc\na\nb\nb\na", b: "c\nb\na\nb\na\nc", changes: 5},
+		{name: "moved block", a: "1\n2\n3\n4\n5\n6", b: "4\n5\n6\n1\n2\n3", changes: 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edits := Lines(tt.a, tt.b)
+
+			a, b := apply(edits)
+			if a != tt.a || b != tt.b {
+				t.Fatalf("edits rebuild %q and %q", a, b)
+			}
+			if got := changes(edits); got != tt.changes {
+				t.Errorf("got %d changes, want %d", got, tt.changes)
+			}
+		})
+	}
+}
+
+// TestLinesMinimal compares random inputs against the edit distance from a
+// plain dynamic programming table.
+func TestLinesMinimal(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	random := func() []string {
+		lines := make([]string, r.IntN(30))
+		for i := range lines {
+			lines[i] = string(rune('a' + r.IntN(4)))
+		}
+		return lines
+	}
+
+	for range 500 {
+		x, y := random(), random()
+		a, b := strings.Join(x, "\n"), strings.Join(y, "\n")
+
+		edits := Lines(a, b)
+		if gotA, gotB := apply(edits); gotA != a || gotB != b {
+			t.Fatalf("Lines(%q, %q) rebuilds %q and %q", a, b, gotA, gotB)
+		}
+		if got, want := changes(edits), distance(x, y); got != want {
+			t.Fatalf("Lines(%q, %q) has %d changes, want %d", a, b, got, want)
+		}
+	}
+}
+
+func distance(x, y []string) int {
+	row := make([]int, len(y)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := range x {
+		prev := row[0]
+		row[0] = i + 1
+		for j := range y {
+			next := row[j+1]
+			if x[i] == y[j] {
+				row[j+1] = prev
+			} else {
+				row[j+1] = min(row[j], row[j+1]) + 1
+			}
+			prev = next
+		}
+	}
+	return row[len(y)]
+}
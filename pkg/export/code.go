@@ -0,0 +1,209 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/daronenko/https-proxy/internal/model"
+)
+
+func Go(req model.Request) string {
+	var builder strings.Builder
+
+	body := model.BuildBody(req)
+
+	builder.WriteString("package main\n\nimport (\n\t\"fmt\"\n\t\"io\"\n\t\"net/http\"\n\t\"os\"\n")
+	if len(body) > 0 {
+		builder.WriteString("\t\"strings\"\n")
+	}
+	builder.WriteString(")\n\nfunc main() {\n")
+
+	bodyArg := "nil"
+	if len(body) > 0 {
+		fmt.Fprintf(&builder, "\tbody := strings.NewReader(%s)\n", strconv.Quote(string(body)))
+		bodyArg = "body"
+	}
+	fmt.Fprintf(&builder, "\treq, err := http.NewRequest(%s, %s, %s)\n", strconv.Quote(req.Method), strconv.Quote(model.BuildURL(req)), bodyArg)
+	builder.WriteString("\tif err != nil {\n\t\tpanic(err)\n\t}\n")
+
+	for _, h := range headers(req) {
+		fmt.Fprintf(&builder, "\treq.Header.Add(%s, %s)\n", strconv.Quote(h.name), strconv.Quote(h.value))
+	}
+	for _, name := range req.Cookies.Keys() {
+		for _, value := range req.Cookies[name] {
+			fmt.Fprintf(&builder, "\treq.AddCookie(&http.Cookie{Name: %s, Value: %s})\n", strconv.Quote(name), strconv.Quote(value))
+		}
+	}
+
+	builder.WriteString("\n\tresp, err := http.DefaultClient.Do(req)\n")
+	builder.WriteString("\tif err != nil {\n\t\tpanic(err)\n\t}\n")
+	builder.WriteString("\tdefer resp.Body.Close()\n\n")
+	builder.WriteString("\tfmt.Println(resp.Status)\n")
+	builder.WriteString("\tio.Copy(os.Stdout, resp.Body)\n")
+	builder.WriteString("}\n")
+
+	return builder.String()
+}
+
+func Python(req model.Request) string {
+	var builder strings.Builder
+
+	builder.WriteString("import requests\n\n")
+	fmt.Fprintf(&builder, "url = %s\n", pythonQuote(model.BuildURL(req)))
+
+	writeDict := func(name string, items [][2]string) {
+		if len(items) == 0 {
+			return
+		}
+		fmt.Fprintf(&builder, "%s = {\n", name)
+		for _, item := range items {
+			fmt.Fprintf(&builder, "    %s: %s,\n", pythonQuote(item[0]), pythonQuote(item[1]))
+		}
+		builder.WriteString("}\n")
+	}
+
+	// requests takes dicts, so repeated headers are joined and repeated
+	// cookies are sent as a header of their own
+	var headerItems, cookieItems [][2]string
+	for _, h := range joinedHeaders(req) {
+		headerItems = append(headerItems, [2]string{h.name, h.value})
+	}
+	if repeated(req.Cookies) {
+		headerItems = append(headerItems, [2]string{"Cookie", model.BuildCookieHeader(req.Cookies)})
+	} else {
+		for _, name := range req.Cookies.Keys() {
+			cookieItems = append(cookieItems, [2]string{name, req.Cookies.Get(name)})
+		}
+	}
+	writeDict("headers", headerItems)
+	writeDict("cookies", cookieItems)
+
+	args := []string{pythonQuote(req.Method), "url"}
+	if len(headerItems) > 0 {
+		args = append(args, "headers=headers")
+	}
+	if len(cookieItems) > 0 {
+		args = append(args, "cookies=cookies")
+	}
+
+	if body := model.BuildBody(req); len(body) > 0 {
+		if utf8.Valid(body) {
+			fmt.Fprintf(&builder, "data = %s\n", pythonQuote(string(body)))
+		} else {
+			fmt.Fprintf(&builder, "data = b%s\n", pythonBytesQuote(body))
+		}
+		args = append(args, "data=data")
+	}
+
+	fmt.Fprintf(&builder, "\nresponse = requests.request(%s, allow_redirects=False)\n", strings.Join(args, ", "))
+	builder.WriteString("print(response.status_code)\n")
+	builder.WriteString("print(response.text)\n")
+
+	return builder.String()
+}
+
+func JavaScript(req model.Request) string {
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "const response = await fetch(%s, {\n", jsQuote(model.BuildURL(req)))
+	fmt.Fprintf(&builder, "  method: %s,\n", jsQuote(req.Method))
+
+	// fetch joins repeated headers anyway
+	hs := joinedHeaders(req)
+	if len(req.Cookies) > 0 {
+		hs = append(hs, header{"Cookie", model.BuildCookieHeader(req.Cookies)})
+	}
+	if len(hs) > 0 {
+		builder.WriteString("  headers: {\n")
+		for _, h := range hs {
+			fmt.Fprintf(&builder, "    %s: %s,\n", jsQuote(h.name), jsQuote(h.value))
+		}
+		builder.WriteString("  },\n")
+	}
+
+	if body := model.BuildBody(req); len(body) > 0 {
+		if utf8.Valid(body) {
+			fmt.Fprintf(&builder, "  body: %s,\n", jsQuote(string(body)))
+		} else {
+			bytes := make([]string, len(body))
+			for i, b := range body {
+				bytes[i] = strconv.Itoa(int(b))
+			}
+			fmt.Fprintf(&builder, "  body: new Uint8Array([%s]),\n", strings.Join(bytes, ", "))
+		}
+	}
+
+	builder.WriteString("  redirect: \"manual\",\n")
+	builder.WriteString("});\n\n")
+	builder.WriteString("console.log(response.status);\n")
+	builder.WriteString("console.log(await response.text());\n")
+
+	return builder.String()
+}
+
+// jsQuote relies on json string escaping, which also escapes the line
+// separators that are not allowed in older javascript string literals.
+func jsQuote(s string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func pythonQuote(s string) string {
+	var builder strings.Builder
+	builder.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '\\', '"':
+			builder.WriteRune('\\')
+			builder.WriteRune(r)
+		case '\n':
+			builder.WriteString(`\n`)
+		case '\r':
+			builder.WriteString(`\r`)
+		case '\t':
+			builder.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&builder, `\x%02x`, r)
+			} else {
+				builder.WriteRune(r)
+			}
+		}
+	}
+	builder.WriteByte('"')
+	return builder.String()
+}
+
+func pythonBytesQuote(b []byte) string {
+	var builder strings.Builder
+	builder.WriteByte('"')
+	for _, c := range b {
+		switch {
+		case c == '\\' || c == '"':
+			builder.WriteByte('\\')
+			builder.WriteByte(c)
+		case c >= 0x20 && c < 0x7f:
+			builder.WriteByte(c)
+		default:
+			fmt.Fprintf(&builder, `\x%02x`, c)
+		}
+	}
+	builder.WriteByte('"')
+	return builder.String()
+}
+
+func repeated(values model.Values) bool {
+	for _, v := range values {
+		if len(v) > 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/daronenko/https-proxy/internal/model"
+)
+
+func Curl(req model.Request) string {
+	var builder strings.Builder
+
+	// arguments can't hold a null byte and binary is unreadable inside
+	// quotes, such bodies are piped in from printf
+	body := model.BuildBody(req)
+	piped := bytes.IndexByte(body, 0) >= 0 || !utf8.Valid(body)
+	if piped {
+		builder.WriteString("printf " + printfQuote(body) + " | ")
+	}
+
+	builder.WriteString("curl")
+	if req.Method != "GET" {
+		builder.WriteString(" -X " + shellQuote(req.Method))
+	}
+	builder.WriteString(" " + shellQuote(model.BuildURL(req)))
+
+	for _, h := range headers(req) {
+		builder.WriteString(" \\\n  -H " + shellQuote(h.name+": "+h.value))
+	}
+
+	if len(req.Cookies) > 0 {
+		builder.WriteString(" \\\n  -b " + shellQuote(model.BuildCookieHeader(req.Cookies)))
+	}
+
+	switch {
+	case piped:
+		builder.WriteString(" \\\n  --data-binary @-")
+	case len(body) > 0:
+		builder.WriteString(" \\\n  --data-binary " + shellQuote(string(body)))
+	}
+
+	builder.WriteString("\n")
+
+	return builder.String()
+}
+
+// shellQuote quotes s for posix shells. Strings with control characters use
+// ansi-c quoting, since those can't be written inside single quotes.
+func shellQuote(s string) string {
+	printable := true
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] == 0x7f {
+			printable = false
+			break
+		}
+	}
+
+	if printable {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+
+	var builder strings.Builder
+	builder.WriteString("$'")
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '\'':
+			builder.WriteByte('\\')
+			builder.WriteByte(c)
+		case '\n':
+			builder.WriteString(`\n`)
+		case '\r':
+			builder.WriteString(`\r`)
+		case '\t':
+			builder.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&builder, `\x%02x`, c)
+			} else {
+				builder.WriteByte(c)
+			}
+		}
+	}
+	builder.WriteString("'")
+
+	return builder.String()
+}
+
+// printfQuote writes b as a single quoted printf format, with octal escapes
+// for every byte that is not printable ascii.
+func printfQuote(b []byte) string {
+	var builder strings.Builder
+	builder.WriteString("'")
+	for _, c := range b {
+		switch {
+		case c == '\\':
+			builder.WriteString(`\\`)
+		case c == '%':
+			builder.WriteString("%%")
+		case c == '\'':
+			builder.WriteString(`'\''`)
+		case c >= 0x20 && c < 0x7f:
+			builder.WriteByte(c)
+		default:
+			fmt.Fprintf(&builder, `\%03o`, c)
+		}
+	}
+	builder.WriteString("'")
+	return builder.String()
+}
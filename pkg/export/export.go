@@ -0,0 +1,73 @@
+package export
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/daronenko/https-proxy/internal/model"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+var renderers = map[string]func(model.Request) string{
+	"curl":   Curl,
+	"raw":    Raw,
+	"go":     Go,
+	"python": Python,
+	"js":     JavaScript,
+}
+
+func Formats() []string {
+	return slices.Sorted(maps.Keys(renderers))
+}
+
+func Render(format string, req model.Request) (string, error) {
+	render, ok := renderers[format]
+	if !ok {
+		return "", ErrUnknownFormat
+	}
+	return render(req), nil
+}
+
+func Raw(req model.Request) string {
+	return model.BuildRaw(req)
+}
+
+type header struct {
+	name  string
+	value string
+}
+
+// headers returns request headers in a stable order, a header sent more
+// than once is returned once per value. Cookies are rendered separately and
+// the body is stored decoded, so headers describing the original encoding
+// and length are skipped.
+func headers(req model.Request) []header {
+	var result []header
+	for _, k := range req.Headers.Keys() {
+		switch http.CanonicalHeaderKey(k) {
+		case "Host", "Cookie", "Content-Length", "Content-Encoding":
+			continue
+		}
+		for _, v := range req.Headers[k] {
+			result = append(result, header{k, v})
+		}
+	}
+	return result
+}
+
+// joinedHeaders merges repeated headers into one, for clients that take
+// headers as a map.
+func joinedHeaders(req model.Request) []header {
+	var result []header
+	for _, h := range headers(req) {
+		if n := len(result); n > 0 && result[n-1].name == h.name {
+			result[n-1].value += ", " + h.value
+			continue
+		}
+		result = append(result, h)
+	}
+	return result
+}
@@ -0,0 +1,108 @@
+package export
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/daronenko/https-proxy/internal/model"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+var requests = map[string]model.Request{
+	// quotes, newlines and separators in every place that is escaped, with
+	// repeated headers, cookies and query params
+	"form": {
+		Method:   "POST",
+		Host:     "example.com",
+		Path:     "/login",
+		Protocol: "https",
+		Headers: model.Values{
+			"Accept":           {"text/html", "application/json"},
+			"Content-Type":     {"application/x-www-form-urlencoded"},
+			"Content-Length":   {"52"},
+			"Content-Encoding": {"gzip"},
+			"X-Note":           {`it's "quoted"`},
+		},
+		Cookies: model.Values{
+			"session": {"abc", "def"},
+			"theme":   {"dark"},
+		},
+		QueryParams: model.Values{
+			"next": {"/home?tab=1&x=2"},
+			"q":    {"a b", "c"},
+		},
+		FormParams: map[string]string{
+			"user":    "o'brien",
+			"comment": "line one\nline \"two\" & more",
+		},
+	},
+	"json": {
+		Method:   "PUT",
+		Host:     "api.example.com:8443",
+		Path:     "/items/1",
+		Protocol: "https",
+		Headers: model.Values{
+			"Content-Type": {"application/json"},
+		},
+		Cookies: model.Values{
+			"token": {"x"},
+		},
+		Body: []byte("{\"name\": \"it's\\n\", \"sep\": \" \"}\n"),
+	},
+	"binary": {
+		Method:   "POST",
+		Host:     "example.com",
+		Path:     "/upload",
+		Protocol: "http",
+		Headers: model.Values{
+			"Content-Type": {"application/octet-stream"},
+		},
+		Body: []byte{0x00, 0xff, '\'', '"', '\n', 0x7f},
+	},
+}
+
+var extensions = map[string]string{
+	"curl":   "sh",
+	"raw":    "http",
+	"go":     "go",
+	"python": "py",
+	"js":     "js",
+}
+
+func TestRender(t *testing.T) {
+	for name, req := range requests {
+		for _, format := range Formats() {
+			t.Run(name+"/"+format, func(t *testing.T) {
+				got, err := Render(format, req)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				golden := filepath.Join("testdata", name+"."+extensions[format]+".golden")
+				if *update {
+					if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("%v, run the test with -update to create it", err)
+				}
+				if got != string(want) {
+					t.Errorf("output differs from %s\ngot:\n%s\nwant:\n%s", golden, got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestRenderUnknownFormat(t *testing.T) {
+	if _, err := Render("php", requests["json"]); err != ErrUnknownFormat {
+		t.Errorf("Render() error = %v, want %v", err, ErrUnknownFormat)
+	}
+}
@@ -0,0 +1,261 @@
+package fuzzer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type Mode string
+
+const (
+	Sniper       Mode = "sniper"
+	BatteringRam Mode = "battering-ram"
+	Pitchfork    Mode = "pitchfork"
+	ClusterBomb  Mode = "cluster-bomb"
+)
+
+const maxResponseSize = 10 << 20
+
+type Result struct {
+	Index    int      `json:"index"`
+	Position int      `json:"position,omitempty"`
+	Payloads []string `json:"payloads"`
+	Status   int      `json:"status,omitempty"`
+	Length   int      `json:"length"`
+	TimeMs   int64    `json:"time_ms"`
+	Matches  []string `json:"matches,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+type Attack struct {
+	Template    Template
+	Mode        Mode
+	Sets        [][]string
+	Grep        []string
+	Concurrency int
+	Delay       time.Duration
+	Scheme      string
+	Host        string
+	Client      *http.Client
+}
+
+type job struct {
+	index    int
+	position int
+	payloads []string
+	values   []string
+}
+
+// Total validates the payload sets against the mode and returns the number of
+// requests the attack sends, counting stops once it exceeds limit.
+func (a *Attack) Total(limit int) (int, error) {
+	positions := a.Template.Positions()
+
+	switch a.Mode {
+	case Sniper, BatteringRam:
+		if len(a.Sets) != 1 {
+			return 0, fmt.Errorf("%s mode expects exactly one payload set, got %d", a.Mode, len(a.Sets))
+		}
+		if a.Mode == Sniper {
+			return positions * len(a.Sets[0]), nil
+		}
+		return len(a.Sets[0]), nil
+	case Pitchfork, ClusterBomb:
+		if len(a.Sets) != positions {
+			return 0, fmt.Errorf("%s mode expects a payload set per position, got %d sets for %d positions", a.Mode, len(a.Sets), positions)
+		}
+		total := len(a.Sets[0])
+		for _, set := range a.Sets[1:] {
+			if a.Mode == Pitchfork {
+				total = min(total, len(set))
+				continue
+			}
+			total *= len(set)
+			if total > limit {
+				return total, nil
+			}
+		}
+		return total, nil
+	default:
+		return 0, fmt.Errorf("unknown attack mode %q", a.Mode)
+	}
+}
+
+func (a *Attack) jobs() iter.Seq[job] {
+	defaults := a.Template.Defaults()
+
+	return func(yield func(job) bool) {
+		index := 0
+		emit := func(position int, payloads, values []string) bool {
+			index++
+			return yield(job{index: index, position: position, payloads: payloads, values: values})
+		}
+
+		switch a.Mode {
+		case Sniper:
+			for position := range defaults {
+				for _, payload := range a.Sets[0] {
+					values := append([]string(nil), defaults...)
+					values[position] = payload
+					if !emit(position+1, []string{payload}, values) {
+						return
+					}
+				}
+			}
+		case BatteringRam:
+			for _, payload := range a.Sets[0] {
+				values := make([]string, len(defaults))
+				for i := range values {
+					values[i] = payload
+				}
+				if !emit(0, []string{payload}, values) {
+					return
+				}
+			}
+		case Pitchfork:
+			for i := 0; ; i++ {
+				values := make([]string, len(a.Sets))
+				for j, set := range a.Sets {
+					if i >= len(set) {
+						return
+					}
+					values[j] = set[i]
+				}
+				if !emit(0, values, values) {
+					return
+				}
+			}
+		case ClusterBomb:
+			counters := make([]int, len(a.Sets))
+			for {
+				values := make([]string, len(a.Sets))
+				for j, set := range a.Sets {
+					if len(set) == 0 {
+						return
+					}
+					values[j] = set[counters[j]]
+				}
+				if !emit(0, values, values) {
+					return
+				}
+
+				// advance the last position first, like an odometer
+				j := len(counters) - 1
+				for ; j >= 0; j-- {
+					counters[j]++
+					if counters[j] < len(a.Sets[j]) {
+						break
+					}
+					counters[j] = 0
+				}
+				if j < 0 {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Run sends every request of the attack and calls report for each result in
+// completion order. Report is never called concurrently.
+func (a *Attack) Run(ctx context.Context, report func(Result)) {
+	concurrency := max(a.Concurrency, 1)
+
+	jobs := make(chan job)
+	results := make(chan Result)
+
+	go func() {
+		defer close(jobs)
+
+		var throttle <-chan time.Time
+		if a.Delay > 0 {
+			ticker := time.NewTicker(a.Delay)
+			defer ticker.Stop()
+			throttle = ticker.C
+		}
+
+		first := true
+		for j := range a.jobs() {
+			if throttle != nil && !first {
+				select {
+				case <-throttle:
+				case <-ctx.Done():
+					return
+				}
+			}
+			first = false
+
+			select {
+			case jobs <- j:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results <- a.send(ctx, j)
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		report(result)
+	}
+}
+
+func (a *Attack) send(ctx context.Context, j job) Result {
+	result := Result{
+		Index:    j.index,
+		Position: j.position,
+		Payloads: j.payloads,
+	}
+
+	req, err := a.Template.Render(j.values, a.Scheme, a.Host)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	start := time.Now()
+	resp, err := a.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	result.TimeMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	result.Status = resp.StatusCode
+	result.Length = len(body)
+
+	lowerBody := bytes.ToLower(body)
+	for _, pattern := range a.Grep {
+		if bytes.Contains(lowerBody, bytes.ToLower([]byte(pattern))) {
+			result.Matches = append(result.Matches, pattern)
+		}
+	}
+
+	return result
+}
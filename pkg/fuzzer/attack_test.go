@@ -0,0 +1,177 @@
+package fuzzer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newAttack(t *testing.T, raw string, mode Mode, sets ...[]string) *Attack {
+	t.Helper()
+
+	template, err := ParseTemplate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Attack{Template: template, Mode: mode, Sets: sets}
+}
+
+func TestAttackJobs(t *testing.T) {
+	const raw = "GET /?a=§1§&b=§2§ HTTP/1.1\r\n\r\n"
+
+	tests := []struct {
+		name string
+		mode Mode
+		sets [][]string
+		want [][]string
+	}{
+		{
+			name: "sniper",
+			mode: Sniper,
+			sets: [][]string{{"x", "y"}},
+			want: [][]string{{"x", "2"}, {"y", "2"}, {"1", "x"}, {"1", "y"}},
+		},
+		{
+			name: "battering ram",
+			mode: BatteringRam,
+			sets: [][]string{{"x", "y"}},
+			want: [][]string{{"x", "x"}, {"y", "y"}},
+		},
+		{
+			name: "pitchfork stops at the shortest set",
+			mode: Pitchfork,
+			sets: [][]string{{"a1", "a2", "a3"}, {"b1", "b2"}},
+			want: [][]string{{"a1", "b1"}, {"a2", "b2"}},
+		},
+		{
+			name: "cluster bomb",
+			mode: ClusterBomb,
+			sets: [][]string{{"a1", "a2"}, {"b1", "b2", "b3"}},
+			want: [][]string{
+				{"a1", "b1"}, {"a1", "b2"}, {"a1", "b3"},
+				{"a2", "b1"}, {"a2", "b2"}, {"a2", "b3"},
+			},
+		},
+		{
+			name: "cluster bomb with an empty set",
+			mode: ClusterBomb,
+			sets: [][]string{{"a1"}, {}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attack := newAttack(t, raw, tt.mode, tt.sets...)
+
+			total, err := attack.Total(1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got [][]string
+			for j := range attack.jobs() {
+				if j.index != len(got)+1 {
+					t.Errorf("job %d has index %d", len(got)+1, j.index)
+				}
+				got = append(got, j.values)
+			}
+
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("jobs = %q, want %q", got, tt.want)
+			}
+			if total != len(tt.want) {
+				t.Errorf("Total() = %d, want %d", total, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestAttackTotal(t *testing.T) {
+	const raw = "GET /?a=§1§&b=§2§ HTTP/1.1\r\n\r\n"
+
+	tests := []struct {
+		name    string
+		mode    Mode
+		sets    [][]string
+		limit   int
+		wantErr bool
+	}{
+		{name: "sniper needs one set", mode: Sniper, sets: [][]string{{"x"}, {"y"}}, wantErr: true},
+		{name: "pitchfork needs a set per position", mode: Pitchfork, sets: [][]string{{"x"}}, wantErr: true},
+		{name: "unknown mode", mode: "shotgun", sets: [][]string{{"x"}}, wantErr: true},
+		{
+			name:  "cluster bomb stops counting past the limit",
+			mode:  ClusterBomb,
+			sets:  [][]string{make([]string, 100), make([]string, 100)},
+			limit: 50,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			total, err := newAttack(t, raw, tt.mode, tt.sets...).Total(tt.limit)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Total() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && total < tt.limit {
+				t.Errorf("Total() = %d, want more than %d", total, tt.limit)
+			}
+		})
+	}
+}
+
+func TestAttackRun(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.URL.Query().Get("id"))
+		mu.Unlock()
+
+		if r.URL.Query().Get("id") == "2" {
+			w.Write([]byte("SQL syntax ERROR"))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	target, _ := url.Parse(srv.URL)
+	attack := newAttack(t, "GET /?id=§1§ HTTP/1.1\r\n\r\n", Sniper, []string{"1", "2", "3"})
+	attack.Grep = []string{"sql syntax"}
+	attack.Concurrency = 2
+	attack.Scheme = target.Scheme
+	attack.Host = target.Host
+	attack.Client = srv.Client()
+
+	var results []Result
+	attack.Run(context.Background(), func(result Result) {
+		results = append(results, result)
+	})
+
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	for _, result := range results {
+		if result.Error != "" || result.Status != http.StatusOK {
+			t.Errorf("result %d: status %d, error %q", result.Index, result.Status, result.Error)
+		}
+
+		matched := len(result.Matches) > 0
+		if want := result.Payloads[0] == "2"; matched != want {
+			t.Errorf("payload %s matched = %v, want %v", result.Payloads[0], matched, want)
+		}
+	}
+
+	slices.Sort(seen)
+	if strings.Join(seen, ",") != "1,2,3" {
+		t.Errorf("server saw ids %q", seen)
+	}
+}
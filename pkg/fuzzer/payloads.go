@@ -0,0 +1,113 @@
+package fuzzer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	SetList    = "list"
+	SetNumbers = "numbers"
+	SetFile    = "file"
+)
+
+var ErrFilesDisabled = errors.New("file payload sets are disabled, no payload directory is configured")
+
+var encoders = map[string]func(string) string{
+	"url":        url.QueryEscape,
+	"double-url": func(s string) string { return url.QueryEscape(url.QueryEscape(s)) },
+	"base64":     func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"hex":        func(s string) string { return hex.EncodeToString([]byte(s)) },
+	"html":       html.EscapeString,
+}
+
+type PayloadSet struct {
+	Type     string   `json:"type"`
+	Values   []string `json:"values,omitempty"`
+	From     int      `json:"from,omitempty"`
+	To       int      `json:"to,omitempty"`
+	Step     int      `json:"step,omitempty"`
+	Path     string   `json:"path,omitempty"`
+	Encoders []string `json:"encoders,omitempty"`
+}
+
+// Generate expands the set and applies its encoders in order. Generation
+// stops once limit payloads are produced. File sets read Path relative to dir,
+// an empty dir disables them.
+func (p PayloadSet) Generate(dir string, limit int) ([]string, error) {
+	var payloads []string
+
+	switch p.Type {
+	case SetList, "":
+		payloads = p.Values
+	case SetNumbers:
+		step := p.Step
+		if step == 0 {
+			step = 1
+		}
+		if (step > 0 && p.From > p.To) || (step < 0 && p.From < p.To) {
+			return nil, fmt.Errorf("number range %d..%d is empty with step %d", p.From, p.To, step)
+		}
+		for n := p.From; (step > 0 && n <= p.To) || (step < 0 && n >= p.To); n += step {
+			if len(payloads) == limit {
+				break
+			}
+			payloads = append(payloads, strconv.Itoa(n))
+		}
+	case SetFile:
+		file, err := openPayloadFile(dir, p.Path)
+		if err != nil {
+			return nil, fmt.Errorf("open payload file: %w", err)
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() && len(payloads) < limit {
+			payloads = append(payloads, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("read payload file: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown payload set type %q", p.Type)
+	}
+
+	if len(payloads) > limit {
+		payloads = payloads[:limit]
+	}
+
+	for _, name := range p.Encoders {
+		encode, ok := encoders[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown encoder %q", name)
+		}
+
+		encoded := make([]string, len(payloads))
+		for i, payload := range payloads {
+			encoded[i] = encode(payload)
+		}
+		payloads = encoded
+	}
+
+	return payloads, nil
+}
+
+// openPayloadFile opens path inside dir, the path comes from the api so
+// anything that leaves dir, through .. or a symlink, is refused.
+func openPayloadFile(dir, path string) (*os.File, error) {
+	if dir == "" {
+		return nil, ErrFilesDisabled
+	}
+	if !filepath.IsLocal(path) {
+		return nil, fmt.Errorf("payload file %q is outside of the payload directory", path)
+	}
+	return os.OpenInRoot(dir, path)
+}
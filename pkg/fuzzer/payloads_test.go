@@ -0,0 +1,76 @@
+package fuzzer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPayloadSetGenerate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "words.txt"), []byte("admin\nroot\nguest\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		set   PayloadSet
+		limit int
+		want  []string
+	}{
+		{name: "list", set: PayloadSet{Values: []string{"a b", "c"}}, limit: 10, want: []string{"a b", "c"}},
+		{name: "numbers", set: PayloadSet{Type: SetNumbers, From: 1, To: 3}, limit: 10, want: []string{"1", "2", "3"}},
+		{name: "numbers down", set: PayloadSet{Type: SetNumbers, From: 9, To: 5, Step: -2}, limit: 10, want: []string{"9", "7", "5"}},
+		{name: "limit", set: PayloadSet{Type: SetNumbers, From: 1, To: 100}, limit: 2, want: []string{"1", "2"}},
+		{name: "file", set: PayloadSet{Type: SetFile, Path: "words.txt"}, limit: 2, want: []string{"admin", "root"}},
+		{
+			name:  "encoders in order",
+			set:   PayloadSet{Values: []string{"<a b>"}, Encoders: []string{"url", "base64"}},
+			limit: 10,
+			want:  []string{"JTNDYStiJTNF"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.set.Generate(dir, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Generate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPayloadFileOutsideDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "payloads")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(root, "secret.txt")
+	if err := os.WriteFile(secret, []byte("password"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"../secret.txt", secret, "sub/../../secret.txt", "link.txt", ""} {
+		t.Run(path, func(t *testing.T) {
+			payloads, err := PayloadSet{Type: SetFile, Path: path}.Generate(dir, 10)
+			if err == nil {
+				t.Errorf("Generate() read %q", payloads)
+			}
+		})
+	}
+
+	_, err := PayloadSet{Type: SetFile, Path: "words.txt"}.Generate("", 10)
+	if !errors.Is(err, ErrFilesDisabled) {
+		t.Errorf("Generate() without a directory error = %v, want %v", err, ErrFilesDisabled)
+	}
+}
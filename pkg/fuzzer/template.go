@@ -0,0 +1,129 @@
+package fuzzer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/daronenko/https-proxy/internal/model"
+)
+
+// Marker delimits payload positions in a template, the text between a pair
+// of markers is the original value used where a position is left untouched.
+const Marker = "§"
+
+var (
+	ErrNoPositions       = errors.New("template has no payload positions")
+	ErrUnbalancedMarkers = errors.New("template has an unclosed payload position")
+)
+
+type Template struct {
+	parts []string
+}
+
+// ParseTemplate splits a raw http request into static parts and payload
+// positions, so that odd parts are positions and even parts are static text.
+func ParseTemplate(raw string) (Template, error) {
+	parts := strings.Split(raw, Marker)
+	if len(parts) == 1 {
+		return Template{}, ErrNoPositions
+	}
+	if len(parts)%2 == 0 {
+		return Template{}, ErrUnbalancedMarkers
+	}
+
+	return Template{parts: parts}, nil
+}
+
+func (t Template) Positions() int {
+	return len(t.parts) / 2
+}
+
+// Defaults returns the original values of every position.
+func (t Template) Defaults() []string {
+	defaults := make([]string, 0, t.Positions())
+	for i := 1; i < len(t.parts); i += 2 {
+		defaults = append(defaults, t.parts[i])
+	}
+	return defaults
+}
+
+// Render substitutes values into positions and parses the result.
+func (t Template) Render(values []string, scheme, host string) (*http.Request, error) {
+	if len(values) != t.Positions() {
+		return nil, fmt.Errorf("expected %d values, got %d", t.Positions(), len(values))
+	}
+
+	var builder strings.Builder
+	for i, part := range t.parts {
+		if i%2 == 1 {
+			part = values[i/2]
+		}
+		builder.WriteString(part)
+	}
+
+	head, body := splitHead(builder.String())
+
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(head + "\r\n\r\n")))
+	if err != nil {
+		return nil, fmt.Errorf("parse rendered request: %w", err)
+	}
+
+	req.RequestURI = ""
+	req.URL.Scheme = scheme
+	if req.URL.Host == "" {
+		req.URL.Host = req.Host
+	}
+	if req.URL.Host == "" {
+		req.URL.Host = host
+		req.Host = host
+	}
+
+	// payloads change the body size, so the length is always recomputed
+	req.Header.Del("Content-Length")
+	req.ContentLength = int64(len(body))
+	req.Body = io.NopCloser(bytes.NewReader([]byte(body)))
+
+	return req, nil
+}
+
+// splitHead separates the head from the body, templates sent as json often
+// carry bare newlines instead of crlf.
+func splitHead(raw string) (string, string) {
+	if i := strings.Index(raw, "\r\n\r\n"); i >= 0 {
+		return raw[:i], raw[i+4:]
+	}
+	if i := strings.Index(raw, "\n\n"); i >= 0 {
+		return raw[:i], raw[i+2:]
+	}
+	return raw, ""
+}
+
+// AutoTemplate renders the stored request with every query, form and cookie
+// value marked as a payload position.
+func AutoTemplate(req model.Request) string {
+	marked := req.Clone()
+	for _, values := range marked.QueryParams {
+		for i, v := range values {
+			values[i] = Marker + v + Marker
+		}
+	}
+	for k, v := range marked.FormParams {
+		marked.FormParams[k] = Marker + v + Marker
+	}
+	for k, values := range marked.Cookies {
+		for i, v := range values {
+			values[i] = Marker + v + Marker
+		}
+		marked.Cookies[k] = values
+	}
+
+	// params are escaped when rendered, the markers are put back so the
+	// positions hold the escaped values
+	return strings.ReplaceAll(model.BuildRaw(marked), url.QueryEscape(Marker), Marker)
+}
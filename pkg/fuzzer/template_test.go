@@ -0,0 +1,126 @@
+package fuzzer
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/daronenko/https-proxy/internal/model"
+)
+
+func TestParseTemplate(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		positions int
+		defaults  []string
+		err       error
+	}{
+		{
+			name: "no markers",
+			raw:  "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n",
+			err:  ErrNoPositions,
+		},
+		{
+			name: "unclosed marker",
+			raw:  "GET /?id=§1 HTTP/1.1\r\n\r\n",
+			err:  ErrUnbalancedMarkers,
+		},
+		{
+			name:      "single position",
+			raw:       "GET /?id=§1§ HTTP/1.1\r\n\r\n",
+			positions: 1,
+			defaults:  []string{"1"},
+		},
+		{
+			name:      "empty and adjacent positions",
+			raw:       "GET /?a=§§&b=§x§§y§ HTTP/1.1\r\n\r\n",
+			positions: 3,
+			defaults:  []string{"", "x", "y"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template, err := ParseTemplate(tt.raw)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ParseTemplate() error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+
+			if got := template.Positions(); got != tt.positions {
+				t.Errorf("Positions() = %d, want %d", got, tt.positions)
+			}
+			if got := template.Defaults(); !slices.Equal(got, tt.defaults) {
+				t.Errorf("Defaults() = %q, want %q", got, tt.defaults)
+			}
+		})
+	}
+}
+
+func TestTemplateRender(t *testing.T) {
+	// bare newlines, as templates sent in json usually have
+	template, err := ParseTemplate("POST /items?id=§1§ HTTP/1.1\nHost: example.com\nContent-Length: 3\n\nq=§abc§")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := template.Render([]string{"42", "longer payload"}, "https", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := req.URL.String(); got != "https://example.com/items?id=42" {
+		t.Errorf("URL = %q", got)
+	}
+	body, _ := io.ReadAll(req.Body)
+	if string(body) != "q=longer payload" {
+		t.Errorf("body = %q", body)
+	}
+	if req.ContentLength != int64(len(body)) || req.Header.Get("Content-Length") != "" {
+		t.Errorf("length was not recomputed: %d, header %q", req.ContentLength, req.Header.Get("Content-Length"))
+	}
+
+	if _, err := template.Render([]string{"1"}, "https", ""); err == nil {
+		t.Error("Render() with too few values did not fail")
+	}
+}
+
+func TestTemplateRenderDefaultHost(t *testing.T) {
+	template, err := ParseTemplate("GET /?id=§1§ HTTP/1.1\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := template.Render([]string{"2"}, "http", "target.local:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Host != "target.local:8080" || req.URL.String() != "http://target.local:8080/?id=2" {
+		t.Errorf("host = %q, url = %q", req.Host, req.URL)
+	}
+}
+
+func TestAutoTemplate(t *testing.T) {
+	template, err := ParseTemplate(AutoTemplate(model.Request{
+		Method:      "POST",
+		Host:        "example.com",
+		Path:        "/login",
+		QueryParams: model.Values{"next": {"/home"}},
+		FormParams:  map[string]string{"user": "o'brien & co"},
+		Cookies:     model.Values{"session": {"abc", "def"}},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaults := template.Defaults()
+	slices.Sort(defaults)
+	// params hold their escaped values, as they are sent
+	if want := []string{"%2Fhome", "abc", "def", "o%27brien+%26+co"}; !slices.Equal(defaults, want) {
+		t.Errorf("Defaults() = %q, want %q", defaults, want)
+	}
+}
@@ -0,0 +1,52 @@
+package httpctl
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// StatusRecorder remembers the status written through it. Flush and Hijack
+// pass through to the wrapped writer, streaming endpoints depend on them.
+type StatusRecorder struct {
+	http.ResponseWriter
+	Status int
+}
+
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *StatusRecorder) WriteHeader(status int) {
+	r.Status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *StatusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *StatusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	r.Status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// Route returns the path template of the matched route, so ids in paths do
+// not end up in metric labels or span names.
+func Route(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if tmpl, err := current.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return "unknown"
+}
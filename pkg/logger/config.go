@@ -1,6 +1,9 @@
 package logger
 
 type Config struct {
+	// Level is trace, debug, info, warn or error, debug when empty.
+	// TraceLevel is the older spelling of trace.
+	Level      string `mapstructure:"level"`
 	TraceLevel bool   `mapstructure:"traceLevel"`
 	StdoutOnly bool   `mapstructure:"stdoutOnly"`
 	Path       string `mapstructure:"path"`
@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"time"
@@ -18,11 +19,9 @@ func Setup(conf *Config) {
 
 	_ = os.MkdirAll(conf.Path, os.ModePerm)
 
-	var level zerolog.Level
-	if conf.TraceLevel {
-		level = zerolog.TraceLevel
-	} else {
-		level = zerolog.DebugLevel
+	// the level is global so it can be changed without replacing the logger
+	if err := SetLevel(conf); err != nil {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	}
 
 	var writer io.Writer
@@ -49,6 +48,31 @@ func Setup(conf *Config) {
 		With().
 		Timestamp().
 		Caller().
-		Logger().
-		Level(level)
+		Logger()
+}
+
+// ParseLevel returns the level conf asks for.
+func ParseLevel(conf *Config) (zerolog.Level, error) {
+	switch {
+	case conf.Level != "":
+		level, err := zerolog.ParseLevel(conf.Level)
+		if err != nil || level < zerolog.TraceLevel || level > zerolog.ErrorLevel {
+			return zerolog.NoLevel, fmt.Errorf("unknown level %q", conf.Level)
+		}
+		return level, nil
+	case conf.TraceLevel:
+		return zerolog.TraceLevel, nil
+	default:
+		return zerolog.DebugLevel, nil
+	}
+}
+
+// SetLevel switches every logger to the level of conf.
+func SetLevel(conf *Config) error {
+	level, err := ParseLevel(conf)
+	if err != nil {
+		return err
+	}
+	zerolog.SetGlobalLevel(level)
+	return nil
 }
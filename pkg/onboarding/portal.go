@@ -0,0 +1,246 @@
+package onboarding
+
+import (
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Files served by the portal, the page itself is served for the empty name.
+const (
+	PEM          = "ca.pem"
+	DER          = "ca.crt"
+	MobileConfig = "ca.mobileconfig"
+	PAC          = "proxy.pac"
+)
+
+var (
+	ErrNotFound = errors.New("no such onboarding file")
+	// ErrInvalidProxyAddress is returned for a proxy address that is not a
+	// plain host:port, the fallback may come from the Host header.
+	ErrInvalidProxyAddress = errors.New("invalid proxy address")
+)
+
+//go:embed portal.html
+var pageSource string
+
+var page = template.Must(template.New("portal").Parse(pageSource))
+
+// Portal hands out the proxy CA and the settings a new device needs.
+type Portal struct {
+	// Host is the name the proxy answers itself, empty disables it.
+	Host string
+	// ProxyAddress is put in the pac file, the address the client reached
+	// the proxy on is used when empty.
+	ProxyAddress string
+
+	caPath string
+}
+
+func New(caPath, host, proxyAddress string) *Portal {
+	return &Portal{
+		Host:         strings.ToLower(host),
+		ProxyAddress: proxyAddress,
+		caPath:       caPath,
+	}
+}
+
+// Matches reports whether a proxied request is addressed to the portal.
+func (p *Portal) Matches(host string) bool {
+	if p.Host == "" {
+		return false
+	}
+	if name, _, found := strings.Cut(host, ":"); found {
+		host = name
+	}
+	return strings.EqualFold(host, p.Host)
+}
+
+// Serve writes the file called name, proxyAddress is the fallback for the
+// pac file and the page.
+func (p *Portal) Serve(w http.ResponseWriter, name, proxyAddress string) error {
+	if p.ProxyAddress != "" {
+		proxyAddress = p.ProxyAddress
+	}
+
+	var (
+		contentType string
+		body        []byte
+		err         error
+	)
+	switch name {
+	case "", "index.html", PAC:
+		// the address ends up in javascript
+		if !validProxyAddress(proxyAddress) {
+			return ErrInvalidProxyAddress
+		}
+	}
+
+	switch name {
+	case "", "index.html":
+		contentType = "text/html; charset=utf-8"
+		body, err = p.page(proxyAddress)
+	case PEM:
+		contentType = "application/x-pem-file"
+		body, err = os.ReadFile(p.caPath)
+	case DER:
+		contentType = "application/x-x509-ca-cert"
+		body, err = p.der()
+	case MobileConfig:
+		contentType = "application/x-apple-aspen-config"
+		body, err = p.mobileConfig()
+	case PAC:
+		contentType = "application/x-ns-proxy-autoconfig"
+		body = pacFile(proxyAddress)
+	default:
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	w.Header().Set("Cache-Control", "no-store")
+	if name != "" && name != "index.html" && name != PAC {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+
+	return nil
+}
+
+func (p *Portal) der() ([]byte, error) {
+	certPEM, err := os.ReadFile(p.caPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("ca certificate is not pem encoded")
+	}
+	return block.Bytes, nil
+}
+
+// mobileConfig wraps the CA in an apple configuration profile. The uuids are
+// derived from the certificate, so reinstalling replaces the old profile.
+func (p *Portal) mobileConfig() ([]byte, error) {
+	der, err := p.der()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(der)
+	uuid := func(salt byte) string {
+		b := sha256.Sum256(append(sum[:], salt))
+		b[6] = b[6]&0x0f | 0x50
+		b[8] = b[8]&0x3f | 0x80
+		return fmt.Sprintf("%X-%X-%X-%X-%X", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, mobileConfigTemplate,
+		base64.StdEncoding.EncodeToString(der),
+		uuid(1),
+		uuid(2),
+	)
+	return buf.Bytes(), nil
+}
+
+const mobileConfigTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>PayloadContent</key>
+	<array>
+		<dict>
+			<key>PayloadCertificateFileName</key>
+			<string>ca.crt</string>
+			<key>PayloadContent</key>
+			<data>%s</data>
+			<key>PayloadDisplayName</key>
+			<string>MITM Proxy CA</string>
+			<key>PayloadIdentifier</key>
+			<string>local.mitm.proxy.ca.certificate</string>
+			<key>PayloadType</key>
+			<string>com.apple.security.root</string>
+			<key>PayloadUUID</key>
+			<string>%s</string>
+			<key>PayloadVersion</key>
+			<integer>1</integer>
+		</dict>
+	</array>
+	<key>PayloadDisplayName</key>
+	<string>MITM Proxy</string>
+	<key>PayloadIdentifier</key>
+	<string>local.mitm.proxy.ca</string>
+	<key>PayloadType</key>
+	<string>Configuration</string>
+	<key>PayloadUUID</key>
+	<string>%s</string>
+	<key>PayloadVersion</key>
+	<integer>1</integer>
+</dict>
+</plist>
+`
+
+// validProxyAddress accepts host:port with an ip address or a name made of
+// letters, digits, dots and dashes.
+func validProxyAddress(address string) bool {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host == "" {
+		return false
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return false
+	}
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	for _, r := range host {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '.' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func pacFile(proxyAddress string) []byte {
+	return fmt.Appendf(nil, `function FindProxyForURL(url, host) {
+	if (isPlainHostName(host) || host === "localhost" || host === "127.0.0.1") {
+		return "DIRECT";
+	}
+	return "PROXY %s";
+}
+`, proxyAddress)
+}
+
+func (p *Portal) page(proxyAddress string) ([]byte, error) {
+	host, port, err := net.SplitHostPort(proxyAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = page.Execute(&buf, map[string]string{
+		"Host":      p.Host,
+		"Proxy":     proxyAddress,
+		"ProxyHost": host,
+		"ProxyPort": port,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
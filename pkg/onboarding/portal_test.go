@@ -0,0 +1,176 @@
+package onboarding_test
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daronenko/https-proxy/pkg/ca"
+	"github.com/daronenko/https-proxy/pkg/onboarding"
+)
+
+func newPortal(t *testing.T, proxyAddress string) (*onboarding.Portal, *ca.Authority, string) {
+	t.Helper()
+
+	authority, err := ca.Generate("test ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "ca.crt")
+	if err := authority.Save(certPath, filepath.Join(dir, "ca.key")); err != nil {
+		t.Fatal(err)
+	}
+	return onboarding.New(certPath, "Mitm.Local", proxyAddress), authority, certPath
+}
+
+func serve(t *testing.T, p *onboarding.Portal, name, proxyAddress string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	if err := p.Serve(rec, name, proxyAddress); err != nil {
+		t.Fatalf("Serve(%q) error = %v", name, err)
+	}
+	return rec
+}
+
+func TestServeCertificates(t *testing.T) {
+	p, authority, certPath := newPortal(t, "")
+
+	rec := serve(t, p, onboarding.PEM, "10.0.0.1:8080")
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), certPEM) {
+		t.Error("pem file differs from the CA")
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/x-pem-file" {
+		t.Errorf("pem content type = %q", got)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="ca.pem"` {
+		t.Errorf("pem disposition = %q", got)
+	}
+
+	rec = serve(t, p, onboarding.DER, "10.0.0.1:8080")
+	cert, err := x509.ParseCertificate(rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("der file does not parse: %v", err)
+	}
+	if !cert.Equal(authority.Cert) {
+		t.Error("der file differs from the CA")
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/x-x509-ca-cert" {
+		t.Errorf("der content type = %q", got)
+	}
+
+	rec = serve(t, p, onboarding.MobileConfig, "10.0.0.1:8080")
+	profile := rec.Body.String()
+	if err := xml.Unmarshal(rec.Body.Bytes(), new(struct{})); err != nil {
+		t.Fatalf("mobileconfig is not xml: %v", err)
+	}
+	if !strings.Contains(profile, base64.StdEncoding.EncodeToString(authority.Cert.Raw)) {
+		t.Error("mobileconfig does not carry the CA")
+	}
+	if again := serve(t, p, onboarding.MobileConfig, "10.0.0.1:8080").Body.String(); again != profile {
+		t.Error("mobileconfig uuids change between downloads, reinstalling would add a second profile")
+	}
+}
+
+func TestServePAC(t *testing.T) {
+	p, _, _ := newPortal(t, "")
+
+	rec := serve(t, p, onboarding.PAC, "10.0.0.1:8080")
+	if !strings.Contains(rec.Body.String(), `return "PROXY 10.0.0.1:8080";`) {
+		t.Errorf("pac file does not point at the fallback address:\n%s", rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/x-ns-proxy-autoconfig" {
+		t.Errorf("pac content type = %q", got)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != "" {
+		t.Errorf("pac is served as an attachment: %q", got)
+	}
+
+	configured, _, _ := newPortal(t, "proxy.lan:3128")
+	rec = serve(t, configured, onboarding.PAC, "10.0.0.1:8080")
+	if !strings.Contains(rec.Body.String(), `return "PROXY proxy.lan:3128";`) {
+		t.Errorf("pac file does not point at the configured address:\n%s", rec.Body)
+	}
+
+	page := serve(t, p, "", "10.0.0.1:8080").Body.String()
+	if !strings.Contains(page, "10.0.0.1") || !strings.Contains(page, "8080") {
+		t.Error("page does not show the proxy address")
+	}
+}
+
+func TestServeRejectsInvalidProxyAddress(t *testing.T) {
+	p, _, _ := newPortal(t, "")
+
+	for _, address := range []string{
+		`evil.com:80"; } alert(1); {"`,
+		"evil.com:80\nfunction",
+		"<script>:80",
+		"example.com",
+		"example.com:0",
+		"example.com:http",
+		":8080",
+	} {
+		for _, name := range []string{onboarding.PAC, ""} {
+			rec := httptest.NewRecorder()
+			if err := p.Serve(rec, name, address); !errors.Is(err, onboarding.ErrInvalidProxyAddress) {
+				t.Errorf("Serve(%q, %q) error = %v, want ErrInvalidProxyAddress", name, address, err)
+			}
+			if rec.Body.Len() > 0 {
+				t.Errorf("Serve(%q, %q) wrote a body", name, address)
+			}
+		}
+	}
+
+	for _, address := range []string{"10.0.0.1:8080", "[fd00::1]:8080", "proxy-1.lan:3128"} {
+		serve(t, p, onboarding.PAC, address)
+	}
+
+	// certificates do not need the address
+	serve(t, p, onboarding.PEM, "<script>")
+}
+
+func TestServeUnknownFile(t *testing.T) {
+	p, _, _ := newPortal(t, "")
+
+	if err := p.Serve(httptest.NewRecorder(), "../ca.key", "10.0.0.1:8080"); !errors.Is(err, onboarding.ErrNotFound) {
+		t.Errorf("Serve() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	p, _, _ := newPortal(t, "")
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"mitm.local", true},
+		{"MITM.LOCAL", true},
+		{"mitm.local:443", true},
+		{"mitm.local.evil.com", false},
+		{"example.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := p.Matches(tt.host); got != tt.want {
+			t.Errorf("Matches(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+
+	if disabled := onboarding.New("", "", ""); disabled.Matches("") {
+		t.Error("portal without a host matched")
+	}
+}
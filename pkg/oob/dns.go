@@ -0,0 +1,126 @@
+package oob
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+const (
+	dnsHeaderLen = 12
+	dnsTypeA     = 1
+	dnsTypeANY   = 255
+	dnsClassIN   = 1
+
+	dnsFlagQR      = 0x8000
+	dnsFlagAA      = 0x0400
+	dnsOpcodeQuery = 0
+	dnsRcodeNotImp = 4
+)
+
+var errMalformedQuery = errors.New("malformed dns query")
+
+func (s *Server) serveDNS(conn net.PacketConn) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		if resp := s.handleDNS(buf[:n], addr.String()); resp != nil {
+			conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+// handleDNS records the token in a query and returns the response, nil when
+// the packet is not worth one. Only standard queries are answered, other
+// opcodes get NOTIMP and responses are dropped so two servers can't bounce
+// packets between each other.
+func (s *Server) handleDNS(msg []byte, remoteAddr string) []byte {
+	name, qtype, end, err := parseQuestion(msg)
+	if err != nil {
+		return nil
+	}
+
+	flags := binary.BigEndian.Uint16(msg[2:4])
+	if flags&dnsFlagQR != 0 {
+		return nil
+	}
+	if opcode := flags >> 11 & 0xf; opcode != dnsOpcodeQuery {
+		return s.answer(msg[:end], qtype, dnsRcodeNotImp)
+	}
+
+	s.record(s.tokenFromName(name), "dns", remoteAddr, name)
+	return s.answer(msg[:end], qtype, 0)
+}
+
+// answer builds an authoritative response that echoes the question and
+// resolves A queries to the public ip of the listener.
+func (s *Server) answer(query []byte, qtype uint16, rcode uint16) []byte {
+	resp := make([]byte, len(query), len(query)+16)
+	copy(resp, query)
+
+	flags := binary.BigEndian.Uint16(query[2:4])
+	flags = dnsFlagQR | dnsFlagAA | (flags & 0x7900) | rcode // opcode and rd from the query
+	binary.BigEndian.PutUint16(resp[2:4], flags)
+	binary.BigEndian.PutUint16(resp[4:6], 1)
+	binary.BigEndian.PutUint16(resp[6:8], 0)
+	binary.BigEndian.PutUint16(resp[8:10], 0)
+	binary.BigEndian.PutUint16(resp[10:12], 0)
+
+	ip := s.conf.PublicIP.To4()
+	if rcode != 0 || ip == nil || (qtype != dnsTypeA && qtype != dnsTypeANY) {
+		return resp
+	}
+
+	binary.BigEndian.PutUint16(resp[6:8], 1)
+	resp = append(resp, 0xc0, dnsHeaderLen) // pointer to the question name
+	resp = binary.BigEndian.AppendUint16(resp, dnsTypeA)
+	resp = binary.BigEndian.AppendUint16(resp, dnsClassIN)
+	resp = binary.BigEndian.AppendUint32(resp, 0) // ttl, callbacks must not be cached
+	resp = binary.BigEndian.AppendUint16(resp, uint16(len(ip)))
+	resp = append(resp, ip...)
+
+	return resp
+}
+
+// parseQuestion returns the name and type of the first question and the
+// offset right after it.
+func parseQuestion(msg []byte) (string, uint16, int, error) {
+	if len(msg) < dnsHeaderLen || binary.BigEndian.Uint16(msg[4:6]) == 0 {
+		return "", 0, 0, errMalformedQuery
+	}
+
+	var labels []string
+	off := dnsHeaderLen
+	for {
+		if off >= len(msg) {
+			return "", 0, 0, errMalformedQuery
+		}
+		size := int(msg[off])
+		off++
+		if size == 0 {
+			break
+		}
+		// compression pointers have the top bits set, a first question
+		// has nothing before it to point at
+		if size > 63 || off+size > len(msg) {
+			return "", 0, 0, errMalformedQuery
+		}
+		labels = append(labels, string(msg[off:off+size]))
+		off += size
+	}
+
+	if off+4 > len(msg) {
+		return "", 0, 0, errMalformedQuery
+	}
+	qtype := binary.BigEndian.Uint16(msg[off : off+2])
+
+	return strings.Join(labels, "."), qtype, off + 4, nil
+}
@@ -0,0 +1,202 @@
+package oob
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// query builds a message with a single question for name.
+func query(id, flags uint16, name string, qtype uint16) []byte {
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = binary.BigEndian.AppendUint16(msg, flags)
+	msg = append(msg, 0, 1, 0, 0, 0, 0, 0, 0)
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	return binary.BigEndian.AppendUint16(msg, dnsClassIN)
+}
+
+func TestParseQuestion(t *testing.T) {
+	valid := query(1, 0x0100, "abc.oob.example.com", dnsTypeA)
+
+	name, qtype, end, err := parseQuestion(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "abc.oob.example.com" || qtype != dnsTypeA || end != len(valid) {
+		t.Errorf("parseQuestion() = %q, %d, %d", name, qtype, end)
+	}
+
+	// additional records after the question are left alone
+	withEDNS := append(bytes.Clone(valid), 0, 0, 41, 16, 0, 0, 0, 0, 0, 0, 0)
+	if _, _, end, err := parseQuestion(withEDNS); err != nil || end != len(valid) {
+		t.Errorf("parseQuestion() with an additional record = %d, %v", end, err)
+	}
+
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{"empty", nil},
+		{"header only", valid[:dnsHeaderLen]},
+		{"no questions", append([]byte{0, 1, 1, 0, 0, 0}, valid[6:]...)},
+		{"compressed name", append(bytes.Clone(valid[:dnsHeaderLen]), 0xc0, 0x0c, 0, 1, 0, 1)},
+		{"compressed label", append(append(bytes.Clone(valid[:dnsHeaderLen]), 3, 'a', 'b', 'c'), 0xc0, 0x0c, 0, 1, 0, 1)},
+		{"label over 63", append(bytes.Clone(valid[:dnsHeaderLen]), append([]byte{64}, make([]byte, 70)...)...)},
+		{"label past the end", append(bytes.Clone(valid[:dnsHeaderLen]), 10, 'a', 'b')},
+		{"no type", valid[:len(valid)-4]},
+		{"no class", valid[:len(valid)-2]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, _, err := parseQuestion(tt.msg); !errors.Is(err, errMalformedQuery) {
+				t.Errorf("parseQuestion() error = %v, want errMalformedQuery", err)
+			}
+		})
+	}
+
+	for n := range len(valid) {
+		if _, _, _, err := parseQuestion(valid[:n]); err == nil {
+			t.Errorf("parseQuestion() accepted a query truncated to %d bytes", n)
+		}
+	}
+}
+
+func TestHandleDNS(t *testing.T) {
+	s := New(Config{Domain: "oob.example.com", PublicIP: net.ParseIP("192.0.2.10")})
+	token := s.NewToken()
+
+	resp := s.handleDNS(query(7, 0x0100, s.Host(token), dnsTypeA), "192.0.2.1:53")
+	if resp == nil {
+		t.Fatal("query was not answered")
+	}
+	if id := binary.BigEndian.Uint16(resp[0:2]); id != 7 {
+		t.Errorf("answer id = %d, want 7", id)
+	}
+	if flags := binary.BigEndian.Uint16(resp[2:4]); flags != dnsFlagQR|dnsFlagAA|0x0100 {
+		t.Errorf("answer flags = %#04x, want qr, aa and rd", flags)
+	}
+	if answers := binary.BigEndian.Uint16(resp[6:8]); answers != 1 {
+		t.Fatalf("answer has %d records, want 1", answers)
+	}
+	if ip := net.IP(resp[len(resp)-4:]); !ip.Equal(s.conf.PublicIP) {
+		t.Errorf("A record = %s, want %s", ip, s.conf.PublicIP)
+	}
+	if got := s.Poll(token); len(got) != 1 || got[0].Protocol != "dns" {
+		t.Errorf("Poll() = %+v, want one dns interaction", got)
+	}
+
+	// other types and a listener without an ip get an empty answer
+	resp = s.handleDNS(query(8, 0, s.Host(token), 28), "192.0.2.1:53")
+	if answers := binary.BigEndian.Uint16(resp[6:8]); answers != 0 {
+		t.Errorf("AAAA answer has %d records", answers)
+	}
+	noIP := New(Config{Domain: "oob.example.com"})
+	resp = noIP.handleDNS(query(9, 0, "x.oob.example.com", dnsTypeA), "192.0.2.1:53")
+	if answers := binary.BigEndian.Uint16(resp[6:8]); answers != 0 {
+		t.Errorf("answer without a public ip has %d records", answers)
+	}
+
+	// a status request keeps its opcode and is refused
+	const opcodeStatus = 2 << 11
+	resp = s.handleDNS(query(10, opcodeStatus|0x0100, s.Host(token), dnsTypeA), "192.0.2.1:53")
+	if resp == nil {
+		t.Fatal("status request was not answered")
+	}
+	flags := binary.BigEndian.Uint16(resp[2:4])
+	if flags != dnsFlagQR|dnsFlagAA|opcodeStatus|0x0100|dnsRcodeNotImp {
+		t.Errorf("status answer flags = %#04x, want the opcode with NOTIMP", flags)
+	}
+	if answers := binary.BigEndian.Uint16(resp[6:8]); answers != 0 {
+		t.Errorf("status answer has %d records", answers)
+	}
+	if got := s.Poll(token); len(got) != 2 {
+		t.Errorf("Poll() = %+v, want the two queries without the status request", got)
+	}
+
+	// responses are never answered
+	if resp := s.handleDNS(query(11, dnsFlagQR, s.Host(token), dnsTypeA), "192.0.2.1:53"); resp != nil {
+		t.Error("a response was answered")
+	}
+}
+
+func FuzzHandleDNS(f *testing.F) {
+	f.Add(query(1, 0x0100, "abc.oob.example.com", dnsTypeA))
+	f.Add(query(1, 2<<11, "abc.oob.example.com", dnsTypeANY))
+	f.Add([]byte{0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xc0, 0x0c, 0, 1, 0, 1})
+
+	s := New(Config{Domain: "oob.example.com", PublicIP: net.ParseIP("192.0.2.10")})
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		s.handleDNS(msg, "192.0.2.1:53")
+	})
+}
+
+// exchange sends msg to the dns listener and returns the answer.
+func exchange(t *testing.T, addr net.Addr, msg []byte) ([]byte, error) {
+	t.Helper()
+
+	conn, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	return buf[:n], err
+}
+
+func TestServeDNSSurvivesMalformedPackets(t *testing.T) {
+	s := New(Config{Domain: "oob.example.com", PublicIP: net.ParseIP("192.0.2.10")})
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go s.serveDNS(conn)
+
+	sender, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	valid := query(1, 0x0100, "abc.oob.example.com", dnsTypeA)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range 500 {
+		var msg []byte
+		if i%2 == 0 {
+			msg = bytes.Clone(valid[:rng.IntN(len(valid))])
+		} else {
+			msg = make([]byte, rng.IntN(600))
+			for j := range msg {
+				msg[j] = byte(rng.Uint32())
+			}
+		}
+		sender.Write(msg)
+	}
+
+	// the flood may still fill the socket buffer, udp drops the query then
+	for attempt := 1; ; attempt++ {
+		_, err := exchange(t, conn.LocalAddr(), valid)
+		if err == nil {
+			break
+		}
+		if attempt == 5 {
+			t.Fatalf("listener stopped answering after malformed packets: %v", err)
+		}
+	}
+}
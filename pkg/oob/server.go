@@ -0,0 +1,182 @@
+package oob
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const tokenTTL = time.Hour
+
+type Interaction struct {
+	Token      string    `json:"token"`
+	Protocol   string    `json:"protocol"`
+	RemoteAddr string    `json:"remote_addr"`
+	Raw        string    `json:"raw"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+type Config struct {
+	// Domain is the zone delegated to the dns listener, tokens are issued
+	// as its subdomains.
+	Domain string
+	// PublicAddress is the host:port targets use to reach the http listener.
+	PublicAddress string
+	// PublicIP is returned in answers to A queries.
+	PublicIP net.IP
+}
+
+// Server records http and dns callbacks that carry one of the issued tokens,
+// so blind vulnerabilities can be confirmed without any reflection.
+type Server struct {
+	conf Config
+
+	mu           sync.Mutex
+	tokens       map[string]time.Time
+	interactions map[string][]Interaction
+
+	httpServer *http.Server
+
+	connMu  sync.Mutex
+	dnsConn net.PacketConn
+	closed  bool
+}
+
+func New(conf Config) *Server {
+	s := &Server{
+		conf:         conf,
+		tokens:       make(map[string]time.Time),
+		interactions: make(map[string][]Interaction),
+	}
+	s.httpServer = &http.Server{
+		Handler:           http.HandlerFunc(s.handleHTTP),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return s
+}
+
+// NewToken issues a unique token that identifies a single payload.
+func (s *Server) NewToken() string {
+	buf := make([]byte, 10)
+	rand.Read(buf)
+	token := hex.EncodeToString(buf)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for t, issued := range s.tokens {
+		if now.Sub(issued) > tokenTTL {
+			delete(s.tokens, t)
+			delete(s.interactions, t)
+		}
+	}
+	s.tokens[token] = now
+
+	return token
+}
+
+// Host returns the hostname that resolves through the dns listener.
+func (s *Server) Host(token string) string {
+	return token + "." + s.conf.Domain
+}
+
+// URL returns the address of the http listener carrying the token.
+func (s *Server) URL(token string) string {
+	return "http://" + s.conf.PublicAddress + "/" + token
+}
+
+// Poll returns callbacks received for the token so far.
+func (s *Server) Poll(token string) []Interaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Interaction(nil), s.interactions[token]...)
+}
+
+func (s *Server) Serve(httpListener net.Listener, dnsConn net.PacketConn) {
+	// Serve runs in its own goroutine and may start after Shutdown
+	s.connMu.Lock()
+	s.dnsConn = dnsConn
+	if s.closed {
+		dnsConn.Close()
+	}
+	s.connMu.Unlock()
+
+	go s.serveDNS(dnsConn)
+
+	if err := s.httpServer.Serve(httpListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Err(err).Msg("oob http listener stopped")
+	}
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.connMu.Lock()
+	s.closed = true
+	if s.dnsConn != nil {
+		s.dnsConn.Close()
+	}
+	s.connMu.Unlock()
+
+	return s.httpServer.Shutdown(ctx)
+}
+
+func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request) {
+	raw, _ := httputil.DumpRequest(r, true)
+
+	candidates := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		candidates = append(candidates, s.tokenFromName(host))
+	} else {
+		candidates = append(candidates, s.tokenFromName(r.Host))
+	}
+
+	for _, token := range candidates {
+		s.record(token, "http", r.RemoteAddr, string(raw))
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) tokenFromName(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	suffix := "." + strings.ToLower(s.conf.Domain)
+	if s.conf.Domain == "" || !strings.HasSuffix(name, suffix) {
+		return ""
+	}
+
+	labels := strings.Split(strings.TrimSuffix(name, suffix), ".")
+	return labels[len(labels)-1]
+}
+
+func (s *Server) record(token, protocol, remoteAddr, raw string) {
+	if token == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, issued := s.tokens[token]; !issued {
+		return
+	}
+
+	log.Info().Str("token", token).Str("protocol", protocol).Msgf("oob interaction from %s", remoteAddr)
+
+	s.interactions[token] = append(s.interactions[token], Interaction{
+		Token:      token,
+		Protocol:   protocol,
+		RemoteAddr: remoteAddr,
+		Raw:        raw,
+		Timestamp:  time.Now(),
+	})
+}
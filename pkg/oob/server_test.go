@@ -0,0 +1,112 @@
+package oob
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// startServer runs s on local listeners and returns their addresses.
+func startServer(t *testing.T, s *Server) (httpAddr string, dnsAddr net.Addr) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		s.Serve(listener, conn)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+		<-served
+	})
+
+	return listener.Addr().String(), conn.LocalAddr()
+}
+
+func hit(t *testing.T, url, host string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "" {
+		req.Host = host
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback got %d", resp.StatusCode)
+	}
+}
+
+func TestCallbackRoundTrip(t *testing.T) {
+	s := New(Config{Domain: "OOB.example.com", PublicIP: net.ParseIP("192.0.2.10")})
+	httpAddr, dnsAddr := startServer(t, s)
+	s.conf.PublicAddress = httpAddr
+
+	byPath, byHost, byDNS := s.NewToken(), s.NewToken(), s.NewToken()
+
+	hit(t, s.URL(byPath)+"/anything?q=1", "")
+	hit(t, "http://"+httpAddr+"/", "deep.label."+s.Host(byHost)+":80")
+	if _, err := exchange(t, dnsAddr, query(1, 0x0100, "sub."+s.Host(byDNS)+".", dnsTypeA)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		token    string
+		protocol string
+	}{
+		{byPath, "http"},
+		{byHost, "http"},
+		{byDNS, "dns"},
+	}
+	for _, tt := range tests {
+		got := s.Poll(tt.token)
+		if len(got) != 1 {
+			t.Errorf("Poll(%s) returned %d interactions, want 1", tt.protocol, len(got))
+			continue
+		}
+		if got[0].Token != tt.token || got[0].Protocol != tt.protocol || got[0].RemoteAddr == "" || got[0].Raw == "" {
+			t.Errorf("Poll(%s) = %+v", tt.protocol, got[0])
+		}
+	}
+}
+
+func TestCallbackUnknownToken(t *testing.T) {
+	s := New(Config{Domain: "oob.example.com"})
+	httpAddr, dnsAddr := startServer(t, s)
+
+	hit(t, "http://"+httpAddr+"/deadbeef", "deadbeef.oob.example.com")
+	if _, err := exchange(t, dnsAddr, query(1, 0, "deadbeef.oob.example.com", dnsTypeA)); err != nil {
+		t.Fatal(err)
+	}
+	// a token of another zone is not taken from the name
+	token := s.NewToken()
+	if _, err := exchange(t, dnsAddr, query(2, 0, token+".example.org", dnsTypeA)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := s.Poll("deadbeef"); len(got) != 0 {
+		t.Errorf("token that was never issued recorded %+v", got)
+	}
+	if got := s.Poll(token); len(got) != 0 {
+		t.Errorf("query of another zone recorded %+v", got)
+	}
+}
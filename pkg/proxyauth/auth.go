@@ -0,0 +1,217 @@
+package proxyauth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrForbidden is returned for clients outside of the allowed networks.
+	ErrForbidden = errors.New("client address is not allowed")
+	// ErrUnauthorized is returned for missing or wrong credentials, the
+	// client is expected to retry after a 407 challenge.
+	ErrUnauthorized = errors.New("proxy authentication required")
+)
+
+// DefaultRealm is sent in the challenge when no realm is configured.
+const DefaultRealm = "mitm-proxy"
+
+// dummyHash is compared against for unknown users, so they take as long to
+// reject as wrong passwords.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+
+type Config struct {
+	// Realm is sent in the 407 challenge, DefaultRealm when empty.
+	Realm string
+	// Users maps names to bcrypt hashes. Basic auth is required when it is
+	// not empty.
+	Users map[string]string
+	// Projects maps user names to the project their traffic belongs to.
+	Projects map[string]string
+	// Allow lists the client networks, everyone is allowed when empty.
+	Allow []*net.IPNet
+}
+
+// Authenticator checks proxy clients by address and Proxy-Authorization.
+type Authenticator struct {
+	mu       sync.RWMutex
+	realm    string
+	users    map[string][]byte
+	projects map[string]string
+	allow    []*net.IPNet
+
+	// verified caches successful checks, bcrypt is too slow to run for
+	// every connection
+	verified map[[sha256.Size]byte]string
+}
+
+func New(conf Config) (*Authenticator, error) {
+	a := &Authenticator{}
+	if err := a.Replace(conf); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Replace validates conf and swaps all of it in at once, the old one is
+// kept on error. Clients have to authenticate again with the new users.
+func (a *Authenticator) Replace(conf Config) error {
+	users := make(map[string][]byte, len(conf.Users))
+	for name, hash := range conf.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("user %q: password must be a bcrypt hash: %w", name, err)
+		}
+		users[name] = []byte(hash)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.realm = conf.Realm
+	if a.realm == "" {
+		a.realm = DefaultRealm
+	}
+	a.users, a.projects, a.allow = users, conf.Projects, conf.Allow
+	a.verified = make(map[[sha256.Size]byte]string)
+	return nil
+}
+
+// LoadHtpasswd reads name:hash lines, only bcrypt hashes are supported.
+func LoadHtpasswd(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		name, hash, found := strings.Cut(text, ":")
+		if !found || name == "" {
+			return nil, fmt.Errorf("%s:%d: expected name:hash", path, line)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s:%d: only bcrypt hashes are supported", path, line)
+		}
+		users[name] = hash
+	}
+
+	return users, scanner.Err()
+}
+
+// Required reports whether clients have to send credentials.
+func (a *Authenticator) Required() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.users) > 0
+}
+
+// Realm is the realm of the 407 challenge.
+func (a *Authenticator) Realm() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.realm
+}
+
+// Project returns the project user is bound to, empty when there is none.
+func (a *Authenticator) Project(user string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.projects[user]
+}
+
+// Authenticate checks the client address and the Proxy-Authorization header
+// and returns the user name, empty when credentials are not required.
+func (a *Authenticator) Authenticate(addr net.Addr, authorization string) (string, error) {
+	if !a.Allowed(addr) {
+		return "", ErrForbidden
+	}
+
+	if !a.Required() {
+		return "", nil
+	}
+
+	// the scheme is case-insensitive
+	scheme, encoded, found := strings.Cut(strings.TrimSpace(authorization), " ")
+	if !found || !strings.EqualFold(scheme, "Basic") {
+		return "", ErrUnauthorized
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", ErrUnauthorized
+	}
+	name, password, found := strings.Cut(string(decoded), ":")
+	if !found {
+		return "", ErrUnauthorized
+	}
+
+	key := sha256.Sum256(decoded)
+	a.mu.RLock()
+	user, ok := a.verified[key]
+	a.mu.RUnlock()
+	if ok {
+		return user, nil
+	}
+
+	a.mu.RLock()
+	hash, known := a.users[name]
+	a.mu.RUnlock()
+	if !known {
+		hash = dummyHash
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !known {
+		return "", ErrUnauthorized
+	}
+
+	a.mu.Lock()
+	// a replace during the check dropped the user or changed its password
+	if current, ok := a.users[name]; ok && bytes.Equal(current, hash) {
+		a.verified[key] = name
+	}
+	a.mu.Unlock()
+
+	return name, nil
+}
+
+// Allowed reports whether addr is in the allowed networks.
+func (a *Authenticator) Allowed(addr net.Addr) bool {
+	a.mu.RLock()
+	allow := a.allow
+	a.mu.RUnlock()
+
+	if len(allow) == 0 {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
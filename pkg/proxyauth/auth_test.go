@@ -0,0 +1,270 @@
+package proxyauth
+
+import (
+	"encoding/base64"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var client = &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 40000}
+
+func hash(t *testing.T, password string, cost int) string {
+	t.Helper()
+
+	h, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(h)
+}
+
+func basic(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func TestAuthenticate(t *testing.T) {
+	a, err := New(Config{Users: map[string]string{"alice": hash(t, "secret", bcrypt.MinCost)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+	tests := []struct {
+		name          string
+		authorization string
+		want          error
+	}{
+		{"right password", basic("alice", "secret"), nil},
+		{"lowercase scheme", "basic " + encoded, nil},
+		{"uppercase scheme", "BASIC " + encoded, nil},
+		{"extra spaces", "  Basic   " + encoded + " ", nil},
+		{"wrong password", basic("alice", "guess"), ErrUnauthorized},
+		{"unknown user", basic("bob", "secret"), ErrUnauthorized},
+		{"no header", "", ErrUnauthorized},
+		{"other scheme", "Bearer " + encoded, ErrUnauthorized},
+		{"scheme only", "Basic", ErrUnauthorized},
+		{"not base64", "Basic ???", ErrUnauthorized},
+		{"no colon", "Basic " + base64.StdEncoding.EncodeToString([]byte("alice")), ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := a.Authenticate(client, tt.authorization)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.want)
+			}
+			if err == nil && user != "alice" {
+				t.Errorf("Authenticate() user = %q, want alice", user)
+			}
+		})
+	}
+}
+
+func TestVerifiedCache(t *testing.T) {
+	a, err := New(Config{Users: map[string]string{"alice": hash(t, "secret", bcrypt.MinCost)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.Authenticate(client, basic("alice", "guess")); err == nil {
+		t.Fatal("wrong password accepted")
+	}
+	if len(a.verified) != 0 {
+		t.Fatal("a failed check was cached")
+	}
+
+	for range 3 {
+		if _, err := a.Authenticate(client, basic("alice", "secret")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(a.verified) != 1 {
+		t.Fatalf("cache holds %d entries, want 1", len(a.verified))
+	}
+
+	// a replace drops the cache, the removed user is refused at once
+	if err := a.Replace(Config{Users: map[string]string{"bob": hash(t, "other", bcrypt.MinCost)}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Authenticate(client, basic("alice", "secret")); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("removed user authenticated from the cache: %v", err)
+	}
+}
+
+func TestUnknownUserTakesAsLong(t *testing.T) {
+	a, err := New(Config{Users: map[string]string{"alice": hash(t, "secret", bcrypt.DefaultCost)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	a.Authenticate(client, basic("alice", "guess"))
+	wrongPassword := time.Since(start)
+
+	start = time.Now()
+	_, err = a.Authenticate(client, basic("mallory", "guess"))
+	unknownUser := time.Since(start)
+
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("unknown user error = %v", err)
+	}
+	if len(a.verified) != 0 {
+		t.Fatal("unknown user was cached")
+	}
+	// both run a bcrypt comparison of the same cost
+	if unknownUser < wrongPassword/4 {
+		t.Errorf("unknown user rejected in %s, a wrong password in %s", unknownUser, wrongPassword)
+	}
+}
+
+func TestLoadHtpasswd(t *testing.T) {
+	alice, bob := hash(t, "a", bcrypt.MinCost), hash(t, "b", bcrypt.MinCost)
+
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		err     string
+	}{
+		{
+			name:    "users with comments and blank lines",
+			content: "# proxy users\n\nalice:" + alice + "\n  bob:" + bob + "  \n",
+			want:    map[string]string{"alice": alice, "bob": bob},
+		},
+		{
+			name:    "line without a hash",
+			content: "alice:" + alice + "\nbob\n",
+			err:     ":2: expected name:hash",
+		},
+		{
+			name:    "empty name",
+			content: ":" + alice + "\n",
+			err:     ":1: expected name:hash",
+		},
+		{
+			name:    "sha1 hash",
+			content: "alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n",
+			err:     ":1: only bcrypt hashes are supported",
+		},
+		{
+			name:    "apr1 hash",
+			content: "alice:$apr1$salt$hash\n",
+			err:     ":1: only bcrypt hashes are supported",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "htpasswd")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			users, err := LoadHtpasswd(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("LoadHtpasswd() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(users) != len(tt.want) {
+				t.Fatalf("LoadHtpasswd() = %v, want %v", users, tt.want)
+			}
+			for name, hash := range tt.want {
+				if users[name] != hash {
+					t.Errorf("user %s hash = %q, want %q", name, users[name], hash)
+				}
+			}
+		})
+	}
+
+	if _, err := LoadHtpasswd(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file error = %v", err)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("10.0.0.0/8")
+	_, ula, _ := net.ParseCIDR("fd00::/8")
+	a, err := New(Config{Allow: []*net.IPNet{lan, ula}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr net.Addr
+		want bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 1}, false},
+		{&net.TCPAddr{IP: net.ParseIP("fd12::1"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}, false},
+		{&net.UnixAddr{Name: "/tmp/proxy.sock", Net: "unix"}, false},
+	}
+	for _, tt := range tests {
+		if got := a.Allowed(tt.addr); got != tt.want {
+			t.Errorf("Allowed(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+
+	outside := &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 1}
+	if _, err := a.Authenticate(outside, ""); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Authenticate() from outside error = %v, want ErrForbidden", err)
+	}
+	if user, err := a.Authenticate(client, ""); err != nil || user != "" {
+		t.Errorf("Authenticate() without users = %q, %v, want no credentials needed", user, err)
+	}
+
+	everyone, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !everyone.Allowed(outside) {
+		t.Error("empty allow list refused a client")
+	}
+}
+
+func TestReplace(t *testing.T) {
+	a, err := New(Config{
+		Users:    map[string]string{"alice": hash(t, "secret", bcrypt.MinCost)},
+		Projects: map[string]string{"alice": "first"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if realm := a.Realm(); realm != DefaultRealm {
+		t.Errorf("realm = %q, want %q", realm, DefaultRealm)
+	}
+
+	err = a.Replace(Config{
+		Realm:    "lab",
+		Users:    map[string]string{"alice": "plain text"},
+		Projects: map[string]string{"alice": "second"},
+	})
+	if err == nil {
+		t.Fatal("Replace() accepted a password that is not a bcrypt hash")
+	}
+	if realm, project := a.Realm(), a.Project("alice"); realm != DefaultRealm || project != "first" {
+		t.Errorf("failed replace left realm %q and project %q, want the old ones", realm, project)
+	}
+
+	err = a.Replace(Config{
+		Realm:    "lab",
+		Users:    map[string]string{"alice": hash(t, "secret", bcrypt.MinCost)},
+		Projects: map[string]string{"alice": "second"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if realm, project := a.Realm(), a.Project("alice"); realm != "lab" || project != "second" {
+		t.Errorf("realm %q and project %q were not replaced", realm, project)
+	}
+}
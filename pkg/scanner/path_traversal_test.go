@@ -0,0 +1,141 @@
+package scanner_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/daronenko/https-proxy/internal/model"
+	"github.com/daronenko/https-proxy/internal/vulnserver"
+	"github.com/daronenko/https-proxy/pkg/scanner"
+)
+
+// newVulnServer serves root/www with a fake passwd one level above it, so
+// the probes never depend on the files of the machine running the tests.
+func newVulnServer(t *testing.T) *url.URL {
+	t.Helper()
+
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"www/report.txt": "quarterly report",
+		"etc/passwd":     "root:x:0:0:root:/root:/bin/sh\n",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := httptest.NewServer(vulnserver.Handler(filepath.Join(root, "www")))
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestPathTraversalScan(t *testing.T) {
+	target := newVulnServer(t)
+
+	tests := []struct {
+		name     string
+		request  model.Request
+		payloads []string
+		want     []string
+	}{
+		{
+			name: "query param",
+			request: model.Request{
+				Method:      http.MethodGet,
+				Path:        "/download",
+				QueryParams: model.Values{"file": {"report.txt"}},
+			},
+			payloads: []string{"report.txt", "../etc/passwd"},
+			want:     []string{"GET param: file (payload: ../etc/passwd)"},
+		},
+		{
+			name: "form param",
+			request: model.Request{
+				Method:     http.MethodPost,
+				Path:       "/export",
+				FormParams: map[string]string{"file": "report.txt"},
+			},
+			payloads: []string{"..\\etc\\passwd"},
+			want:     []string{"POST param: file (payload: ..\\etc\\passwd)"},
+		},
+		{
+			name: "encoded path segment",
+			request: model.Request{
+				Method: http.MethodGet,
+				Path:   "/static/report.txt",
+			},
+			payloads: []string{"..%2fetc%2fpasswd"},
+			want:     []string{"Path segment: 1 (payload: ..%2fetc%2fpasswd)"},
+		},
+		{
+			name: "null byte cuts the suffix",
+			request: model.Request{
+				Method:      http.MethodGet,
+				Path:        "/download",
+				QueryParams: model.Values{"file": {"report.txt"}},
+			},
+			payloads: []string{"../etc/passwd%00.png"},
+			want:     []string{"GET param: file (payload: ../etc/passwd%00.png)"},
+		},
+		{
+			name: "no traversal",
+			request: model.Request{
+				Method:      http.MethodGet,
+				Path:        "/download",
+				QueryParams: model.Values{"file": {"report.txt"}},
+			},
+			payloads: []string{"report.txt", "../../missing"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := scanner.PathTraversal{
+				Payloads:   tt.payloads,
+				Signatures: scanner.DefaultPathTraversalSignatures,
+			}
+
+			tt.request.Protocol = target.Scheme
+			tt.request.Host = target.Host
+
+			got := s.Scan(tt.request, func(req *http.Request) bool {
+				contentType := req.Header.Get("Content-Type")
+				if req.Method == http.MethodGet && contentType != "" {
+					t.Errorf("GET probe sent Content-Type %q", contentType)
+				}
+				if req.Method == http.MethodPost && contentType != "application/x-www-form-urlencoded" {
+					t.Errorf("POST probe sent Content-Type %q", contentType)
+				}
+
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Errorf("probe failed: %v", err)
+					return false
+				}
+				defer resp.Body.Close()
+
+				body, _ := io.ReadAll(resp.Body)
+				return s.Match(body)
+			})
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Scan() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
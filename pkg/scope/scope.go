@@ -0,0 +1,327 @@
+package scope
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Action string
+
+const (
+	// Record intercepts the traffic and stores it.
+	Record Action = "record"
+	// Intercept decrypts and forwards the traffic without storing it.
+	Intercept Action = "mitm"
+	// Tunnel passes the bytes through without decrypting them.
+	Tunnel Action = "tunnel"
+)
+
+var (
+	ErrOutOfScope = errors.New("target is out of scope")
+	// ErrNoInclude refuses active testing while the scope covers everything.
+	ErrNoInclude = errors.New("scope has no include rules")
+)
+
+// Rule matches targets by scheme, host, port and path. Empty fields match
+// everything. Host is an exact name, a wildcard like *.example.com that
+// matches subdomains, an ip address or a cidr. Path is a regular expression.
+type Rule struct {
+	Scheme string `json:"scheme,omitempty"`
+	Host   string `json:"host,omitempty"`
+	Port   int    `json:"port,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
+type Config struct {
+	// Include lists the targets in scope, when empty everything is
+	// recorded but nothing may be scanned or fuzzed.
+	Include []Rule `json:"include"`
+	// Exclude removes targets from the scope, it wins over Include.
+	Exclude []Rule `json:"exclude"`
+	// OutOfScope is applied to traffic outside of the scope, Tunnel when
+	// empty.
+	OutOfScope Action `json:"out_of_scope"`
+}
+
+// Target is where a request goes, Port is derived from the scheme when zero.
+type Target struct {
+	Scheme string
+	Host   string
+	Port   int
+	Path   string
+}
+
+func TargetFromURL(u *url.URL) Target {
+	target := Target{
+		Scheme: strings.ToLower(u.Scheme),
+		Host:   u.Hostname(),
+		Path:   u.EscapedPath(),
+	}
+	if target.Path == "" {
+		target.Path = "/"
+	}
+	target.Port, _ = strconv.Atoi(u.Port())
+
+	return target
+}
+
+// Scope decides what happens to traffic. It is safe for concurrent use and
+// can be replaced at runtime.
+type Scope struct {
+	mu       sync.RWMutex
+	conf     Config
+	include  []matcher
+	exclude  []matcher
+	fallback Action
+}
+
+func New(conf Config) (*Scope, error) {
+	s := &Scope{}
+	if err := s.Replace(conf); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Replace validates conf and swaps it in, the old one is kept on error.
+func (s *Scope) Replace(conf Config) error {
+	include, err := compile(conf.Include)
+	if err != nil {
+		return fmt.Errorf("include: %w", err)
+	}
+	exclude, err := compile(conf.Exclude)
+	if err != nil {
+		return fmt.Errorf("exclude: %w", err)
+	}
+
+	fallback := conf.OutOfScope
+	switch fallback {
+	case "":
+		fallback = Tunnel
+	case Record, Intercept, Tunnel:
+	default:
+		return fmt.Errorf("unknown out of scope action %q", fallback)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conf = conf
+	if s.conf.Include == nil {
+		s.conf.Include = []Rule{}
+	}
+	if s.conf.Exclude == nil {
+		s.conf.Exclude = []Rule{}
+	}
+	s.conf.OutOfScope = fallback
+	s.include, s.exclude, s.fallback = include, exclude, fallback
+
+	return nil
+}
+
+func (s *Scope) Config() Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.conf
+}
+
+// Defined reports whether the scope has include rules. Without them every
+// target is in scope, which is fine to record but not to attack.
+func (s *Scope) Defined() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.include) > 0
+}
+
+// Contains reports whether a request to target is in scope.
+func (s *Scope) Contains(target Target) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.contains(target.normalize(), false)
+}
+
+// Decide returns the action for a request to target.
+func (s *Scope) Decide(target Target) Action {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.contains(target.normalize(), false) {
+		return Record
+	}
+	return s.fallback
+}
+
+// DecideConnect tells whether a CONNECT to host and port is intercepted or
+// tunneled. The path is not known yet, so the connection is intercepted
+// whenever some request inside of it could be recorded, Decide is then
+// called per request.
+func (s *Scope) DecideConnect(host string, port int) Action {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	target := Target{Scheme: "https", Host: host, Port: port}.normalize()
+	if s.fallback == Tunnel && !s.contains(target, true) {
+		return Tunnel
+	}
+	return Intercept
+}
+
+func (s *Scope) contains(target Target, anyPath bool) bool {
+	for _, m := range s.exclude {
+		// without a path only rules covering every path exclude a target
+		if (!anyPath || m.path == nil) && m.match(target, anyPath) {
+			return false
+		}
+	}
+
+	if len(s.include) == 0 {
+		return true
+	}
+	for _, m := range s.include {
+		if m.match(target, anyPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// Transport refuses requests to targets outside of scope, and every request
+// while the scope has no include rules, so scanners and the fuzzer never
+// reach third parties.
+type Transport struct {
+	Scope *Scope
+	Base  http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var err error
+	switch {
+	case !t.Scope.Defined():
+		err = ErrNoInclude
+	case !t.Scope.Contains(TargetFromURL(req.URL)):
+		err = ErrOutOfScope
+	}
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("%s: %w", req.URL.Redacted(), err)
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+func (t Target) normalize() Target {
+	t.Scheme = strings.ToLower(t.Scheme)
+	t.Host = strings.TrimSuffix(strings.ToLower(t.Host), ".")
+	if t.Port == 0 {
+		switch t.Scheme {
+		case "http":
+			t.Port = 80
+		case "https":
+			t.Port = 443
+		}
+	}
+	return t
+}
+
+type matcher struct {
+	scheme   string
+	host     string
+	wildcard bool
+	network  *net.IPNet
+	port     int
+	path     *regexp.Regexp
+}
+
+func compile(rules []Rule) ([]matcher, error) {
+	matchers := make([]matcher, 0, len(rules))
+	for i, rule := range rules {
+		m := matcher{
+			scheme: strings.ToLower(rule.Scheme),
+			port:   rule.Port,
+		}
+
+		switch m.scheme {
+		case "", "http", "https":
+		default:
+			return nil, fmt.Errorf("rule %d: unknown scheme %q", i, rule.Scheme)
+		}
+
+		if rule.Port < 0 || rule.Port > 65535 {
+			return nil, fmt.Errorf("rule %d: invalid port %d", i, rule.Port)
+		}
+
+		host := strings.TrimSuffix(strings.ToLower(rule.Host), ".")
+		switch {
+		case strings.Contains(host, "/"):
+			_, network, err := net.ParseCIDR(host)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid cidr %q", i, rule.Host)
+			}
+			m.network = network
+		case strings.HasPrefix(host, "*."):
+			m.host, m.wildcard = host[1:], true
+		case strings.Contains(host, "*"):
+			return nil, fmt.Errorf("rule %d: wildcard is only allowed as the first label in %q", i, rule.Host)
+		default:
+			m.host = host
+		}
+
+		if rule.Path != "" {
+			path, err := regexp.Compile(rule.Path)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid path regexp: %w", i, err)
+			}
+			m.path = path
+		}
+
+		matchers = append(matchers, m)
+	}
+
+	return matchers, nil
+}
+
+// match ignores the path when anyPath is set, as if it matched.
+func (m matcher) match(target Target, anyPath bool) bool {
+	if m.scheme != "" && m.scheme != target.Scheme {
+		return false
+	}
+
+	if m.port != 0 && m.port != target.Port {
+		return false
+	}
+
+	switch {
+	case m.network != nil:
+		ip := net.ParseIP(target.Host)
+		if ip == nil || !m.network.Contains(ip) {
+			return false
+		}
+	case m.wildcard:
+		if !strings.HasSuffix(target.Host, m.host) {
+			return false
+		}
+	case m.host != "":
+		if target.Host != m.host {
+			return false
+		}
+	}
+
+	if m.path != nil && !anyPath && !m.path.MatchString(target.Path) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,276 @@
+package scope_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/daronenko/https-proxy/pkg/scope"
+)
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		name   string
+		conf   scope.Config
+		target scope.Target
+		want   bool
+	}{
+		{
+			name:   "empty include covers everything",
+			target: scope.Target{Scheme: "https", Host: "example.com", Path: "/"},
+			want:   true,
+		},
+		{
+			name:   "wildcard matches a subdomain",
+			conf:   scope.Config{Include: []scope.Rule{{Host: "*.example.com"}}},
+			target: scope.Target{Scheme: "https", Host: "www.example.com", Path: "/"},
+			want:   true,
+		},
+		{
+			name:   "wildcard matches a deeper subdomain",
+			conf:   scope.Config{Include: []scope.Rule{{Host: "*.example.com"}}},
+			target: scope.Target{Scheme: "https", Host: "a.b.example.com", Path: "/"},
+			want:   true,
+		},
+		{
+			name:   "wildcard does not match the apex",
+			conf:   scope.Config{Include: []scope.Rule{{Host: "*.example.com"}}},
+			target: scope.Target{Scheme: "https", Host: "example.com", Path: "/"},
+		},
+		{
+			name:   "wildcard does not match a suffix of a label",
+			conf:   scope.Config{Include: []scope.Rule{{Host: "*.example.com"}}},
+			target: scope.Target{Scheme: "https", Host: "badexample.com", Path: "/"},
+		},
+		{
+			name:   "exact host ignores case and the trailing dot",
+			conf:   scope.Config{Include: []scope.Rule{{Host: "Example.com."}}},
+			target: scope.Target{Scheme: "https", Host: "EXAMPLE.com.", Path: "/"},
+			want:   true,
+		},
+		{
+			name:   "exact host does not match a subdomain",
+			conf:   scope.Config{Include: []scope.Rule{{Host: "example.com"}}},
+			target: scope.Target{Scheme: "https", Host: "www.example.com", Path: "/"},
+		},
+		{
+			name:   "cidr matches an address inside",
+			conf:   scope.Config{Include: []scope.Rule{{Host: "10.0.0.0/8"}}},
+			target: scope.Target{Scheme: "http", Host: "10.1.2.3", Path: "/"},
+			want:   true,
+		},
+		{
+			name:   "cidr does not match an address outside",
+			conf:   scope.Config{Include: []scope.Rule{{Host: "10.0.0.0/8"}}},
+			target: scope.Target{Scheme: "http", Host: "11.0.0.1", Path: "/"},
+		},
+		{
+			name:   "cidr does not match a name",
+			conf:   scope.Config{Include: []scope.Rule{{Host: "10.0.0.0/8"}}},
+			target: scope.Target{Scheme: "http", Host: "intranet", Path: "/"},
+		},
+		{
+			name:   "ipv6 cidr",
+			conf:   scope.Config{Include: []scope.Rule{{Host: "fd00::/8"}}},
+			target: scope.Target{Scheme: "https", Host: "fd12::1", Path: "/"},
+			want:   true,
+		},
+		{
+			name:   "https defaults to port 443",
+			conf:   scope.Config{Include: []scope.Rule{{Host: "example.com", Port: 443}}},
+			target: scope.Target{Scheme: "https", Host: "example.com", Path: "/"},
+			want:   true,
+		},
+		{
+			name:   "http defaults to port 80",
+			conf:   scope.Config{Include: []scope.Rule{{Host: "example.com", Port: 80}}},
+			target: scope.Target{Scheme: "http", Host: "example.com", Path: "/"},
+			want:   true,
+		},
+		{
+			name:   "explicit port differs from the rule",
+			conf:   scope.Config{Include: []scope.Rule{{Host: "example.com", Port: 443}}},
+			target: scope.Target{Scheme: "https", Host: "example.com", Port: 8443, Path: "/"},
+		},
+		{
+			name:   "scheme mismatch",
+			conf:   scope.Config{Include: []scope.Rule{{Scheme: "https", Host: "example.com"}}},
+			target: scope.Target{Scheme: "http", Host: "example.com", Path: "/"},
+		},
+		{
+			name:   "path regexp",
+			conf:   scope.Config{Include: []scope.Rule{{Host: "example.com", Path: "^/api/"}}},
+			target: scope.Target{Scheme: "https", Host: "example.com", Path: "/api/users"},
+			want:   true,
+		},
+		{
+			name:   "path regexp mismatch",
+			conf:   scope.Config{Include: []scope.Rule{{Host: "example.com", Path: "^/api/"}}},
+			target: scope.Target{Scheme: "https", Host: "example.com", Path: "/static/app.js"},
+		},
+		{
+			name: "exclude wins over include",
+			conf: scope.Config{
+				Include: []scope.Rule{{Host: "*.example.com"}},
+				Exclude: []scope.Rule{{Host: "cdn.example.com"}},
+			},
+			target: scope.Target{Scheme: "https", Host: "cdn.example.com", Path: "/"},
+		},
+		{
+			name: "exclude wins over an empty include",
+			conf: scope.Config{
+				Exclude: []scope.Rule{{Host: "example.com", Path: "^/logout"}},
+			},
+			target: scope.Target{Scheme: "https", Host: "example.com", Path: "/logout"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := scope.New(tt.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Contains(tt.target); got != tt.want {
+				t.Errorf("Contains(%+v) = %v, want %v", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecideConnect(t *testing.T) {
+	tests := []struct {
+		name string
+		conf scope.Config
+		host string
+		port int
+		want scope.Action
+	}{
+		{
+			name: "included host is intercepted",
+			conf: scope.Config{Include: []scope.Rule{{Host: "example.com"}}},
+			host: "example.com",
+			port: 443,
+			want: scope.Intercept,
+		},
+		{
+			name: "other host is tunneled",
+			conf: scope.Config{Include: []scope.Rule{{Host: "example.com"}}},
+			host: "other.com",
+			port: 443,
+			want: scope.Tunnel,
+		},
+		{
+			name: "include with a path still intercepts the connection",
+			conf: scope.Config{Include: []scope.Rule{{Host: "example.com", Path: "^/api/"}}},
+			host: "example.com",
+			port: 443,
+			want: scope.Intercept,
+		},
+		{
+			name: "exclude with a path does not tunnel the connection",
+			conf: scope.Config{
+				Include: []scope.Rule{{Host: "example.com"}},
+				Exclude: []scope.Rule{{Host: "example.com", Path: "^/logout"}},
+			},
+			host: "example.com",
+			port: 443,
+			want: scope.Intercept,
+		},
+		{
+			name: "exclude without a path tunnels the connection",
+			conf: scope.Config{
+				Include: []scope.Rule{{Host: "*.example.com"}},
+				Exclude: []scope.Rule{{Host: "cdn.example.com"}},
+			},
+			host: "cdn.example.com",
+			port: 443,
+			want: scope.Tunnel,
+		},
+		{
+			name: "port of the rule",
+			conf: scope.Config{Include: []scope.Rule{{Host: "example.com", Port: 8443}}},
+			host: "example.com",
+			port: 443,
+			want: scope.Tunnel,
+		},
+		{
+			name: "recording everything intercepts every connection",
+			conf: scope.Config{Include: []scope.Rule{{Host: "example.com"}}, OutOfScope: scope.Record},
+			host: "other.com",
+			port: 443,
+			want: scope.Intercept,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := scope.New(tt.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.DecideConnect(tt.host, tt.port); got != tt.want {
+				t.Errorf("DecideConnect(%s, %d) = %s, want %s", tt.host, tt.port, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	for _, conf := range []scope.Config{
+		{Include: []scope.Rule{{Scheme: "ftp"}}},
+		{Include: []scope.Rule{{Host: "www.*.example.com"}}},
+		{Include: []scope.Rule{{Host: "10.0.0.0/33"}}},
+		{Include: []scope.Rule{{Port: 70000}}},
+		{Exclude: []scope.Rule{{Path: "("}}},
+		{OutOfScope: "drop"},
+	} {
+		if _, err := scope.New(conf); err == nil {
+			t.Errorf("New(%+v) accepted an invalid config", conf)
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		conf scope.Config
+		want error
+	}{
+		{
+			name: "no include rules",
+			want: scope.ErrNoInclude,
+		},
+		{
+			name: "out of scope",
+			conf: scope.Config{Include: []scope.Rule{{Host: "example.com"}}},
+			want: scope.ErrOutOfScope,
+		},
+		{
+			name: "in scope",
+			conf: scope.Config{Include: []scope.Rule{{Host: "127.0.0.1"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := scope.New(tt.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client := &http.Client{Transport: &scope.Transport{Scope: s}}
+			resp, err := client.Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Get() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}